@@ -0,0 +1,88 @@
+package riscv
+
+// CLINT (Core Local Interruptor) provides the machine-level software interrupt
+// and timer interrupt of the hart. The register layout is compatible with SiFive
+// CLINT which is used by QEMU virt machine. Only hart 0 is connected.
+//
+// mtime is incremented once per instruction, so the timer is deterministic.
+// The machine timer interrupt is pending while mtime >= mtimecmp, and the machine
+// software interrupt is pending while msip is 1.
+//
+// see: 3.2.1 Machine Timer Registers (mtime and mtimecmp)
+// see: https://github.com/riscv/riscv-aclint/blob/main/riscv-aclint.adoc
+type CLINT struct {
+	msip     uint64
+	mtimecmp uint64
+	mtime    uint64
+}
+
+var (
+	_ Device          = (*CLINT)(nil)
+	_ Ticker          = (*CLINT)(nil)
+	_ InterruptSource = (*CLINT)(nil)
+)
+
+const (
+	clintStartAddress = 0x2000000
+	clintSize         = 0x10000
+
+	// The offsets of the registers.
+	clintMsip     = 0x0
+	clintMtimecmp = 0x4000
+	clintMtime    = 0xbff8
+)
+
+// NewCLINT creates CLINT. mtimecmp is initialized to the maximum value so that
+// the timer interrupt is not pending until the software sets it.
+func NewCLINT() *CLINT {
+	return &CLINT{
+		mtimecmp: ^uint64(0),
+	}
+}
+
+// StartAddr represents start address for CLINT.
+func (c *CLINT) StartAddr() uint64 { return clintStartAddress }
+
+// EndAddr represents end of address for CLINT.
+func (c *CLINT) EndAddr() uint64 { return clintStartAddress + clintSize }
+
+// Read reads the register. The 64-bit registers can be read by two 32-bit accesses.
+func (c *CLINT) Read(addr, size uint64) uint64 {
+	switch {
+	case addr >= clintMsip && addr < clintMsip+4:
+		return readRegister(c.msip, addr-clintMsip, size)
+	case addr >= clintMtimecmp && addr < clintMtimecmp+8:
+		return readRegister(c.mtimecmp, addr-clintMtimecmp, size)
+	case addr >= clintMtime && addr < clintMtime+8:
+		return readRegister(c.mtime, addr-clintMtime, size)
+	}
+	return 0
+}
+
+// Write writes the register. The 64-bit registers can be written by two 32-bit accesses.
+// The upper 31 bits of msip are hardwired to zero.
+func (c *CLINT) Write(addr, size, value uint64) {
+	switch {
+	case addr >= clintMsip && addr < clintMsip+4:
+		c.msip = writeRegister(c.msip, addr-clintMsip, size, value) & 1
+	case addr >= clintMtimecmp && addr < clintMtimecmp+8:
+		c.mtimecmp = writeRegister(c.mtimecmp, addr-clintMtimecmp, size, value)
+	case addr >= clintMtime && addr < clintMtime+8:
+		c.mtime = writeRegister(c.mtime, addr-clintMtime, size, value)
+	}
+}
+
+// Tick increments mtime.
+func (c *CLINT) Tick() { c.mtime++ }
+
+// PendingInterrupts returns MSIP and MTIP.
+func (c *CLINT) PendingInterrupts() uint64 {
+	var pending uint64
+	if c.msip != 0 {
+		pending |= 1 << MachineSoftwareInterrupt
+	}
+	if c.mtime >= c.mtimecmp {
+		pending |= 1 << MachineTimerInterrupt
+	}
+	return pending
+}
@@ -0,0 +1,62 @@
+package riscv
+
+import "testing"
+
+func TestCLINT(t *testing.T) {
+	clint := NewCLINT()
+	cpu := NewCPU(make([]byte, 4), WithDevices(clint))
+	const (
+		msip     = clintStartAddress + clintMsip
+		mtimecmp = clintStartAddress + clintMtimecmp
+		mtime    = clintStartAddress + clintMtime
+	)
+	if got := clint.PendingInterrupts(); got != 0 {
+		t.Fatalf("want no interrupts after reset but got 0x%x", got)
+	}
+
+	// The 64-bit registers are accessed by two 32-bit accesses in RV32.
+	mustWrite(t, cpu.bus, mtimecmp+4, 4, 0)
+	mustWrite(t, cpu.bus, mtimecmp, 4, 3)
+	if got := mustRead(t, cpu.bus, mtimecmp, 8); got != 3 {
+		t.Errorf("want mtimecmp 3 but got %d", got)
+	}
+	for i := 0; i < 2; i++ {
+		clint.Tick()
+	}
+	if got := clint.PendingInterrupts(); got != 0 {
+		t.Errorf("want MTIP is not pending at mtime 2 but got 0x%x", got)
+	}
+	clint.Tick()
+	if got := clint.PendingInterrupts(); got != 1<<MachineTimerInterrupt {
+		t.Errorf("want MTIP is pending at mtime 3 but got 0x%x", got)
+	}
+	if got := mustRead(t, cpu.bus, mtime, 4); got != 3 {
+		t.Errorf("want mtime 3 but got %d", got)
+	}
+
+	// mtime is writable.
+	mustWrite(t, cpu.bus, mtime+4, 4, 1)
+	if got := mustRead(t, cpu.bus, mtime, 8); got != 1<<32|3 {
+		t.Errorf("want mtime 0x100000003 but got 0x%x", got)
+	}
+
+	mustWrite(t, cpu.bus, mtimecmp, 8, ^uint64(0))
+	mustWrite(t, cpu.bus, msip, 4, 0xffffffff)
+	if got := mustRead(t, cpu.bus, msip, 4); got != 1 {
+		t.Errorf("want msip 1 but got 0x%x", got)
+	}
+	if got := clint.PendingInterrupts(); got != 1<<MachineSoftwareInterrupt {
+		t.Errorf("want only MSIP is pending but got 0x%x", got)
+	}
+
+	// The pending interrupts are reflected to mip.
+	cpu.sampleInterrupts()
+	if got := cpu.csrs.Get(CSRMip); got != mipMSIP {
+		t.Errorf("want mip 0x%x but got 0x%x", mipMSIP, got)
+	}
+	mustWrite(t, cpu.bus, msip, 4, 0)
+	cpu.sampleInterrupts()
+	if got := cpu.csrs.Get(CSRMip); got != 0 {
+		t.Errorf("want mip 0 but got 0x%x", got)
+	}
+}
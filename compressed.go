@@ -0,0 +1,252 @@
+package riscv
+
+// isCompressed reports whether the instruction is a 16-bit compressed instruction.
+// The lowest two bits of 32-bit instructions are always 0b11.
+//
+// see: 1.5 Base Instruction-Length Encoding
+func isCompressed(rawInst uint32) bool {
+	return rawInst&0b11 != 0b11
+}
+
+// bits returns inst[hi:lo].
+func bits(inst uint32, hi, lo uint32) uint32 {
+	return (inst >> lo) & (1<<(hi-lo+1) - 1)
+}
+
+// cReg returns the register number which is specified by 3-bit rs1′, rs2′ and rd′ fields.
+// These fields encode the 8 most popular registers (x8 to x15).
+func cReg(r uint32) uint32 {
+	return r + 8
+}
+
+func encodeR(funct7, rs2, rs1, funct3, rd, opcode uint32) uint32 {
+	return funct7<<25 | rs2<<20 | rs1<<15 | funct3<<12 | rd<<7 | opcode
+}
+
+func encodeI(imm, rs1, funct3, rd, opcode uint32) uint32 {
+	return bits(imm, 11, 0)<<20 | rs1<<15 | funct3<<12 | rd<<7 | opcode
+}
+
+func encodeS(imm, rs2, rs1, funct3, opcode uint32) uint32 {
+	return bits(imm, 11, 5)<<25 | rs2<<20 | rs1<<15 | funct3<<12 | bits(imm, 4, 0)<<7 | opcode
+}
+
+func encodeB(imm, rs2, rs1, funct3, opcode uint32) uint32 {
+	return bits(imm, 12, 12)<<31 | bits(imm, 10, 5)<<25 | rs2<<20 | rs1<<15 | funct3<<12 |
+		bits(imm, 4, 1)<<8 | bits(imm, 11, 11)<<7 | opcode
+}
+
+func encodeU(imm, rd, opcode uint32) uint32 {
+	return imm&0xfffff000 | rd<<7 | opcode
+}
+
+func encodeJ(imm, rd, opcode uint32) uint32 {
+	return bits(imm, 20, 20)<<31 | bits(imm, 10, 1)<<21 | bits(imm, 11, 11)<<20 |
+		bits(imm, 19, 12)<<12 | rd<<7 | opcode
+}
+
+// expandCompressed expands the 16-bit compressed instruction to the equivalent
+// 32-bit instruction. It reports false if the instruction is illegal or reserved.
+//
+// The HINT instructions are expanded to the base instructions which have no effect.
+// Some encodings are interpreted differently in RV32C and RV64C.
+//
+// see: Chapter 16 "C" Standard Extension for Compressed Instructions, Version 2.0
+func expandCompressed(rawInst uint32, xlen XLEN) (uint32, bool) {
+	rv64 := xlen == XLEN64
+	inst := rawInst & 0xffff
+	// The instruction whose all bits are zero is defined as illegal.
+	if inst == 0 {
+		return 0, false
+	}
+	funct3 := bits(inst, 15, 13)
+	switch inst & 0b11 {
+	case 0b00:
+		rs1 := cReg(bits(inst, 9, 7))
+		rd := cReg(bits(inst, 4, 2)) // rd′ and rs2′ are in the same position.
+		// uimm[5:3|2|6] of C.LW, C.FLW, C.SW and C.FSW.
+		wordImm := bits(inst, 12, 10)<<3 | bits(inst, 6, 6)<<2 | bits(inst, 5, 5)<<6
+		// uimm[5:3|7:6] of C.FLD, C.FSD, C.LD and C.SD.
+		doubleImm := bits(inst, 12, 10)<<3 | bits(inst, 6, 5)<<6
+		switch funct3 {
+		case 0b000: // C.ADDI4SPN
+			imm := bits(inst, 12, 11)<<4 | bits(inst, 10, 7)<<6 | bits(inst, 6, 6)<<2 | bits(inst, 5, 5)<<3
+			if imm == 0 {
+				return 0, false
+			}
+			return encodeI(imm, 2, 0b000, rd, OPIMM), true
+		case 0b001: // C.FLD
+			return encodeI(doubleImm, rs1, 0b011, rd, OPLOADFP), true
+		case 0b010: // C.LW
+			return encodeI(wordImm, rs1, 0b010, rd, OPLOAD), true
+		case 0b011:
+			if rv64 { // C.LD
+				return encodeI(doubleImm, rs1, 0b011, rd, OPLOAD), true
+			}
+			// C.FLW
+			return encodeI(wordImm, rs1, 0b010, rd, OPLOADFP), true
+		case 0b101: // C.FSD
+			return encodeS(doubleImm, rd, rs1, 0b011, OPSTOREFP), true
+		case 0b110: // C.SW
+			return encodeS(wordImm, rd, rs1, 0b010, OPSTORE), true
+		case 0b111:
+			if rv64 { // C.SD
+				return encodeS(doubleImm, rd, rs1, 0b011, OPSTORE), true
+			}
+			// C.FSW
+			return encodeS(wordImm, rd, rs1, 0b010, OPSTOREFP), true
+		}
+	case 0b01:
+		rd := bits(inst, 11, 7)
+		imm := SignedExtend(bits(inst, 12, 12)<<5|bits(inst, 6, 2), 6)
+		// offset[11|4|9:8|10|6|7|3:1|5] of C.J and C.JAL.
+		jumpImm := SignedExtend(bits(inst, 12, 12)<<11|bits(inst, 11, 11)<<4|bits(inst, 10, 9)<<8|
+			bits(inst, 8, 8)<<10|bits(inst, 7, 7)<<6|bits(inst, 6, 6)<<7|bits(inst, 5, 3)<<1|
+			bits(inst, 2, 2)<<5, 12)
+		// offset[8|4:3|7:6|2:1|5] of C.BEQZ and C.BNEZ.
+		branchImm := SignedExtend(bits(inst, 12, 12)<<8|bits(inst, 11, 10)<<3|bits(inst, 6, 5)<<6|
+			bits(inst, 4, 3)<<1|bits(inst, 2, 2)<<5, 9)
+		switch funct3 {
+		case 0b000: // C.ADDI (C.NOP if rd is x0)
+			return encodeI(imm, rd, 0b000, rd, OPIMM), true
+		case 0b001:
+			if rv64 { // C.ADDIW
+				if rd == 0 {
+					return 0, false
+				}
+				return encodeI(imm, rd, 0b000, rd, OPIMM32), true
+			}
+			// C.JAL
+			return encodeJ(jumpImm, 1, OPJAL), true
+		case 0b010: // C.LI
+			return encodeI(imm, 0, 0b000, rd, OPIMM), true
+		case 0b011:
+			if rd == 2 { // C.ADDI16SP
+				imm := SignedExtend(bits(inst, 12, 12)<<9|bits(inst, 6, 6)<<4|bits(inst, 5, 5)<<6|
+					bits(inst, 4, 3)<<7|bits(inst, 2, 2)<<5, 10)
+				if imm == 0 {
+					return 0, false
+				}
+				return encodeI(imm, 2, 0b000, 2, OPIMM), true
+			}
+			// C.LUI
+			if imm == 0 {
+				return 0, false
+			}
+			return encodeU(imm<<12, rd, OPLUI), true
+		case 0b100:
+			rd := cReg(bits(inst, 9, 7))
+			rs2 := cReg(bits(inst, 4, 2))
+			switch bits(inst, 11, 10) {
+			case 0b00, 0b01: // C.SRLI, C.SRAI
+				// shamt[5] must be zero for RV32C.
+				if bits(inst, 12, 12) != 0 && !rv64 {
+					return 0, false
+				}
+				shamt := bits(inst, 12, 12)<<5 | bits(inst, 6, 2)
+				if bits(inst, 11, 10) == 0b01 {
+					shamt |= 0b010000 << 6
+				}
+				return encodeI(shamt, rd, 0b101, rd, OPIMM), true
+			case 0b10: // C.ANDI
+				return encodeI(imm, rd, 0b111, rd, OPIMM), true
+			case 0b11:
+				if bits(inst, 12, 12) != 0 {
+					// C.SUBW and C.ADDW are only available in RV64.
+					if !rv64 {
+						return 0, false
+					}
+					switch bits(inst, 6, 5) {
+					case 0b00: // C.SUBW
+						return encodeR(0b0100000, rs2, rd, 0b000, rd, OPREG32), true
+					case 0b01: // C.ADDW
+						return encodeR(0, rs2, rd, 0b000, rd, OPREG32), true
+					}
+					return 0, false
+				}
+				switch bits(inst, 6, 5) {
+				case 0b00: // C.SUB
+					return encodeR(0b0100000, rs2, rd, 0b000, rd, OPREG), true
+				case 0b01: // C.XOR
+					return encodeR(0, rs2, rd, 0b100, rd, OPREG), true
+				case 0b10: // C.OR
+					return encodeR(0, rs2, rd, 0b110, rd, OPREG), true
+				case 0b11: // C.AND
+					return encodeR(0, rs2, rd, 0b111, rd, OPREG), true
+				}
+			}
+		case 0b101: // C.J
+			return encodeJ(jumpImm, 0, OPJAL), true
+		case 0b110: // C.BEQZ
+			return encodeB(branchImm, 0, cReg(bits(inst, 9, 7)), 0b000, OPBRANCH), true
+		case 0b111: // C.BNEZ
+			return encodeB(branchImm, 0, cReg(bits(inst, 9, 7)), 0b001, OPBRANCH), true
+		}
+	case 0b10:
+		rd := bits(inst, 11, 7) // rs1 is in the same position.
+		rs2 := bits(inst, 6, 2)
+		// uimm[5|4:2|7:6] of C.LWSP and C.FLWSP.
+		lwspImm := bits(inst, 12, 12)<<5 | bits(inst, 6, 4)<<2 | bits(inst, 3, 2)<<6
+		// uimm[5:2|7:6] of C.SWSP and C.FSWSP.
+		swspImm := bits(inst, 12, 9)<<2 | bits(inst, 8, 7)<<6
+		// uimm[5|4:3|8:6] of C.LDSP and C.FLDSP.
+		ldspImm := bits(inst, 12, 12)<<5 | bits(inst, 6, 5)<<3 | bits(inst, 4, 2)<<6
+		// uimm[5:3|8:6] of C.SDSP and C.FSDSP.
+		sdspImm := bits(inst, 12, 10)<<3 | bits(inst, 9, 7)<<6
+		switch funct3 {
+		case 0b000: // C.SLLI
+			// shamt[5] must be zero for RV32C.
+			if bits(inst, 12, 12) != 0 && !rv64 {
+				return 0, false
+			}
+			return encodeI(bits(inst, 12, 12)<<5|rs2, rd, 0b001, rd, OPIMM), true
+		case 0b001: // C.FLDSP
+			return encodeI(ldspImm, 2, 0b011, rd, OPLOADFP), true
+		case 0b010: // C.LWSP
+			if rd == 0 {
+				return 0, false
+			}
+			return encodeI(lwspImm, 2, 0b010, rd, OPLOAD), true
+		case 0b011:
+			if rv64 { // C.LDSP
+				if rd == 0 {
+					return 0, false
+				}
+				return encodeI(ldspImm, 2, 0b011, rd, OPLOAD), true
+			}
+			// C.FLWSP
+			return encodeI(lwspImm, 2, 0b010, rd, OPLOADFP), true
+		case 0b100:
+			if bits(inst, 12, 12) == 0 {
+				if rs2 == 0 { // C.JR
+					if rd == 0 {
+						return 0, false
+					}
+					return encodeI(0, rd, 0b000, 0, OPJALR), true
+				}
+				// C.MV
+				return encodeR(0, rs2, 0, 0b000, rd, OPREG), true
+			}
+			if rs2 == 0 {
+				if rd == 0 { // C.EBREAK
+					return encodeI(1, 0, 0b000, 0, OPSYSTEM), true
+				}
+				// C.JALR
+				return encodeI(0, rd, 0b000, 1, OPJALR), true
+			}
+			// C.ADD
+			return encodeR(0, rs2, rd, 0b000, rd, OPREG), true
+		case 0b101: // C.FSDSP
+			return encodeS(sdspImm, rs2, 2, 0b011, OPSTOREFP), true
+		case 0b110: // C.SWSP
+			return encodeS(swspImm, rs2, 2, 0b010, OPSTORE), true
+		case 0b111:
+			if rv64 { // C.SDSP
+				return encodeS(sdspImm, rs2, 2, 0b011, OPSTORE), true
+			}
+			// C.FSWSP
+			return encodeS(swspImm, rs2, 2, 0b010, OPSTOREFP), true
+		}
+	}
+	return 0, false
+}
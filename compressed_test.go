@@ -0,0 +1,84 @@
+package riscv
+
+import "testing"
+
+func TestExpandCompressed(t *testing.T) {
+	cases := []struct {
+		name    string
+		xlen    XLEN
+		rawInst uint32
+		want    uint32
+		wantOK  bool
+	}{
+		{name: "c.addi4spn a5, sp, 1020", rawInst: 0x1ffc, want: 0x3fc10793, wantOK: true}, // addi a5, sp, 1020
+		{name: "c.fld fa1, 248(a0)", rawInst: 0x3d6c, want: 0x0f853587, wantOK: true},      // fld fa1, 248(a0)
+		{name: "c.lw s0, 124(a5)", rawInst: 0x5fe0, want: 0x07c7a403, wantOK: true},        // lw s0, 124(a5)
+		{name: "c.flw fs0, 64(a5)", rawInst: 0x63a0, want: 0x0407a407, wantOK: true},       // flw fs0, 64(a5)
+		{name: "c.fsd fa1, 8(a0)", rawInst: 0xa50c, want: 0x00b53427, wantOK: true},        // fsd fa1, 8(a0)
+		{name: "c.sw a0, 124(a5)", rawInst: 0xdfe8, want: 0x06a7ae23, wantOK: true},        // sw a0, 124(a5)
+		{name: "c.fsw fa0, 4(a5)", rawInst: 0xe3c8, want: 0x00a7a227, wantOK: true},        // fsw fa0, 4(a5)
+		{name: "c.nop", rawInst: 0x0001, want: 0x00000013, wantOK: true},                   // addi zero, zero, 0
+		{name: "c.addi a0, -32", rawInst: 0x1501, want: 0xfe050513, wantOK: true},          // addi a0, a0, -32
+		{name: "c.jal -2048", rawInst: 0x3001, want: 0x801ff0ef, wantOK: true},             // jal ra, -2048
+		{name: "c.li a1, 31", rawInst: 0x45fd, want: 0x01f00593, wantOK: true},             // addi a1, zero, 31
+		{name: "c.addi16sp sp, -512", rawInst: 0x7101, want: 0xe0010113, wantOK: true},     // addi sp, sp, -512
+		{name: "c.lui a4, 0xfffe0", rawInst: 0x7701, want: 0xfffe0737, wantOK: true},       // lui a4, 0xfffe0
+		{name: "c.srli a2, 31", rawInst: 0x827d, want: 0x01f65613, wantOK: true},           // srli a2, a2, 31
+		{name: "c.srai a3, 1", rawInst: 0x8685, want: 0x4016d693, wantOK: true},            // srai a3, a3, 1
+		{name: "c.andi a3, -1", rawInst: 0x9afd, want: 0xfff6f693, wantOK: true},           // andi a3, a3, -1
+		{name: "c.sub a3, a2", rawInst: 0x8e91, want: 0x40c686b3, wantOK: true},            // sub a3, a3, a2
+		{name: "c.xor a1, a0", rawInst: 0x8da9, want: 0x00a5c5b3, wantOK: true},            // xor a1, a1, a0
+		{name: "c.or a1, a2", rawInst: 0x8dd1, want: 0x00c5e5b3, wantOK: true},             // or a1, a1, a2
+		{name: "c.and a1, s1", rawInst: 0x8de5, want: 0x0095f5b3, wantOK: true},            // and a1, a1, s1
+		{name: "c.j 2046", rawInst: 0xaffd, want: 0x7fe0006f, wantOK: true},                // jal zero, 2046
+		{name: "c.beqz a3, -256", rawInst: 0xd281, want: 0xf00680e3, wantOK: true},         // beq a3, zero, -256
+		{name: "c.bnez s0, 254", rawInst: 0xec7d, want: 0x0e041f63, wantOK: true},          // bne s0, zero, 254
+		{name: "c.slli t0, 31", rawInst: 0x02fe, want: 0x01f29293, wantOK: true},           // slli t0, t0, 31
+		{name: "c.fldsp fa1, 504(sp)", rawInst: 0x35fe, want: 0x1f813587, wantOK: true},    // fld fa1, 504(sp)
+		{name: "c.lwsp s1, 252(sp)", rawInst: 0x54fe, want: 0x0fc12483, wantOK: true},      // lw s1, 252(sp)
+		{name: "c.flwsp ft0, 4(sp)", rawInst: 0x6012, want: 0x00412007, wantOK: true},      // flw ft0, 4(sp)
+		{name: "c.jr ra", rawInst: 0x8082, want: 0x00008067, wantOK: true},                 // jalr zero, 0(ra)
+		{name: "c.mv a2, t6", rawInst: 0x867e, want: 0x01f00633, wantOK: true},             // add a2, zero, t6
+		{name: "c.ebreak", rawInst: 0x9002, want: 0x00100073, wantOK: true},                // ebreak
+		{name: "c.jalr t2", rawInst: 0x9382, want: 0x000380e7, wantOK: true},               // jalr ra, 0(t2)
+		{name: "c.add a0, a1", rawInst: 0x952e, want: 0x00b50533, wantOK: true},            // add a0, a0, a1
+		{name: "c.fsdsp fa0, 504(sp)", rawInst: 0xbfaa, want: 0x1ea13c27, wantOK: true},    // fsd fa0, 504(sp)
+		{name: "c.swsp a2, 252(sp)", rawInst: 0xdfb2, want: 0x0ec12e23, wantOK: true},      // sw a2, 252(sp)
+		{name: "c.fswsp ft1, 8(sp)", rawInst: 0xe406, want: 0x00112427, wantOK: true},      // fsw ft1, 8(sp)
+		{name: "illegal instruction", rawInst: 0x0000},
+		{name: "c.addi4spn with zero immediate", rawInst: 0x0008},
+		{name: "c.addi16sp with zero immediate", rawInst: 0x6101},
+		{name: "c.lui with zero immediate", rawInst: 0x6701},
+		{name: "c.srli with shamt[5] on RV32", rawInst: 0x9205},
+		{name: "c.lwsp x0", rawInst: 0x4002},
+		{name: "c.jr x0", rawInst: 0x8002},
+		{name: "c.subw on RV32", rawInst: 0x9e15},
+		{name: "c.addiw a0, -1", xlen: XLEN64, rawInst: 0x357d, want: 0xfff5051b, wantOK: true},     // addiw a0, a0, -1
+		{name: "c.ld a1, 248(a0)", xlen: XLEN64, rawInst: 0x7d6c, want: 0x0f853583, wantOK: true},   // ld a1, 248(a0)
+		{name: "c.sd a1, 8(a0)", xlen: XLEN64, rawInst: 0xe50c, want: 0x00b53423, wantOK: true},     // sd a1, 8(a0)
+		{name: "c.srli a2, 63", xlen: XLEN64, rawInst: 0x927d, want: 0x03f65613, wantOK: true},      // srli a2, a2, 63
+		{name: "c.srai a3, 33", xlen: XLEN64, rawInst: 0x9685, want: 0x4216d693, wantOK: true},      // srai a3, a3, 33
+		{name: "c.subw a2, a3", xlen: XLEN64, rawInst: 0x9e15, want: 0x40d6063b, wantOK: true},      // subw a2, a2, a3
+		{name: "c.addw a2, a3", xlen: XLEN64, rawInst: 0x9e35, want: 0x00d6063b, wantOK: true},      // addw a2, a2, a3
+		{name: "c.slli t0, 63", xlen: XLEN64, rawInst: 0x12fe, want: 0x03f29293, wantOK: true},      // slli t0, t0, 63
+		{name: "c.ldsp s1, 504(sp)", xlen: XLEN64, rawInst: 0x74fe, want: 0x1f813483, wantOK: true}, // ld s1, 504(sp)
+		{name: "c.sdsp a2, 504(sp)", xlen: XLEN64, rawInst: 0xffb2, want: 0x1ec13c23, wantOK: true}, // sd a2, 504(sp)
+		{name: "c.addiw x0", xlen: XLEN64, rawInst: 0x2005},
+		{name: "c.ldsp x0", xlen: XLEN64, rawInst: 0x6002},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			xlen := tc.xlen
+			if xlen == 0 {
+				xlen = XLEN32
+			}
+			got, ok := expandCompressed(tc.rawInst, xlen)
+			if ok != tc.wantOK {
+				t.Fatalf("want ok %v but got %v", tc.wantOK, ok)
+			}
+			if got != tc.want {
+				t.Errorf("want 0x%08x but got 0x%08x", tc.want, got)
+			}
+		})
+	}
+}
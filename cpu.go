@@ -1,6 +1,7 @@
 package riscv
 
 import (
+	"errors"
 	"fmt"
 	"log"
 	"strconv"
@@ -14,16 +15,175 @@ import (
 type CPU struct {
 	// 32 64-bit integer registers.
 	// RV32I, RV64I
-	xregs [32]uint32
+	//
+	// In RV32, the values are held in the lower 32 bits and the upper bits are zero.
+	xregs [32]uint64
+	// 32 floating-point registers. The single-precision values are
+	// NaN-boxed if "D" extension is enabled.
+	// RV32F, RV32D
+	fregs [32]uint64
 	// Program counter to hold the dram address of the
 	// next instruction that would be executed
-	pc     uint32
-	nextpc uint32
+	pc     uint64
+	nextpc uint64
 	bus    *Bus
+	csrs   *CSRFile
+	// reservation is the reservation set for LR/SC.
+	reservation *Reservation
+	// ext is the enabled ISA extensions.
+	ext Extensions
+	// xlen is the width of the integer registers.
+	xlen XLEN
+	// mode is the current privilege mode.
+	mode PrivilegeMode
+	// adUpdate is set if the MMU updates A and D bits of the page table entries.
+	adUpdate bool
+	// misaligned is set if the misaligned loads and stores are performed.
+	misaligned bool
+	// entry is the address of the first instruction.
+	entry uint64
+	// imageEnd is the end address of the program image in DRAM. The rest of DRAM
+	// holds the data and the stack, so the program ends when it is reached.
+	imageEnd uint64
+	// tlbSize is the number of the TLB entries.
+	tlbSize int
+	tlb     *tlb
+	// pmpEntries is the number of the implemented PMP entries.
+	pmpEntries int
+	// pmp is the decoded PMP entries which are enabled.
+	pmp []pmpEntry
+
+	// devices is the devices which are connected to the bus in addition to DRAM.
+	devices []Device
+	tickers []Ticker
+	sources []InterruptSource
+	halters []Halter
+	htif    *HTIF
+	// seip is SEIP bit of mip which is written by the software. SEIP is also
+	// asserted by the interrupt sources, and the bit read from mip is the logical-OR of them.
+	seip uint64
+
+	// trapped is set when the trap is taken and cleared when the
+	// first instruction of the trap handler is completed.
+	trapped bool
+
+	env EnvironmentHandler
+	// halted is set when the CPU is stopped by the environment.
+	halted   bool
+	exitCode int
 
 	debug bool
 }
 
+// XLEN represents the width of the integer registers in bits.
+type XLEN int
+
+const (
+	// XLEN32 is for RV32I Base Integer Instruction Set.
+	XLEN32 XLEN = 32
+	// XLEN64 is for RV64I Base Integer Instruction Set.
+	XLEN64 XLEN = 64
+)
+
+// Option represents an option for NewCPU.
+type Option func(*CPU)
+
+// WithXLEN sets the width of the integer registers.
+// It panics if xlen is neither XLEN32 nor XLEN64.
+//
+// The default is XLEN32.
+func WithXLEN(xlen XLEN) Option {
+	if xlen != XLEN32 && xlen != XLEN64 {
+		panic(fmt.Sprintf("riscv: unsupported XLEN: %d", xlen))
+	}
+	return func(c *CPU) {
+		c.xlen = xlen
+	}
+}
+
+// WithExtensions sets the ISA extensions which are enabled.
+// The base integer instruction set (I) is always enabled.
+// If ExtensionD is specified, ExtensionF is also enabled.
+// If ExtensionS is specified, ExtensionU is also enabled.
+//
+// The default is RV32IMAFDCSU.
+func WithExtensions(ext Extensions) Option {
+	return func(c *CPU) {
+		ext |= ExtensionI
+		if ext&ExtensionD != 0 {
+			ext |= ExtensionF
+		}
+		if ext&ExtensionS != 0 {
+			ext |= ExtensionU
+		}
+		c.ext = ext
+	}
+}
+
+// WithADUpdate sets whether the MMU updates A (accessed) and D (dirty) bits of
+// the page table entries. If it is disabled, a page fault is raised when the bits
+// need to be set, and the software is responsible for setting them.
+//
+// The default is enabled.
+func WithADUpdate(enabled bool) Option {
+	return func(c *CPU) {
+		c.adUpdate = enabled
+	}
+}
+
+// WithMisalignedAccess sets whether the misaligned loads and stores are performed
+// byte by byte instead of raising the address misaligned exceptions. Such an access
+// is not atomic. LR/SC and AMOs always raise the exceptions when they are misaligned.
+//
+// The default is disabled.
+func WithMisalignedAccess(enabled bool) Option {
+	return func(c *CPU) {
+		c.misaligned = enabled
+	}
+}
+
+// WithTLBSize sets the number of the TLB entries. The TLB is disabled if size is zero.
+// It panics if size is neither zero nor a power of two.
+//
+// The default is 256.
+func WithTLBSize(size int) Option {
+	if size < 0 || size&(size-1) != 0 {
+		panic(fmt.Sprintf("riscv: invalid TLB size: %d", size))
+	}
+	return func(c *CPU) {
+		c.tlbSize = size
+	}
+}
+
+// WithDevices connects the devices to the bus in addition to DRAM.
+//
+// If the device implements Ticker, it is ticked before each instruction. If the
+// device implements InterruptSource, its interrupts are reflected to mip. If the
+// device implements Halter, it can stop Run.
+func WithDevices(devices ...Device) Option {
+	return func(c *CPU) {
+		c.devices = append(c.devices, devices...)
+	}
+}
+
+// WithEntryPoint sets the address of the first instruction. e.g. Program.Entry.
+//
+// The default is the start address of DRAM.
+func WithEntryPoint(addr uint64) Option {
+	return func(c *CPU) {
+		c.entry = addr
+	}
+}
+
+// WithEnvironmentHandler sets the handler which is called on ECALL and EBREAK.
+//
+// If the handler is not set, these instructions raise the exception.
+func WithEnvironmentHandler(h EnvironmentHandler) Option {
+	return func(c *CPU) {
+		c.env = h
+	}
+}
+
 func (c *CPU) debugf(format string, v ...interface{}) {
 	if c.debug {
 		log.Printf(format, v...)
@@ -32,45 +192,309 @@ func (c *CPU) debugf(format string, v ...interface{}) {
 
 const dramSize = 1024 * 1024 * 128 // (128MiB).
 
-func NewCPU(code []byte) *CPU {
+func NewCPU(code []byte, opts ...Option) *CPU {
 	dram := NewDRAM(code, dramSize)
-	regs := [32]uint32{
+	regs := [32]uint64{
 		2: dram.StartAddr() + dramSize, // set stack pointer.
 	}
-	return &CPU{
-		xregs:  regs,
-		pc:     0,
-		nextpc: dram.StartAddr(),
-		bus:    NewBus(dram),
+	c := &CPU{
+		xregs:      regs,
+		pc:         0,
+		entry:      dram.StartAddr(),
+		imageEnd:   dram.StartAddr() + uint64(len(code)),
+		ext:        defaultExtensions,
+		xlen:       XLEN32,
+		mode:       MachineMode,
+		adUpdate:   true,
+		tlbSize:    defaultTLBSize,
+		pmpEntries: defaultPMPEntries,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	c.nextpc = c.entry
+	devices := append([]Device{dram}, c.devices...)
+	if c.htif != nil {
+		// HTIF is found before DRAM because tohost is in DRAM.
+		devices = append([]Device{c.htif}, devices...)
 	}
+	c.bus = NewBus(devices...)
+	c.reservation = c.bus.NewReservation()
+	for _, dev := range c.devices {
+		if t, ok := dev.(Ticker); ok {
+			c.tickers = append(c.tickers, t)
+		}
+		if s, ok := dev.(InterruptSource); ok {
+			c.sources = append(c.sources, s)
+		}
+		if h, ok := dev.(Halter); ok {
+			c.halters = append(c.halters, h)
+		}
+		if m, ok := dev.(busMaster); ok {
+			m.connect(c.bus)
+		}
+	}
+	if c.htif != nil {
+		c.htif.bus = c.bus
+		c.halters = append(c.halters, c.htif)
+	}
+	c.tlb = newTLB(c.tlbSize)
+	c.csrs = newCSRFile(0, c.ext, c.xlen)
+	c.csrs.addPMP(c.pmpEntries, c.xlen)
+	for addr := uint32(CSRPmpcfg0); addr < CSRPmpaddr0+maxPMPEntries; addr++ {
+		c.csrs.OnWrite(addr, func(_, _ uint64) { c.updatePMP() })
+	}
+	c.csrs.OnWrite(CSRMip, func(_, new uint64) { c.seip = new & mipSEIP })
+	return c
+}
+
+// has reports whether the extension is enabled.
+func (c *CPU) has(ext Extensions) bool { return c.ext&ext == ext }
+
+// XLEN returns the width of the integer registers.
+func (c *CPU) XLEN() XLEN { return c.xlen }
+
+// Mode returns the current privilege mode.
+func (c *CPU) Mode() PrivilegeMode { return c.mode }
+
+// TLBStats returns the statistics of the TLB.
+func (c *CPU) TLBStats() TLBStats { return c.tlb.stats }
+
+// CSRs returns control and status registers of the CPU.
+func (c *CPU) CSRs() *CSRFile { return c.csrs }
+
+// Halted reports whether the CPU has been halted by the environment or the device.
+func (c *CPU) Halted() bool { return c.halted }
+
+// ExitCode returns the exit code which is passed when the CPU is halted.
+func (c *CPU) ExitCode() int { return c.exitCode }
+
+// ialign returns the instruction-address alignment constraint in bytes.
+// It is also the length of the shortest instruction.
+//
+// see: 1.5 Base Instruction-Length Encoding
+func (c *CPU) ialign() uint64 {
+	if c.has(ExtensionC) {
+		return 2
+	}
+	return 4
+}
+
+// truncate returns the lower XLEN bits of v.
+func (c *CPU) truncate(v uint64) uint64 {
+	if c.xlen == XLEN32 {
+		return uint64(uint32(v))
+	}
+	return v
+}
+
+// word sign-extends the 32-bit value to XLEN bits.
+// e.g. the result of LW and *W instructions in RV64.
+func (c *CPU) word(v uint32) uint64 {
+	if c.xlen == XLEN32 {
+		return uint64(v)
+	}
+	return uint64(int64(int32(v)))
+}
+
+// compute performs the ALU operation on XLEN-bit values.
+func (c *CPU) compute(op string, rs1, rs2 uint64) uint64 {
+	if c.xlen == XLEN32 {
+		return uint64(alu.Compute(op, uint32(rs1), uint32(rs2)))
+	}
+	return alu.Compute64(op, rs1, rs2)
+}
+
+// computeWord performs the ALU operation on the lower 32 bits and sign-extends the result.
+// This is used by *W instructions in RV64.
+func (c *CPU) computeWord(op string, rs1, rs2 uint64) uint64 {
+	return c.word(alu.Compute(op, uint32(rs1), uint32(rs2)))
 }
 
+// compare compares XLEN-bit values.
+func (c *CPU) compare(cmp string, rs1, rs2 uint64) bool {
+	if c.xlen == XLEN32 {
+		return branch.Comparator(cmp, uint32(rs1), uint32(rs2))
+	}
+	return branch.Comparator64(cmp, rs1, rs2)
+}
+
+// Next advances the program counter to the next instruction. It returns false if
+// the CPU is halted. The end of the program is detected by Fetch because it needs
+// the physical address of the instruction.
 func (c *CPU) Next() bool {
 	c.pc = c.nextpc
 	c.nextpc += 4
-	return c.bus.IsValidAddr(c.nextpc)
+	return !c.halted
+}
+
+// errEndOfProgram is returned by Fetch when the instruction is at the end of the program.
+var errEndOfProgram = errors.New("end of program")
+
+// StopReason represents the reason why Run is returned.
+type StopReason int
+
+const (
+	// StopEndOfProgram means that the program counter has reached the end of the program.
+	StopEndOfProgram StopReason = iota
+	// StopHalted means that the CPU is halted by the environment or the device.
+	// The exit code is set to RunResult.
+	StopHalted
+	// StopReset means that the device requests to reset the system. The caller
+	// may create a new CPU to restart the program.
+	StopReset
+)
+
+func (r StopReason) String() string {
+	switch r {
+	case StopEndOfProgram:
+		return "end of program"
+	case StopHalted:
+		return "halted"
+	case StopReset:
+		return "reset"
+	}
+	return fmt.Sprintf("StopReason(%d)", int(r))
 }
 
-func (c *CPU) Run() error {
+// RunResult is returned by Run when the CPU is stopped.
+type RunResult struct {
+	Reason StopReason
+	// ExitCode is the exit status when Reason is StopHalted. Zero means success.
+	ExitCode int
+}
+
+// Run executes the program until the end of the program or the CPU is halted.
+// It returns the reason why the CPU is stopped.
+//
+// The synchronous exceptions are delivered to the trap handler which is set to mtvec.
+// If the trap handler can not make progress because the first instruction of the handler
+// also raises an exception, the exception is returned.
+//
+// The devices are ticked and the interrupts are taken between the instructions.
+// If the device implements Halter, it can stop the CPU after each instruction.
+func (c *CPU) Run() (RunResult, error) {
 	for c.Next() {
-		// 1. Fetch.
-		inst, err := c.Fetch()
-		if err != nil {
-			return err
+		for _, t := range c.tickers {
+			t.Tick()
+		}
+		if code, ok := c.pendingInterrupt(); ok {
+			c.trap(uint32(code), true, 0)
+			continue
+		}
+		if err := c.step(); err != nil {
+			if errors.Is(err, errEndOfProgram) {
+				break
+			}
+			var e *Exception
+			if !errors.As(err, &e) || c.trapped {
+				return RunResult{}, err
+			}
+			c.trap(uint32(e.Code), false, e.Value)
+			continue
+		}
+		c.trapped = false
+		for _, h := range c.halters {
+			if result, ok := h.Halt(); ok {
+				if result.Reason == StopHalted {
+					c.halted = true
+					c.exitCode = result.ExitCode
+				}
+				return result, nil
+			}
+		}
+		if c.halted {
+			return RunResult{Reason: StopHalted, ExitCode: c.exitCode}, nil
 		}
-		// 2. Decode.
-		decoded := c.Decode(inst)
-		// 3. Execute.
-		c.Execute(decoded)
 	}
-	return nil
+	return RunResult{Reason: StopEndOfProgram}, nil
+}
+
+func (c *CPU) step() error {
+	// 1. Fetch.
+	inst, err := c.Fetch()
+	if err != nil {
+		return err
+	}
+	// 2. Decode.
+	var decoded *Instruction
+	if isCompressed(inst) {
+		if !c.has(ExtensionC) {
+			return &Exception{Code: IllegalInstruction, Value: uint64(inst)}
+		}
+		expanded, ok := expandCompressed(inst, c.xlen)
+		if !ok {
+			return &Exception{Code: IllegalInstruction, Value: uint64(inst)}
+		}
+		c.nextpc = c.pc + 2
+		decoded = c.Decode(expanded)
+		// The exceptions report the original 16-bit instruction.
+		decoded.raw = inst
+	} else {
+		decoded = c.Decode(inst)
+	}
+	// 3. Execute.
+	return c.Execute(decoded)
 }
 
 // Fetch reads the next instruction to be executed from the memory where the program is stored.
 //
 // see: https://book.rvemu.app/hardware-components/01-cpu.html#fetch-stage
+//
+// If "C" extension is enabled, the instruction is fetched in 16-bit parcels because
+// 32-bit instructions are only aligned on a two-byte boundary. The compressed instruction
+// is returned in the lower 16 bits.
+//
+// errEndOfProgram is returned if the program counter has reached the end of the program.
 func (c *CPU) Fetch() (uint32, error) {
-	return c.bus.Read(c.pc, 4) // 4 * 8 bit == 32 bit
+	if !c.has(ExtensionC) {
+		inst, err := c.fetch(c.pc, 4, true) // 4 * 8 bit == 32 bit
+		return uint32(inst), err
+	}
+	lo, err := c.fetch(c.pc, 2, true)
+	if err != nil {
+		return 0, err
+	}
+	if isCompressed(uint32(lo)) {
+		return uint32(lo), nil
+	}
+	// The upper parcel may be in the next page.
+	// mtval holds the address of the portion of the instruction that caused the fault.
+	hi, err := c.fetch(c.pc+2, 2, false)
+	if err != nil {
+		return 0, err
+	}
+	return uint32(hi<<16 | lo), nil
+}
+
+// fetch reads size bytes of the instruction at the virtual address addr.
+// If first is set, addr is the start of the instruction and errEndOfProgram is
+// returned when the program ends there.
+func (c *CPU) fetch(addr, size uint64, first bool) (uint64, error) {
+	addr = c.truncate(addr)
+	paddr, err := c.physicalAddr(addr, size, accessInstruction)
+	if err != nil {
+		return 0, err
+	}
+	if first && c.endOfProgram(paddr) {
+		return 0, errEndOfProgram
+	}
+	v, err := c.bus.Read(paddr, size)
+	if err != nil {
+		c.debugf("%v", err)
+		return 0, &Exception{Code: InstructionAccessFault, Value: addr}
+	}
+	return v, nil
+}
+
+// endOfProgram reports whether the instruction at the physical address paddr is
+// after the program image or the memory.
+func (c *CPU) endOfProgram(paddr uint64) bool {
+	end := paddr + c.ialign()
+	if paddr >= dramStartAddress && end > c.imageEnd {
+		return true
+	}
+	return !c.bus.IsValidAddr(end)
 }
 
 func (c *CPU) Decode(rawInst uint32) *Instruction {
@@ -89,18 +513,27 @@ func (c *CPU) Decode(rawInst uint32) *Instruction {
 		rs1:    (rawInst >> 15) & 0b11111,   // bits 15 to 19
 		rs2:    (rawInst >> 20) & 0b11111,   // bits 20 to 24
 		funct7: (rawInst >> 25) & 0b1111111, // bits 25 to 31
+		rs3:    (rawInst >> 27) & 0b11111,   // bits 27 to 31 (R4-type)
 		format: fotmat,
-		imm:    decodeImmediate(rawInst, fotmat),
+		imm:    uint64(int64(int32(decodeImmediate(rawInst, fotmat)))),
 	}
 }
 
 // Execute performs the action required by the instruction.
-func (c *CPU) Execute(inst *Instruction) {
+//
+// If the instruction raises a synchronous exception, *Exception is returned.
+func (c *CPU) Execute(inst *Instruction) error {
+	err := c.execute(inst)
+	// x0 is hardwired with all bits equal to 0, so the writes to x0 are discarded.
 	c.xregs[0] = 0
+	return err
+}
 
+func (c *CPU) execute(inst *Instruction) error {
 	rd := inst.rd
 	rs1 := inst.rs1
 	rs2 := inst.rs2
+	rv64 := c.xlen == XLEN64
 
 	// Chapter 19 RV32/64G Instruction Set Listings
 	switch inst.opcode {
@@ -108,157 +541,774 @@ func (c *CPU) Execute(inst *Instruction) {
 		switch inst.funct3 {
 		case 0b000:
 			c.debugf("addi rd, rs1=%d, imm=%d", c.xregs[rs1], inst.imm)
-			c.xregs[rd] = alu.Compute(alu.ADD, c.xregs[rs1], inst.imm)
-			return
-		case 0b001:
-			c.debugf("slli rd, rs1=%d, shamt=%d", c.xregs[rs1], inst.imm)
-			c.xregs[rd] = alu.Compute(alu.SLL, c.xregs[rs1], inst.imm)
-			return
-		case 0b101:
-			switch inst.funct7 {
-			case 0b0000000:
-				c.debugf("srli rd, rs1=%d, shamt=%d", c.xregs[rs1], inst.imm)
-				c.xregs[rd] = alu.Compute(alu.SRL, c.xregs[rs1], inst.imm)
-				return
-			case 0b0100000:
-				shamt := SignedExtend(inst.imm, 4) // shamt ~ 4 bit range
+			c.xregs[rd] = c.compute(alu.ADD, c.xregs[rs1], inst.imm)
+			return nil
+		case 0b001, 0b101:
+			// shamt is imm[4:0] in RV32 and imm[5:0] in RV64.
+			// In RV32, the instructions with imm[5] = 1 are reserved.
+			shamt := inst.imm & uint64(c.xlen-1)
+			if !rv64 && inst.imm&0b100000 != 0 {
+				break
+			}
+			funct6 := (inst.imm & 0xfff) >> 6
+			switch {
+			case inst.funct3 == 0b001 && funct6 == 0b000000:
+				c.debugf("slli rd, rs1=%d, shamt=%d", c.xregs[rs1], shamt)
+				c.xregs[rd] = c.compute(alu.SLL, c.xregs[rs1], shamt)
+				return nil
+			case inst.funct3 == 0b101 && funct6 == 0b000000:
+				c.debugf("srli rd, rs1=%d, shamt=%d", c.xregs[rs1], shamt)
+				c.xregs[rd] = c.compute(alu.SRL, c.xregs[rs1], shamt)
+				return nil
+			case inst.funct3 == 0b101 && funct6 == 0b010000:
 				c.debugf("srai rd, rs1=%d, shamt=%d", c.xregs[rs1], shamt)
-				c.xregs[rd] = alu.Compute(alu.SRA, c.xregs[rs1], shamt)
-				return
+				c.xregs[rd] = c.compute(alu.SRA, c.xregs[rs1], shamt)
+				return nil
 			}
 		case 0b010:
 			c.debugf("slti rd, rs1=%d, imm=%d", c.xregs[rs1], inst.imm)
-			c.xregs[rd] = alu.Compute(alu.SLT, c.xregs[rs1], inst.imm)
-			return
+			c.xregs[rd] = c.compute(alu.SLT, c.xregs[rs1], inst.imm)
+			return nil
 		case 0b011:
 			c.debugf("sltiu rd, rs1=%d, imm=%d", c.xregs[rs1], inst.imm)
-			c.xregs[rd] = alu.Compute(alu.SLTU, c.xregs[rs1], inst.imm)
-			return
+			c.xregs[rd] = c.compute(alu.SLTU, c.xregs[rs1], inst.imm)
+			return nil
 		case 0b100:
 			c.debugf("xori rd, rs1=%d, imm=%d", c.xregs[rs1], inst.imm)
-			c.xregs[rd] = alu.Compute(alu.XOR, c.xregs[rs1], inst.imm)
-			return
+			c.xregs[rd] = c.compute(alu.XOR, c.xregs[rs1], inst.imm)
+			return nil
 		case 0b110:
 			c.debugf("ori rd, rs1=%d, imm=%d", c.xregs[rs1], inst.imm)
-			c.xregs[rd] = alu.Compute(alu.OR, c.xregs[rs1], inst.imm)
-			return
+			c.xregs[rd] = c.compute(alu.OR, c.xregs[rs1], inst.imm)
+			return nil
 		case 0b111:
 			c.debugf("andi rd, rs1=%d, imm=%d", c.xregs[rs1], inst.imm)
-			c.xregs[rd] = alu.Compute(alu.AND, c.xregs[rs1], inst.imm)
-			return
+			c.xregs[rd] = c.compute(alu.AND, c.xregs[rs1], inst.imm)
+			return nil
+		}
+	case OPIMM32:
+		if !rv64 {
+			break
+		}
+		shamt := inst.imm & 0b11111
+		switch inst.funct3 {
+		case 0b000:
+			c.debugf("addiw rd, rs1=%d, imm=%d", c.xregs[rs1], inst.imm)
+			c.xregs[rd] = c.computeWord(alu.ADD, c.xregs[rs1], inst.imm)
+			return nil
+		case 0b001:
+			if inst.funct7 == 0b0000000 {
+				c.debugf("slliw rd, rs1=%d, shamt=%d", c.xregs[rs1], shamt)
+				c.xregs[rd] = c.computeWord(alu.SLL, c.xregs[rs1], shamt)
+				return nil
+			}
+		case 0b101:
+			switch inst.funct7 {
+			case 0b0000000:
+				c.debugf("srliw rd, rs1=%d, shamt=%d", c.xregs[rs1], shamt)
+				c.xregs[rd] = c.computeWord(alu.SRL, c.xregs[rs1], shamt)
+				return nil
+			case 0b0100000:
+				c.debugf("sraiw rd, rs1=%d, shamt=%d", c.xregs[rs1], shamt)
+				c.xregs[rd] = c.computeWord(alu.SRA, c.xregs[rs1], shamt)
+				return nil
+			}
 		}
 	case OPREG:
+		if inst.funct7 == 0b0000001 && c.has(ExtensionM) {
+			return c.executeMulDiv(inst)
+		}
 		switch inst.funct3 {
 		case 0b000:
 			switch inst.funct7 {
 			case 0b0000000:
 				c.debugf("add rd, rs1=%d, rs2=%d", c.xregs[rs1], c.xregs[rs2])
-				c.xregs[rd] = alu.Compute(alu.ADD, c.xregs[rs1], c.xregs[rs2])
-				return
+				c.xregs[rd] = c.compute(alu.ADD, c.xregs[rs1], c.xregs[rs2])
+				return nil
 			case 0b0100000:
 				c.debugf("sub rd, rs1=%d, rs2=%d", c.xregs[rs1], c.xregs[rs2])
-				c.xregs[rd] = alu.Compute(alu.SUB, c.xregs[rs1], c.xregs[rs2])
-				return
+				c.xregs[rd] = c.compute(alu.SUB, c.xregs[rs1], c.xregs[rs2])
+				return nil
 			}
 		case 0b001:
 			c.debugf("sll rd, rs1=%d, rs2=%d", c.xregs[rs1], c.xregs[rs2])
-			c.xregs[rd] = alu.Compute(alu.SLL, c.xregs[rs1], c.xregs[rs2])
-			return
+			c.xregs[rd] = c.compute(alu.SLL, c.xregs[rs1], c.xregs[rs2])
+			return nil
 		case 0b010:
 			c.debugf("slt rd, rs1=%d, rs2=%d", c.xregs[rs1], c.xregs[rs2])
-			c.xregs[rd] = alu.Compute(alu.SLT, c.xregs[rs1], c.xregs[rs2])
-			return
+			c.xregs[rd] = c.compute(alu.SLT, c.xregs[rs1], c.xregs[rs2])
+			return nil
 		case 0b011:
 			c.debugf("sltu rd, rs1=%d, rs2=%d", c.xregs[rs1], c.xregs[rs2])
-			c.xregs[rd] = alu.Compute(alu.SLTU, c.xregs[rs1], c.xregs[rs2])
-			return
+			c.xregs[rd] = c.compute(alu.SLTU, c.xregs[rs1], c.xregs[rs2])
+			return nil
 		case 0b100:
 			c.debugf("xor rd, rs1=%d, rs2=%d", c.xregs[rs1], c.xregs[rs2])
-			c.xregs[rd] = alu.Compute(alu.XOR, c.xregs[rs1], c.xregs[rs2])
-			return
+			c.xregs[rd] = c.compute(alu.XOR, c.xregs[rs1], c.xregs[rs2])
+			return nil
 		case 0b101:
 			switch inst.funct7 {
 			case 0b0000000:
 				c.debugf("srl rd, rs1=%d, rs2=%d", c.xregs[rs1], c.xregs[rs2])
-				c.xregs[rd] = alu.Compute(alu.SRL, c.xregs[rs1], c.xregs[rs2])
-				return
+				c.xregs[rd] = c.compute(alu.SRL, c.xregs[rs1], c.xregs[rs2])
+				return nil
 			case 0b0100000:
 				c.debugf("sra rd, rs1=%d, rs2=%d", c.xregs[rs1], c.xregs[rs2])
-				c.xregs[rd] = alu.Compute(alu.SRA, c.xregs[rs1], c.xregs[rs2])
-				return
+				c.xregs[rd] = c.compute(alu.SRA, c.xregs[rs1], c.xregs[rs2])
+				return nil
 			}
 		case 0b110:
 			c.debugf("or rd, rs1=%d, rs2=%d", c.xregs[rs1], c.xregs[rs2])
-			c.xregs[rd] = alu.Compute(alu.OR, c.xregs[rs1], c.xregs[rs2])
-			return
+			c.xregs[rd] = c.compute(alu.OR, c.xregs[rs1], c.xregs[rs2])
+			return nil
 		case 0b111:
 			c.debugf("and rd, rs1=%d, rs2=%d", c.xregs[rs1], c.xregs[rs2])
-			c.xregs[rd] = alu.Compute(alu.AND, c.xregs[rs1], c.xregs[rs2])
-			return
+			c.xregs[rd] = c.compute(alu.AND, c.xregs[rs1], c.xregs[rs2])
+			return nil
+		}
+	case OPREG32:
+		if !rv64 {
+			break
+		}
+		if inst.funct7 == 0b0000001 && c.has(ExtensionM) {
+			return c.executeMulDivWord(inst)
+		}
+		switch inst.funct3 {
+		case 0b000:
+			switch inst.funct7 {
+			case 0b0000000:
+				c.debugf("addw rd, rs1=%d, rs2=%d", c.xregs[rs1], c.xregs[rs2])
+				c.xregs[rd] = c.computeWord(alu.ADD, c.xregs[rs1], c.xregs[rs2])
+				return nil
+			case 0b0100000:
+				c.debugf("subw rd, rs1=%d, rs2=%d", c.xregs[rs1], c.xregs[rs2])
+				c.xregs[rd] = c.computeWord(alu.SUB, c.xregs[rs1], c.xregs[rs2])
+				return nil
+			}
+		case 0b001:
+			if inst.funct7 == 0b0000000 {
+				c.debugf("sllw rd, rs1=%d, rs2=%d", c.xregs[rs1], c.xregs[rs2])
+				c.xregs[rd] = c.computeWord(alu.SLL, c.xregs[rs1], c.xregs[rs2])
+				return nil
+			}
+		case 0b101:
+			switch inst.funct7 {
+			case 0b0000000:
+				c.debugf("srlw rd, rs1=%d, rs2=%d", c.xregs[rs1], c.xregs[rs2])
+				c.xregs[rd] = c.computeWord(alu.SRL, c.xregs[rs1], c.xregs[rs2])
+				return nil
+			case 0b0100000:
+				c.debugf("sraw rd, rs1=%d, rs2=%d", c.xregs[rs1], c.xregs[rs2])
+				c.xregs[rd] = c.computeWord(alu.SRA, c.xregs[rs1], c.xregs[rs2])
+				return nil
+			}
 		}
 	case OPAUIPC:
 		c.debugf("auipc rd, imm=%d", inst.imm)
-		c.xregs[rd] = alu.Compute(alu.ADD, c.pc, inst.imm)
-		return
+		c.xregs[rd] = c.compute(alu.ADD, c.pc, inst.imm)
+		return nil
 	case OPLUI:
 		c.debugf("lui rd, imm=%d", inst.imm)
-		c.xregs[rd] = inst.imm
-		return
+		c.xregs[rd] = c.truncate(inst.imm)
+		return nil
 	case OPJAL:
 		c.debugf("jal rd, offset=%d", inst.imm)
-		c.xregs[rd] = c.pc + 4
-		c.pc += inst.imm
-		return
+		t := c.nextpc // the address of the following instruction
+		if err := c.jump(c.pc + inst.imm); err != nil {
+			return err
+		}
+		c.xregs[rd] = t
+		return nil
 	case OPJALR:
 		c.debugf("jalr rd, rs1=%d, offset=%d", c.xregs[rs1], inst.imm)
-		t := c.pc + 4
+		t := c.nextpc // the address of the following instruction
+		if err := c.jump((c.xregs[rs1] + inst.imm) &^ 1); err != nil {
+			return err
+		}
 		c.xregs[rd] = t
-		c.pc = (c.xregs[rs1] + inst.imm) &^ 1
-		return
+		return nil
 	case OPBRANCH:
 		switch inst.funct3 {
 		case 0b000:
 			c.debugf("beq rs1=%d, rs2=%d, offset=%d", c.xregs[rs1], c.xregs[rs2], inst.imm)
-			if branch.Comparator(branch.EQ, c.xregs[rs1], c.xregs[rs2]) {
-				c.pc += inst.imm
+			if c.compare(branch.EQ, c.xregs[rs1], c.xregs[rs2]) {
+				return c.jump(c.pc + inst.imm)
 			}
-			return
+			return nil
 		case 0b001:
 			c.debugf("bne rs1=%d, rs2=%d, offset=%d", c.xregs[rs1], c.xregs[rs2], inst.imm)
-			if branch.Comparator(branch.NE, c.xregs[rs1], c.xregs[rs2]) {
-				c.pc += inst.imm
+			if c.compare(branch.NE, c.xregs[rs1], c.xregs[rs2]) {
+				return c.jump(c.pc + inst.imm)
 			}
-			return
+			return nil
 		case 0b100:
 			c.debugf("blt rs1=%d, rs2=%d, offset=%d", c.xregs[rs1], c.xregs[rs2], inst.imm)
-			if branch.Comparator(branch.LT, c.xregs[rs1], c.xregs[rs2]) {
-				c.pc += inst.imm
+			if c.compare(branch.LT, c.xregs[rs1], c.xregs[rs2]) {
+				return c.jump(c.pc + inst.imm)
 			}
-			return
+			return nil
 		case 0b101:
 			c.debugf("bge rs1=%d, rs2=%d, offset=%d", c.xregs[rs1], c.xregs[rs2], inst.imm)
-			if branch.Comparator(branch.GE, c.xregs[rs1], c.xregs[rs2]) {
-				c.pc += inst.imm
+			if c.compare(branch.GE, c.xregs[rs1], c.xregs[rs2]) {
+				return c.jump(c.pc + inst.imm)
 			}
-			return
+			return nil
 		case 0b110:
 			c.debugf("bltu rs1=%d, rs2=%d, offset=%d", c.xregs[rs1], c.xregs[rs2], inst.imm)
-			if branch.Comparator(branch.LTU, c.xregs[rs1], c.xregs[rs2]) {
-				c.pc += inst.imm
+			if c.compare(branch.LTU, c.xregs[rs1], c.xregs[rs2]) {
+				return c.jump(c.pc + inst.imm)
 			}
-			return
+			return nil
 		case 0b111:
 			c.debugf("bgeu rs1=%d, rs2=%d, offset=%d", c.xregs[rs1], c.xregs[rs2], inst.imm)
-			if branch.Comparator(branch.GEU, c.xregs[rs1], c.xregs[rs2]) {
-				c.pc += inst.imm
+			if c.compare(branch.GEU, c.xregs[rs1], c.xregs[rs2]) {
+				return c.jump(c.pc + inst.imm)
 			}
-			return
+			return nil
 		}
 	case OPLOAD:
+		addr := c.truncate(c.xregs[rs1] + inst.imm)
+		switch inst.funct3 {
+		case 0b000:
+			c.debugf("lb rd, offset=%d(rs1=%d)", inst.imm, c.xregs[rs1])
+			v, err := c.load(addr, 1)
+			if err != nil {
+				return err
+			}
+			c.xregs[rd] = c.word(SignedExtend(uint32(v), 8))
+			return nil
+		case 0b001:
+			c.debugf("lh rd, offset=%d(rs1=%d)", inst.imm, c.xregs[rs1])
+			v, err := c.load(addr, 2)
+			if err != nil {
+				return err
+			}
+			c.xregs[rd] = c.word(SignedExtend(uint32(v), 16))
+			return nil
+		case 0b010:
+			c.debugf("lw rd, offset=%d(rs1=%d)", inst.imm, c.xregs[rs1])
+			v, err := c.load(addr, 4)
+			if err != nil {
+				return err
+			}
+			c.xregs[rd] = c.word(uint32(v))
+			return nil
+		case 0b011:
+			if !rv64 {
+				break
+			}
+			c.debugf("ld rd, offset=%d(rs1=%d)", inst.imm, c.xregs[rs1])
+			v, err := c.load(addr, 8)
+			if err != nil {
+				return err
+			}
+			c.xregs[rd] = v
+			return nil
+		case 0b100:
+			c.debugf("lbu rd, offset=%d(rs1=%d)", inst.imm, c.xregs[rs1])
+			v, err := c.load(addr, 1)
+			if err != nil {
+				return err
+			}
+			c.xregs[rd] = v
+			return nil
+		case 0b101:
+			c.debugf("lhu rd, offset=%d(rs1=%d)", inst.imm, c.xregs[rs1])
+			v, err := c.load(addr, 2)
+			if err != nil {
+				return err
+			}
+			c.xregs[rd] = v
+			return nil
+		case 0b110:
+			if !rv64 {
+				break
+			}
+			c.debugf("lwu rd, offset=%d(rs1=%d)", inst.imm, c.xregs[rs1])
+			v, err := c.load(addr, 4)
+			if err != nil {
+				return err
+			}
+			c.xregs[rd] = v
+			return nil
+		}
 	case OPSTORE:
+		addr := c.truncate(c.xregs[rs1] + inst.imm)
+		switch inst.funct3 {
+		case 0b000:
+			c.debugf("sb rs2=%d, offset=%d(rs1=%d)", c.xregs[rs2], inst.imm, c.xregs[rs1])
+			return c.store(addr, 1, c.xregs[rs2])
+		case 0b001:
+			c.debugf("sh rs2=%d, offset=%d(rs1=%d)", c.xregs[rs2], inst.imm, c.xregs[rs1])
+			return c.store(addr, 2, c.xregs[rs2])
+		case 0b010:
+			c.debugf("sw rs2=%d, offset=%d(rs1=%d)", c.xregs[rs2], inst.imm, c.xregs[rs1])
+			return c.store(addr, 4, c.xregs[rs2])
+		case 0b011:
+			if !rv64 {
+				break
+			}
+			c.debugf("sd rs2=%d, offset=%d(rs1=%d)", c.xregs[rs2], inst.imm, c.xregs[rs1])
+			return c.store(addr, 8, c.xregs[rs2])
+		}
+	case OPMISCMEM:
+		switch inst.funct3 {
+		case 0b000:
+			// All memory accesses are performed in program order,
+			// so FENCE does nothing.
+			c.debugf("fence")
+			return nil
+		case 0b001:
+			// Instructions are always fetched from the memory,
+			// so FENCE.I does nothing.
+			c.debugf("fence.i")
+			return nil
+		}
+	case OPAMO:
+		if c.has(ExtensionA) && (inst.funct3 == 0b010 || inst.funct3 == 0b011 && rv64) {
+			return c.executeAtomic(inst)
+		}
+	case OPLOADFP, OPSTOREFP, OPMADD, OPMSUB, OPNMSUB, OPNMADD, OPFP:
+		if c.has(ExtensionF) {
+			return c.executeFloat(inst)
+		}
 	case OPSYSTEM:
+		switch inst.funct3 {
+		case 0b000:
+			if inst.funct7 == 0b0001001 && rd == 0 {
+				// SFENCE.VMA is illegal in U-mode, and in S-mode if TVM is set.
+				if !c.has(ExtensionS) || c.mode == UserMode ||
+					c.mode == SupervisorMode && c.csrs.Get(CSRMstatus)&mstatusTVM != 0 {
+					break
+				}
+				c.debugf("sfence.vma rs1=%d, rs2=%d", c.xregs[rs1], c.xregs[rs2])
+				c.sfenceVMA(rs1, rs2)
+				return nil
+			}
+			switch inst.imm {
+			case 0b000000000000:
+				c.debugf("ecall")
+				return c.callEnvironment(ECALL)
+			case 0b000000000001:
+				c.debugf("ebreak")
+				return c.callEnvironment(EBREAK)
+			case 0b000100000010:
+				// SRET is illegal in U-mode, and in S-mode if TSR is set.
+				if !c.has(ExtensionS) || c.mode == UserMode ||
+					c.mode == SupervisorMode && c.csrs.Get(CSRMstatus)&mstatusTSR != 0 {
+					break
+				}
+				c.debugf("sret")
+				c.sret()
+				return nil
+			case 0b001100000010:
+				if c.mode != MachineMode {
+					break
+				}
+				c.debugf("mret")
+				c.mret()
+				return nil
+			case 0b000100000101:
+				// WFI is illegal in U-mode, and in S-mode if TW is set.
+				// Otherwise it is implemented as NOP because it is a hint.
+				//
+				// see: 3.3.3 Wait for Interrupt
+				if c.mode == UserMode && c.has(ExtensionS) ||
+					c.mode != MachineMode && c.csrs.Get(CSRMstatus)&mstatusTW != 0 {
+					break
+				}
+				c.debugf("wfi")
+				return nil
+			}
+		case 0b001:
+			c.debugf("csrrw rd, csr=0x%03x, rs1=%d", inst.csr(), c.xregs[rs1])
+			return c.accessCSR(inst, rd != 0, true, func(uint64) uint64 {
+				return c.xregs[rs1]
+			})
+		case 0b010:
+			c.debugf("csrrs rd, csr=0x%03x, rs1=%d", inst.csr(), c.xregs[rs1])
+			return c.accessCSR(inst, true, rs1 != 0, func(v uint64) uint64 {
+				return v | c.xregs[rs1]
+			})
+		case 0b011:
+			c.debugf("csrrc rd, csr=0x%03x, rs1=%d", inst.csr(), c.xregs[rs1])
+			return c.accessCSR(inst, true, rs1 != 0, func(v uint64) uint64 {
+				return v &^ c.xregs[rs1]
+			})
+		case 0b101:
+			c.debugf("csrrwi rd, csr=0x%03x, uimm=%d", inst.csr(), rs1)
+			return c.accessCSR(inst, rd != 0, true, func(uint64) uint64 {
+				return uint64(rs1)
+			})
+		case 0b110:
+			c.debugf("csrrsi rd, csr=0x%03x, uimm=%d", inst.csr(), rs1)
+			return c.accessCSR(inst, true, rs1 != 0, func(v uint64) uint64 {
+				return v | uint64(rs1)
+			})
+		case 0b111:
+			c.debugf("csrrci rd, csr=0x%03x, uimm=%d", inst.csr(), rs1)
+			return c.accessCSR(inst, true, rs1 != 0, func(v uint64) uint64 {
+				return v &^ uint64(rs1)
+			})
+		}
+	}
+	return &Exception{Code: IllegalInstruction, Value: uint64(inst.raw)}
+}
+
+// mulDivOp returns the ALU operation of the instructions in "M" Standard Extension.
+func mulDivOp(funct3 uint32) string {
+	switch funct3 {
+	case 0b000:
+		return alu.MUL
+	case 0b001:
+		return alu.MULH
+	case 0b010:
+		return alu.MULHSU
+	case 0b011:
+		return alu.MULHU
+	case 0b100:
+		return alu.DIV
+	case 0b101:
+		return alu.DIVU
+	case 0b110:
+		return alu.REM
+	}
+	return alu.REMU
+}
+
+// executeMulDiv executes the instructions in "M" Standard Extension for Integer Multiplication and Division.
+//
+// see: Chapter 7 "M" Standard Extension for Integer Multiplication and Division, Version 2.0
+func (c *CPU) executeMulDiv(inst *Instruction) error {
+	rd, rs1, rs2 := inst.rd, inst.rs1, inst.rs2
+	op := mulDivOp(inst.funct3)
+	c.debugf("%s rd, rs1=%d, rs2=%d", op, c.xregs[rs1], c.xregs[rs2])
+	c.xregs[rd] = c.compute(op, c.xregs[rs1], c.xregs[rs2])
+	return nil
+}
+
+// executeMulDivWord executes MULW, DIVW, DIVUW, REMW and REMUW in RV64.
+// They operate on the lower 32 bits and sign-extend the 32-bit result.
+//
+// see: 7.1 Multiplication Operations
+// see: 7.2 Division Operations
+func (c *CPU) executeMulDivWord(inst *Instruction) error {
+	rd, rs1, rs2 := inst.rd, inst.rs1, inst.rs2
+	switch inst.funct3 {
+	case 0b000, 0b100, 0b101, 0b110, 0b111:
+	default:
+		return &Exception{Code: IllegalInstruction, Value: uint64(inst.raw)}
+	}
+	op := mulDivOp(inst.funct3)
+	c.debugf("%sw rd, rs1=%d, rs2=%d", op, c.xregs[rs1], c.xregs[rs2])
+	c.xregs[rd] = c.computeWord(op, c.xregs[rs1], c.xregs[rs2])
+	return nil
+}
+
+// executeAtomic executes the instructions in "A" Standard Extension for Atomic Instructions.
+//
+// The aq and rl bits are ignored because all memory accesses are performed in program order.
+// The doubleword instructions (*.D) are only available in RV64.
+//
+// see: Chapter 8 "A" Standard Extension for Atomic Instructions, Version 2.1
+func (c *CPU) executeAtomic(inst *Instruction) error {
+	rd, rs1, rs2 := inst.rd, inst.rs1, inst.rs2
+	addr := c.xregs[rs1]
+	src := c.xregs[rs2]
+
+	// The operations are performed on the words (32 bits) or the doublewords (64 bits).
+	var (
+		size    uint64 = 4
+		suffix         = ".w"
+		result         = func(v uint64) uint64 { return c.word(uint32(v)) }
+		compute        = func(op string, a, b uint64) uint64 { return uint64(alu.Compute(op, uint32(a), uint32(b))) }
+		compare        = func(cmp string, a, b uint64) bool { return branch.Comparator(cmp, uint32(a), uint32(b)) }
+	)
+	if inst.funct3 == 0b011 {
+		size = 8
+		suffix = ".d"
+		result = func(v uint64) uint64 { return v }
+		compute = alu.Compute64
+		compare = branch.Comparator64
+	}
+
+	funct5 := inst.funct7 >> 2
+	switch funct5 {
+	case 0b00010:
+		if rs2 != 0 {
+			break
+		}
+		c.debugf("lr%s rd, (rs1=%d)", suffix, addr)
+		if addr%size != 0 {
+			return &Exception{Code: LoadAddressMisaligned, Value: addr}
+		}
+		paddr, err := c.physicalAddr(addr, size, accessLoad)
+		if err != nil {
+			return err
+		}
+		v, err := c.bus.LoadReserved(c.reservation, paddr, size)
+		if err != nil {
+			c.debugf("%v", err)
+			return &Exception{Code: LoadAccessFault, Value: addr}
+		}
+		c.xregs[rd] = result(v)
+		return nil
+	case 0b00011:
+		c.debugf("sc%s rd, rs2=%d, (rs1=%d)", suffix, src, addr)
+		if addr%size != 0 {
+			return &Exception{Code: StoreAMOAddressMisaligned, Value: addr}
+		}
+		paddr, err := c.physicalAddr(addr, size, accessStore)
+		if err != nil {
+			return err
+		}
+		ok, err := c.bus.StoreConditional(c.reservation, paddr, size, src)
+		if err != nil {
+			c.debugf("%v", err)
+			return &Exception{Code: StoreAMOAccessFault, Value: addr}
+		}
+		if ok {
+			c.xregs[rd] = 0
+		} else {
+			c.xregs[rd] = 1
+		}
+		return nil
+	}
+
+	var (
+		name   string
+		modify func(old uint64) uint64
+	)
+	switch funct5 {
+	case 0b00001:
+		name = "amoswap"
+		modify = func(uint64) uint64 { return src }
+	case 0b00000:
+		name = "amoadd"
+		modify = func(old uint64) uint64 { return compute(alu.ADD, old, src) }
+	case 0b00100:
+		name = "amoxor"
+		modify = func(old uint64) uint64 { return compute(alu.XOR, old, src) }
+	case 0b01100:
+		name = "amoand"
+		modify = func(old uint64) uint64 { return compute(alu.AND, old, src) }
+	case 0b01000:
+		name = "amoor"
+		modify = func(old uint64) uint64 { return compute(alu.OR, old, src) }
+	case 0b10000:
+		name = "amomin"
+		modify = func(old uint64) uint64 {
+			if compare(branch.LT, old, src) {
+				return old
+			}
+			return src
+		}
+	case 0b10100:
+		name = "amomax"
+		modify = func(old uint64) uint64 {
+			if compare(branch.GE, old, src) {
+				return old
+			}
+			return src
+		}
+	case 0b11000:
+		name = "amominu"
+		modify = func(old uint64) uint64 {
+			if compare(branch.LTU, old, src) {
+				return old
+			}
+			return src
+		}
+	case 0b11100:
+		name = "amomaxu"
+		modify = func(old uint64) uint64 {
+			if compare(branch.GEU, old, src) {
+				return old
+			}
+			return src
+		}
+	default:
+		return &Exception{Code: IllegalInstruction, Value: uint64(inst.raw)}
+	}
+	c.debugf("%s%s rd, rs2=%d, (rs1=%d)", name, suffix, src, addr)
+	if addr%size != 0 {
+		return &Exception{Code: StoreAMOAddressMisaligned, Value: addr}
+	}
+	// AMOs are translated as stores. The writable pages are always readable.
+	paddr, err := c.physicalAddr(addr, size, accessStore)
+	if err != nil {
+		return err
+	}
+	old, err := c.bus.AtomicModify(paddr, size, modify)
+	if err != nil {
+		c.debugf("%v", err)
+		return &Exception{Code: StoreAMOAccessFault, Value: addr}
+	}
+	c.xregs[rd] = result(old)
+	return nil
+}
+
+// jump changes the address of the next instruction to target.
+// The target address must be aligned on a four-byte boundary, or a two-byte
+// boundary if "C" extension is enabled.
+//
+// see: 2.5 Control Transfer Instructions
+func (c *CPU) jump(target uint64) error {
+	target = c.truncate(target)
+	if target%c.ialign() != 0 {
+		return &Exception{Code: InstructionAddressMisaligned, Value: target}
+	}
+	c.nextpc = target
+	return nil
+}
+
+// callEnvironment passes the control to the environment handler.
+func (c *CPU) callEnvironment(call EnvironmentCall) error {
+	result := EnvironmentResult{Action: EnvironmentTrap}
+	if c.env != nil {
+		result = c.env.HandleEnvironmentCall(call, &c.xregs, c.bus)
+		// The handler may write the values which are wider than XLEN.
+		for i, v := range c.xregs {
+			c.xregs[i] = c.truncate(v)
+		}
+	}
+	switch result.Action {
+	case EnvironmentContinue:
+		return nil
+	case EnvironmentHalt:
+		c.halted = true
+		c.exitCode = result.ExitCode
+		return nil
 	}
-	panic(fmt.Sprintf("unimplemented opcode: %d", inst.opcode))
+	if call == EBREAK {
+		return &Exception{Code: Breakpoint, Value: c.pc}
+	}
+	// The exception codes are 8, 9 and 11 for U-mode, S-mode and M-mode.
+	return &Exception{Code: EnvironmentCallFromUMode + ExceptionCode(c.mode)}
+}
+
+// accessCSR reads and writes the CSR atomically for Zicsr instructions.
+//
+// If read is false, the CSR is not read and no read side effects are caused.
+// If write is false, the CSR is not written. In this case, read-only CSR can be accessed.
+// The old value of the CSR is written to rd.
+//
+// The access to the CSR which requires the higher privilege level raises an illegal instruction.
+//
+// see: 9.1 CSR Instructions
+// see: 2.1 CSR Address Mapping Conventions (The RISC-V Instruction Set Manual Volume II: Privileged Architecture)
+func (c *CPU) accessCSR(inst *Instruction, read, write bool, newValue func(old uint64) uint64) error {
+	addr := inst.csr()
+	illegal := &Exception{Code: IllegalInstruction, Value: uint64(inst.raw)}
+	if !c.csrs.Exists(addr) || c.mode < csrPrivilege(addr) {
+		return illegal
+	}
+	// satp can not be accessed in S-mode if TVM is set.
+	if addr == CSRSatp && c.mode == SupervisorMode && c.csrs.Get(CSRMstatus)&mstatusTVM != 0 {
+		return illegal
+	}
+	if write && isReadOnlyCSR(addr) {
+		return illegal
+	}
+	if isFloatCSR(addr) && c.csrs.Get(CSRMstatus)&mstatusFS == fsOff {
+		return illegal
+	}
+	var old uint64
+	if read {
+		v, err := c.csrs.Read(addr)
+		if err != nil {
+			return illegal
+		}
+		old = v
+	}
+	if write {
+		// The read-modify-write of mip uses SEIP written by the software instead of
+		// the one read from mip, so SEIP asserted by the interrupt controller is not latched.
+		//
+		// see: 3.1.9 Machine Interrupt Registers (mip and mie)
+		in := old
+		if addr == CSRMip {
+			in = old&^mipSEIP | c.seip
+		}
+		if err := c.csrs.Write(addr, newValue(in)); err != nil {
+			return illegal
+		}
+		if isFloatCSR(addr) {
+			c.dirtyFloat()
+		}
+	}
+	c.xregs[inst.rd] = old
+	return nil
+}
+
+// load reads size bytes from the virtual address addr through the bus.
+// The address must be naturally aligned, otherwise a load address misaligned is returned
+// unless the misaligned access is enabled.
+// If no device is mapped to the address, a load access fault is returned.
+func (c *CPU) load(addr, size uint64) (uint64, error) {
+	if addr%size != 0 {
+		if !c.misaligned {
+			return 0, &Exception{Code: LoadAddressMisaligned, Value: addr}
+		}
+		var v uint64
+		for i := uint64(0); i < size; i++ {
+			b, err := c.load(c.truncate(addr+i), 1)
+			if err != nil {
+				return 0, err
+			}
+			v |= b << (8 * i)
+		}
+		return v, nil
+	}
+	paddr, err := c.physicalAddr(addr, size, accessLoad)
+	if err != nil {
+		return 0, err
+	}
+	v, err := c.bus.Read(paddr, size)
+	if err != nil {
+		c.debugf("%v", err)
+		return 0, &Exception{Code: LoadAccessFault, Value: addr}
+	}
+	return v, nil
+}
+
+// store writes the lower size bytes of value to the virtual address addr through the bus.
+// The address must be naturally aligned, otherwise a store/AMO address misaligned is returned
+// unless the misaligned access is enabled.
+// If no device is mapped to the address, a store/AMO access fault is returned.
+func (c *CPU) store(addr, size, value uint64) error {
+	if addr%size != 0 {
+		if !c.misaligned {
+			return &Exception{Code: StoreAMOAddressMisaligned, Value: addr}
+		}
+		// All bytes are translated first, so nothing is written if the access
+		// crosses into the page which is not writable.
+		paddrs := make([]uint64, size)
+		for i := range paddrs {
+			vaddr := c.truncate(addr + uint64(i))
+			paddr, err := c.physicalAddr(vaddr, 1, accessStore)
+			if err != nil {
+				return err
+			}
+			paddrs[i] = paddr
+		}
+		for i, paddr := range paddrs {
+			if err := c.bus.Write(paddr, 1, value>>(8*i)&0xff); err != nil {
+				c.debugf("%v", err)
+				return &Exception{Code: StoreAMOAccessFault, Value: c.truncate(addr + uint64(i))}
+			}
+		}
+		return nil
+	}
+	paddr, err := c.physicalAddr(addr, size, accessStore)
+	if err != nil {
+		return err
+	}
+	if err := c.bus.Write(paddr, size, value); err != nil {
+		c.debugf("%v", err)
+		return &Exception{Code: StoreAMOAccessFault, Value: addr}
+	}
+	return nil
 }
 
 func (c *CPU) DumpRegisters() {
@@ -271,12 +1321,13 @@ func (c *CPU) DumpRegisters() {
 		"Binary",
 	})
 
+	digits := int(c.xlen)
 	for i, xreg := range c.xregs {
 		table.Append([]string{
 			xregsABINames[i],
-			strconv.FormatUint(uint64(xreg), 10),
-			fmt.Sprintf("0x%08x", xreg),
-			fmt.Sprintf("0b%032b", xreg),
+			strconv.FormatUint(xreg, 10),
+			fmt.Sprintf("0x%0*x", digits/4, xreg),
+			fmt.Sprintf("0b%0*b", digits, xreg),
 		})
 	}
 	table.Render()
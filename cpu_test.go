@@ -13,17 +13,259 @@ import (
 func TestCPU(t *testing.T) {
 	cases := []struct {
 		name      string
-		wantXregs [32]uint32
+		opts      []Option
+		wantXregs [32]uint64
 	}{
 		{
 			name: "add-addi",
-			wantXregs: [32]uint32{
+			wantXregs: [32]uint64{
 				2:  dramStartAddress + dramSize,
 				29: 5,
 				30: 37,
 				31: 42,
 			},
 		},
+		{
+			name: "store-load",
+			wantXregs: [32]uint64{
+				1: dramStartAddress,
+				2: 0xfffffff0,
+				3: 0xfffffff0,
+				4: 0x7f,
+				5: 0xffff7ff0,
+				6: 0x00007ff0,
+				7: 0xfffffff0,
+				8: 0x7ff0,
+			},
+		},
+		{
+			name: "csr",
+			wantXregs: [32]uint64{
+				1:  0x4014112d, // misa: RV32IMAFDCSU
+				2:  0,
+				3:  0x12345678,
+				4:  0x12345678,
+				5:  0x12345678,
+				6:  0xff,
+				7:  0x12345600,
+				8:  0x1234561f,
+				9:  0x1234561c,
+				10: 5,
+				11: 0xffffffff,
+				12: 0xaaa,
+				13: 0x807e79aa, // FS is Dirty and SD is set.
+				14: 0xfffffffc,
+				15: 0xfffffffe, // mepc[0] is always zero.
+				16: 0,
+			},
+		},
+		{
+			name: "mul-div",
+			wantXregs: [32]uint64{
+				1:  0xfffffff9, // -7
+				2:  3,
+				3:  0x80000000,
+				4:  0xffffffff, // -1
+				5:  0xffffffeb, // -21
+				6:  0x40000000,
+				7:  0xffffffff,
+				8:  0xfffffffe,
+				9:  0xfffffffe, // -2
+				10: 0x55555553,
+				11: 0xffffffff, // -1
+				12: 0,
+				13: 0xffffffff, // division by zero
+				14: 0xffffffff,
+				15: 0xfffffff9,
+				16: 0xfffffff9,
+				17: 0x80000000, // overflow
+				18: 0,
+			},
+		},
+		{
+			name: "atomic",
+			wantXregs: [32]uint64{
+				2:  dramStartAddress + dramSize,
+				5:  1,
+				6:  0x0f,
+				8:  dramStartAddress + 4, // lock
+				9:  dramStartAddress + 8, // counter
+				10: 0,
+				11: 0, // sc.w succeeded
+				12: 1, // sc.w failed
+				13: 5,
+				14: 1, // sc.w failed
+				18: 1,
+				19: 0,
+				20: 10,
+				21: 0xfffffffd, // -3
+				22: 7,
+				23: 7,
+				24: 0xffffffff,
+				25: 0xf0,
+				26: 0xff,
+				27: 0xf0,
+			},
+		},
+		{
+			name: "float",
+			wantXregs: [32]uint64{
+				2:  dramStartAddress + dramSize,
+				5:  1,
+				6:  3,
+				8:  dramStartAddress + 8, // data
+				9:  1,
+				10: 0x40900000, // 4.5
+				11: 1,
+				12: 2,
+				13: 0x1,  // NX
+				14: 0x40, // positive normal number
+				15: 5,
+				16: 0,
+				17: 0x40150000, // 5.25
+				18: 0xc0a80000, // -5.25
+				19: 0x80007800,
+				20: 0,
+				21: 0x10, // NV
+			},
+		},
+		{
+			name: "compressed",
+			wantXregs: [32]uint64{
+				1:  dramStartAddress + 0x8e,
+				2:  dramStartAddress + 0x30, // stack_top
+				5:  100,
+				6:  6,
+				7:  dramStartAddress + 0x8e,
+				8:  6,
+				9:  13,
+				10: 6,
+				11: 9,
+				12: 12,
+				13: 0,
+				14: 0x1000,
+				15: dramStartAddress + 0x18,
+			},
+		},
+		{
+			name: "privilege",
+			wantXregs: [32]uint64{
+				2:  dramStartAddress + dramSize,
+				5:  dramStartAddress + 0x60,
+				6:  dramStartAddress + 0x98,
+				8:  0x2002, // sstatus: SIE is set and FS is Initial.
+				9:  0,      // mstatus can not be read in U-mode.
+				18: 2,
+				19: 0x29, // illegal instruction, environment call from S-mode
+				20: 8,    // environment call from U-mode
+				21: 0x2000,
+				22: dramStartAddress + 0x64,
+			},
+		},
+		{
+			name: "sv32",
+			wantXregs: [32]uint64{
+				2:  dramStartAddress + dramSize,
+				5:  0x40003000,
+				6:  0xc0000000,
+				7:  0x9abcdef0,
+				8:  0x12345678,
+				9:  0x12345678,
+				18: 0xfd, // store/AMO page fault, load page fault
+				19: 0xc0001000,
+				20: 0x9abcdef0,
+				21: 0,
+				22: 0x20000cc7, // A and D are set.
+				28: 0xc0001000,
+				29: dramStartAddress + 0x2000, // l2
+				30: dramStartAddress + 0x3094,
+			},
+		},
+		{
+			name: "clint",
+			opts: []Option{WithDevices(NewCLINT())},
+			wantXregs: [32]uint64{
+				2:  dramStartAddress + dramSize,
+				5:  0x2000000,
+				6:  0xffffffff,
+				7:  1 << 3,
+				8:  2,
+				9:  0x0703, // machine timer interrupt, machine software interrupt
+				10: 107,    // mtime is 7 when it is read.
+				11: 3,
+				18: 0x80000003,
+				19: 1,
+			},
+		},
+		{
+			name: "plic",
+			opts: []Option{WithDevices(newPLICWithLines(1, 2))},
+			wantXregs: [32]uint64{
+				2:  dramStartAddress + dramSize,
+				5:  0xc001000,
+				6:  2,
+				7:  0xc002000,
+				8:  2,
+				9:  0x21, // source 2 has the higher priority.
+				10: 1,
+				18: 0,
+				19: 0b110, // the lines are still asserted.
+				29: 0xfffffffd,
+			},
+		},
+		{
+			name: "virtio-blk",
+			opts: []Option{WithDevices(NewVirtioMMIO(0, NewVirtioBlock(memDisk(append([]byte("RISC-V disk"), make([]byte, 501)...)), 512), nil))},
+			wantXregs: [32]uint64{
+				2:  dramStartAddress + dramSize,
+				5:  dramStartAddress + 0x3c0, // status
+				6:  dramStartAddress + 0x180, // used
+				8:  0x10001000,
+				9:  0x74726976, // magic
+				10: 0x43534952, // "RISC"
+				11: 0,          // VIRTIO_BLK_S_OK
+				12: 1,          // used buffer notification
+				13: 0,
+				18: 2,  // block device
+				19: 11, // FEATURES_OK
+			},
+		},
+		{
+			name: "rv64",
+			opts: []Option{WithXLEN(XLEN64)},
+			wantXregs: [32]uint64{
+				1:  0xffffffffffffffff, // sc.d succeeded
+				2:  dramStartAddress + dramSize,
+				3:  0x80000000,
+				4:  0x41e0000000000000, // 2147483648.0
+				5:  7,
+				6:  dramStartAddress + 0x10,
+				7:  5,
+				8:  dramStartAddress + 0x8, // data
+				10: 0xffffffffffffffff,
+				11: 0x7fffffff,
+				12: 0xffffffff80000000, // addiw overflows
+				13: 0x8000000000000000,
+				14: 0xffffffff80000000,
+				15: 0x80000000,
+				16: 0xfffffffff8000000,
+				17: 0x08000000,
+				18: 0xffffffff80000001,
+				19: 0xffffffffffffffff,
+				20: 0xffffffff,
+				21: 0xffffffffffffffff,
+				22: 0x7ffffff9,
+				23: 0x37ffffff9,
+				24: 0xffffffff80000000, // overflow
+				25: 3,
+				26: 0xfffffffffffffffe,
+				27: 0,
+				28: 5,
+				29: 0x8000000000000000,
+				30: 0x8000000000000000,
+				31: 0,
+			},
+		},
 	}
 	for _, tc := range cases {
 		tc := tc
@@ -33,8 +275,8 @@ func TestCPU(t *testing.T) {
 			if err != nil {
 				t.Fatal(err)
 			}
-			cpu := NewCPU(code)
-			if err := cpu.Run(); err != nil {
+			cpu := NewCPU(code, tc.opts...)
+			if _, err := cpu.Run(); err != nil {
 				t.Fatal(err)
 			}
 
@@ -190,3 +432,334 @@ func TestUnSignedExtend(t *testing.T) {
 		})
 	}
 }
+
+func TestExecuteLoad(t *testing.T) {
+	data := []byte{0x80, 0xff, 0x7f, 0x01, 0x12, 0x34, 0x56, 0x78}
+	cases := []struct {
+		name    string
+		rawInst uint32
+		base    uint64
+		want    uint64
+		wantErr *Exception
+	}{
+		{
+			name:    "lb x2, 0(x1)",
+			rawInst: 0x00008103,
+			base:    dramStartAddress,
+			want:    0xffffff80,
+		},
+		{
+			name:    "lb x2, 2(x1)",
+			rawInst: 0x00208103,
+			base:    dramStartAddress,
+			want:    0x7f,
+		},
+		{
+			name:    "lh x2, 0(x1)",
+			rawInst: 0x00009103,
+			base:    dramStartAddress,
+			want:    0xffffff80,
+		},
+		{
+			name:    "lw x2, 0(x1)",
+			rawInst: 0x0000a103,
+			base:    dramStartAddress,
+			want:    0x017fff80,
+		},
+		{
+			name:    "lbu x2, 0(x1)",
+			rawInst: 0x0000c103,
+			base:    dramStartAddress,
+			want:    0x80,
+		},
+		{
+			name:    "lhu x2, 0(x1)",
+			rawInst: 0x0000d103,
+			base:    dramStartAddress,
+			want:    0xff80,
+		},
+		{
+			name:    "lw x2, -4(x1)",
+			rawInst: 0xffc0a103,
+			base:    dramStartAddress + 8,
+			want:    0x78563412,
+		},
+		{
+			name:    "lw x2, -4(x1) before dram",
+			rawInst: 0xffc0a103,
+			base:    dramStartAddress,
+			wantErr: &Exception{
+				Code:  LoadAccessFault,
+				Value: dramStartAddress - 4,
+			},
+		},
+		{
+			name:    "lw x2, 0(x1) after end of dram",
+			rawInst: 0x0000a103,
+			base:    dramStartAddress + dramSize,
+			wantErr: &Exception{
+				Code:  LoadAccessFault,
+				Value: dramStartAddress + dramSize,
+			},
+		},
+		{
+			name:    "lw x2, 0(x1) misaligned",
+			rawInst: 0x0000a103,
+			base:    dramStartAddress + 2,
+			wantErr: &Exception{
+				Code:  LoadAddressMisaligned,
+				Value: dramStartAddress + 2,
+			},
+		},
+		{
+			name:    "lh x2, 0(x1) misaligned",
+			rawInst: 0x00009103,
+			base:    dramStartAddress + 1,
+			wantErr: &Exception{
+				Code:  LoadAddressMisaligned,
+				Value: dramStartAddress + 1,
+			},
+		},
+	}
+	for _, tc := range cases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			cpu := NewCPU(data)
+			cpu.xregs[1] = tc.base
+			err := cpu.Execute(cpu.Decode(tc.rawInst))
+			if tc.wantErr != nil {
+				if diff := cmp.Diff(tc.wantErr, err); diff != "" {
+					t.Fatalf("(-want, +got)\n%s", diff)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := cpu.xregs[2]; tc.want != got {
+				t.Errorf("want 0x%08x but got 0x%08x", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestExecuteShift(t *testing.T) {
+	cases := []struct {
+		name    string
+		rawInst uint32
+		rs1     uint64
+		rs2     uint64
+		want    uint64
+	}{
+		{
+			name:    "sll x2, x1, x3",
+			rawInst: 0x00309133,
+			rs1:     0x80000001,
+			rs2:     33, // only the lower 5 bits are used.
+			want:    0x00000002,
+		},
+		{
+			name:    "srl x2, x1, x3",
+			rawInst: 0x0030d133,
+			rs1:     0x80000001,
+			rs2:     33,
+			want:    0x40000000,
+		},
+		{
+			name:    "sra x2, x1, x3",
+			rawInst: 0x4030d133,
+			rs1:     0x80000001,
+			rs2:     4,
+			want:    0xf8000000,
+		},
+		{
+			name:    "sra x2, x1, x3 by more than 31",
+			rawInst: 0x4030d133,
+			rs1:     0x80000001,
+			rs2:     33,
+			want:    0xc0000000,
+		},
+		{
+			name:    "srai x2, x1, 1",
+			rawInst: 0x4010d113,
+			rs1:     0x80000001,
+			want:    0xc0000000,
+		},
+		{
+			name:    "srai x2, x1, 16",
+			rawInst: 0x4100d113,
+			rs1:     0x80000001,
+			want:    0xffff8000,
+		},
+		{
+			name:    "srai x2, x1, 31",
+			rawInst: 0x41f0d113,
+			rs1:     0x80000001,
+			want:    0xffffffff,
+		},
+	}
+	for _, tc := range cases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			cpu := NewCPU(nil)
+			cpu.xregs[1] = tc.rs1
+			cpu.xregs[3] = tc.rs2
+			if err := cpu.Execute(cpu.Decode(tc.rawInst)); err != nil {
+				t.Fatal(err)
+			}
+			if got := cpu.xregs[2]; tc.want != got {
+				t.Errorf("want 0x%08x but got 0x%08x", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestExecuteStore(t *testing.T) {
+	cases := []struct {
+		name    string
+		rawInst uint32
+		base    uint64
+		value   uint64
+		wantMem []byte
+		wantErr *Exception
+	}{
+		{
+			name:    "sb x2, 0(x1)",
+			rawInst: 0x00208023,
+			base:    dramStartAddress,
+			value:   0x12345678,
+			wantMem: []byte{0x78, 0, 0, 0, 0, 0, 0, 0},
+		},
+		{
+			name:    "sb x2, 3(x1)",
+			rawInst: 0x002081a3,
+			base:    dramStartAddress,
+			value:   0xff,
+			wantMem: []byte{0, 0, 0, 0xff, 0, 0, 0, 0},
+		},
+		{
+			name:    "sh x2, 0(x1)",
+			rawInst: 0x00209023,
+			base:    dramStartAddress,
+			value:   0x12345678,
+			wantMem: []byte{0x78, 0x56, 0, 0, 0, 0, 0, 0},
+		},
+		{
+			name:    "sw x2, 0(x1)",
+			rawInst: 0x0020a023,
+			base:    dramStartAddress,
+			value:   0x12345678,
+			wantMem: []byte{0x78, 0x56, 0x34, 0x12, 0, 0, 0, 0},
+		},
+		{
+			name:    "sw x2, -4(x1)",
+			rawInst: 0xfe20ae23,
+			base:    dramStartAddress + 8,
+			value:   0xdeadbeef,
+			wantMem: []byte{0, 0, 0, 0, 0xef, 0xbe, 0xad, 0xde},
+		},
+		{
+			name:    "sw x2, -4(x1) before dram",
+			rawInst: 0xfe20ae23,
+			base:    dramStartAddress,
+			value:   0xdeadbeef,
+			wantMem: make([]byte, 8),
+			wantErr: &Exception{
+				Code:  StoreAMOAccessFault,
+				Value: dramStartAddress - 4,
+			},
+		},
+		{
+			name:    "sh x2, 0(x1) after end of dram",
+			rawInst: 0x00209023,
+			base:    dramStartAddress + dramSize,
+			value:   0xffff,
+			wantMem: make([]byte, 8),
+			wantErr: &Exception{
+				Code:  StoreAMOAccessFault,
+				Value: dramStartAddress + dramSize,
+			},
+		},
+		{
+			name:    "sh x2, 7(x1) misaligned",
+			rawInst: 0x002093a3,
+			base:    dramStartAddress,
+			value:   0xffff,
+			wantMem: make([]byte, 8),
+			wantErr: &Exception{
+				Code:  StoreAMOAddressMisaligned,
+				Value: dramStartAddress + 7,
+			},
+		},
+	}
+	for _, tc := range cases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			cpu := NewCPU(make([]byte, 8))
+			cpu.xregs[1] = tc.base
+			cpu.xregs[2] = tc.value
+			err := cpu.Execute(cpu.Decode(tc.rawInst))
+			if tc.wantErr != nil {
+				if diff := cmp.Diff(tc.wantErr, err); diff != "" {
+					t.Fatalf("(-want, +got)\n%s", diff)
+				}
+			} else if err != nil {
+				t.Fatal(err)
+			}
+			got := make([]byte, len(tc.wantMem))
+			for i := range got {
+				v, err := cpu.bus.Read(dramStartAddress+uint64(i), 1)
+				if err != nil {
+					t.Fatal(err)
+				}
+				got[i] = byte(v)
+			}
+			if diff := cmp.Diff(tc.wantMem, got); diff != "" {
+				t.Fatalf("(-want, +got)\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestStoreBelowInitialSP(t *testing.T) {
+	cpu := NewCPU(make([]byte, 8))
+	sp := cpu.xregs[2]
+	cpu.xregs[3] = 0xdeadbeef
+	// sw x3, -4(x2)
+	if err := cpu.Execute(cpu.Decode(0xfe312e23)); err != nil {
+		t.Fatal(err)
+	}
+	// lw x4, -4(x2)
+	if err := cpu.Execute(cpu.Decode(0xffc12203)); err != nil {
+		t.Fatal(err)
+	}
+	if want, got := uint64(0xdeadbeef), cpu.xregs[4]; want != got {
+		t.Errorf("want 0x%08x but got 0x%08x at 0x%08x", want, got, sp-4)
+	}
+}
+
+func TestMisalignedAccess(t *testing.T) {
+	cpu := NewCPU([]byte{0x80, 0xff, 0x7f, 0x01, 0x12, 0x34, 0x56, 0x78}, WithMisalignedAccess(true))
+	cpu.xregs[1] = dramStartAddress
+	// lw x2, 1(x1)
+	if err := cpu.Execute(cpu.Decode(0x0010a103)); err != nil {
+		t.Fatal(err)
+	}
+	if want, got := uint64(0x12017fff), cpu.xregs[2]; want != got {
+		t.Errorf("want 0x%08x but got 0x%08x", want, got)
+	}
+	// sh x2, 7(x1) crosses the doubleword boundary.
+	cpu.xregs[2] = 0xabcd
+	if err := cpu.Execute(cpu.Decode(0x002093a3)); err != nil {
+		t.Fatal(err)
+	}
+	if got := mustRead(t, cpu.bus, dramStartAddress+7, 2); got != 0xabcd {
+		t.Errorf("want 0xabcd but got 0x%x", got)
+	}
+	// lr.w x2, (x1) is still misaligned.
+	cpu.xregs[1] = dramStartAddress + 1
+	want := &Exception{Code: LoadAddressMisaligned, Value: dramStartAddress + 1}
+	if diff := cmp.Diff(want, cpu.Execute(cpu.Decode(0x1000a12f))); diff != "" {
+		t.Errorf("(-want, +got)\n%s", diff)
+	}
+}
@@ -0,0 +1,495 @@
+package riscv
+
+import "fmt"
+
+// Control and status register (CSR) addresses.
+//
+// see: 2.2 CSR Listing (The RISC-V Instruction Set Manual Volume II: Privileged Architecture)
+const (
+	// Floating-Point Control and Status Registers.
+	CSRFflags = 0x001
+	CSRFrm    = 0x002
+	CSRFcsr   = 0x003
+
+	// Supervisor Trap Setup.
+	CSRSstatus    = 0x100
+	CSRSie        = 0x104
+	CSRStvec      = 0x105
+	CSRScounteren = 0x106
+
+	// Supervisor Trap Handling.
+	CSRSscratch = 0x140
+	CSRSepc     = 0x141
+	CSRScause   = 0x142
+	CSRStval    = 0x143
+	CSRSip      = 0x144
+
+	// Supervisor Protection and Translation.
+	CSRSatp = 0x180
+
+	// Machine Information Registers.
+	CSRMvendorid = 0xf11
+	CSRMarchid   = 0xf12
+	CSRMimpid    = 0xf13
+	CSRMhartid   = 0xf14
+
+	// Machine Trap Setup.
+	CSRMstatus    = 0x300
+	CSRMisa       = 0x301
+	CSRMedeleg    = 0x302
+	CSRMideleg    = 0x303
+	CSRMie        = 0x304
+	CSRMtvec      = 0x305
+	CSRMcounteren = 0x306
+
+	// Machine Trap Handling.
+	CSRMscratch = 0x340
+	CSRMepc     = 0x341
+	CSRMcause   = 0x342
+	CSRMtval    = 0x343
+	CSRMip      = 0x344
+
+	// Machine Memory Protection. pmpcfg0-pmpcfg15 and pmpaddr0-pmpaddr63 follow
+	// the first registers.
+	CSRPmpcfg0  = 0x3a0
+	CSRPmpaddr0 = 0x3b0
+)
+
+// mstatus fields.
+//
+// see: 3.1.6 Machine Status Registers (mstatus and mstatush)
+const (
+	mstatusSIE      = 1 << 1
+	mstatusMIE      = 1 << 3
+	mstatusSPIE     = 1 << 5
+	mstatusMPIE     = 1 << 7
+	mstatusSPP      = 1 << 8
+	mstatusMPP      = 0b11 << mstatusMPPShift
+	mstatusMPPShift = 11
+	mstatusFS       = 0b11 << 13
+	mstatusMPRV     = 1 << 17
+	mstatusSUM      = 1 << 18
+	mstatusMXR      = 1 << 19
+	mstatusTVM      = 1 << 20
+	mstatusTW       = 1 << 21
+	mstatusTSR      = 1 << 22
+	// UXL and SXL are only available in RV64.
+	mstatusUXL = 0b11 << 32
+	mstatusSXL = 0b11 << 34
+)
+
+// sstatus is a restricted view of mstatus.
+//
+// see: 4.1.1 Supervisor Status Register (sstatus)
+const sstatusMask = mstatusSIE | mstatusSPIE | mstatusSPP | mstatusFS | mstatusSUM | mstatusMXR | mstatusUXL
+
+// mstatusSD returns SD bit of mstatus which is the most significant bit.
+func mstatusSD(xlen XLEN) uint64 {
+	return 1 << (xlen - 1)
+}
+
+// The status of the floating-point unit which is held in mstatus.FS.
+//
+// see: 3.1.6.6 Extension Context Status in mstatus Register
+const (
+	fsOff     = 0b00 << 13
+	fsInitial = 0b01 << 13
+	fsClean   = 0b10 << 13
+	fsDirty   = 0b11 << 13
+)
+
+// fcsr fields.
+//
+// see: 11.2 Floating-Point Control and Status Register
+const (
+	fcsrFflags       = 0b11111
+	fcsrFrm          = 0b111 << fcsrFrmShift
+	fcsrFrmShift     = 5
+	fcsrWritableMask = fcsrFrm | fcsrFflags
+)
+
+// mip and mie fields.
+//
+// see: 3.1.9 Machine Interrupt Registers (mip and mie)
+const (
+	mipSSIP = 1 << 1
+	mipMSIP = 1 << 3
+	mipSTIP = 1 << 5
+	mipMTIP = 1 << 7
+	mipSEIP = 1 << 9
+	mipMEIP = 1 << 11
+
+	mipSupervisor = mipSSIP | mipSTIP | mipSEIP
+)
+
+// medelegMask is a mask of the exceptions which can be delegated to S-mode.
+// Environment call from M-mode can not be delegated, and the reserved codes are excluded.
+//
+// see: 3.1.8 Machine Trap Delegation Registers (medeleg and mideleg)
+const medelegMask = 0b1011_0011_1111_1111
+
+// satp fields.
+//
+// see: 4.1.11 Supervisor Address Translation and Protection (satp) Register
+const satpModeBare = 0
+
+// satpMode returns MODE field of satp. It is satp[31] in RV32 and satp[63:60] in RV64.
+func satpMode(satp uint64, xlen XLEN) uint64 {
+	if xlen == XLEN64 {
+		return satp >> 60
+	}
+	return satp >> 31 & 1
+}
+
+// mtvec modes.
+//
+// see: 3.1.7 Machine Trap-Vector Base-Address Register (mtvec)
+const (
+	mtvecModeDirect   = 0
+	mtvecModeVectored = 1
+	mtvecModeMask     = 0b11
+)
+
+// misaMXL returns MXL field of misa which encodes the native base integer ISA width.
+//
+// see: 3.1.1 Machine ISA Register misa
+func misaMXL(xlen XLEN) uint64 {
+	if xlen == XLEN64 {
+		return 2 << 62
+	}
+	return 1 << 30
+}
+
+// Extensions represents a set of the ISA extensions. Each bit is same as
+// the Extensions field of misa.
+type Extensions uint32
+
+const (
+	// ExtensionA is "A" Standard Extension for Atomic Instructions.
+	ExtensionA Extensions = 1 << ('A' - 'A')
+	// ExtensionC is "C" Standard Extension for Compressed Instructions.
+	ExtensionC Extensions = 1 << ('C' - 'A')
+	// ExtensionD is "D" Standard Extension for Double-Precision Floating-Point.
+	// It depends on ExtensionF.
+	ExtensionD Extensions = 1 << ('D' - 'A')
+	// ExtensionF is "F" Standard Extension for Single-Precision Floating-Point.
+	ExtensionF Extensions = 1 << ('F' - 'A')
+	// ExtensionI is RV32I/RV64I Base Integer Instruction Set. It is always enabled.
+	ExtensionI Extensions = 1 << ('I' - 'A')
+	// ExtensionM is "M" Standard Extension for Integer Multiplication and Division.
+	ExtensionM Extensions = 1 << ('M' - 'A')
+	// ExtensionS is Supervisor mode. It depends on ExtensionU.
+	ExtensionS Extensions = 1 << ('S' - 'A')
+	// ExtensionU is User mode.
+	ExtensionU Extensions = 1 << ('U' - 'A')
+)
+
+// defaultExtensions is the extensions which are enabled by default. (RV32IMAFDCSU)
+const defaultExtensions = ExtensionI | ExtensionM | ExtensionA | ExtensionF | ExtensionD | ExtensionC |
+	ExtensionS | ExtensionU
+
+// CSRReadHook is called when the CSR is read by the software.
+// value is the current value of the CSR and the returned value is read instead.
+//
+// This is useful to implement the CSR which is backed by a device. e.g. time.
+type CSRReadHook func(value uint64) uint64
+
+// CSRWriteHook is called after the CSR is written by the software.
+// old is the value before the write and new is the value which is stored.
+type CSRWriteHook func(old, new uint64)
+
+// csr represents a control and status register.
+type csr struct {
+	value uint64
+	// readMask is a mask of the bits which are readable.
+	// The other bits are always read as zero.
+	readMask uint64
+	// writeMask is a mask of the bits which are writable by the software.
+	// The other bits keep their value. (e.g. read-only fields)
+	writeMask uint64
+	// legalize converts the written value to legal value for WARL
+	// (Write Any values, Reads Legal values) fields.
+	legalize func(old, new uint64) uint64
+
+	readHooks  []CSRReadHook
+	writeHooks []CSRWriteHook
+}
+
+// CSRFile represents control and status registers which are associated with a hart.
+//
+// see: 2.1 CSR Address Mapping Conventions
+type CSRFile struct {
+	csrs map[uint32]*csr
+}
+
+func newCSRFile(hartID uint64, ext Extensions, xlen XLEN) *CSRFile {
+	f := &CSRFile{
+		csrs: make(map[uint32]*csr),
+	}
+	// The registers are XLEN bits wide.
+	mask := ^uint64(0) >> (64 - xlen)
+
+	f.add(CSRMvendorid, 0, mask, 0, nil)
+	f.add(CSRMarchid, 0, mask, 0, nil)
+	f.add(CSRMimpid, 0, mask, 0, nil)
+	f.add(CSRMhartid, hartID, mask, 0, nil)
+
+	hasS := ext&ExtensionS != 0
+	hasU := ext&ExtensionU != 0
+
+	// MPP is WARL. The modes which are not supported can not be written.
+	// SD is read-only and summarizes FS.
+	// The fields for the less-privileged modes are read-only zero if the modes are not supported.
+	mstatus := uint64(mstatusMPP)
+	mstatusWritable := uint64(mstatusMIE | mstatusMPIE | mstatusMPP)
+	if ext&ExtensionF != 0 {
+		// The reset value of FS is unspecified. It is set to Initial so that
+		// the programs can use floating-point instructions without enabling the unit.
+		mstatus |= fsInitial
+		mstatusWritable |= mstatusFS
+	}
+	if hasU {
+		mstatusWritable |= mstatusMPRV | mstatusTW
+		if xlen == XLEN64 {
+			// UXL is read-only and U-mode is always XLEN=64.
+			mstatus |= 2 << 32
+		}
+	}
+	if hasS {
+		mstatusWritable |= mstatusSIE | mstatusSPIE | mstatusSPP | mstatusSUM | mstatusMXR | mstatusTVM | mstatusTSR
+		if xlen == XLEN64 {
+			// SXL is read-only and S-mode is always XLEN=64.
+			mstatus |= 2 << 34
+		}
+	}
+	f.add(CSRMstatus, mstatus, mask, mstatusWritable, func(old, new uint64) uint64 {
+		if !ext.supportsMode(PrivilegeMode(new & mstatusMPP >> mstatusMPPShift)) {
+			new = new&^mstatusMPP | old&mstatusMPP
+		}
+		if new&mstatusFS == fsDirty {
+			return new | mstatusSD(xlen)
+		}
+		return new &^ mstatusSD(xlen)
+	})
+	// misa is WARL. The writes are ignored because the extensions can not be changed.
+	f.add(CSRMisa, misaMXL(xlen)|uint64(ext), mask, 0, nil)
+	mieWritable := uint64(mipMSIP | mipMTIP | mipMEIP)
+	if hasS {
+		mieWritable |= mipSupervisor
+	}
+	f.add(CSRMie, 0, mask, mieWritable, nil)
+	f.add(CSRMtvec, 0, mask, mask, legalizeTvec)
+
+	f.add(CSRMscratch, 0, mask, mask, nil)
+	// mepc[0] is always zero. mepc[1:0] are always zero on implementations that
+	// do not support IALIGN=16 (i.e. "C" extension is disabled).
+	epcWritable := mask &^ 0b11
+	if ext&ExtensionC != 0 {
+		epcWritable = mask &^ 0b1
+	}
+	f.add(CSRMepc, 0, mask, epcWritable, nil)
+	f.add(CSRMcause, 0, mask, mask, nil)
+	f.add(CSRMtval, 0, mask, mask, nil)
+	// The pending bits are set by the interrupt controllers.
+	// M-mode software can also set the pending bits for S-mode.
+	mipWritable := uint64(0)
+	if hasS {
+		mipWritable = mipSupervisor
+	}
+	f.add(CSRMip, 0, mask, mipWritable, nil)
+
+	// No hardware performance counters are implemented, but the counter-enable
+	// registers exist so that the software can write them.
+	if hasU {
+		f.add(CSRMcounteren, 0, mask, 0xffffffff, nil)
+	}
+	if hasS {
+		f.addSupervisor(mask, mstatusWritable, epcWritable, xlen)
+	}
+
+	if ext&ExtensionF != 0 {
+		// fflags and frm are the views of fcsr.
+		f.add(CSRFcsr, 0, mask, fcsrWritableMask, nil)
+		f.add(CSRFflags, 0, mask, fcsrFflags, nil)
+		f.add(CSRFrm, 0, mask, fcsrFrm>>fcsrFrmShift, nil)
+		f.OnRead(CSRFflags, func(uint64) uint64 {
+			return f.Get(CSRFcsr) & fcsrFflags
+		})
+		f.OnWrite(CSRFflags, func(_, new uint64) {
+			f.Set(CSRFcsr, f.Get(CSRFcsr)&^fcsrFflags|new)
+		})
+		f.OnRead(CSRFrm, func(uint64) uint64 {
+			return f.Get(CSRFcsr) & fcsrFrm >> fcsrFrmShift
+		})
+		f.OnWrite(CSRFrm, func(_, new uint64) {
+			f.Set(CSRFcsr, f.Get(CSRFcsr)&^fcsrFrm|new<<fcsrFrmShift)
+		})
+	}
+	return f
+}
+
+// addSupervisor adds the CSRs for S-mode.
+//
+// sstatus, sie and sip are the views of mstatus, mie and mip.
+//
+// see: 4.1 Supervisor CSRs
+func (f *CSRFile) addSupervisor(mask, mstatusWritable, epcWritable uint64, xlen XLEN) {
+	f.add(CSRMedeleg, 0, mask, medelegMask, nil)
+	f.add(CSRMideleg, 0, mask, mipSupervisor, nil)
+
+	sstatusReadMask := mask & (sstatusMask | mstatusSD(xlen))
+	sstatusWritable := mstatusWritable & sstatusMask
+	f.add(CSRSstatus, 0, sstatusReadMask, sstatusWritable, nil)
+	f.OnRead(CSRSstatus, func(uint64) uint64 {
+		return f.Get(CSRMstatus) & sstatusReadMask
+	})
+	f.OnWrite(CSRSstatus, func(_, new uint64) {
+		// mstatus is written through Write to legalize SD.
+		_ = f.Write(CSRMstatus, f.Get(CSRMstatus)&^sstatusWritable|new)
+	})
+
+	// The interrupts which are not delegated are invisible in sie and sip.
+	f.add(CSRSie, 0, mask, mipSupervisor, nil)
+	f.OnRead(CSRSie, func(uint64) uint64 {
+		return f.Get(CSRMie) & f.Get(CSRMideleg)
+	})
+	f.OnWrite(CSRSie, func(_, new uint64) {
+		deleg := f.Get(CSRMideleg)
+		f.Set(CSRMie, f.Get(CSRMie)&^deleg|new&deleg)
+	})
+	// Only SSIP is writable in sip.
+	f.add(CSRSip, 0, mask, mipSSIP, nil)
+	f.OnRead(CSRSip, func(uint64) uint64 {
+		return f.Get(CSRMip) & f.Get(CSRMideleg)
+	})
+	f.OnWrite(CSRSip, func(_, new uint64) {
+		writable := f.Get(CSRMideleg) & mipSSIP
+		f.Set(CSRMip, f.Get(CSRMip)&^writable|new&writable)
+	})
+
+	f.add(CSRStvec, 0, mask, mask, legalizeTvec)
+	f.add(CSRScounteren, 0, mask, 0xffffffff, nil)
+	f.add(CSRSscratch, 0, mask, mask, nil)
+	f.add(CSRSepc, 0, mask, epcWritable, nil)
+	f.add(CSRScause, 0, mask, mask, nil)
+	f.add(CSRStval, 0, mask, mask, nil)
+	// The write which sets the unsupported mode has no effect.
+	f.add(CSRSatp, 0, mask, mask, func(old, new uint64) uint64 {
+		if !satpModeSupported(satpMode(new, xlen), xlen) {
+			return old
+		}
+		return new
+	})
+}
+
+// legalizeTvec legalizes MODE field of mtvec and stvec.
+// MODE values >= 2 are reserved, so the write to MODE is ignored.
+func legalizeTvec(old, new uint64) uint64 {
+	if new&mtvecModeMask > mtvecModeVectored {
+		return new&^mtvecModeMask | old&mtvecModeMask
+	}
+	return new
+}
+
+// isFloatCSR reports whether the CSR belongs to the floating-point unit.
+func isFloatCSR(addr uint32) bool {
+	return addr == CSRFflags || addr == CSRFrm || addr == CSRFcsr
+}
+
+func (f *CSRFile) add(addr uint32, reset, readMask, writeMask uint64, legalize func(old, new uint64) uint64) {
+	f.csrs[addr] = &csr{
+		value:     reset,
+		readMask:  readMask,
+		writeMask: writeMask,
+		legalize:  legalize,
+	}
+}
+
+// csrPrivilege returns the lowest privilege level that can access the CSR.
+// It is encoded in csr[9:8].
+func csrPrivilege(addr uint32) PrivilegeMode {
+	return PrivilegeMode((addr >> 8) & 0b11)
+}
+
+// isReadOnlyCSR reports whether the CSR is read-only.
+// The top two bits (csr[11:10]) indicate whether the register is read/write (00, 01, or 10) or read-only (11).
+func isReadOnlyCSR(addr uint32) bool {
+	return (addr>>10)&0b11 == 0b11
+}
+
+// Exists reports whether the CSR is implemented.
+func (f *CSRFile) Exists(addr uint32) bool {
+	_, ok := f.csrs[addr]
+	return ok
+}
+
+// Read reads the CSR as the software does. The read hooks are called.
+func (f *CSRFile) Read(addr uint32) (uint64, error) {
+	r, ok := f.csrs[addr]
+	if !ok {
+		return 0, fmt.Errorf("csr 0x%03x is not implemented", addr)
+	}
+	v := r.value & r.readMask
+	for _, hook := range r.readHooks {
+		v = hook(v)
+	}
+	return v, nil
+}
+
+// Write writes the CSR as the software does. Only writable bits are updated and
+// the value is legalized for WARL fields. Then the write hooks are called.
+func (f *CSRFile) Write(addr uint32, value uint64) error {
+	r, ok := f.csrs[addr]
+	if !ok {
+		return fmt.Errorf("csr 0x%03x is not implemented", addr)
+	}
+	if isReadOnlyCSR(addr) {
+		return fmt.Errorf("csr 0x%03x is read-only", addr)
+	}
+	old := r.value
+	v := old&^r.writeMask | value&r.writeMask
+	if r.legalize != nil {
+		v = r.legalize(old, v)
+	}
+	r.value = v
+	for _, hook := range r.writeHooks {
+		hook(old, v)
+	}
+	return nil
+}
+
+// Get returns the raw value of the CSR. The masks and the hooks are not applied.
+//
+// This is used by the hardware. e.g. trap handling.
+func (f *CSRFile) Get(addr uint32) uint64 {
+	r, ok := f.csrs[addr]
+	if !ok {
+		return 0
+	}
+	return r.value
+}
+
+// Set sets the raw value to the CSR. The masks and the hooks are not applied.
+//
+// This is used by the hardware. e.g. an interrupt controller sets pending bits to mip.
+func (f *CSRFile) Set(addr uint32, value uint64) {
+	r, ok := f.csrs[addr]
+	if !ok {
+		return
+	}
+	r.value = value
+}
+
+// OnRead attaches the hook which is called when the CSR is read.
+func (f *CSRFile) OnRead(addr uint32, hook CSRReadHook) {
+	if r, ok := f.csrs[addr]; ok {
+		r.readHooks = append(r.readHooks, hook)
+	}
+}
+
+// OnWrite attaches the hook which is called when the CSR is written.
+func (f *CSRFile) OnWrite(addr uint32, hook CSRWriteHook) {
+	if r, ok := f.csrs[addr]; ok {
+		r.writeHooks = append(r.writeHooks, hook)
+	}
+}
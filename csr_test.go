@@ -0,0 +1,181 @@
+package riscv
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestCSRFile(t *testing.T) {
+	t.Run("read-only", func(t *testing.T) {
+		f := newCSRFile(3, defaultExtensions, XLEN32)
+		if err := f.Write(CSRMhartid, 1); err == nil {
+			t.Fatal("want error")
+		}
+		got, err := f.Read(CSRMhartid)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != 3 {
+			t.Errorf("want 3 but got %d", got)
+		}
+	})
+	t.Run("write mask", func(t *testing.T) {
+		f := newCSRFile(0, defaultExtensions, XLEN32)
+		f.Set(CSRMip, mipMTIP)
+		if err := f.Write(CSRMip, 0); err != nil {
+			t.Fatal(err)
+		}
+		if got := f.Get(CSRMip); got != mipMTIP {
+			t.Errorf("want 0x%x but got 0x%x", mipMTIP, got)
+		}
+	})
+	t.Run("warl", func(t *testing.T) {
+		f := newCSRFile(0, defaultExtensions, XLEN32)
+		if err := f.Write(CSRMtvec, 0x80000001); err != nil {
+			t.Fatal(err)
+		}
+		// reserved mode is ignored.
+		if err := f.Write(CSRMtvec, 0x80000102); err != nil {
+			t.Fatal(err)
+		}
+		if got := f.Get(CSRMtvec); got != 0x80000101 {
+			t.Errorf("want 0x80000101 but got 0x%x", got)
+		}
+	})
+	t.Run("sstatus", func(t *testing.T) {
+		f := newCSRFile(0, defaultExtensions, XLEN32)
+		// MIE and MPP are not visible in sstatus.
+		if err := f.Write(CSRSstatus, mstatusSIE|mstatusMIE); err != nil {
+			t.Fatal(err)
+		}
+		if want, got := uint64(mstatusSIE|mstatusMPP), f.Get(CSRMstatus); got != want {
+			t.Errorf("want mstatus 0x%x but got 0x%x", want, got)
+		}
+		got, err := f.Read(CSRSstatus)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != mstatusSIE {
+			t.Errorf("want sstatus 0x%x but got 0x%x", mstatusSIE, got)
+		}
+	})
+	t.Run("sie", func(t *testing.T) {
+		f := newCSRFile(0, defaultExtensions, XLEN32)
+		// Only the delegated interrupts are visible in sie.
+		if err := f.Write(CSRMideleg, mipSTIP); err != nil {
+			t.Fatal(err)
+		}
+		if err := f.Write(CSRSie, mipSSIP|mipSTIP); err != nil {
+			t.Fatal(err)
+		}
+		if got := f.Get(CSRMie); got != mipSTIP {
+			t.Errorf("want mie 0x%x but got 0x%x", mipSTIP, got)
+		}
+	})
+	t.Run("mpp", func(t *testing.T) {
+		f := newCSRFile(0, ExtensionI|ExtensionU, XLEN32)
+		// S-mode is not supported.
+		if err := f.Write(CSRMstatus, uint64(SupervisorMode)<<mstatusMPPShift); err != nil {
+			t.Fatal(err)
+		}
+		if got := f.Get(CSRMstatus); got != mstatusMPP {
+			t.Errorf("want mstatus 0x%x but got 0x%x", mstatusMPP, got)
+		}
+	})
+	t.Run("hooks", func(t *testing.T) {
+		f := newCSRFile(0, defaultExtensions, XLEN32)
+		var written [][2]uint64
+		f.OnRead(CSRMscratch, func(v uint64) uint64 { return v + 1 })
+		f.OnWrite(CSRMscratch, func(old, new uint64) {
+			written = append(written, [2]uint64{old, new})
+		})
+		if err := f.Write(CSRMscratch, 10); err != nil {
+			t.Fatal(err)
+		}
+		if err := f.Write(CSRMscratch, 20); err != nil {
+			t.Fatal(err)
+		}
+		got, err := f.Read(CSRMscratch)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != 21 {
+			t.Errorf("want 21 but got %d", got)
+		}
+		want := [][2]uint64{{0, 10}, {10, 20}}
+		if diff := cmp.Diff(want, written); diff != "" {
+			t.Errorf("(-want, +got)\n%s", diff)
+		}
+	})
+}
+
+func TestExecuteCSR(t *testing.T) {
+	cases := []struct {
+		name    string
+		opts    []Option
+		setup   func(cpu *CPU)
+		rawInst uint32
+		wantErr error
+	}{
+		{
+			name:    "csrw mhartid, x1",
+			rawInst: 0xf1409073,
+			wantErr: &Exception{Code: IllegalInstruction, Value: 0xf1409073},
+		},
+		{
+			name:    "csrr x1, mhartid",
+			rawInst: 0xf14020f3,
+		},
+		{
+			name:    "csrr x1, 0x7c0",
+			rawInst: 0x7c0020f3,
+			wantErr: &Exception{Code: IllegalInstruction, Value: 0x7c0020f3},
+		},
+		{
+			name:    "csrr x1, sstatus in S-mode",
+			setup:   func(cpu *CPU) { cpu.mode = SupervisorMode },
+			rawInst: 0x100020f3,
+		},
+		{
+			name:    "csrr x1, mstatus in S-mode",
+			setup:   func(cpu *CPU) { cpu.mode = SupervisorMode },
+			rawInst: 0x300020f3,
+			wantErr: &Exception{Code: IllegalInstruction, Value: 0x300020f3},
+		},
+		{
+			name:    "csrr x1, sstatus in U-mode",
+			setup:   func(cpu *CPU) { cpu.mode = UserMode },
+			rawInst: 0x100020f3,
+			wantErr: &Exception{Code: IllegalInstruction, Value: 0x100020f3},
+		},
+		{
+			name: "csrr x1, satp in S-mode with TVM",
+			setup: func(cpu *CPU) {
+				cpu.mode = SupervisorMode
+				cpu.csrs.Set(CSRMstatus, cpu.csrs.Get(CSRMstatus)|mstatusTVM)
+			},
+			rawInst: 0x180020f3,
+			wantErr: &Exception{Code: IllegalInstruction, Value: 0x180020f3},
+		},
+		{
+			name:    "csrr x1, sstatus without S-mode",
+			opts:    []Option{WithExtensions(ExtensionU)},
+			rawInst: 0x100020f3,
+			wantErr: &Exception{Code: IllegalInstruction, Value: 0x100020f3},
+		},
+	}
+	for _, tc := range cases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			cpu := NewCPU(make([]byte, 8), tc.opts...)
+			if tc.setup != nil {
+				tc.setup(cpu)
+			}
+			err := cpu.Execute(cpu.Decode(tc.rawInst))
+			if diff := cmp.Diff(tc.wantErr, err); diff != "" {
+				t.Fatalf("(-want, +got)\n%s", diff)
+			}
+		})
+	}
+}
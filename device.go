@@ -2,13 +2,75 @@ package riscv
 
 import (
 	"fmt"
+	"sync"
 )
 
+// Device represents a memory-mapped device which is connected to the bus.
+//
+// addr which is passed to Read and Write is the offset from StartAddr.
+// size is the access width in bytes. i.e. 1, 2, 4 or 8.
 type Device interface {
-	StartAddr() uint32
-	EndAddr() uint32
-	Read(addr, size uint32) uint32
-	Write(addr, size, value uint32)
+	StartAddr() uint64
+	EndAddr() uint64
+	Read(addr, size uint64) uint64
+	Write(addr, size, value uint64)
+}
+
+// Ticker is implemented by the devices which advance their state as the CPU
+// executes the instructions. e.g. timers.
+type Ticker interface {
+	// Tick is called by the CPU once before each instruction is executed.
+	Tick()
+}
+
+// InterruptSource is implemented by the devices which drive the interrupt-pending
+// bits of mip. e.g. CLINT and PLIC.
+//
+// The CPU samples the interrupts from all sources before each instruction is executed.
+type InterruptSource interface {
+	// PendingInterrupts returns the interrupts which are asserted by the device.
+	// Bit i is set if the interrupt whose InterruptCode is i is pending.
+	PendingInterrupts() uint64
+}
+
+// Halter is implemented by the devices which can stop the CPU. e.g. the test finisher.
+type Halter interface {
+	// Halt is called by Run after each instruction. It reports true with the result
+	// if the device requests to stop the CPU.
+	Halt() (RunResult, bool)
+}
+
+// InterruptLine is a wired interrupt line from a device to the interrupt controller.
+// It may be driven by the goroutine other than the CPU. e.g. the receiver of UART.
+type InterruptLine interface {
+	// SetLevel asserts the line if level is true, and deasserts it otherwise.
+	SetLevel(level bool)
+}
+
+// busMaster is implemented by the devices which access the memory through the bus
+// by themselves. e.g. DMA of virtio devices. The bus is connected by NewCPU.
+type busMaster interface {
+	connect(bus *Bus)
+}
+
+// readRegister returns size bytes at offset in the register which is wider than
+// the access. e.g. the upper half of the 64-bit register is read by the 32-bit access at offset 4.
+func readRegister(reg, offset, size uint64) uint64 {
+	return reg >> (8 * offset) & sizeMask(size)
+}
+
+// writeRegister returns the register whose size bytes at offset are replaced by value.
+func writeRegister(reg, offset, size, value uint64) uint64 {
+	mask := sizeMask(size) << (8 * offset)
+	return reg&^mask | value<<(8*offset)&mask
+}
+
+// sizeMask returns the mask of the lower size bytes.
+func sizeMask(size uint64) uint64 {
+	if size >= 8 {
+		return ^uint64(0)
+	}
+	return 1<<(8*size) - 1
 }
 
 // Bus represents a system bus which is a single computer bus that connects the major components
@@ -58,12 +120,16 @@ type Device interface {
 //     [VIRT_DRAM] =        { 0x80000000,           0x0 },
 // };
 type Bus struct {
+	// mu serializes the accesses to the devices so that AMOs are performed atomically.
+	mu        sync.Mutex
 	devices   []Device
-	limitAddr uint32
+	limitAddr uint64
+
+	reservations []*Reservation
 }
 
 func NewBus(devices ...Device) *Bus {
-	var limitAddr uint32
+	var limitAddr uint64
 	for _, device := range devices {
 		if device.EndAddr() > limitAddr {
 			limitAddr = device.EndAddr()
@@ -75,21 +141,27 @@ func NewBus(devices ...Device) *Bus {
 	}
 }
 
-func (b *Bus) IsValidAddr(addr uint32) bool {
+func (b *Bus) IsValidAddr(addr uint64) bool {
 	return addr <= b.limitAddr
 }
 
-func (b *Bus) findDevice(addr, size uint32) (Device, error) {
+func (b *Bus) findDevice(addr, size uint64) (Device, error) {
 	useAddrLen := addr + size - 1
 	for _, dev := range b.devices {
-		if dev.StartAddr() <= addr && useAddrLen <= dev.EndAddr() {
+		if dev.StartAddr() <= addr && useAddrLen < dev.EndAddr() {
 			return dev, nil
 		}
 	}
 	return nil, fmt.Errorf("device is not found (addr: %08x, size: %d)", addr, size)
 }
 
-func (b *Bus) Read(addr, size uint32) (uint32, error) {
+func (b *Bus) Read(addr, size uint64) (uint64, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.read(addr, size)
+}
+
+func (b *Bus) read(addr, size uint64) (uint64, error) {
 	device, err := b.findDevice(addr, size)
 	if err != nil {
 		return 0, err
@@ -97,11 +169,117 @@ func (b *Bus) Read(addr, size uint32) (uint32, error) {
 	return device.Read(addr-device.StartAddr(), size), nil
 }
 
-func (b *Bus) Write(addr, size, value uint32) error {
+// Write writes the value to the device. The reservations which
+// contain the written address are invalidated.
+func (b *Bus) Write(addr, size, value uint64) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.write(addr, size, value)
+}
+
+func (b *Bus) write(addr, size, value uint64) error {
 	device, err := b.findDevice(addr, size)
 	if err != nil {
 		return err
 	}
 	device.Write(addr-device.StartAddr(), size, value)
+	for _, r := range b.reservations {
+		if r.valid && addr < r.addr+r.size && r.addr < addr+size {
+			r.valid = false
+		}
+	}
 	return nil
 }
+
+// readBytes reads len(p) bytes at addr. It is used by the devices to access the memory.
+func (b *Bus) readBytes(addr uint64, p []byte) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for i := range p {
+		v, err := b.read(addr+uint64(i), 1)
+		if err != nil {
+			return err
+		}
+		p[i] = byte(v)
+	}
+	return nil
+}
+
+// writeBytes writes p at addr. It is used by the devices to access the memory.
+func (b *Bus) writeBytes(addr uint64, p []byte) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for i, v := range p {
+		if err := b.write(addr+uint64(i), 1, uint64(v)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Reservation represents a reservation set which is registered by
+// the load-reserved instruction (LR).
+//
+// see: 8.2 Load-Reserved/Store-Conditional Instructions
+type Reservation struct {
+	valid bool
+	addr  uint64
+	size  uint64
+}
+
+// NewReservation creates a new reservation set which is tracked by the bus.
+// Each hart should have own reservation set.
+func (b *Bus) NewReservation() *Reservation {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	r := &Reservation{}
+	b.reservations = append(b.reservations, r)
+	return r
+}
+
+// LoadReserved reads the value and registers the reservation set
+// which covers the read bytes.
+func (b *Bus) LoadReserved(r *Reservation, addr, size uint64) (uint64, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	v, err := b.read(addr, size)
+	if err != nil {
+		return 0, err
+	}
+	r.valid = true
+	r.addr = addr
+	r.size = size
+	return v, nil
+}
+
+// StoreConditional writes the value only if the reservation set is still valid
+// and covers the address. It reports whether the value is written.
+// The reservation set is invalidated regardless of success or failure.
+func (b *Bus) StoreConditional(r *Reservation, addr, size, value uint64) (bool, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !r.valid || r.addr != addr || r.size != size {
+		r.valid = false
+		return false, nil
+	}
+	r.valid = false
+	if err := b.write(addr, size, value); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// AtomicModify reads the value and writes the value which is returned by f
+// atomically. It returns the value before the modification.
+func (b *Bus) AtomicModify(addr, size uint64, f func(old uint64) uint64) (uint64, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	old, err := b.read(addr, size)
+	if err != nil {
+		return 0, err
+	}
+	if err := b.write(addr, size, f(old)); err != nil {
+		return 0, err
+	}
+	return old, nil
+}
@@ -2,44 +2,70 @@ package riscv
 
 // DRAM (Dyanmic random access memory) is our memory that contains
 // all the instructions to be executed and the data.
+//
+// The memory is allocated in chunks when they are written first, so the
+// untouched memory does not cost anything. It is read as zero.
 type DRAM struct {
-	mem []byte
+	size   uint64
+	chunks []*[dramChunkSize]byte
 }
 
 var _ Device = (*DRAM)(nil)
 
-const dramStartAddress = 0x80000000
+const (
+	dramStartAddress = 0x80000000
+	dramChunkSize    = 64 * 1024 // (64KiB).
+)
 
+// NewDRAM creates the DRAM of size bytes which is initialized by code.
 func NewDRAM(code []byte, size int) *DRAM {
-	mem := make([]byte, len(code))
-	copy(mem, code)
-	return &DRAM{
-		mem: mem,
+	if size < len(code) {
+		size = len(code)
 	}
+	d := &DRAM{
+		size:   uint64(size),
+		chunks: make([]*[dramChunkSize]byte, (size+dramChunkSize-1)/dramChunkSize),
+	}
+	for i := 0; i < len(code); i += dramChunkSize {
+		copy(d.chunk(uint64(i))[:], code[i:])
+	}
+	return d
+}
+
+// chunk returns the chunk which contains addr. It is allocated if it is not yet.
+func (d *DRAM) chunk(addr uint64) *[dramChunkSize]byte {
+	c := d.chunks[addr/dramChunkSize]
+	if c == nil {
+		c = new([dramChunkSize]byte)
+		d.chunks[addr/dramChunkSize] = c
+	}
+	return c
 }
 
 // Read reads any values from dram.
 // size specify the bit size. i.e 8, 16, 32, 64 bit...
-func (d *DRAM) Read(addr, size uint32) uint32 {
-	var result uint32
-	for i := uint32(0); i < size; i++ {
-		idx := int(addr + i)
-		result |= uint32(d.mem[idx]) << (8 * i)
+func (d *DRAM) Read(addr, size uint64) uint64 {
+	var result uint64
+	for i := uint64(0); i < size; i++ {
+		idx := addr + i
+		if c := d.chunks[idx/dramChunkSize]; c != nil {
+			result |= uint64(c[idx%dramChunkSize]) << (8 * i)
+		}
 	}
 	return result
 }
 
 // Write writes any values to dram.
 // size specify the bit size. i.e 8, 16, 32, 64 bit...
-func (d *DRAM) Write(addr, size, value uint32) {
-	for i := uint32(0); i < size; i++ {
-		idx := int(addr + i)
-		d.mem[idx] = byte(value >> ((8 * i) & 0xff)) // 0xff ~ 8 bit masking (byte type == uint8)
+func (d *DRAM) Write(addr, size, value uint64) {
+	for i := uint64(0); i < size; i++ {
+		idx := addr + i
+		d.chunk(idx)[idx%dramChunkSize] = byte(value >> ((8 * i) & 0xff)) // 0xff ~ 8 bit masking (byte type == uint8)
 	}
 }
 
 // StartAddr represents start address for DRAM.
-func (d *DRAM) StartAddr() uint32 { return dramStartAddress }
+func (d *DRAM) StartAddr() uint64 { return dramStartAddress }
 
 // EndAddr represents end of address for DRAM.
-func (d *DRAM) EndAddr() uint32 { return d.StartAddr() + uint32(len(d.mem)) }
+func (d *DRAM) EndAddr() uint64 { return d.StartAddr() + d.size }
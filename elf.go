@@ -0,0 +1,93 @@
+package riscv
+
+import (
+	"debug/elf"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Program is an executable which is loaded from the ELF file.
+type Program struct {
+	// Image is the memory image which is placed at the start of DRAM.
+	// The bytes which are not initialized by the file (e.g. .bss) are zero.
+	Image []byte
+	// Entry is the address of the entry point.
+	Entry uint64
+	// XLEN is XLEN32 for ELFCLASS32 and XLEN64 for ELFCLASS64.
+	XLEN XLEN
+
+	symbols map[string]uint64
+}
+
+// LoadELF loads the RISC-V executable. The loadable segments are placed by their
+// physical addresses which must be in DRAM.
+func LoadELF(r io.ReaderAt) (*Program, error) {
+	f, err := elf.NewFile(r)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	if f.Machine != elf.EM_RISCV {
+		return nil, fmt.Errorf("not a RISC-V executable: %s", f.Machine)
+	}
+	if f.Type != elf.ET_EXEC {
+		return nil, fmt.Errorf("not an executable file: %s", f.Type)
+	}
+	p := &Program{
+		Entry:   f.Entry,
+		XLEN:    XLEN32,
+		symbols: make(map[string]uint64),
+	}
+	if f.Class == elf.ELFCLASS64 {
+		p.XLEN = XLEN64
+	}
+
+	var size uint64
+	for _, prog := range f.Progs {
+		if prog.Type != elf.PT_LOAD {
+			continue
+		}
+		if prog.Filesz > prog.Memsz {
+			return nil, fmt.Errorf("segment at 0x%x is larger in the file than in the memory", prog.Paddr)
+		}
+		if prog.Memsz == 0 {
+			continue
+		}
+		// The end of the segment is not calculated first because it may overflow.
+		if prog.Paddr < dramStartAddress || prog.Paddr-dramStartAddress > dramSize ||
+			prog.Memsz > dramSize-(prog.Paddr-dramStartAddress) {
+			return nil, fmt.Errorf("segment is out of DRAM: 0x%x-0x%x", prog.Paddr, prog.Paddr+prog.Memsz)
+		}
+		if end := prog.Paddr + prog.Memsz - dramStartAddress; end > size {
+			size = end
+		}
+	}
+	p.Image = make([]byte, size)
+	for _, prog := range f.Progs {
+		if prog.Type != elf.PT_LOAD || prog.Filesz == 0 {
+			continue
+		}
+		off := prog.Paddr - dramStartAddress
+		if _, err := prog.ReadAt(p.Image[off:off+prog.Filesz], 0); err != nil {
+			return nil, fmt.Errorf("failed to read segment at 0x%x: %w", prog.Paddr, err)
+		}
+	}
+
+	syms, err := f.Symbols()
+	if err != nil && !errors.Is(err, elf.ErrNoSymbols) {
+		return nil, err
+	}
+	for _, sym := range syms {
+		if sym.Name != "" {
+			p.symbols[sym.Name] = sym.Value
+		}
+	}
+	return p, nil
+}
+
+// Symbol returns the value of the symbol. e.g. the address of tohost.
+func (p *Program) Symbol(name string) (uint64, bool) {
+	v, ok := p.symbols[name]
+	return v, ok
+}
@@ -0,0 +1,94 @@
+package riscv
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadELF(t *testing.T) {
+	f, err := os.Open(filepath.Join("testdata", "htif", "htif"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	p, err := LoadELF(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Entry != dramStartAddress {
+		t.Errorf("want entry 0x%x but got 0x%x", dramStartAddress, p.Entry)
+	}
+	if p.XLEN != XLEN32 {
+		t.Errorf("want XLEN %d but got %d", XLEN32, p.XLEN)
+	}
+	// The image ends with fromhost.
+	if want := 0x1048; len(p.Image) != want {
+		t.Errorf("want image size 0x%x but got 0x%x", want, len(p.Image))
+	}
+	// j reset_vector
+	if got := p.Image[:4]; got[0] != 0x6f {
+		t.Errorf("want jal at the entry but got % x", got)
+	}
+	for name, want := range map[string]uint64{
+		"_start":   0x80000000,
+		"tohost":   0x80001000,
+		"fromhost": 0x80001040,
+	} {
+		if got, ok := p.Symbol(name); !ok || got != want {
+			t.Errorf("want %s 0x%x but got 0x%x (%t)", name, want, got, ok)
+		}
+	}
+	if _, ok := p.Symbol("reset_vector"); ok {
+		t.Errorf("want the local symbol is not found")
+	}
+}
+
+func TestLoadELFNotRISCV(t *testing.T) {
+	exe, err := os.Executable()
+	if err != nil {
+		t.Skip(err)
+	}
+	f, err := os.Open(exe)
+	if err != nil {
+		t.Skip(err)
+	}
+	defer f.Close()
+	if _, err := LoadELF(f); err == nil {
+		t.Errorf("want error for the executable which is not for RISC-V")
+	}
+}
+
+func TestLoadELFMalformedSegment(t *testing.T) {
+	orig, err := os.ReadFile(filepath.Join("testdata", "htif", "htif"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The fields of the first program header of ELF32.
+	const (
+		pPaddr  = 12
+		pFilesz = 16
+		pMemsz  = 20
+	)
+	phoff := binary.LittleEndian.Uint32(orig[0x1c:])
+	filesz := binary.LittleEndian.Uint32(orig[phoff+pFilesz:])
+	for _, tc := range []struct {
+		name  string
+		field uint32
+		value uint32
+	}{
+		{name: "file size exceeds memory size", field: pMemsz, value: filesz - 1},
+		{name: "no memory size", field: pMemsz, value: 0},
+		{name: "out of DRAM", field: pPaddr, value: 0xffffff00},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			b := append([]byte(nil), orig...)
+			binary.LittleEndian.PutUint32(b[phoff+tc.field:], tc.value)
+			if _, err := LoadELF(bytes.NewReader(b)); err == nil {
+				t.Errorf("want error for the malformed segment")
+			}
+		})
+	}
+}
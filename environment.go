@@ -0,0 +1,167 @@
+package riscv
+
+import (
+	"fmt"
+	"io"
+)
+
+// EnvironmentCall represents the instruction which is used to request
+// the execution environment.
+//
+// see: 2.8 Environment Call and Breakpoints
+type EnvironmentCall int
+
+const (
+	// ECALL is used to make a service request to the execution environment.
+	ECALL EnvironmentCall = iota
+	// EBREAK is used to return control to a debugging environment.
+	EBREAK
+)
+
+func (e EnvironmentCall) String() string {
+	switch e {
+	case ECALL:
+		return "ecall"
+	case EBREAK:
+		return "ebreak"
+	}
+	return fmt.Sprintf("EnvironmentCall(%d)", int(e))
+}
+
+// EnvironmentAction tells the CPU how to proceed after EnvironmentHandler is returned.
+type EnvironmentAction int
+
+const (
+	// EnvironmentContinue continues the execution from the next instruction.
+	EnvironmentContinue EnvironmentAction = iota
+	// EnvironmentHalt stops the CPU with the exit code.
+	EnvironmentHalt
+	// EnvironmentTrap raises the exception which is corresponding to the instruction.
+	// i.e. environment call exception for ECALL and breakpoint exception for EBREAK.
+	EnvironmentTrap
+)
+
+// EnvironmentResult is returned by EnvironmentHandler.
+type EnvironmentResult struct {
+	Action EnvironmentAction
+	// ExitCode is used when Action is EnvironmentHalt.
+	ExitCode int
+}
+
+// EnvironmentHandler handles ECALL and EBREAK instructions instead of the execution
+// environment such as an operating system or a debugger.
+//
+// The handler can read and write the integer registers and access the bus.
+// e.g. a system call can be implemented by reading the arguments from the registers.
+// In RV32, only the lower 32 bits of the registers are used.
+type EnvironmentHandler interface {
+	HandleEnvironmentCall(call EnvironmentCall, xregs *[32]uint64, bus *Bus) EnvironmentResult
+}
+
+// The EnvironmentHandlerFunc type is an adapter to allow the use of ordinary
+// functions as EnvironmentHandler.
+type EnvironmentHandlerFunc func(call EnvironmentCall, xregs *[32]uint64, bus *Bus) EnvironmentResult
+
+var _ EnvironmentHandler = (EnvironmentHandlerFunc)(nil)
+
+// HandleEnvironmentCall calls f(call, xregs, bus).
+func (f EnvironmentHandlerFunc) HandleEnvironmentCall(call EnvironmentCall, xregs *[32]uint64, bus *Bus) EnvironmentResult {
+	return f(call, xregs, bus)
+}
+
+// System call numbers which are used in RISC-V Linux and newlib.
+// see: https://github.com/riscv-software-src/riscv-pk/blob/master/pk/syscall.h
+const (
+	sysWrite = 64
+	sysExit  = 93
+)
+
+// SyscallHandler is an EnvironmentHandler which implements the minimum
+// system calls to run bare-metal programs.
+//
+// The system call number is passed in a7, the arguments are passed in a0-a5
+// and the return value is written to a0.
+//
+//   - write(fd, buf, count): writes to the writer when fd is 1 (stdout) or 2 (stderr).
+//   - exit(code): halts the CPU with the exit code.
+//
+// Unknown system calls and EBREAK raise the exception.
+type SyscallHandler struct {
+	w io.Writer
+}
+
+var _ EnvironmentHandler = (*SyscallHandler)(nil)
+
+// NewSyscallHandler creates a new SyscallHandler which writes the output to w.
+func NewSyscallHandler(w io.Writer) *SyscallHandler {
+	return &SyscallHandler{w: w}
+}
+
+// HandleEnvironmentCall implements EnvironmentHandler interface.
+func (s *SyscallHandler) HandleEnvironmentCall(call EnvironmentCall, xregs *[32]uint64, bus *Bus) EnvironmentResult {
+	if call != ECALL {
+		return EnvironmentResult{Action: EnvironmentTrap}
+	}
+	const (
+		a0 = 10
+		a1 = 11
+		a2 = 12
+		a7 = 17
+	)
+	switch xregs[a7] {
+	case sysWrite:
+		fd, buf, count := xregs[a0], xregs[a1], xregs[a2]
+		if fd != 1 && fd != 2 {
+			xregs[a0] = ^uint64(0) // -1
+			return EnvironmentResult{Action: EnvironmentContinue}
+		}
+		n, err := copyFromBus(s.w, bus, buf, count)
+		if err != nil && n == 0 {
+			xregs[a0] = ^uint64(0) // -1
+			return EnvironmentResult{Action: EnvironmentContinue}
+		}
+		xregs[a0] = n
+		return EnvironmentResult{Action: EnvironmentContinue}
+	case sysExit:
+		return EnvironmentResult{
+			Action:   EnvironmentHalt,
+			ExitCode: int(int32(xregs[a0])),
+		}
+	}
+	return EnvironmentResult{Action: EnvironmentTrap}
+}
+
+// busCopyChunkSize is the size of the buffer which copies the memory to io.Writer.
+const busCopyChunkSize = 4096
+
+// copyFromBus writes count bytes at addr to w. The bytes are copied by chunks, so the
+// buffer is not sized by the program. The copy stops at the first bus error, and the
+// bytes before it are written. It returns the number of the bytes which are written.
+func copyFromBus(w io.Writer, bus *Bus, addr, count uint64) (uint64, error) {
+	chunk := make([]byte, busCopyChunkSize)
+	var written uint64
+	for written < count {
+		p := chunk
+		if count-written < uint64(len(p)) {
+			p = p[:count-written]
+		}
+		var readErr error
+		for i := range p {
+			v, err := bus.Read(addr+written+uint64(i), 1)
+			if err != nil {
+				p, readErr = p[:i], err
+				break
+			}
+			p[i] = byte(v)
+		}
+		n, err := w.Write(p)
+		written += uint64(n)
+		if err != nil {
+			return written, err
+		}
+		if readErr != nil {
+			return written, readErr
+		}
+	}
+	return written, nil
+}
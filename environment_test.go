@@ -0,0 +1,135 @@
+package riscv
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestSyscallHandler(t *testing.T) {
+	code, err := os.ReadFile(filepath.Join("testdata", "syscall", "syscall.bin"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	cpu := NewCPU(code, WithEnvironmentHandler(NewSyscallHandler(&buf)))
+	result, err := cpu.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (RunResult{Reason: StopHalted, ExitCode: 3}); result != want {
+		t.Errorf("want %+v but got %+v", want, result)
+	}
+	if !cpu.Halted() {
+		t.Fatal("want halted")
+	}
+	if got := cpu.ExitCode(); got != 3 {
+		t.Errorf("want exit code 3 but got %d", got)
+	}
+	if got := buf.String(); got != "hello\n" {
+		t.Errorf("want %q but got %q", "hello\n", got)
+	}
+}
+
+func TestEnvironmentHandler(t *testing.T) {
+	const (
+		ecall  = 0x00000073
+		ebreak = 0x00100073
+	)
+	cases := []struct {
+		name    string
+		rawInst uint32
+		handler EnvironmentHandler
+		wantErr *Exception
+	}{
+		{
+			name:    "ecall without handler",
+			rawInst: ecall,
+			wantErr: &Exception{Code: EnvironmentCallFromMMode},
+		},
+		{
+			name:    "ebreak without handler",
+			rawInst: ebreak,
+			wantErr: &Exception{Code: Breakpoint, Value: dramStartAddress},
+		},
+		{
+			name:    "ebreak continue",
+			rawInst: ebreak,
+			handler: EnvironmentHandlerFunc(func(call EnvironmentCall, xregs *[32]uint64, bus *Bus) EnvironmentResult {
+				if call != EBREAK {
+					t.Errorf("want %s but got %s", EBREAK, call)
+				}
+				xregs[10] = 42
+				return EnvironmentResult{Action: EnvironmentContinue}
+			}),
+		},
+		{
+			name:    "ecall trap",
+			rawInst: ecall,
+			handler: EnvironmentHandlerFunc(func(call EnvironmentCall, xregs *[32]uint64, bus *Bus) EnvironmentResult {
+				return EnvironmentResult{Action: EnvironmentTrap}
+			}),
+			wantErr: &Exception{Code: EnvironmentCallFromMMode},
+		},
+	}
+	for _, tc := range cases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			var opts []Option
+			if tc.handler != nil {
+				opts = append(opts, WithEnvironmentHandler(tc.handler))
+			}
+			cpu := NewCPU(make([]byte, 8), opts...)
+			cpu.Next()
+			err := cpu.Execute(cpu.Decode(tc.rawInst))
+			if tc.wantErr != nil {
+				if diff := cmp.Diff(tc.wantErr, err); diff != "" {
+					t.Fatalf("(-want, +got)\n%s", diff)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := cpu.xregs[10]; got != 42 {
+				t.Errorf("want 42 but got %d", got)
+			}
+		})
+	}
+}
+
+func TestSyscallHandlerWrite(t *testing.T) {
+	const (
+		a0 = 10
+		a1 = 11
+		a2 = 12
+		a7 = 17
+	)
+	cpu := NewCPU([]byte("0123"))
+	if err := cpu.bus.Write(dramStartAddress+dramSize-4, 4, 'c'|'d'<<8|'e'<<16|'f'<<24); err != nil {
+		t.Fatal(err)
+	}
+	cases := []struct {
+		name       string
+		buf, count uint64
+		want       uint64
+		wantOut    string
+	}{
+		{name: "whole buffer", buf: dramStartAddress, count: 4, want: 4, wantOut: "0123"},
+		{name: "stops at the bus error", buf: dramStartAddress + dramSize - 4, count: ^uint64(0), want: 4, wantOut: "cdef"},
+		{name: "unmapped buffer", buf: 0, count: 4, want: ^uint64(0)},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			var out bytes.Buffer
+			xregs := [32]uint64{a0: 1, a1: tc.buf, a2: tc.count, a7: sysWrite}
+			NewSyscallHandler(&out).HandleEnvironmentCall(ECALL, &xregs, cpu.bus)
+			if xregs[a0] != tc.want || out.String() != tc.wantOut {
+				t.Errorf("want %d and %q but got %d and %q", tc.want, tc.wantOut, xregs[a0], out.String())
+			}
+		})
+	}
+}
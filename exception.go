@@ -0,0 +1,109 @@
+package riscv
+
+import "fmt"
+
+// ExceptionCode represents the code of synchronous exception which is written
+// to the mcause register.
+//
+// see: 3.1.15 Machine Cause Register (mcause)
+type ExceptionCode uint32
+
+const (
+	InstructionAddressMisaligned ExceptionCode = 0
+	InstructionAccessFault       ExceptionCode = 1
+	IllegalInstruction           ExceptionCode = 2
+	Breakpoint                   ExceptionCode = 3
+	LoadAddressMisaligned        ExceptionCode = 4
+	LoadAccessFault              ExceptionCode = 5
+	StoreAMOAddressMisaligned    ExceptionCode = 6
+	StoreAMOAccessFault          ExceptionCode = 7
+	EnvironmentCallFromUMode     ExceptionCode = 8
+	EnvironmentCallFromSMode     ExceptionCode = 9
+	EnvironmentCallFromMMode     ExceptionCode = 11
+	InstructionPageFault         ExceptionCode = 12
+	LoadPageFault                ExceptionCode = 13
+	StoreAMOPageFault            ExceptionCode = 15
+)
+
+func (e ExceptionCode) String() string {
+	switch e {
+	case InstructionAddressMisaligned:
+		return "instruction address misaligned"
+	case InstructionAccessFault:
+		return "instruction access fault"
+	case IllegalInstruction:
+		return "illegal instruction"
+	case Breakpoint:
+		return "breakpoint"
+	case LoadAddressMisaligned:
+		return "load address misaligned"
+	case LoadAccessFault:
+		return "load access fault"
+	case StoreAMOAddressMisaligned:
+		return "store/AMO address misaligned"
+	case StoreAMOAccessFault:
+		return "store/AMO access fault"
+	case EnvironmentCallFromUMode:
+		return "environment call from U-mode"
+	case EnvironmentCallFromSMode:
+		return "environment call from S-mode"
+	case EnvironmentCallFromMMode:
+		return "environment call from M-mode"
+	case InstructionPageFault:
+		return "instruction page fault"
+	case LoadPageFault:
+		return "load page fault"
+	case StoreAMOPageFault:
+		return "store/AMO page fault"
+	}
+	return fmt.Sprintf("reserved exception (%d)", uint32(e))
+}
+
+// Exception represents a synchronous exception which is raised while
+// an instruction is executed.
+type Exception struct {
+	Code ExceptionCode
+	// Value is the exception-specific information. e.g. the faulting address.
+	// This value would be written to the mtval register.
+	Value uint64
+}
+
+var _ error = (*Exception)(nil)
+
+func (e *Exception) Error() string {
+	return fmt.Sprintf("%s (tval: 0x%08x)", e.Code, e.Value)
+}
+
+// InterruptCode represents the code of asynchronous interrupt which is written to
+// the mcause register with the interrupt bit. The bit of the same position in
+// mip and mie is corresponding to the interrupt.
+//
+// see: 3.1.15 Machine Cause Register (mcause)
+type InterruptCode uint32
+
+const (
+	SupervisorSoftwareInterrupt InterruptCode = 1
+	MachineSoftwareInterrupt    InterruptCode = 3
+	SupervisorTimerInterrupt    InterruptCode = 5
+	MachineTimerInterrupt       InterruptCode = 7
+	SupervisorExternalInterrupt InterruptCode = 9
+	MachineExternalInterrupt    InterruptCode = 11
+)
+
+func (i InterruptCode) String() string {
+	switch i {
+	case SupervisorSoftwareInterrupt:
+		return "supervisor software interrupt"
+	case MachineSoftwareInterrupt:
+		return "machine software interrupt"
+	case SupervisorTimerInterrupt:
+		return "supervisor timer interrupt"
+	case MachineTimerInterrupt:
+		return "machine timer interrupt"
+	case SupervisorExternalInterrupt:
+		return "supervisor external interrupt"
+	case MachineExternalInterrupt:
+		return "machine external interrupt"
+	}
+	return fmt.Sprintf("reserved interrupt (%d)", uint32(i))
+}
@@ -0,0 +1,75 @@
+package riscv
+
+// TestFinisher is SiFive test finisher which lets the program stop the emulator
+// with the exit status. It is mapped to VIRT_TEST region of QEMU virt machine.
+//
+// The program writes the 32-bit status to offset 0. The lower 16 bits select
+// the command and the upper 16 bits are the exit code for the failure.
+//
+//	0x5555:             pass. Run returns StopHalted with the exit code 0.
+//	code<<16 | 0x3333:  fail. Run returns StopHalted with the exit code.
+//	0x7777:             reset. Run returns StopReset.
+//
+// The other values are ignored.
+//
+// see: https://github.com/qemu/qemu/blob/master/hw/misc/sifive_test.c
+type TestFinisher struct {
+	result RunResult
+	// requested is set when the command is written and cleared when it is reported to the CPU.
+	requested bool
+}
+
+var (
+	_ Device = (*TestFinisher)(nil)
+	_ Halter = (*TestFinisher)(nil)
+)
+
+const (
+	testFinisherStartAddress = 0x100000
+	testFinisherSize         = 0x1000
+
+	finisherFail  = 0x3333
+	finisherPass  = 0x5555
+	finisherReset = 0x7777
+)
+
+// NewTestFinisher creates the test finisher.
+func NewTestFinisher() *TestFinisher {
+	return &TestFinisher{}
+}
+
+// StartAddr represents start address for the test finisher.
+func (f *TestFinisher) StartAddr() uint64 { return testFinisherStartAddress }
+
+// EndAddr represents end of address for the test finisher.
+func (f *TestFinisher) EndAddr() uint64 { return testFinisherStartAddress + testFinisherSize }
+
+// Read always returns 0.
+func (f *TestFinisher) Read(addr, size uint64) uint64 { return 0 }
+
+// Write accepts the command which is written to offset 0 by the 32-bit access.
+func (f *TestFinisher) Write(addr, size, value uint64) {
+	if addr != 0 || size != 4 {
+		return
+	}
+	switch value & 0xffff {
+	case finisherPass:
+		f.result = RunResult{Reason: StopHalted, ExitCode: 0}
+	case finisherFail:
+		f.result = RunResult{Reason: StopHalted, ExitCode: int(value >> 16 & 0xffff)}
+	case finisherReset:
+		f.result = RunResult{Reason: StopReset}
+	default:
+		return
+	}
+	f.requested = true
+}
+
+// Halt reports the command which is written by the program.
+func (f *TestFinisher) Halt() (RunResult, bool) {
+	if !f.requested {
+		return RunResult{}, false
+	}
+	f.requested = false
+	return f.result, true
+}
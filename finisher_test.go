@@ -0,0 +1,46 @@
+package riscv
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestTestFinisher(t *testing.T) {
+	code, err := os.ReadFile(filepath.Join("testdata", "finisher", "finisher.bin"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cases := []struct {
+		name       string
+		status     uint64
+		want       RunResult
+		wantHalted bool
+	}{
+		{name: "pass", status: 0x5555, want: RunResult{Reason: StopHalted, ExitCode: 0}, wantHalted: true},
+		{name: "fail", status: 42<<16 | 0x3333, want: RunResult{Reason: StopHalted, ExitCode: 42}, wantHalted: true},
+		{name: "reset", status: 0x7777, want: RunResult{Reason: StopReset}},
+		{name: "unknown command", status: 0x1234, want: RunResult{Reason: StopEndOfProgram}},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			cpu := NewCPU(code, WithDevices(NewTestFinisher()))
+			cpu.xregs[10] = tc.status
+			got, err := cpu.Run()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tc.want {
+				t.Errorf("want %+v but got %+v", tc.want, got)
+			}
+			if cpu.Halted() != tc.wantHalted || cpu.ExitCode() != tc.want.ExitCode {
+				t.Errorf("want halted=%t, exit code=%d but got halted=%t, exit code=%d",
+					tc.wantHalted, tc.want.ExitCode, cpu.Halted(), cpu.ExitCode())
+			}
+			// The instruction after the write is executed only if the CPU is not stopped.
+			if want := tc.want.Reason == StopEndOfProgram; (cpu.xregs[8] == 1) != want {
+				t.Errorf("want the next instruction is executed: %t", want)
+			}
+		})
+	}
+}
@@ -0,0 +1,371 @@
+package riscv
+
+import "github.com/Code-Hex/go-riscv/internal/fpu"
+
+// nanBox is the upper 32 bits of NaN-boxed single-precision value.
+//
+// see: 12.2 NaN Boxing of Narrower Values
+const nanBox = 0xffffffff_00000000
+
+// readFloat reads the floating-point register as the format.
+// If "D" extension is enabled, the single-precision value must be NaN-boxed.
+// Otherwise the value is treated as the canonical NaN.
+func (c *CPU) readFloat(f fpu.Format, r uint32) uint64 {
+	v := c.fregs[r]
+	if f == fpu.Double {
+		return v
+	}
+	if c.has(ExtensionD) && v&nanBox != nanBox {
+		return fpu.Single.CanonicalNaN()
+	}
+	return v &^ nanBox
+}
+
+// writeFloat writes the value of the format to the floating-point register.
+func (c *CPU) writeFloat(f fpu.Format, r uint32, v uint64) {
+	if f == fpu.Single && c.has(ExtensionD) {
+		v |= nanBox
+	}
+	c.fregs[r] = v
+	c.dirtyFloat()
+}
+
+// dirtyFloat sets mstatus.FS to Dirty because the floating-point state is modified.
+func (c *CPU) dirtyFloat() {
+	mstatus := c.csrs.Get(CSRMstatus)
+	c.csrs.Set(CSRMstatus, mstatus|fsDirty|mstatusSD(c.xlen))
+}
+
+// accrueFloatFlags sets the accrued exception flags to fflags.
+func (c *CPU) accrueFloatFlags(flags fpu.Flags) {
+	if flags == 0 {
+		return
+	}
+	c.csrs.Set(CSRFcsr, c.csrs.Get(CSRFcsr)|uint64(flags))
+	c.dirtyFloat()
+}
+
+// roundingMode returns the rounding mode which is specified by rm field.
+// If rm is DYN (0b111), the rounding mode is selected by frm.
+func (c *CPU) roundingMode(rm uint32) (fpu.RoundingMode, bool) {
+	const dyn = 0b111
+	if rm == dyn {
+		rm = uint32(c.csrs.Get(CSRFcsr) & fcsrFrm >> fcsrFrmShift)
+	}
+	mode := fpu.RoundingMode(rm)
+	return mode, mode.Valid()
+}
+
+// floatFormat returns the format which is specified by fmt field.
+func (c *CPU) floatFormat(fmt uint32) (fpu.Format, bool) {
+	switch fmt {
+	case 0b00:
+		return fpu.Single, true
+	case 0b01:
+		return fpu.Double, c.has(ExtensionD)
+	}
+	return fpu.Format{}, false
+}
+
+// executeFloat executes the instructions in "F" and "D" Standard Extension.
+//
+// see: Chapter 11 "F" Standard Extension for Single-Precision Floating-Point, Version 2.2
+// see: Chapter 12 "D" Standard Extension for Double-Precision Floating-Point, Version 2.2
+func (c *CPU) executeFloat(inst *Instruction) error {
+	illegal := &Exception{Code: IllegalInstruction, Value: uint64(inst.raw)}
+	// If mstatus.FS is Off, the floating-point instructions raise an illegal instruction exception.
+	if c.csrs.Get(CSRMstatus)&mstatusFS == fsOff {
+		return illegal
+	}
+	rd, rs1, rs2, rs3 := inst.rd, inst.rs1, inst.rs2, inst.rs3
+	rv64 := c.xlen == XLEN64
+
+	switch inst.opcode {
+	case OPLOADFP:
+		addr := c.truncate(c.xregs[rs1] + inst.imm)
+		switch inst.funct3 {
+		case 0b010:
+			c.debugf("flw rd, offset=%d(rs1=%d)", inst.imm, c.xregs[rs1])
+			v, err := c.load(addr, 4)
+			if err != nil {
+				return err
+			}
+			c.writeFloat(fpu.Single, rd, v)
+			return nil
+		case 0b011:
+			if !c.has(ExtensionD) {
+				return illegal
+			}
+			c.debugf("fld rd, offset=%d(rs1=%d)", inst.imm, c.xregs[rs1])
+			v, err := c.load(addr, 8)
+			if err != nil {
+				return err
+			}
+			c.writeFloat(fpu.Double, rd, v)
+			return nil
+		}
+		return illegal
+	case OPSTOREFP:
+		addr := c.truncate(c.xregs[rs1] + inst.imm)
+		switch inst.funct3 {
+		case 0b010:
+			// FSW does not check NaN-boxing. The lower 32 bits are stored.
+			c.debugf("fsw rs2, offset=%d(rs1=%d)", inst.imm, c.xregs[rs1])
+			return c.store(addr, 4, c.fregs[rs2])
+		case 0b011:
+			if !c.has(ExtensionD) {
+				return illegal
+			}
+			c.debugf("fsd rs2, offset=%d(rs1=%d)", inst.imm, c.xregs[rs1])
+			return c.store(addr, 8, c.fregs[rs2])
+		}
+		return illegal
+	}
+
+	f, ok := c.floatFormat(inst.funct7 & 0b11)
+	if !ok {
+		return illegal
+	}
+
+	switch inst.opcode {
+	case OPMADD, OPMSUB, OPNMSUB, OPNMADD:
+		rm, ok := c.roundingMode(inst.funct3)
+		if !ok {
+			return illegal
+		}
+		a, b, x := c.readFloat(f, rs1), c.readFloat(f, rs2), c.readFloat(f, rs3)
+		switch inst.opcode {
+		case OPMADD: // (rs1 × rs2) + rs3
+			c.debugf("fmadd rd, rs1, rs2, rs3")
+		case OPMSUB: // (rs1 × rs2) - rs3
+			c.debugf("fmsub rd, rs1, rs2, rs3")
+			x ^= f.SignBit()
+		case OPNMSUB: // -(rs1 × rs2) + rs3
+			c.debugf("fnmsub rd, rs1, rs2, rs3")
+			a ^= f.SignBit()
+		case OPNMADD: // -(rs1 × rs2) - rs3
+			c.debugf("fnmadd rd, rs1, rs2, rs3")
+			a ^= f.SignBit()
+			x ^= f.SignBit()
+		}
+		v, flags := f.MulAdd(a, b, x, rm)
+		c.accrueFloatFlags(flags)
+		c.writeFloat(f, rd, v)
+		return nil
+	}
+
+	// OP-FP
+	a, b := c.readFloat(f, rs1), c.readFloat(f, rs2)
+	funct5 := inst.funct7 >> 2
+	switch funct5 {
+	case 0b00000, 0b00001, 0b00010, 0b00011, 0b01011:
+		rm, ok := c.roundingMode(inst.funct3)
+		if !ok {
+			return illegal
+		}
+		var (
+			v     uint64
+			flags fpu.Flags
+		)
+		switch funct5 {
+		case 0b00000:
+			c.debugf("fadd rd, rs1, rs2")
+			v, flags = f.Add(a, b, rm)
+		case 0b00001:
+			c.debugf("fsub rd, rs1, rs2")
+			v, flags = f.Sub(a, b, rm)
+		case 0b00010:
+			c.debugf("fmul rd, rs1, rs2")
+			v, flags = f.Mul(a, b, rm)
+		case 0b00011:
+			c.debugf("fdiv rd, rs1, rs2")
+			v, flags = f.Div(a, b, rm)
+		case 0b01011:
+			if rs2 != 0 {
+				return illegal
+			}
+			c.debugf("fsqrt rd, rs1")
+			v, flags = f.Sqrt(a, rm)
+		}
+		c.accrueFloatFlags(flags)
+		c.writeFloat(f, rd, v)
+		return nil
+	case 0b00100:
+		sign := f.SignBit()
+		var v uint64
+		switch inst.funct3 {
+		case 0b000:
+			c.debugf("fsgnj rd, rs1, rs2")
+			v = a&^sign | b&sign
+		case 0b001:
+			c.debugf("fsgnjn rd, rs1, rs2")
+			v = a&^sign | ^b&sign
+		case 0b010:
+			c.debugf("fsgnjx rd, rs1, rs2")
+			v = a ^ b&sign
+		default:
+			return illegal
+		}
+		c.writeFloat(f, rd, v)
+		return nil
+	case 0b00101:
+		var (
+			v     uint64
+			flags fpu.Flags
+		)
+		switch inst.funct3 {
+		case 0b000:
+			c.debugf("fmin rd, rs1, rs2")
+			v, flags = f.Min(a, b)
+		case 0b001:
+			c.debugf("fmax rd, rs1, rs2")
+			v, flags = f.Max(a, b)
+		default:
+			return illegal
+		}
+		c.accrueFloatFlags(flags)
+		c.writeFloat(f, rd, v)
+		return nil
+	case 0b01000:
+		// FCVT.S.D and FCVT.D.S. rs2 field holds the source format.
+		src, ok := c.floatFormat(rs2)
+		if !ok || src == f {
+			return illegal
+		}
+		rm, ok := c.roundingMode(inst.funct3)
+		if !ok {
+			return illegal
+		}
+		c.debugf("fcvt rd, rs1")
+		v, flags := f.Convert(src, c.readFloat(src, rs1), rm)
+		c.accrueFloatFlags(flags)
+		c.writeFloat(f, rd, v)
+		return nil
+	case 0b10100:
+		var (
+			ok    bool
+			flags fpu.Flags
+		)
+		switch inst.funct3 {
+		case 0b010:
+			c.debugf("feq rd, rs1, rs2")
+			ok, flags = f.Eq(a, b)
+		case 0b001:
+			c.debugf("flt rd, rs1, rs2")
+			ok, flags = f.Lt(a, b)
+		case 0b000:
+			c.debugf("fle rd, rs1, rs2")
+			ok, flags = f.Le(a, b)
+		default:
+			return illegal
+		}
+		c.accrueFloatFlags(flags)
+		if ok {
+			c.xregs[rd] = 1
+		} else {
+			c.xregs[rd] = 0
+		}
+		return nil
+	case 0b11000:
+		rm, ok := c.roundingMode(inst.funct3)
+		if !ok {
+			return illegal
+		}
+		// The 32-bit results are sign-extended to XLEN bits even if the destination is unsigned.
+		var flags fpu.Flags
+		switch {
+		case rs2 == 0b00000:
+			c.debugf("fcvt.w rd, rs1")
+			var v int64
+			v, flags = f.ToInt(a, 32, rm)
+			c.xregs[rd] = c.word(uint32(v))
+		case rs2 == 0b00001:
+			c.debugf("fcvt.wu rd, rs1")
+			var v uint64
+			v, flags = f.ToUint(a, 32, rm)
+			c.xregs[rd] = c.word(uint32(v))
+		case rs2 == 0b00010 && rv64:
+			c.debugf("fcvt.l rd, rs1")
+			var v int64
+			v, flags = f.ToInt(a, 64, rm)
+			c.xregs[rd] = uint64(v)
+		case rs2 == 0b00011 && rv64:
+			c.debugf("fcvt.lu rd, rs1")
+			c.xregs[rd], flags = f.ToUint(a, 64, rm)
+		default:
+			return illegal
+		}
+		c.accrueFloatFlags(flags)
+		return nil
+	case 0b11010:
+		rm, ok := c.roundingMode(inst.funct3)
+		if !ok {
+			return illegal
+		}
+		var (
+			v     uint64
+			flags fpu.Flags
+		)
+		switch {
+		case rs2 == 0b00000:
+			c.debugf("fcvt.w rd, rs1=%d", c.xregs[rs1])
+			v, flags = f.FromInt(int64(int32(c.xregs[rs1])), rm)
+		case rs2 == 0b00001:
+			c.debugf("fcvt.wu rd, rs1=%d", c.xregs[rs1])
+			v, flags = f.FromUint(uint64(uint32(c.xregs[rs1])), rm)
+		case rs2 == 0b00010 && rv64:
+			c.debugf("fcvt.l rd, rs1=%d", c.xregs[rs1])
+			v, flags = f.FromInt(int64(c.xregs[rs1]), rm)
+		case rs2 == 0b00011 && rv64:
+			c.debugf("fcvt.lu rd, rs1=%d", c.xregs[rs1])
+			v, flags = f.FromUint(c.xregs[rs1], rm)
+		default:
+			return illegal
+		}
+		c.accrueFloatFlags(flags)
+		c.writeFloat(f, rd, v)
+		return nil
+	case 0b11100:
+		if rs2 != 0 {
+			return illegal
+		}
+		switch inst.funct3 {
+		case 0b000:
+			if f == fpu.Single {
+				// FMV.X.W does not check NaN-boxing.
+				c.debugf("fmv.x.w rd, rs1")
+				c.xregs[rd] = c.word(uint32(c.fregs[rs1]))
+				return nil
+			}
+			// FMV.X.D is only available in RV64.
+			if !rv64 {
+				return illegal
+			}
+			c.debugf("fmv.x.d rd, rs1")
+			c.xregs[rd] = c.fregs[rs1]
+			return nil
+		case 0b001:
+			c.debugf("fclass rd, rs1")
+			c.xregs[rd] = uint64(f.Classify(a))
+			return nil
+		}
+		return illegal
+	case 0b11110:
+		if rs2 != 0 || inst.funct3 != 0 {
+			return illegal
+		}
+		if f == fpu.Single {
+			c.debugf("fmv.w.x rd, rs1=%d", c.xregs[rs1])
+			c.writeFloat(fpu.Single, rd, uint64(uint32(c.xregs[rs1])))
+			return nil
+		}
+		// FMV.D.X is only available in RV64.
+		if !rv64 {
+			return illegal
+		}
+		c.debugf("fmv.d.x rd, rs1=%d", c.xregs[rs1])
+		c.writeFloat(fpu.Double, rd, c.xregs[rs1])
+		return nil
+	}
+	return illegal
+}
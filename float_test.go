@@ -0,0 +1,113 @@
+package riscv
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestExecuteFloat(t *testing.T) {
+	const (
+		faddS    = 0x003100d3 // fadd.s ft1, ft2, ft3, rne
+		faddSDyn = 0x003170d3 // fadd.s ft1, ft2, ft3, dyn
+		faddD    = 0x023100d3 // fadd.d ft1, ft2, ft3, rne
+	)
+	cases := []struct {
+		name    string
+		opts    []Option
+		rawInst uint32
+		setup   func(cpu *CPU)
+		want    uint64
+		wantErr bool
+	}{
+		{
+			name:    "fadd.s with NaN-boxed values",
+			rawInst: faddS,
+			setup: func(cpu *CPU) {
+				cpu.fregs[2] = 0xffffffff_3f800000 // 1.0
+				cpu.fregs[3] = 0xffffffff_3f800000 // 1.0
+			},
+			want: 0xffffffff_40000000, // 2.0
+		},
+		{
+			name:    "fadd.s with invalid NaN-boxed value",
+			rawInst: faddS,
+			setup: func(cpu *CPU) {
+				cpu.fregs[2] = 0x3f800000
+				cpu.fregs[3] = 0xffffffff_3f800000
+			},
+			want: 0xffffffff_7fc00000, // canonical NaN
+		},
+		{
+			name:    "fadd.s without D extension",
+			opts:    []Option{WithExtensions(ExtensionF)},
+			rawInst: faddS,
+			setup: func(cpu *CPU) {
+				cpu.fregs[2] = 0x3f800000
+				cpu.fregs[3] = 0x3f800000
+			},
+			want: 0x40000000,
+		},
+		{
+			name:    "fadd.d",
+			rawInst: faddD,
+			setup: func(cpu *CPU) {
+				cpu.fregs[2] = 0x3ff00000_00000000 // 1.0
+				cpu.fregs[3] = 0x3ff00000_00000000 // 1.0
+			},
+			want: 0x40000000_00000000, // 2.0
+		},
+		{
+			name:    "fadd.d without D extension",
+			opts:    []Option{WithExtensions(ExtensionF)},
+			rawInst: faddD,
+			wantErr: true,
+		},
+		{
+			name:    "invalid dynamic rounding mode",
+			rawInst: faddSDyn,
+			setup: func(cpu *CPU) {
+				cpu.csrs.Set(CSRFcsr, 0b101<<fcsrFrmShift)
+			},
+			wantErr: true,
+		},
+		{
+			name:    "FS is off",
+			rawInst: faddS,
+			setup: func(cpu *CPU) {
+				cpu.csrs.Set(CSRMstatus, cpu.csrs.Get(CSRMstatus)&^mstatusFS)
+			},
+			wantErr: true,
+		},
+		{
+			name:    "F extension is disabled",
+			opts:    []Option{WithExtensions(ExtensionM)},
+			rawInst: faddS,
+			wantErr: true,
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			cpu := NewCPU(make([]byte, 4), tc.opts...)
+			if tc.setup != nil {
+				tc.setup(cpu)
+			}
+			err := cpu.Execute(cpu.Decode(tc.rawInst))
+			if tc.wantErr {
+				var e *Exception
+				if !errors.As(err, &e) || e.Code != IllegalInstruction {
+					t.Fatalf("want illegal instruction but got %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := cpu.fregs[1]; got != tc.want {
+				t.Errorf("want 0x%016x but got 0x%016x", tc.want, got)
+			}
+			if got := cpu.csrs.Get(CSRMstatus) & mstatusFS; got != fsDirty {
+				t.Errorf("want FS is dirty but got 0x%x", got)
+			}
+		})
+	}
+}
@@ -3,7 +3,8 @@ module github.com/Code-Hex/go-riscv
 go 1.17
 
 require (
-	github.com/google/go-cmp v0.5.7 // indirect
-	github.com/mattn/go-runewidth v0.0.9 // indirect
-	github.com/olekukonko/tablewriter v0.0.5 // indirect
+	github.com/google/go-cmp v0.5.7
+	github.com/olekukonko/tablewriter v0.0.5
 )
+
+require github.com/mattn/go-runewidth v0.0.9 // indirect
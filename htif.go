@@ -0,0 +1,164 @@
+package riscv
+
+import (
+	"fmt"
+	"io"
+)
+
+// HTIF is Berkeley Host-Target Interface which is used by riscv-tests and the programs
+// for Spike. The program writes the command to tohost, and HTIF writes the response
+// to fromhost. Both are 64-bit words in DRAM. The command is encoded as follows.
+//
+//	63      56 55     48 47                 0
+//	| device  | command |      payload       |
+//
+// The following devices and commands are supported.
+//
+//	device 0, command 0: If payload[0] is 1, the program exits with payload >> 1.
+//	                     Otherwise payload is the address of the system call arguments. (syscall proxy)
+//	device 1, command 1: payload[7:0] is written to the console.
+//
+// HTIF is mapped over tohost in front of DRAM. RV32 writes tohost by two 32-bit
+// stores, so the command is issued when its most significant byte is written, and
+// it is handled after the instruction. tohost is cleared when the command is handled.
+//
+// see: https://github.com/riscv-software-src/riscv-isa-sim/blob/master/fesvr/htif.cc
+type HTIF struct {
+	tohostAddr   uint64
+	fromhostAddr uint64
+	console      io.Writer
+	bus          *Bus
+
+	tohost uint64
+	// issued is set when the command is written and cleared when it is handled.
+	issued bool
+}
+
+var (
+	_ Device = (*HTIF)(nil)
+	_ Halter = (*HTIF)(nil)
+)
+
+const (
+	htifDeviceSyscall = 0
+	htifDeviceConsole = 1
+
+	htifConsolePutchar = 1
+
+	// htifSyscallArgs is the number of 64-bit words of the system call arguments.
+	// The first word is the system call number and it is overwritten by the return value.
+	htifSyscallArgs = 8
+	// enosys is returned for the system calls which are not supported.
+	enosys = 38
+)
+
+// NewHTIF creates HTIF which uses the 64-bit words at tohost and fromhost.
+// fromhost may be 0 if the program does not wait for the responses.
+// The bytes which are written to the console are written to console.
+func NewHTIF(tohost, fromhost uint64, console io.Writer) *HTIF {
+	if console == nil {
+		console = io.Discard
+	}
+	return &HTIF{
+		tohostAddr:   tohost,
+		fromhostAddr: fromhost,
+		console:      console,
+	}
+}
+
+// NewHTIFFromELF creates HTIF whose addresses are taken from "tohost" and "fromhost"
+// symbols of the program. fromhost is optional.
+func NewHTIFFromELF(p *Program, console io.Writer) (*HTIF, error) {
+	tohost, ok := p.Symbol("tohost")
+	if !ok {
+		return nil, fmt.Errorf("tohost symbol is not found")
+	}
+	fromhost, _ := p.Symbol("fromhost")
+	return NewHTIF(tohost, fromhost, console), nil
+}
+
+// WithHTIF connects HTIF to the CPU. HTIF takes precedence over DRAM at tohost.
+func WithHTIF(h *HTIF) Option {
+	return func(c *CPU) {
+		c.htif = h
+	}
+}
+
+// StartAddr represents start address for HTIF. It is the address of tohost.
+func (h *HTIF) StartAddr() uint64 { return h.tohostAddr }
+
+// EndAddr represents end of address for HTIF.
+func (h *HTIF) EndAddr() uint64 { return h.tohostAddr + 8 }
+
+// Read reads tohost. It is 0 after the command is handled.
+func (h *HTIF) Read(addr, size uint64) uint64 {
+	return readRegister(h.tohost, addr, size)
+}
+
+// Write writes tohost. The command is issued by writing the most significant byte.
+func (h *HTIF) Write(addr, size, value uint64) {
+	h.tohost = writeRegister(h.tohost, addr, size, value)
+	if addr+size == 8 {
+		h.issued = true
+	}
+}
+
+// Halt handles the issued command. It reports true if the program exits.
+func (h *HTIF) Halt() (RunResult, bool) {
+	if !h.issued {
+		return RunResult{}, false
+	}
+	cmd := h.tohost
+	h.issued, h.tohost = false, 0
+
+	device, command, payload := cmd>>56, cmd>>48&0xff, cmd&(1<<48-1)
+	switch {
+	case cmd == 0:
+	case device == htifDeviceSyscall && command == 0:
+		if payload&1 != 0 {
+			return RunResult{Reason: StopHalted, ExitCode: int(payload >> 1)}, true
+		}
+		if code, exit := h.syscall(payload); exit {
+			return RunResult{Reason: StopHalted, ExitCode: code}, true
+		}
+		h.respond(device, command, 1)
+	case device == htifDeviceConsole && command == htifConsolePutchar:
+		_, _ = h.console.Write([]byte{byte(payload)})
+		h.respond(device, command, 0x100|payload&0xff)
+	}
+	return RunResult{}, false
+}
+
+// respond writes the response to fromhost.
+func (h *HTIF) respond(device, command, payload uint64) {
+	if h.fromhostAddr != 0 {
+		_ = h.bus.Write(h.fromhostAddr, 8, device<<56|command<<48|payload)
+	}
+}
+
+// syscall performs the system call whose arguments are at addr. The return value
+// is written to the first word. It reports true with the exit code if the program exits.
+func (h *HTIF) syscall(addr uint64) (int, bool) {
+	var args [htifSyscallArgs]uint64
+	for i := range args {
+		v, err := h.bus.Read(addr+uint64(i)*8, 8)
+		if err != nil {
+			return 0, false
+		}
+		args[i] = v
+	}
+	ret := ^uint64(enosys - 1) // -ENOSYS
+	switch args[0] {
+	case sysExit:
+		return int(int32(args[1])), true
+	case sysWrite:
+		// write(fd, buf, count). Both stdout and stderr are written to the console.
+		fd, buf, count := args[1], args[2], args[3]
+		if fd != 1 && fd != 2 {
+			break
+		}
+		ret, _ = copyFromBus(h.console, h.bus, buf, count)
+	}
+	_ = h.bus.Write(addr, 8, ret)
+	return 0, false
+}
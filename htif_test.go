@@ -0,0 +1,231 @@
+package riscv
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestHTIFProgram(t *testing.T) {
+	f, err := os.Open(filepath.Join("testdata", "htif", "htif"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	p, err := LoadELF(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var console bytes.Buffer
+	h, err := NewHTIFFromELF(p, &console)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cpu := NewCPU(p.Image, WithXLEN(p.XLEN), WithEntryPoint(p.Entry), WithHTIF(h))
+	got, err := cpu.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (RunResult{Reason: StopHalted, ExitCode: 0}); got != want {
+		t.Errorf("want %+v but got %+v (gp=%d)", want, got, cpu.xregs[3])
+	}
+	if want := "ok\nhi\n"; console.String() != want {
+		t.Errorf("want console %q but got %q", want, console.String())
+	}
+}
+
+func TestRiscvTests(t *testing.T) {
+	tests := []string{
+		"rv32ui-p-simple",
+		"rv32ui-p-add",
+		"rv32ui-p-addi",
+		"rv32ui-p-and",
+		"rv32ui-p-andi",
+		"rv32ui-p-auipc",
+		"rv32ui-p-beq",
+		"rv32ui-p-bge",
+		"rv32ui-p-bgeu",
+		"rv32ui-p-blt",
+		"rv32ui-p-bltu",
+		"rv32ui-p-bne",
+		"rv32ui-p-fence_i",
+		"rv32ui-p-jal",
+		"rv32ui-p-jalr",
+		"rv32ui-p-lb",
+		"rv32ui-p-lbu",
+		"rv32ui-p-lh",
+		"rv32ui-p-lhu",
+		"rv32ui-p-lw",
+		"rv32ui-p-lui",
+		"rv32ui-p-ma_data",
+		"rv32ui-p-or",
+		"rv32ui-p-ori",
+		"rv32ui-p-sb",
+		"rv32ui-p-sh",
+		"rv32ui-p-sw",
+		"rv32ui-p-sll",
+		"rv32ui-p-slli",
+		"rv32ui-p-slt",
+		"rv32ui-p-slti",
+		"rv32ui-p-sltiu",
+		"rv32ui-p-sltu",
+		"rv32ui-p-sra",
+		"rv32ui-p-srai",
+		"rv32ui-p-srl",
+		"rv32ui-p-srli",
+		"rv32ui-p-sub",
+		"rv32ui-p-xor",
+		"rv32ui-p-xori",
+	}
+	for _, name := range tests {
+		name := name
+		t.Run(name, func(t *testing.T) {
+			f, err := os.Open(filepath.Join("testdata", "riscv-tests", "isa", name))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer f.Close()
+			p, err := LoadELF(f)
+			if err != nil {
+				t.Fatal(err)
+			}
+			h, err := NewHTIFFromELF(p, &bytes.Buffer{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			// ma_data expects the misaligned loads and stores to be handled without a trap.
+			cpu := NewCPU(p.Image, WithXLEN(p.XLEN), WithEntryPoint(p.Entry), WithHTIF(h), WithMisalignedAccess(true))
+			got, err := cpu.Run()
+			if err != nil {
+				t.Fatal(err)
+			}
+			// The failed test number is reported as the exit code.
+			if want := (RunResult{Reason: StopHalted, ExitCode: 0}); got != want {
+				t.Errorf("want %+v but got %+v", want, got)
+			}
+		})
+	}
+}
+
+func TestHTIF(t *testing.T) {
+	const (
+		tohost   = dramStartAddress + 0x1000
+		fromhost = dramStartAddress + 0x1040
+		args     = dramStartAddress + 0x100
+		buf      = dramStartAddress + 0x200
+	)
+	type access struct {
+		addr, size, value uint64
+	}
+	cases := []struct {
+		name         string
+		mem          []access
+		tohost       []access
+		want         RunResult
+		wantHalted   bool
+		wantConsole  string
+		wantFromhost uint64
+		wantRet      uint64
+	}{
+		{
+			name:       "exit",
+			tohost:     []access{{tohost, 8, 42<<1 | 1}},
+			want:       RunResult{Reason: StopHalted, ExitCode: 42},
+			wantHalted: true,
+		},
+		{
+			name:       "exit by two 32-bit writes",
+			tohost:     []access{{tohost, 4, 3<<1 | 1}, {tohost + 4, 4, 0}},
+			want:       RunResult{Reason: StopHalted, ExitCode: 3},
+			wantHalted: true,
+		},
+		{
+			// The lower word looks like the exit command until the upper word is written.
+			name:         "putchar by two 32-bit writes",
+			tohost:       []access{{tohost, 4, 'A'}, {tohost + 4, 4, 0x01010000}},
+			wantConsole:  "A",
+			wantFromhost: 1<<56 | 1<<48 | 0x100 | 'A',
+		},
+		{
+			name:        "putchar without the upper word",
+			tohost:      []access{{tohost, 4, 'A'}},
+			wantConsole: "",
+		},
+		{
+			name: "write syscall",
+			mem: []access{
+				{args, 8, sysWrite}, {args + 8, 8, 1}, {args + 16, 8, buf}, {args + 24, 8, 2},
+				{buf, 2, 'h' | 'i'<<8},
+			},
+			tohost:       []access{{tohost, 8, args}},
+			wantConsole:  "hi",
+			wantFromhost: 1,
+			wantRet:      2,
+		},
+		{
+			// The huge count stops at the end of DRAM.
+			name: "write syscall beyond the memory",
+			mem: []access{
+				{args, 8, sysWrite}, {args + 8, 8, 1}, {args + 16, 8, dramStartAddress + dramSize - 2}, {args + 24, 8, ^uint64(0)},
+				{dramStartAddress + dramSize - 2, 2, 'o' | 'k'<<8},
+			},
+			tohost:       []access{{tohost, 8, args}},
+			wantConsole:  "ok",
+			wantFromhost: 1,
+			wantRet:      2,
+		},
+		{
+			name:         "unknown syscall",
+			mem:          []access{{args, 8, 1024}},
+			tohost:       []access{{tohost, 8, args}},
+			wantFromhost: 1,
+			wantRet:      ^uint64(enosys - 1),
+		},
+		{
+			name:       "exit syscall",
+			mem:        []access{{args, 8, sysExit}, {args + 8, 8, 7}},
+			tohost:     []access{{tohost, 8, args}},
+			want:       RunResult{Reason: StopHalted, ExitCode: 7},
+			wantHalted: true,
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			var console bytes.Buffer
+			h := NewHTIF(tohost, fromhost, &console)
+			cpu := NewCPU(make([]byte, 0x2000), WithHTIF(h))
+			for _, a := range tc.mem {
+				if err := cpu.bus.Write(a.addr, a.size, a.value); err != nil {
+					t.Fatal(err)
+				}
+			}
+			var (
+				got    RunResult
+				halted bool
+			)
+			for _, a := range tc.tohost {
+				if err := cpu.bus.Write(a.addr, a.size, a.value); err != nil {
+					t.Fatal(err)
+				}
+				got, halted = h.Halt()
+			}
+			if halted != tc.wantHalted || got != tc.want {
+				t.Errorf("want %+v (%t) but got %+v (%t)", tc.want, tc.wantHalted, got, halted)
+			}
+			if console.String() != tc.wantConsole {
+				t.Errorf("want console %q but got %q", tc.wantConsole, console.String())
+			}
+			if got, _ := cpu.bus.Read(fromhost, 8); got != tc.wantFromhost {
+				t.Errorf("want fromhost 0x%x but got 0x%x", tc.wantFromhost, got)
+			}
+			if got, _ := cpu.bus.Read(args, 8); tc.wantRet != 0 && got != tc.wantRet {
+				t.Errorf("want return value 0x%x but got 0x%x", tc.wantRet, got)
+			}
+			// tohost is cleared when the command is handled.
+			if got, _ := cpu.bus.Read(tohost, 8); halted && got != 0 {
+				t.Errorf("want tohost is cleared but got 0x%x", got)
+			}
+		})
+	}
+}
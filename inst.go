@@ -1,7 +1,5 @@
 package riscv
 
-import "fmt"
-
 // Green Card
 // https://www.cl.cam.ac.uk/teaching/1617/ECAD+Arch/files/docs/RISCVGreenCardv8-20151013.pdf
 
@@ -14,8 +12,16 @@ type Instruction struct {
 	rs1    uint32
 	rs2    uint32
 	funct7 uint32
+	rs3    uint32
 	format InstFormat
-	imm    uint32
+	// imm is the immediate which is sign-extended to 64 bits.
+	imm uint64
+}
+
+// csr returns the address of the control and status register for Zicsr instructions.
+// The address is encoded in imm[11:0] of I-type instruction.
+func (i *Instruction) csr() uint32 {
+	return uint32(i.imm & 0xfff)
 }
 
 // Instformat is a format of instruction.
@@ -34,25 +40,58 @@ const (
 	UType InstFormat = "U"
 	// JType represent J instruction format.
 	JType InstFormat = "J"
+	// R4Type represent R4 instruction format which has three source registers.
+	// This is used by fused multiply-add instructions.
+	R4Type InstFormat = "R4"
 )
 
 const (
 	// OPLOAD represent opcode for load operations.
 	// LB, LH, LW...
 	OPLOAD = 0b0000011
+	// OPLOADFP represent opcode for floating-point load operations.
+	// FLW, FLD
+	OPLOADFP = 0b0000111
+	// OPMISCMEM represent opcode for memory ordering operations.
+	// FENCE, FENCE.I
+	OPMISCMEM = 0b0001111
 	// OPIMM represent opcode for operations are using immediate.
 	// ADDI, SLTI, SLTIU...
 	OPIMM = 0b0010011
 	// OPAUIPC represent opcode for AUIPC.
 	OPAUIPC = 0b0010111
+	// OPIMM32 represent opcode for operations are using immediate on 32-bit values. (RV64 only)
+	// ADDIW, SLLIW, SRLIW, SRAIW
+	OPIMM32 = 0b0011011
 	// OPSTORE represent opcode for store operations.
 	// SB, SH, SW...
 	OPSTORE = 0b0100011
+	// OPSTOREFP represent opcode for floating-point store operations.
+	// FSW, FSD
+	OPSTOREFP = 0b0100111
+	// OPAMO represent opcode for atomic memory operations.
+	// LR.W, SC.W, AMOSWAP.W...
+	// see: Chapter 8 "A" Standard Extension for Atomic Instructions
+	OPAMO = 0b0101111
 	// OPREG represent opcode for operations are using any registers.
 	// ADD, SUB, SLL...
 	OPREG = 0b0110011
 	// OPLUI represent opcode for LUI.
 	OPLUI = 0b0110111
+	// OPREG32 represent opcode for operations are using registers on 32-bit values. (RV64 only)
+	// ADDW, SUBW, SLLW...
+	OPREG32 = 0b0111011
+	// OPMADD represent opcode for FMADD.S and FMADD.D.
+	OPMADD = 0b1000011
+	// OPMSUB represent opcode for FMSUB.S and FMSUB.D.
+	OPMSUB = 0b1000111
+	// OPNMSUB represent opcode for FNMSUB.S and FNMSUB.D.
+	OPNMSUB = 0b1001011
+	// OPNMADD represent opcode for FNMADD.S and FNMADD.D.
+	OPNMADD = 0b1001111
+	// OPFP represent opcode for floating-point computational operations.
+	// FADD.S, FSUB.S, FMUL.S...
+	OPFP = 0b1010011
 	// OPBRANCH represent opcode for conditional operations.
 	// BEQ, BNE, BLT...
 	OPBRANCH = 0b1100011
@@ -74,6 +113,8 @@ const (
 // +---------------+--------------------+-------------+------------+------------+-------------+--------+
 // | R             | funct7             | rs2         | rs1        | funct3     | rd          | opcode |
 // +---------------+--------------------+-------------+------------+------------+-------------+--------+
+// | R4            | rs3`/`fmt          | rs2         | rs1        | rm         | rd          | opcode |
+// +---------------+--------------------+-------------+------------+------------+-------------+--------+
 // | I             | imm[11:5]`/`funct7 | imm[4:0]    | rs1        | funct3     | rd          | opcode |
 // +---------------+--------------------+-------------+------------+------------+-------------+--------+
 // | S             | imm[11:5]          | rs2         | rs1        | funct3     | imm[4:0]    | opcode |
@@ -87,11 +128,13 @@ const (
 func detectInstructionFormat(opcode, funct3 uint32) InstFormat {
 	// RV32I Base Instruction Set
 	switch opcode {
-	case OPREG: // in RV32I Base Instruction Set
+	case OPREG, OPREG32, OPAMO, OPFP:
 		return RType
-	case OPLOAD, OPIMM, OPJALR, OPSYSTEM:
+	case OPMADD, OPMSUB, OPNMSUB, OPNMADD:
+		return R4Type
+	case OPLOAD, OPLOADFP, OPMISCMEM, OPIMM, OPIMM32, OPJALR, OPSYSTEM:
 		return IType
-	case OPSTORE:
+	case OPSTORE, OPSTOREFP:
 		return SType
 	case OPBRANCH:
 		return BType
@@ -100,8 +143,8 @@ func detectInstructionFormat(opcode, funct3 uint32) InstFormat {
 	case OPJAL: // JAL
 		return JType
 	}
-
-	panic(fmt.Errorf("unexpected opcode: 0b%07b", opcode))
+	// unknown opcode. It will be an illegal instruction.
+	return ""
 }
 
 // I have referenced https://guillaume-savaton-eseo.github.io/emulsiV/doc/
@@ -1,6 +1,10 @@
 package alu
 
-import "fmt"
+import (
+	"fmt"
+	"math"
+	"math/bits"
+)
 
 const (
 	ADD  = "add"
@@ -13,8 +17,20 @@ const (
 	SLTU = "sltu"
 	SRL  = "srl"
 	SRA  = "sra"
+
+	// M Standard Extension
+	MUL    = "mul"
+	MULH   = "mulh"
+	MULHSU = "mulhsu"
+	MULHU  = "mulhu"
+	DIV    = "div"
+	DIVU   = "divu"
+	REM    = "rem"
+	REMU   = "remu"
 )
 
+// Compute performs the operation for XLEN=32.
+//
 // https://sites.pitt.edu/~kmram/CoE0147/lectures/datapath3.pdf
 // https://msyksphinz-self.github.io/riscv-isadoc/html/rvi.html#slti
 func Compute(op string, rs1, rs2 uint32) uint32 {
@@ -30,7 +46,8 @@ func Compute(op string, rs1, rs2 uint32) uint32 {
 	case AND:
 		return rs1 & rs2
 	case SLL:
-		return rs1 << rs2
+		// The shift amount is held in the lower 5 bits.
+		return rs1 << (rs2 & 0b11111)
 	case SLT:
 		if int32(rs1) < int32(rs2) {
 			return 1
@@ -42,9 +59,129 @@ func Compute(op string, rs1, rs2 uint32) uint32 {
 		}
 		return 0
 	case SRL:
-		return rs1 >> rs2
+		return rs1 >> (rs2 & 0b11111)
+	case SRA:
+		return uint32(int32(rs1) >> (rs2 & 0b11111))
+	case MUL:
+		return rs1 * rs2
+	case MULH:
+		return uint32((int64(int32(rs1)) * int64(int32(rs2))) >> 32)
+	case MULHSU:
+		return uint32((int64(int32(rs1)) * int64(rs2)) >> 32)
+	case MULHU:
+		return uint32((uint64(rs1) * uint64(rs2)) >> 32)
+	case DIV:
+		// 7.2 Division Operations
+		// The quotient of division by zero has all bits set.
+		// The quotient of signed division with overflow is equal to the dividend.
+		switch {
+		case rs2 == 0:
+			return math.MaxUint32
+		case int32(rs1) == math.MinInt32 && int32(rs2) == -1:
+			return rs1
+		}
+		return uint32(int32(rs1) / int32(rs2))
+	case DIVU:
+		if rs2 == 0 {
+			return math.MaxUint32
+		}
+		return rs1 / rs2
+	case REM:
+		// The remainder of division by zero equals the dividend.
+		// The remainder of signed division with overflow is zero.
+		switch {
+		case rs2 == 0:
+			return rs1
+		case int32(rs1) == math.MinInt32 && int32(rs2) == -1:
+			return 0
+		}
+		return uint32(int32(rs1) % int32(rs2))
+	case REMU:
+		if rs2 == 0 {
+			return rs1
+		}
+		return rs1 % rs2
+	}
+	panic(fmt.Errorf("invalid ALU operation: %q", op))
+}
+
+// Compute64 performs the operation for XLEN=64.
+func Compute64(op string, rs1, rs2 uint64) uint64 {
+	switch op {
+	case ADD:
+		return rs1 + rs2
+	case SUB:
+		return rs1 - rs2
+	case OR:
+		return rs1 | rs2
+	case XOR:
+		return rs1 ^ rs2
+	case AND:
+		return rs1 & rs2
+	case SLL:
+		// The shift amount is held in the lower 6 bits.
+		return rs1 << (rs2 & 0b111111)
+	case SLT:
+		if int64(rs1) < int64(rs2) {
+			return 1
+		}
+		return 0
+	case SLTU:
+		if rs1 < rs2 {
+			return 1
+		}
+		return 0
+	case SRL:
+		return rs1 >> (rs2 & 0b111111)
 	case SRA:
-		return rs1 >> int32(rs2)
+		return uint64(int64(rs1) >> (rs2 & 0b111111))
+	case MUL:
+		return rs1 * rs2
+	case MULH:
+		// The upper bits of the signed product are derived from the unsigned product.
+		hi, _ := bits.Mul64(rs1, rs2)
+		if int64(rs1) < 0 {
+			hi -= rs2
+		}
+		if int64(rs2) < 0 {
+			hi -= rs1
+		}
+		return hi
+	case MULHSU:
+		hi, _ := bits.Mul64(rs1, rs2)
+		if int64(rs1) < 0 {
+			hi -= rs2
+		}
+		return hi
+	case MULHU:
+		hi, _ := bits.Mul64(rs1, rs2)
+		return hi
+	case DIV:
+		switch {
+		case rs2 == 0:
+			return math.MaxUint64
+		case int64(rs1) == math.MinInt64 && int64(rs2) == -1:
+			return rs1
+		}
+		return uint64(int64(rs1) / int64(rs2))
+	case DIVU:
+		if rs2 == 0 {
+			return math.MaxUint64
+		}
+		return rs1 / rs2
+	case REM:
+		switch {
+		case rs2 == 0:
+			return rs1
+		case int64(rs1) == math.MinInt64 && int64(rs2) == -1:
+			return 0
+		}
+		return uint64(int64(rs1) % int64(rs2))
+	case REMU:
+		if rs2 == 0 {
+			return rs1
+		}
+		return rs1 % rs2
 	}
 	panic(fmt.Errorf("invalid ALU operation: %q", op))
 }
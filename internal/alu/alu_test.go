@@ -0,0 +1,24 @@
+package alu
+
+import "testing"
+
+func TestComputeShift(t *testing.T) {
+	cases := []struct {
+		op       string
+		rs1, rs2 uint32
+		want     uint32
+	}{
+		{op: SLL, rs1: 0x80000001, rs2: 1, want: 0x00000002},
+		{op: SLL, rs1: 0x80000001, rs2: 33, want: 0x00000002},
+		{op: SRL, rs1: 0x80000001, rs2: 1, want: 0x40000000},
+		{op: SRL, rs1: 0x80000001, rs2: 0xffffffe1, want: 0x40000000},
+		{op: SRA, rs1: 0x80000001, rs2: 1, want: 0xc0000000},
+		{op: SRA, rs1: 0x80000001, rs2: 31, want: 0xffffffff},
+		{op: SRA, rs1: 0x40000000, rs2: 33, want: 0x20000000},
+	}
+	for _, tc := range cases {
+		if got := Compute(tc.op, tc.rs1, tc.rs2); tc.want != got {
+			t.Errorf("%s 0x%08x, %d: want 0x%08x but got 0x%08x", tc.op, tc.rs1, tc.rs2, tc.want, got)
+		}
+	}
+}
@@ -11,6 +11,7 @@ const (
 	GEU = "geu"
 )
 
+// Comparator compares the values for XLEN=32.
 func Comparator(cmp string, rs1, rs2 uint32) bool {
 	switch cmp {
 	case EQ:
@@ -28,3 +29,22 @@ func Comparator(cmp string, rs1, rs2 uint32) bool {
 	}
 	panic(fmt.Errorf("invalid branch comparator: %q", cmp))
 }
+
+// Comparator64 compares the values for XLEN=64.
+func Comparator64(cmp string, rs1, rs2 uint64) bool {
+	switch cmp {
+	case EQ:
+		return rs1 == rs2
+	case NE:
+		return rs1 != rs2
+	case LT:
+		return int64(rs1) < int64(rs2)
+	case GE:
+		return int64(rs1) >= int64(rs2)
+	case LTU:
+		return rs1 < rs2
+	case GEU:
+		return rs1 >= rs2
+	}
+	panic(fmt.Errorf("invalid branch comparator: %q", cmp))
+}
@@ -0,0 +1,660 @@
+// Package fpu implements IEEE 754-2008 binary floating-point arithmetic which
+// is required by RISC-V "F" and "D" extensions.
+//
+// Go's float32 and float64 always round to nearest, ties to even and do not
+// report exceptions, so the operations are computed with math/big and rounded
+// to the destination format by the specified rounding mode. The accrued
+// exception flags are returned with the result.
+//
+// The values are passed as raw bits. Single-precision values use lower 32 bits.
+package fpu
+
+import (
+	"math"
+	"math/big"
+)
+
+// RoundingMode represents the rounding mode which is encoded in frm and
+// the rm field of the instructions.
+//
+// see: 11.2 Floating-Point Control and Status Register
+type RoundingMode uint32
+
+const (
+	// RNE rounds to nearest, ties to even.
+	RNE RoundingMode = 0b000
+	// RTZ rounds towards zero.
+	RTZ RoundingMode = 0b001
+	// RDN rounds down (towards -infinity).
+	RDN RoundingMode = 0b010
+	// RUP rounds up (towards +infinity).
+	RUP RoundingMode = 0b011
+	// RMM rounds to nearest, ties to max magnitude.
+	RMM RoundingMode = 0b100
+)
+
+// Valid reports whether the rounding mode is valid.
+func (rm RoundingMode) Valid() bool {
+	return rm <= RMM
+}
+
+func (rm RoundingMode) mode() big.RoundingMode {
+	switch rm {
+	case RTZ:
+		return big.ToZero
+	case RDN:
+		return big.ToNegativeInf
+	case RUP:
+		return big.ToPositiveInf
+	case RMM:
+		return big.ToNearestAway
+	}
+	return big.ToNearestEven
+}
+
+// Flags represents the accrued exception flags which are encoded in fflags.
+type Flags uint32
+
+const (
+	// NX is Inexact.
+	NX Flags = 1 << 0
+	// UF is Underflow.
+	UF Flags = 1 << 1
+	// OF is Overflow.
+	OF Flags = 1 << 2
+	// DZ is Divide by Zero.
+	DZ Flags = 1 << 3
+	// NV is Invalid Operation.
+	NV Flags = 1 << 4
+)
+
+// Format represents IEEE 754 binary interchange format.
+type Format struct {
+	expBits  uint
+	fracBits uint
+}
+
+var (
+	// Single is binary32 format.
+	Single = Format{expBits: 8, fracBits: 23}
+	// Double is binary64 format.
+	Double = Format{expBits: 11, fracBits: 52}
+)
+
+// Bits returns the width of the format.
+func (f Format) Bits() uint { return 1 + f.expBits + f.fracBits }
+
+// SignBit returns the mask of the sign bit.
+func (f Format) SignBit() uint64 { return 1 << (f.expBits + f.fracBits) }
+
+// CanonicalNaN returns the canonical NaN which is a quiet NaN with positive sign
+// and zero payload. RISC-V always returns it when the result is NaN.
+func (f Format) CanonicalNaN() uint64 {
+	return f.expMask()<<f.fracBits | 1<<(f.fracBits-1)
+}
+
+// prec is the precision (the number of significand bits) including the hidden bit.
+func (f Format) prec() uint       { return f.fracBits + 1 }
+func (f Format) bias() int        { return 1<<(f.expBits-1) - 1 }
+func (f Format) expMask() uint64  { return 1<<f.expBits - 1 }
+func (f Format) fracMask() uint64 { return 1<<f.fracBits - 1 }
+
+// emin and emax are the exponents of the minimum normal number and the maximum
+// finite number in the form of mant × 2^exp with 0.5 <= mant < 1. (see: big.Float.MantExp)
+func (f Format) emin() int { return 2 - f.bias() }
+func (f Format) emax() int { return f.bias() + 1 }
+
+func (f Format) zero(neg bool) uint64 {
+	if neg {
+		return f.SignBit()
+	}
+	return 0
+}
+
+func (f Format) inf(neg bool) uint64 {
+	return f.zero(neg) | f.expMask()<<f.fracBits
+}
+
+func (f Format) maxFinite(neg bool) uint64 {
+	return f.zero(neg) | (f.expMask()-1)<<f.fracBits | f.fracMask()
+}
+
+type class int
+
+const (
+	classZero class = iota
+	classSubnormal
+	classNormal
+	classInf
+	classQNaN
+	classSNaN
+)
+
+// number is an unpacked floating-point value.
+type number struct {
+	class class
+	neg   bool
+	// v is the exact value for subnormal and normal numbers.
+	v *big.Float
+}
+
+func (n number) isNaN() bool    { return n.class == classQNaN || n.class == classSNaN }
+func (n number) isFinite() bool { return n.class == classSubnormal || n.class == classNormal }
+
+func (f Format) unpack(a uint64) number {
+	neg := a&f.SignBit() != 0
+	exp := (a >> f.fracBits) & f.expMask()
+	frac := a & f.fracMask()
+	switch {
+	case exp == f.expMask() && frac == 0:
+		return number{class: classInf, neg: neg}
+	case exp == f.expMask() && frac&(1<<(f.fracBits-1)) != 0:
+		return number{class: classQNaN, neg: neg}
+	case exp == f.expMask():
+		return number{class: classSNaN, neg: neg}
+	case exp == 0 && frac == 0:
+		return number{class: classZero, neg: neg}
+	}
+	c := classNormal
+	mant := frac
+	if exp == 0 {
+		c = classSubnormal
+		exp = 1
+	} else {
+		mant |= 1 << f.fracBits
+	}
+	v := new(big.Float).SetUint64(mant)
+	v.SetMantExp(v, int(exp)-f.bias()-int(f.fracBits))
+	if neg {
+		v.Neg(v)
+	}
+	return number{class: c, neg: neg, v: v}
+}
+
+// nanFlags returns NV if any of operands is signaling NaN.
+func nanFlags(ns ...number) Flags {
+	for _, n := range ns {
+		if n.class == classSNaN {
+			return NV
+		}
+	}
+	return 0
+}
+
+// round rounds the exact non-zero finite value v to the format.
+//
+// Tininess is detected after rounding as RISC-V requires.
+func (f Format) round(v *big.Float, rm RoundingMode) (uint64, Flags) {
+	neg := v.Signbit()
+	p := f.prec()
+
+	// Round as if the exponent range is unbounded.
+	r := new(big.Float).SetMode(rm.mode()).SetPrec(p).Set(v)
+	exp := r.MantExp(nil)
+	if exp > f.emax() {
+		return f.overflow(neg, rm), OF | NX
+	}
+	if v.MantExp(nil) >= f.emin() {
+		var flags Flags
+		if r.Acc() != big.Exact {
+			flags |= NX
+		}
+		return f.encode(r), flags
+	}
+
+	// Subnormal range. The result is a multiple of the minimum subnormal number.
+	q := new(big.Float).SetMantExp(v, int(p)-f.emin())
+	n, inexact := roundToInt(q, rm)
+	var flags Flags
+	if inexact {
+		flags |= NX
+		if exp < f.emin() {
+			flags |= UF
+		}
+	}
+	if n.Sign() == 0 {
+		return f.zero(neg), flags
+	}
+	res := new(big.Float).SetInt(n)
+	res.SetMantExp(res, f.emin()-int(p))
+	return f.encode(res), flags
+}
+
+// overflow returns the result of overflow which depends on the rounding mode.
+func (f Format) overflow(neg bool, rm RoundingMode) uint64 {
+	switch rm {
+	case RTZ:
+		return f.maxFinite(neg)
+	case RDN:
+		if !neg {
+			return f.maxFinite(neg)
+		}
+	case RUP:
+		if neg {
+			return f.maxFinite(neg)
+		}
+	}
+	return f.inf(neg)
+}
+
+// encode encodes v which is exactly representable in the format.
+func (f Format) encode(v *big.Float) uint64 {
+	neg := v.Signbit()
+	if v.Sign() == 0 {
+		return f.zero(neg)
+	}
+	mant := new(big.Float)
+	exp := mant.SetPrec(0).Set(v).MantExp(mant)
+	mant.Abs(mant)
+	if exp >= f.emin() {
+		m, _ := new(big.Float).SetMantExp(mant, int(f.prec())).Uint64()
+		biased := uint64(exp - 1 + f.bias())
+		return f.zero(neg) | biased<<f.fracBits | m&f.fracMask()
+	}
+	m, _ := new(big.Float).SetMantExp(mant, exp+int(f.prec())-f.emin()).Uint64()
+	return f.zero(neg) | m
+}
+
+var half = big.NewFloat(0.5)
+
+// roundToInt rounds x to an integer by the rounding mode.
+// It also reports whether the result is inexact.
+func roundToInt(x *big.Float, rm RoundingMode) (*big.Int, bool) {
+	t, acc := x.Int(nil) // truncated towards zero.
+	if acc == big.Exact {
+		return t, false
+	}
+	frac := new(big.Float).SetPrec(x.MinPrec()).Sub(x, new(big.Float).SetInt(t))
+	frac.Abs(frac)
+	cmp := frac.Cmp(half)
+	var away bool
+	switch rm {
+	case RNE:
+		away = cmp > 0 || (cmp == 0 && t.Bit(0) == 1)
+	case RMM:
+		away = cmp >= 0
+	case RDN:
+		away = x.Sign() < 0
+	case RUP:
+		away = x.Sign() > 0
+	}
+	if away {
+		if x.Sign() < 0 {
+			t.Sub(t, big.NewInt(1))
+		} else {
+			t.Add(t, big.NewInt(1))
+		}
+	}
+	return t, true
+}
+
+// addPrec returns the precision which is needed to hold the exact sum of x and y
+// which have xprec and yprec significand bits.
+func addPrec(x, y *big.Float, xprec, yprec uint) uint {
+	ex, ey := x.MantExp(nil), y.MantExp(nil)
+	d := ex - ey
+	if d < 0 {
+		d = -d
+	}
+	return uint(d) + xprec + yprec + 2
+}
+
+// sticky sets the sticky bit below the least significant bit of the truncated value t
+// if t is inexact. Rounding the result to the smaller precision is always correct.
+func sticky(t *big.Float, inexact bool) *big.Float {
+	if !inexact {
+		return t
+	}
+	prec := t.Prec() + 1
+	ulp := new(big.Float).SetMantExp(big.NewFloat(0.5), t.MantExp(nil)-int(t.Prec()))
+	if t.Signbit() {
+		ulp.Neg(ulp)
+	}
+	return new(big.Float).SetPrec(prec).Add(t, ulp)
+}
+
+// Add returns a + b.
+func (f Format) Add(a, b uint64, rm RoundingMode) (uint64, Flags) {
+	x, y := f.unpack(a), f.unpack(b)
+	if x.isNaN() || y.isNaN() {
+		return f.CanonicalNaN(), nanFlags(x, y)
+	}
+	switch {
+	case x.class == classInf && y.class == classInf:
+		if x.neg != y.neg {
+			return f.CanonicalNaN(), NV
+		}
+		return f.inf(x.neg), 0
+	case x.class == classInf:
+		return f.inf(x.neg), 0
+	case y.class == classInf:
+		return f.inf(y.neg), 0
+	case x.class == classZero && y.class == classZero:
+		if x.neg == y.neg {
+			return f.zero(x.neg), 0
+		}
+		return f.zero(rm == RDN), 0
+	case x.class == classZero:
+		return b, 0
+	case y.class == classZero:
+		return a, 0
+	}
+	sum := new(big.Float).SetPrec(addPrec(x.v, y.v, f.prec(), f.prec())).Add(x.v, y.v)
+	if sum.Sign() == 0 {
+		return f.zero(rm == RDN), 0
+	}
+	return f.round(sum, rm)
+}
+
+// Sub returns a - b.
+func (f Format) Sub(a, b uint64, rm RoundingMode) (uint64, Flags) {
+	return f.Add(a, b^f.SignBit(), rm)
+}
+
+// Mul returns a × b.
+func (f Format) Mul(a, b uint64, rm RoundingMode) (uint64, Flags) {
+	x, y := f.unpack(a), f.unpack(b)
+	if x.isNaN() || y.isNaN() {
+		return f.CanonicalNaN(), nanFlags(x, y)
+	}
+	neg := x.neg != y.neg
+	switch {
+	case x.class == classInf && y.class == classZero,
+		x.class == classZero && y.class == classInf:
+		return f.CanonicalNaN(), NV
+	case x.class == classInf || y.class == classInf:
+		return f.inf(neg), 0
+	case x.class == classZero || y.class == classZero:
+		return f.zero(neg), 0
+	}
+	prod := new(big.Float).SetPrec(2*f.prec()).Mul(x.v, y.v)
+	return f.round(prod, rm)
+}
+
+// MulAdd returns a × b + c with only one rounding.
+func (f Format) MulAdd(a, b, c uint64, rm RoundingMode) (uint64, Flags) {
+	x, y, z := f.unpack(a), f.unpack(b), f.unpack(c)
+	// The invalid operation exception is raised for ∞ × 0 even if the addend is a quiet NaN.
+	if (x.class == classInf && y.class == classZero) || (x.class == classZero && y.class == classInf) {
+		return f.CanonicalNaN(), NV
+	}
+	if x.isNaN() || y.isNaN() || z.isNaN() {
+		return f.CanonicalNaN(), nanFlags(x, y, z)
+	}
+	neg := x.neg != y.neg
+	switch {
+	case x.class == classInf || y.class == classInf:
+		if z.class == classInf && z.neg != neg {
+			return f.CanonicalNaN(), NV
+		}
+		return f.inf(neg), 0
+	case z.class == classInf:
+		return f.inf(z.neg), 0
+	case x.class == classZero || y.class == classZero:
+		if z.class == classZero {
+			if z.neg == neg {
+				return f.zero(neg), 0
+			}
+			return f.zero(rm == RDN), 0
+		}
+		return c, 0
+	}
+	prod := new(big.Float).SetPrec(2*f.prec()).Mul(x.v, y.v)
+	if z.class == classZero {
+		return f.round(prod, rm)
+	}
+	sum := new(big.Float).SetPrec(addPrec(prod, z.v, 2*f.prec(), f.prec())).Add(prod, z.v)
+	if sum.Sign() == 0 {
+		return f.zero(rm == RDN), 0
+	}
+	return f.round(sum, rm)
+}
+
+// Div returns a ÷ b.
+func (f Format) Div(a, b uint64, rm RoundingMode) (uint64, Flags) {
+	x, y := f.unpack(a), f.unpack(b)
+	if x.isNaN() || y.isNaN() {
+		return f.CanonicalNaN(), nanFlags(x, y)
+	}
+	neg := x.neg != y.neg
+	switch {
+	case x.class == classInf && y.class == classInf,
+		x.class == classZero && y.class == classZero:
+		return f.CanonicalNaN(), NV
+	case x.class == classInf:
+		return f.inf(neg), 0
+	case y.class == classInf:
+		return f.zero(neg), 0
+	case y.class == classZero:
+		return f.inf(neg), DZ
+	case x.class == classZero:
+		return f.zero(neg), 0
+	}
+	q := new(big.Float).SetMode(big.ToZero).SetPrec(2*f.prec()+3).Quo(x.v, y.v)
+	return f.round(sticky(q, q.Acc() != big.Exact), rm)
+}
+
+// Sqrt returns the square root of a.
+func (f Format) Sqrt(a uint64, rm RoundingMode) (uint64, Flags) {
+	x := f.unpack(a)
+	switch {
+	case x.isNaN():
+		return f.CanonicalNaN(), nanFlags(x)
+	case x.class == classZero:
+		return a, 0
+	case x.neg:
+		return f.CanonicalNaN(), NV
+	case x.class == classInf:
+		return a, 0
+	}
+	prec := 2*f.prec() + 3
+	s := new(big.Float).SetPrec(prec + 16).Sqrt(x.v)
+	t := new(big.Float).SetMode(big.ToZero).SetPrec(prec).Set(s)
+	// Correct the truncated root t so that t² <= x < (t+ulp)².
+	ulp := new(big.Float).SetMantExp(big.NewFloat(1), t.MantExp(nil)-int(prec))
+	square := func(v *big.Float) *big.Float {
+		return new(big.Float).SetPrec(2*prec+2).Mul(v, v)
+	}
+	for square(t).Cmp(x.v) > 0 {
+		t.Sub(t, ulp)
+	}
+	for {
+		next := new(big.Float).SetPrec(prec+1).Add(t, ulp)
+		if square(next).Cmp(x.v) > 0 {
+			break
+		}
+		t.SetPrec(prec + 1).Set(next)
+	}
+	return f.round(sticky(t, square(t).Cmp(x.v) != 0), rm)
+}
+
+// Convert converts a in the format src to the format f.
+func (f Format) Convert(src Format, a uint64, rm RoundingMode) (uint64, Flags) {
+	x := src.unpack(a)
+	switch {
+	case x.isNaN():
+		return f.CanonicalNaN(), nanFlags(x)
+	case x.class == classInf:
+		return f.inf(x.neg), 0
+	case x.class == classZero:
+		return f.zero(x.neg), 0
+	}
+	return f.round(x.v, rm)
+}
+
+// FromInt converts the signed integer to the format.
+func (f Format) FromInt(v int64, rm RoundingMode) (uint64, Flags) {
+	if v == 0 {
+		return 0, 0
+	}
+	return f.round(new(big.Float).SetInt64(v), rm)
+}
+
+// FromUint converts the unsigned integer to the format.
+func (f Format) FromUint(v uint64, rm RoundingMode) (uint64, Flags) {
+	if v == 0 {
+		return 0, 0
+	}
+	return f.round(new(big.Float).SetUint64(v), rm)
+}
+
+// ToInt converts a to the signed integer which has bits width.
+// The out of range values and NaN are clamped and the invalid operation exception is raised.
+//
+// see: Table 11.4 Domains of float-to-integer conversions and behavior for invalid inputs.
+func (f Format) ToInt(a uint64, bits uint, rm RoundingMode) (int64, Flags) {
+	max := int64(1)<<(bits-1) - 1
+	min := -max - 1
+	x := f.unpack(a)
+	switch {
+	case x.isNaN():
+		return max, NV
+	case x.class == classInf && x.neg:
+		return min, NV
+	case x.class == classInf:
+		return max, NV
+	case x.class == classZero:
+		return 0, 0
+	}
+	n, inexact := roundToInt(x.v, rm)
+	if n.Cmp(big.NewInt(max)) > 0 {
+		return max, NV
+	}
+	if n.Cmp(big.NewInt(min)) < 0 {
+		return min, NV
+	}
+	if inexact {
+		return n.Int64(), NX
+	}
+	return n.Int64(), 0
+}
+
+// ToUint converts a to the unsigned integer which has bits width.
+// The out of range values and NaN are clamped and the invalid operation exception is raised.
+func (f Format) ToUint(a uint64, bits uint, rm RoundingMode) (uint64, Flags) {
+	max := uint64(math.MaxUint64) >> (64 - bits)
+	x := f.unpack(a)
+	switch {
+	case x.isNaN():
+		return max, NV
+	case x.class == classInf && x.neg:
+		return 0, NV
+	case x.class == classInf:
+		return max, NV
+	case x.class == classZero:
+		return 0, 0
+	}
+	n, inexact := roundToInt(x.v, rm)
+	if n.Sign() < 0 {
+		return 0, NV
+	}
+	if n.Cmp(new(big.Int).SetUint64(max)) > 0 {
+		return max, NV
+	}
+	if inexact {
+		return n.Uint64(), NX
+	}
+	return n.Uint64(), 0
+}
+
+// float64 returns the value as float64. It is exact for both formats.
+func (f Format) float64(a uint64) float64 {
+	if f == Single {
+		return float64(math.Float32frombits(uint32(a)))
+	}
+	return math.Float64frombits(a)
+}
+
+// Eq reports whether a == b. Only signaling NaN raises the invalid operation exception.
+func (f Format) Eq(a, b uint64) (bool, Flags) {
+	x, y := f.unpack(a), f.unpack(b)
+	if x.isNaN() || y.isNaN() {
+		return false, nanFlags(x, y)
+	}
+	return f.float64(a) == f.float64(b), 0
+}
+
+// Lt reports whether a < b. Any NaN raises the invalid operation exception.
+func (f Format) Lt(a, b uint64) (bool, Flags) {
+	x, y := f.unpack(a), f.unpack(b)
+	if x.isNaN() || y.isNaN() {
+		return false, NV
+	}
+	return f.float64(a) < f.float64(b), 0
+}
+
+// Le reports whether a <= b. Any NaN raises the invalid operation exception.
+func (f Format) Le(a, b uint64) (bool, Flags) {
+	x, y := f.unpack(a), f.unpack(b)
+	if x.isNaN() || y.isNaN() {
+		return false, NV
+	}
+	return f.float64(a) <= f.float64(b), 0
+}
+
+// Min returns the minimum number. -0 is considered to be less than +0.
+// If only one operand is NaN, the result is the non-NaN operand.
+func (f Format) Min(a, b uint64) (uint64, Flags) {
+	return f.minMax(a, b, true)
+}
+
+// Max returns the maximum number. +0 is considered to be greater than -0.
+// If only one operand is NaN, the result is the non-NaN operand.
+func (f Format) Max(a, b uint64) (uint64, Flags) {
+	return f.minMax(a, b, false)
+}
+
+func (f Format) minMax(a, b uint64, min bool) (uint64, Flags) {
+	x, y := f.unpack(a), f.unpack(b)
+	flags := nanFlags(x, y)
+	switch {
+	case x.isNaN() && y.isNaN():
+		return f.CanonicalNaN(), flags
+	case x.isNaN():
+		return b, flags
+	case y.isNaN():
+		return a, flags
+	}
+	if x.class == classZero && y.class == classZero {
+		if x.neg == min {
+			return a, 0
+		}
+		return b, 0
+	}
+	if (f.float64(a) < f.float64(b)) == min {
+		return a, 0
+	}
+	return b, 0
+}
+
+// Classify returns the mask which indicates the class of the value.
+//
+// see: Table 11.5 Format of result of FCLASS instruction.
+func (f Format) Classify(a uint64) uint64 {
+	x := f.unpack(a)
+	switch x.class {
+	case classInf:
+		if x.neg {
+			return 1 << 0
+		}
+		return 1 << 7
+	case classNormal:
+		if x.neg {
+			return 1 << 1
+		}
+		return 1 << 6
+	case classSubnormal:
+		if x.neg {
+			return 1 << 2
+		}
+		return 1 << 5
+	case classZero:
+		if x.neg {
+			return 1 << 3
+		}
+		return 1 << 4
+	case classSNaN:
+		return 1 << 8
+	}
+	return 1 << 9
+}
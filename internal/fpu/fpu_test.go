@@ -0,0 +1,207 @@
+package fpu
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+)
+
+// randomFloat64 returns random bits which are biased to the interesting values.
+func randomFloat64(r *rand.Rand) uint64 {
+	switch r.Intn(8) {
+	case 0:
+		// subnormal
+		return r.Uint64() & (Double.SignBit() | Double.fracMask())
+	case 1:
+		// near the minimum normal
+		return r.Uint64()&(Double.SignBit()|Double.fracMask()) | uint64(r.Intn(60))<<52
+	case 2:
+		// near the maximum
+		return r.Uint64()&(Double.SignBit()|Double.fracMask()) | uint64(0x7fe-r.Intn(4))<<52
+	}
+	return math.Float64bits(r.NormFloat64() * math.Pow(2, float64(r.Intn(200)-100)))
+}
+
+func randomFloat32(r *rand.Rand) uint32 {
+	switch r.Intn(8) {
+	case 0:
+		return r.Uint32() & 0x807fffff
+	case 1:
+		return r.Uint32()&0x807fffff | uint32(r.Intn(30))<<23
+	case 2:
+		return r.Uint32()&0x807fffff | uint32(0xfe-r.Intn(4))<<23
+	}
+	return math.Float32bits(float32(r.NormFloat64() * math.Pow(2, float64(r.Intn(60)-30))))
+}
+
+func sameFloat64(got uint64, want float64) bool {
+	if math.IsNaN(want) {
+		return got == Double.CanonicalNaN()
+	}
+	return got == math.Float64bits(want)
+}
+
+func sameFloat32(got uint64, want float32) bool {
+	if want != want {
+		return got == Single.CanonicalNaN()
+	}
+	return got == uint64(math.Float32bits(want))
+}
+
+// Go's floating-point arithmetic rounds to nearest, ties to even.
+func TestNearestEvenDouble(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < 20000; i++ {
+		a, b, c := randomFloat64(r), randomFloat64(r), randomFloat64(r)
+		x, y, z := math.Float64frombits(a), math.Float64frombits(b), math.Float64frombits(c)
+		if got, _ := Double.Add(a, b, RNE); !sameFloat64(got, x+y) {
+			t.Fatalf("%v + %v: want %v but got %v", x, y, x+y, math.Float64frombits(got))
+		}
+		if got, _ := Double.Sub(a, b, RNE); !sameFloat64(got, x-y) {
+			t.Fatalf("%v - %v: want %v but got %v", x, y, x-y, math.Float64frombits(got))
+		}
+		if got, _ := Double.Mul(a, b, RNE); !sameFloat64(got, x*y) {
+			t.Fatalf("%v * %v: want %v but got %v", x, y, x*y, math.Float64frombits(got))
+		}
+		if got, _ := Double.Div(a, b, RNE); !sameFloat64(got, x/y) {
+			t.Fatalf("%v / %v: want %v but got %v", x, y, x/y, math.Float64frombits(got))
+		}
+		if got, _ := Double.Sqrt(a, RNE); !sameFloat64(got, math.Sqrt(x)) {
+			t.Fatalf("sqrt(%v): want %v but got %v", x, math.Sqrt(x), math.Float64frombits(got))
+		}
+		if want := math.FMA(x, y, z); true {
+			if got, _ := Double.MulAdd(a, b, c, RNE); !sameFloat64(got, want) {
+				t.Fatalf("%v * %v + %v: want %v but got %v", x, y, z, want, math.Float64frombits(got))
+			}
+		}
+		if got, _ := Single.Convert(Double, a, RNE); !sameFloat32(got, float32(x)) {
+			t.Fatalf("float32(%v): want %v but got %v", x, float32(x), math.Float32frombits(uint32(got)))
+		}
+	}
+}
+
+func TestNearestEvenSingle(t *testing.T) {
+	r := rand.New(rand.NewSource(2))
+	for i := 0; i < 20000; i++ {
+		a, b := randomFloat32(r), randomFloat32(r)
+		x, y := math.Float32frombits(a), math.Float32frombits(b)
+		if got, _ := Single.Add(uint64(a), uint64(b), RNE); !sameFloat32(got, x+y) {
+			t.Fatalf("%v + %v: want %v but got %v", x, y, x+y, math.Float32frombits(uint32(got)))
+		}
+		if got, _ := Single.Mul(uint64(a), uint64(b), RNE); !sameFloat32(got, x*y) {
+			t.Fatalf("%v * %v: want %v but got %v", x, y, x*y, math.Float32frombits(uint32(got)))
+		}
+		if got, _ := Single.Div(uint64(a), uint64(b), RNE); !sameFloat32(got, x/y) {
+			t.Fatalf("%v / %v: want %v but got %v", x, y, x/y, math.Float32frombits(uint32(got)))
+		}
+		want := float32(math.Sqrt(float64(x)))
+		if got, _ := Single.Sqrt(uint64(a), RNE); !sameFloat32(got, want) {
+			t.Fatalf("sqrt(%v): want %v but got %v", x, want, math.Float32frombits(uint32(got)))
+		}
+	}
+}
+
+func TestRoundingMode(t *testing.T) {
+	one := math.Float32bits(1)
+	three := math.Float32bits(3)
+	cases := []struct {
+		name      string
+		rm        RoundingMode
+		a, b      uint32
+		want      uint32
+		wantFlags Flags
+	}{
+		{name: "1/3 rne", rm: RNE, a: one, b: three, want: 0x3eaaaaab, wantFlags: NX},
+		{name: "1/3 rtz", rm: RTZ, a: one, b: three, want: 0x3eaaaaaa, wantFlags: NX},
+		{name: "1/3 rdn", rm: RDN, a: one, b: three, want: 0x3eaaaaaa, wantFlags: NX},
+		{name: "1/3 rup", rm: RUP, a: one, b: three, want: 0x3eaaaaab, wantFlags: NX},
+		{name: "-1/3 rdn", rm: RDN, a: one | 1<<31, b: three, want: 0xbeaaaaab, wantFlags: NX},
+		{name: "-1/3 rup", rm: RUP, a: one | 1<<31, b: three, want: 0xbeaaaaaa, wantFlags: NX},
+		{name: "3/3 exact", rm: RUP, a: three, b: three, want: one},
+		{name: "1/0", rm: RNE, a: one, b: 0, want: 0x7f800000, wantFlags: DZ},
+		{name: "0/0", rm: RNE, a: 0, b: 0, want: 0x7fc00000, wantFlags: NV},
+		{name: "max/0.5 rne", rm: RNE, a: 0x7f7fffff, b: 0x3f000000, want: 0x7f800000, wantFlags: OF | NX},
+		{name: "max/0.5 rtz", rm: RTZ, a: 0x7f7fffff, b: 0x3f000000, want: 0x7f7fffff, wantFlags: OF | NX},
+		{name: "min subnormal/2 rne", rm: RNE, a: 1, b: 0x40000000, want: 0, wantFlags: UF | NX},
+		{name: "min subnormal/2 rup", rm: RUP, a: 1, b: 0x40000000, want: 1, wantFlags: UF | NX},
+		{name: "3*min subnormal/2 rmm", rm: RMM, a: 3, b: 0x40000000, want: 2, wantFlags: UF | NX},
+		{name: "2*min subnormal/2 exact", rm: RNE, a: 2, b: 0x40000000, want: 1},
+		{name: "snan", rm: RNE, a: 0x7f800001, b: one, want: 0x7fc00000, wantFlags: NV},
+		{name: "qnan", rm: RNE, a: 0x7fc00001, b: one, want: 0x7fc00000},
+	}
+	for _, tc := range cases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			got, flags := Single.Div(uint64(tc.a), uint64(tc.b), tc.rm)
+			if got != uint64(tc.want) {
+				t.Errorf("want 0x%08x but got 0x%08x", tc.want, got)
+			}
+			if flags != tc.wantFlags {
+				t.Errorf("want flags %05b but got %05b", tc.wantFlags, flags)
+			}
+		})
+	}
+}
+
+func TestTininessAfterRounding(t *testing.T) {
+	// (min normal - 1/2 ulp) rounds up to the min normal in RNE.
+	// It is not tiny after rounding, so underflow is not raised.
+	minNormal := uint64(0x00800000)
+	got, flags := Single.Mul(0x007fffff, 0x3f800001, RNE) // largest subnormal × (1 + 2^-23)
+	if got != minNormal || flags != NX {
+		t.Errorf("want 0x%08x (NX) but got 0x%08x (%05b)", minNormal, got, flags)
+	}
+}
+
+func TestToInt(t *testing.T) {
+	cases := []struct {
+		name      string
+		a         float32
+		rm        RoundingMode
+		want      int64
+		wantFlags Flags
+	}{
+		{name: "2.5 rne", a: 2.5, rm: RNE, want: 2, wantFlags: NX},
+		{name: "2.5 rmm", a: 2.5, rm: RMM, want: 3, wantFlags: NX},
+		{name: "-2.5 rdn", a: -2.5, rm: RDN, want: -3, wantFlags: NX},
+		{name: "-2.5 rtz", a: -2.5, rm: RTZ, want: -2, wantFlags: NX},
+		{name: "-2.5 rup", a: -2.5, rm: RUP, want: -2, wantFlags: NX},
+		{name: "3", a: 3, rm: RNE, want: 3},
+		{name: "large", a: 3e9, rm: RNE, want: math.MaxInt32, wantFlags: NV},
+		{name: "-inf", a: float32(math.Inf(-1)), rm: RNE, want: math.MinInt32, wantFlags: NV},
+		{name: "nan", a: float32(math.NaN()), rm: RNE, want: math.MaxInt32, wantFlags: NV},
+	}
+	for _, tc := range cases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			got, flags := Single.ToInt(uint64(math.Float32bits(tc.a)), 32, tc.rm)
+			if got != tc.want || flags != tc.wantFlags {
+				t.Errorf("want %d (%05b) but got %d (%05b)", tc.want, tc.wantFlags, got, flags)
+			}
+		})
+	}
+
+	if got, flags := Single.ToUint(uint64(math.Float32bits(-0.5)), 32, RNE); got != 0 || flags != NX {
+		t.Errorf("-0.5: want 0 (NX) but got %d (%05b)", got, flags)
+	}
+	if got, flags := Single.ToUint(uint64(math.Float32bits(-1)), 32, RNE); got != 0 || flags != NV {
+		t.Errorf("-1: want 0 (NV) but got %d (%05b)", got, flags)
+	}
+}
+
+func TestMinMax(t *testing.T) {
+	negZero, posZero := uint64(0x80000000), uint64(0)
+	if got, _ := Single.Min(posZero, negZero); got != negZero {
+		t.Errorf("min(+0, -0): want -0 but got 0x%08x", got)
+	}
+	if got, _ := Single.Max(negZero, posZero); got != posZero {
+		t.Errorf("max(-0, +0): want +0 but got 0x%08x", got)
+	}
+	one := uint64(math.Float32bits(1))
+	if got, flags := Single.Min(0x7f800001, one); got != one || flags != NV {
+		t.Errorf("min(snan, 1): want 1 (NV) but got 0x%08x (%05b)", got, flags)
+	}
+	if got, flags := Single.Max(0x7fc00000, 0x7fc00000); got != Single.CanonicalNaN() || flags != 0 {
+		t.Errorf("max(qnan, qnan): want canonical NaN but got 0x%08x (%05b)", got, flags)
+	}
+}
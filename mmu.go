@@ -0,0 +1,325 @@
+package riscv
+
+// accessType represents the type of the memory access which is translated by the MMU.
+type accessType int
+
+const (
+	accessInstruction accessType = iota
+	accessLoad
+	accessStore
+)
+
+// pageFault returns the page fault exception for the access type.
+func (a accessType) pageFault(vaddr uint64) *Exception {
+	switch a {
+	case accessInstruction:
+		return &Exception{Code: InstructionPageFault, Value: vaddr}
+	case accessLoad:
+		return &Exception{Code: LoadPageFault, Value: vaddr}
+	}
+	return &Exception{Code: StoreAMOPageFault, Value: vaddr}
+}
+
+// accessFault returns the access fault exception for the access type.
+func (a accessType) accessFault(addr uint64) *Exception {
+	switch a {
+	case accessInstruction:
+		return &Exception{Code: InstructionAccessFault, Value: addr}
+	case accessLoad:
+		return &Exception{Code: LoadAccessFault, Value: addr}
+	}
+	return &Exception{Code: StoreAMOAccessFault, Value: addr}
+}
+
+// Page table entry fields.
+//
+// see: 4.3.1 Addressing and Memory Protection
+const (
+	pteV = 1 << 0
+	pteR = 1 << 1
+	pteW = 1 << 2
+	pteX = 1 << 3
+	pteU = 1 << 4
+	pteG = 1 << 5
+	pteA = 1 << 6
+	pteD = 1 << 7
+
+	// ptePPNShift is the position of PPN field in the page table entry.
+	ptePPNShift = 10
+)
+
+const (
+	// pageShift is the number of bits of the page offset. The page size is 4 KiB.
+	pageShift = 12
+	pageSize  = 1 << pageShift
+)
+
+// pagingMode represents the structure of the virtual address and the page table.
+type pagingMode struct {
+	// levels is the number of the levels of the page table.
+	levels int
+	// vpnBits is the number of bits of each VPN field.
+	vpnBits uint
+	// pteSize is the size of the page table entry in bytes.
+	pteSize uint64
+	// ppnBits is the number of bits of PPN field in the page table entry.
+	// The bits above PPN field are reserved and must be zero.
+	ppnBits uint
+	// vaBits is the number of bits of the virtual address. The upper bits of
+	// the virtual address must be equal to the most significant bit in RV64.
+	vaBits uint
+}
+
+var (
+	// sv32 is Page-Based 32-bit Virtual-Memory System.
+	//
+	// see: 4.3 Sv32: Page-Based 32-bit Virtual-Memory Systems
+	sv32 = pagingMode{levels: 2, vpnBits: 10, pteSize: 4, ppnBits: 22, vaBits: 32}
+	// sv39 is Page-Based 39-bit Virtual-Memory System. It supports 1 GiB gigapages
+	// and 2 MiB megapages.
+	//
+	// see: 4.4 Sv39: Page-Based 39-bit Virtual-Memory System
+	sv39 = pagingMode{levels: 3, vpnBits: 9, pteSize: 8, ppnBits: 44, vaBits: 39}
+	// sv48 is Page-Based 48-bit Virtual-Memory System. It supports 512 GiB terapages
+	// in addition to the pages of Sv39.
+	//
+	// see: 4.5 Sv48: Page-Based 48-bit Virtual-Memory System
+	sv48 = pagingMode{levels: 4, vpnBits: 9, pteSize: 8, ppnBits: 44, vaBits: 48}
+)
+
+// satp fields.
+const (
+	// RV32
+	satpModeSv32    = 1
+	satp32PPN       = 1<<22 - 1
+	satp32ASIDShift = 22
+	satp32ASID      = 1<<9 - 1
+	// RV64
+	satpModeSv39    = 8
+	satpModeSv48    = 9
+	satp64PPN       = 1<<44 - 1
+	satp64ASIDShift = 44
+	satp64ASID      = 1<<16 - 1
+)
+
+// satpModeSupported reports whether MODE field of satp is supported.
+func satpModeSupported(mode uint64, xlen XLEN) bool {
+	if mode == satpModeBare {
+		return true
+	}
+	if xlen == XLEN64 {
+		return mode == satpModeSv39 || mode == satpModeSv48
+	}
+	return mode == satpModeSv32
+}
+
+// pagingMode returns the paging mode which is selected by satp and the PPN of
+// the root page table. It reports false if the address translation is disabled. (Bare mode)
+func (c *CPU) pagingMode() (pagingMode, uint64, bool) {
+	satp := c.csrs.Get(CSRSatp)
+	if c.xlen == XLEN32 {
+		if satpMode(satp, c.xlen) == satpModeSv32 {
+			return sv32, satp & satp32PPN, true
+		}
+		return pagingMode{}, 0, false
+	}
+	switch satpMode(satp, c.xlen) {
+	case satpModeSv39:
+		return sv39, satp & satp64PPN, true
+	case satpModeSv48:
+		return sv48, satp & satp64PPN, true
+	}
+	return pagingMode{}, 0, false
+}
+
+// asid returns ASID field of satp which identifies the current address space.
+func (c *CPU) asid() uint64 {
+	satp := c.csrs.Get(CSRSatp)
+	if c.xlen == XLEN32 {
+		return satp >> satp32ASIDShift & satp32ASID
+	}
+	return satp >> satp64ASIDShift & satp64ASID
+}
+
+// effectiveMode returns the privilege mode which is used for the address translation
+// and the protection. If MPRV is set, the loads and the stores are performed as though
+// the current privilege mode were set to MPP.
+//
+// see: 3.1.6.3 Memory Privilege in mstatus Register
+func (c *CPU) effectiveMode(access accessType) PrivilegeMode {
+	mstatus := c.csrs.Get(CSRMstatus)
+	if access != accessInstruction && mstatus&mstatusMPRV != 0 {
+		return PrivilegeMode(mstatus & mstatusMPP >> mstatusMPPShift)
+	}
+	return c.mode
+}
+
+// translate translates the virtual address to the physical address.
+//
+// The address is not translated in M-mode or if satp is in Bare mode. Otherwise,
+// the translation is looked up in the TLB, and the page table is walked from the
+// root which is specified by satp if it is not cached.
+// A page fault is raised if the page is not mapped or the access is not permitted,
+// and an access fault is raised if the page table entry can not be accessed.
+//
+// see: 4.3.2 Virtual Address Translation Process
+func (c *CPU) translate(vaddr uint64, access accessType) (uint64, error) {
+	return c.translateAccess(vaddr, access, true)
+}
+
+// translateAccess is same as translate except that the TLB is left untouched if
+// update is false. It is used to check the address in advance without affecting
+// the statistics of the TLB.
+func (c *CPU) translateAccess(vaddr uint64, access accessType, update bool) (uint64, error) {
+	mode := c.effectiveMode(access)
+	if mode == MachineMode {
+		return vaddr, nil
+	}
+	pm, rootPPN, ok := c.pagingMode()
+	if !ok {
+		return vaddr, nil
+	}
+	// The virtual address must be canonical. i.e. bits above vaBits are all equal
+	// to the most significant bit.
+	if c.xlen == XLEN64 {
+		shift := 64 - pm.vaBits
+		if uint64(int64(vaddr<<shift)>>shift) != vaddr {
+			return 0, access.pageFault(vaddr)
+		}
+	}
+
+	vpn := vaddr >> pageShift
+	asid := c.asid()
+	offset := vaddr & (pageSize - 1)
+	// The cached entry is used only if A bit, and D bit for the stores, are already
+	// set. Otherwise the page table is walked again to update them.
+	ad := accessedDirty(access)
+	if e := c.tlb.lookup(vpn, asid); e != nil && e.pte&ad == ad {
+		if update {
+			c.tlb.stats.Hits++
+		}
+		if !c.permitted(e.pte, mode, access) {
+			return 0, access.pageFault(vaddr)
+		}
+		return e.ppn<<pageShift | offset, nil
+	}
+	if update {
+		c.tlb.stats.Misses++
+	}
+	e, err := c.walk(pm, rootPPN, vaddr, mode, access)
+	if err != nil {
+		return 0, err
+	}
+	if update {
+		e.asid = asid
+		c.tlb.insert(e)
+	}
+	return e.ppn<<pageShift | offset, nil
+}
+
+// accessedDirty returns the bits of the page table entry which must be set before
+// the access. A bit is required for any access, and D bit is also required for the stores.
+func accessedDirty(access accessType) uint64 {
+	if access == accessStore {
+		return pteA | pteD
+	}
+	return pteA
+}
+
+// walk walks the page table from rootPPN and returns the translation of the page
+// which contains vaddr. ASID of the returned entry is not set.
+func (c *CPU) walk(pm pagingMode, rootPPN, vaddr uint64, mode PrivilegeMode, access accessType) (tlbEntry, error) {
+	vpnMask := uint64(1)<<pm.vpnBits - 1
+	ppnMask := uint64(1)<<pm.ppnBits - 1
+	vpn := vaddr >> pageShift
+	a := rootPPN * pageSize
+	for i := pm.levels - 1; i >= 0; i-- {
+		pteAddr := a + (vpn>>(pm.vpnBits*uint(i))&vpnMask)*pm.pteSize
+		// The implicit accesses to the page table are checked by PMP as S-mode accesses.
+		if !c.pmpPermitted(pteAddr, pm.pteSize, SupervisorMode, accessLoad) {
+			return tlbEntry{}, access.accessFault(vaddr)
+		}
+		pte, err := c.bus.Read(pteAddr, pm.pteSize)
+		if err != nil {
+			c.debugf("%v", err)
+			return tlbEntry{}, access.accessFault(vaddr)
+		}
+		reserved := pte >> (ptePPNShift + pm.ppnBits)
+		if pte&pteV == 0 || pte&pteR == 0 && pte&pteW != 0 || reserved != 0 {
+			return tlbEntry{}, access.pageFault(vaddr)
+		}
+		ppn := pte >> ptePPNShift & ppnMask
+		if pte&(pteR|pteX) == 0 {
+			// The pointer to the next level of the page table.
+			a = ppn * pageSize
+			continue
+		}
+
+		// The leaf page table entry is found.
+		if !c.permitted(pte, mode, access) {
+			return tlbEntry{}, access.pageFault(vaddr)
+		}
+		// The superpage must be aligned.
+		superpageBits := pm.vpnBits * uint(i)
+		offsetMask := uint64(1)<<superpageBits - 1
+		if ppn&offsetMask != 0 {
+			return tlbEntry{}, access.pageFault(vaddr)
+		}
+		// A bit, and D bit for the stores, are set by the MMU. If the MMU is configured
+		// not to update them, a page fault is raised instead so that the software sets them.
+		ad := accessedDirty(access)
+		if pte&ad != ad {
+			if !c.adUpdate {
+				return tlbEntry{}, access.pageFault(vaddr)
+			}
+			if !c.pmpPermitted(pteAddr, pm.pteSize, SupervisorMode, accessStore) {
+				return tlbEntry{}, access.accessFault(vaddr)
+			}
+			// The update is performed atomically with respect to the other accesses to the entry.
+			old, err := c.bus.AtomicModify(pteAddr, pm.pteSize, func(old uint64) uint64 {
+				return old | ad
+			})
+			if err != nil {
+				c.debugf("%v", err)
+				return tlbEntry{}, access.accessFault(vaddr)
+			}
+			pte = old | ad
+		}
+		// The lower bits of PPN are taken from VPN for the superpage.
+		return tlbEntry{
+			vpn:           vpn,
+			ppn:           ppn&^offsetMask | vpn&offsetMask,
+			pte:           pte,
+			superpageBits: superpageBits,
+		}, nil
+	}
+	return tlbEntry{}, access.pageFault(vaddr)
+}
+
+// permitted reports whether the access is permitted by the leaf page table entry.
+//
+// The pages with U bit are accessible only in U-mode, except that the loads and
+// the stores in S-mode are permitted if SUM is set. If MXR is set, the loads from
+// the executable pages are also permitted.
+//
+// see: 4.3.1 Addressing and Memory Protection
+func (c *CPU) permitted(pte uint64, mode PrivilegeMode, access accessType) bool {
+	mstatus := c.csrs.Get(CSRMstatus)
+	switch mode {
+	case UserMode:
+		if pte&pteU == 0 {
+			return false
+		}
+	case SupervisorMode:
+		if pte&pteU != 0 && (access == accessInstruction || mstatus&mstatusSUM == 0) {
+			return false
+		}
+	}
+	switch access {
+	case accessInstruction:
+		return pte&pteX != 0
+	case accessLoad:
+		return pte&pteR != 0 || mstatus&mstatusMXR != 0 && pte&pteX != 0
+	}
+	return pte&pteW != 0
+}
@@ -0,0 +1,247 @@
+package riscv
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestTranslateSv32(t *testing.T) {
+	const (
+		root  = dramStartAddress
+		l2    = dramStartAddress + pageSize
+		vaddr = 0x00400123 // VPN[1] = 1, VPN[0] = 0
+		ppn   = 0x90000
+		paddr = 0x90000123
+		leaf  = ppn << ptePPNShift
+	)
+	cases := []struct {
+		name     string
+		opts     []Option
+		mode     PrivilegeMode
+		mstatus  uint64
+		pte      uint64
+		megapage bool
+		access   accessType
+		want     uint64
+		wantErr  ExceptionCode
+	}{
+		{name: "S-mode load", mode: SupervisorMode, pte: leaf | pteV | pteR | pteA, access: accessLoad, want: paddr},
+		{name: "S-mode store", mode: SupervisorMode, pte: leaf | pteV | pteR | pteW | pteA | pteD, access: accessStore, want: paddr},
+		{name: "S-mode load from user page", mode: SupervisorMode, pte: leaf | pteV | pteR | pteU | pteA, access: accessLoad, wantErr: LoadPageFault},
+		{name: "S-mode load from user page with SUM", mode: SupervisorMode, mstatus: mstatusSUM, pte: leaf | pteV | pteR | pteU | pteA, access: accessLoad, want: paddr},
+		{name: "S-mode fetch from user page with SUM", mode: SupervisorMode, mstatus: mstatusSUM, pte: leaf | pteV | pteX | pteU | pteA, access: accessInstruction, wantErr: InstructionPageFault},
+		{name: "U-mode fetch", mode: UserMode, pte: leaf | pteV | pteX | pteU | pteA, access: accessInstruction, want: paddr},
+		{name: "U-mode load from supervisor page", mode: UserMode, pte: leaf | pteV | pteR | pteA, access: accessLoad, wantErr: LoadPageFault},
+		{name: "load from execute-only page", mode: SupervisorMode, pte: leaf | pteV | pteX | pteA, access: accessLoad, wantErr: LoadPageFault},
+		{name: "load from execute-only page with MXR", mode: SupervisorMode, mstatus: mstatusMXR, pte: leaf | pteV | pteX | pteA, access: accessLoad, want: paddr},
+		{name: "store to read-only page", mode: SupervisorMode, pte: leaf | pteV | pteR | pteA, access: accessStore, wantErr: StoreAMOPageFault},
+		{name: "invalid entry", mode: SupervisorMode, pte: 0, access: accessLoad, wantErr: LoadPageFault},
+		{name: "reserved entry (W without R)", mode: SupervisorMode, pte: leaf | pteV | pteW | pteA, access: accessStore, wantErr: StoreAMOPageFault},
+		{name: "A is not set", opts: []Option{WithADUpdate(false)}, mode: SupervisorMode, pte: leaf | pteV | pteR, access: accessLoad, wantErr: LoadPageFault},
+		{name: "D is not set", opts: []Option{WithADUpdate(false)}, mode: SupervisorMode, pte: leaf | pteV | pteR | pteW | pteA, access: accessStore, wantErr: StoreAMOPageFault},
+		{name: "megapage", mode: SupervisorMode, pte: leaf | pteV | pteR | pteA, megapage: true, access: accessLoad, want: paddr},
+		{name: "misaligned megapage", mode: SupervisorMode, pte: (ppn+1)<<ptePPNShift | pteV | pteR | pteA, megapage: true, access: accessLoad, wantErr: LoadPageFault},
+		{name: "M-mode", mode: MachineMode, pte: 0, access: accessLoad, want: vaddr},
+		{name: "M-mode with MPRV", mode: MachineMode, mstatus: mstatusMPRV | uint64(SupervisorMode)<<mstatusMPPShift, pte: leaf | pteV | pteR | pteU | pteA, access: accessLoad, wantErr: LoadPageFault},
+		{name: "M-mode fetch with MPRV", mode: MachineMode, mstatus: mstatusMPRV | uint64(SupervisorMode)<<mstatusMPPShift, pte: 0, access: accessInstruction, want: vaddr},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			cpu := NewCPU(make([]byte, 2*pageSize), tc.opts...)
+			permitAll(t, cpu)
+			cpu.mode = tc.mode
+			cpu.csrs.Set(CSRMstatus, tc.mstatus)
+			if err := cpu.csrs.Write(CSRSatp, 1<<31|root>>pageShift); err != nil {
+				t.Fatal(err)
+			}
+			if tc.megapage {
+				mustWrite(t, cpu.bus, root+1*4, 4, tc.pte)
+			} else {
+				mustWrite(t, cpu.bus, root+1*4, 4, l2>>pageShift<<ptePPNShift|pteV)
+				mustWrite(t, cpu.bus, l2, 4, tc.pte)
+			}
+			got, err := cpu.translate(vaddr, tc.access)
+			if tc.wantErr != 0 {
+				var e *Exception
+				if !errors.As(err, &e) || e.Code != tc.wantErr || e.Value != vaddr {
+					t.Fatalf("want %s but got %v", tc.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tc.want {
+				t.Errorf("want 0x%x but got 0x%x", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestTranslateUpdatesAD(t *testing.T) {
+	const root = dramStartAddress
+	cpu := NewCPU(make([]byte, pageSize))
+	permitAll(t, cpu)
+	cpu.mode = SupervisorMode
+	if err := cpu.csrs.Write(CSRSatp, 1<<31|root>>pageShift); err != nil {
+		t.Fatal(err)
+	}
+	// 0x00000000-0x003fffff is mapped to 0x00000000.
+	mustWrite(t, cpu.bus, root, 4, pteV|pteR|pteW)
+	if _, err := cpu.translate(0x1000, accessLoad); err != nil {
+		t.Fatal(err)
+	}
+	if got := mustRead(t, cpu.bus, root, 4); got != pteV|pteR|pteW|pteA {
+		t.Errorf("want A is set but got 0x%x", got)
+	}
+	if _, err := cpu.translate(0x1000, accessStore); err != nil {
+		t.Fatal(err)
+	}
+	if got := mustRead(t, cpu.bus, root, 4); got != pteV|pteR|pteW|pteA|pteD {
+		t.Errorf("want A and D are set but got 0x%x", got)
+	}
+}
+
+func mustWrite(t *testing.T, bus *Bus, addr, size, value uint64) {
+	t.Helper()
+	if err := bus.Write(addr, size, value); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func mustRead(t *testing.T, bus *Bus, addr, size uint64) uint64 {
+	t.Helper()
+	v, err := bus.Read(addr, size)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return v
+}
+
+func TestTranslateRV64(t *testing.T) {
+	const (
+		root   = dramStartAddress
+		l1     = dramStartAddress + 1*pageSize
+		l0     = dramStartAddress + 2*pageSize
+		root48 = dramStartAddress + 3*pageSize
+		vaddr  = 0x40403123 // VPN[2] = 1, VPN[1] = 2, VPN[0] = 3
+		sv39   = satpModeSv39<<60 | root>>pageShift
+		sv48   = satpModeSv48<<60 | root48>>pageShift
+		rwxa   = pteV | pteR | pteW | pteX | pteA | pteD
+	)
+	// pointer returns the non-leaf entry which points to the page table.
+	pointer := func(table uint64) uint64 { return table>>pageShift<<ptePPNShift | pteV }
+	cases := []struct {
+		name    string
+		satp    uint64
+		ptes    map[uint64]uint64
+		vaddr   uint64
+		want    uint64
+		wantErr bool
+	}{
+		{
+			name:  "sv39 page",
+			satp:  sv39,
+			ptes:  map[uint64]uint64{root + 1*8: pointer(l1), l1 + 2*8: pointer(l0), l0 + 3*8: 0x90000<<ptePPNShift | rwxa},
+			vaddr: vaddr,
+			want:  0x90000123,
+		},
+		{
+			name:  "sv39 megapage",
+			satp:  sv39,
+			ptes:  map[uint64]uint64{root + 1*8: pointer(l1), l1 + 2*8: 0x80000<<ptePPNShift | rwxa},
+			vaddr: vaddr,
+			want:  0x80003123,
+		},
+		{
+			name:  "sv39 gigapage",
+			satp:  sv39,
+			ptes:  map[uint64]uint64{root + 1*8: 0x80000<<ptePPNShift | rwxa},
+			vaddr: vaddr,
+			want:  0x80403123,
+		},
+		{
+			name:    "sv39 misaligned gigapage",
+			satp:    sv39,
+			ptes:    map[uint64]uint64{root + 1*8: 0x80200<<ptePPNShift | rwxa},
+			vaddr:   vaddr,
+			wantErr: true,
+		},
+		{
+			name:    "sv39 reserved bits",
+			satp:    sv39,
+			ptes:    map[uint64]uint64{root + 1*8: 1<<54 | 0x80000<<ptePPNShift | rwxa},
+			vaddr:   vaddr,
+			wantErr: true,
+		},
+		{
+			name:    "sv39 non-canonical address",
+			satp:    sv39,
+			ptes:    map[uint64]uint64{root + 1*8: 0x80000<<ptePPNShift | rwxa},
+			vaddr:   1<<39 | vaddr,
+			wantErr: true,
+		},
+		{
+			name:  "sv39 negative address",
+			satp:  sv39,
+			ptes:  map[uint64]uint64{root + 511*8: 0x80000<<ptePPNShift | rwxa},
+			vaddr: 0xffffffffc0000123,
+			want:  0x80000123,
+		},
+		{
+			name:  "sv48 page",
+			satp:  sv48,
+			ptes:  map[uint64]uint64{root48: pointer(root), root + 1*8: pointer(l1), l1 + 2*8: pointer(l0), l0 + 3*8: 0x90000<<ptePPNShift | rwxa},
+			vaddr: vaddr,
+			want:  0x90000123,
+		},
+		{
+			name:  "sv48 address which is not canonical in sv39",
+			satp:  sv48,
+			ptes:  map[uint64]uint64{root48 + 1*8: pointer(root), root + 1*8: 0x80000<<ptePPNShift | rwxa},
+			vaddr: 1<<39 | vaddr,
+			want:  0x80403123,
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			cpu := NewCPU(make([]byte, 4*pageSize), WithXLEN(XLEN64))
+			permitAll(t, cpu)
+			cpu.mode = SupervisorMode
+			if err := cpu.csrs.Write(CSRSatp, tc.satp); err != nil {
+				t.Fatal(err)
+			}
+			for addr, pte := range tc.ptes {
+				mustWrite(t, cpu.bus, addr, 8, pte)
+			}
+			got, err := cpu.translate(tc.vaddr, accessLoad)
+			if tc.wantErr {
+				var e *Exception
+				if !errors.As(err, &e) || e.Code != LoadPageFault || e.Value != tc.vaddr {
+					t.Fatalf("want load page fault but got %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tc.want {
+				t.Errorf("want 0x%x but got 0x%x", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestSatpMode(t *testing.T) {
+	cpu := NewCPU(make([]byte, 4), WithXLEN(XLEN64))
+	if err := cpu.csrs.Write(CSRSatp, satpModeSv48<<60|1); err != nil {
+		t.Fatal(err)
+	}
+	// Sv57 is not supported, so the write has no effect.
+	if err := cpu.csrs.Write(CSRSatp, 10<<60|2); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := cpu.csrs.Get(CSRSatp), uint64(satpModeSv48<<60|1); got != want {
+		t.Errorf("want 0x%x but got 0x%x", want, got)
+	}
+}
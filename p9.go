@@ -0,0 +1,1115 @@
+package riscv
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// p9Server is 9P2000.L file server which exports the host directory. The requests
+// are handled one by one.
+//
+// The guest cannot access the files outside the directory. ".." of the root is the
+// root itself, the walk does not go through the symbolic links, and the paths which
+// are followed by the host (e.g. the file to open) must be resolved inside the directory.
+//
+// see: https://github.com/chaos/diod/blob/master/protocol.md
+type p9Server struct {
+	// root is the absolute path of the exported directory without the symbolic links.
+	root     string
+	readOnly bool
+	msize    uint32
+	fids     map[uint32]*p9Fid
+	// qids is the unique identifiers of the files which are indexed by the path.
+	qids map[string]uint64
+	// lastQid is the last identifier which is assigned. The identifiers are never
+	// reused because Linux uses them as the inode numbers.
+	lastQid uint64
+}
+
+// p9Fid is the file which is identified by fid.
+type p9Fid struct {
+	// path is the slash-separated path which is relative to the root. The root is ".".
+	path string
+	// file is set while the regular file is opened.
+	file *os.File
+	// entries is set while the directory is opened.
+	entries []p9Dirent
+}
+
+// p9Dirent is the directory entry which is returned by Treaddir.
+type p9Dirent struct {
+	qid  p9Qid
+	typ  uint8
+	name string
+}
+
+// p9Qid is the server's unique identification of the file.
+type p9Qid struct {
+	typ     uint8
+	version uint32
+	path    uint64
+}
+
+const (
+	p9Version = "9P2000.L"
+	// p9MaxMsize is the maximum size of the message which is accepted by the server.
+	p9MaxMsize = 64 * 1024
+	// p9MinMsize is the minimum size of the message which is accepted. It is the same as Linux.
+	p9MinMsize = 4096
+	// p9MaxWelem is the maximum number of the names in Twalk. (MAXWELEM)
+	p9MaxWelem = 16
+	// p9HeaderSize is the size of size[4] type[1] tag[2].
+	p9HeaderSize = 7
+	// p9IOHeaderSize is the size of the header of Rread. (count[4])
+	p9IOHeaderSize = p9HeaderSize + 4
+
+	// The message types. The response is the request + 1.
+	p9Rlerror    = 7
+	p9Tstatfs    = 8
+	p9Tlopen     = 12
+	p9Tlcreate   = 14
+	p9Tsymlink   = 16
+	p9Tmknod     = 18
+	p9Trename    = 20
+	p9Treadlink  = 22
+	p9Tgetattr   = 24
+	p9Tsetattr   = 26
+	p9Txattrwalk = 30
+	p9Treaddir   = 40
+	p9Tfsync     = 50
+	p9Tlock      = 52
+	p9Tgetlock   = 54
+	p9Tlink      = 70
+	p9Tmkdir     = 72
+	p9Trenameat  = 74
+	p9Tunlinkat  = 76
+	p9Tversion   = 100
+	p9Tattach    = 104
+	p9Tflush     = 108
+	p9Twalk      = 110
+	p9Tread      = 116
+	p9Twrite     = 118
+	p9Tclunk     = 120
+	p9Tremove    = 122
+
+	// The types of qid.
+	p9QTDir     = 0x80
+	p9QTSymlink = 0x02
+	p9QTFile    = 0x00
+
+	// The bits of valid of Tsetattr.
+	p9SetattrMode     = 0x001
+	p9SetattrSize     = 0x008
+	p9SetattrAtime    = 0x010
+	p9SetattrMtime    = 0x020
+	p9SetattrAtimeSet = 0x080
+	p9SetattrMtimeSet = 0x100
+
+	// p9GetattrBasic is the fields which are returned by Rgetattr. (P9_GETATTR_BASIC)
+	p9GetattrBasic = 0x7ff
+
+	// The flags of Tlopen and Tlcreate. They are Linux open(2) flags.
+	p9OAccmode = 0o3
+	p9OWronly  = 0o1
+	p9ORdwr    = 0o2
+	p9OCreat   = 0o100
+	p9OExcl    = 0o200
+	p9OTrunc   = 0o1000
+
+	// p9AtRemoveDir is the flag of Tunlinkat.
+	p9AtRemoveDir = 0x200
+	// p9LockSuccess is the status of Rlock.
+	p9LockSuccess = 0
+	// p9LockTypeUnlck is the type of Rgetlock which means the lock can be taken.
+	p9LockTypeUnlck = 2
+
+	// v9fsMagic is the file system type which is returned by Rstatfs.
+	v9fsMagic = 0x01021997
+
+	// The file types of Linux st_mode.
+	p9SIFDir = 0o040000
+	p9SIFReg = 0o100000
+	p9SIFLnk = 0o120000
+
+	// The directory entry types of Linux.
+	p9DTDir = 4
+	p9DTReg = 8
+	p9DTLnk = 10
+)
+
+// Linux errno which is returned by Rlerror.
+const (
+	p9EPERM        = 1
+	p9ENOENT       = 2
+	p9EIO          = 5
+	p9EBADF        = 9
+	p9EACCES       = 13
+	p9EEXIST       = 17
+	p9EXDEV        = 18
+	p9ENOTDIR      = 20
+	p9EISDIR       = 21
+	p9EINVAL       = 22
+	p9ENOSPC       = 28
+	p9EROFS        = 30
+	p9ENAMETOOLONG = 36
+	p9ENOTEMPTY    = 39
+	p9EPROTO       = 71
+	p9EOPNOTSUPP   = 95
+)
+
+// p9Error is the error which is returned to the guest by Rlerror.
+type p9Error uint32
+
+func (e p9Error) Error() string { return syscall.Errno(e).Error() }
+
+// newP9Server creates the server which exports dir.
+func newP9Server(dir string, readOnly bool) (*p9Server, error) {
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+	root, err := filepath.EvalSymlinks(abs)
+	if err != nil {
+		return nil, err
+	}
+	fi, err := os.Stat(root)
+	if err != nil {
+		return nil, err
+	}
+	if !fi.IsDir() {
+		return nil, &fs.PathError{Op: "export", Path: dir, Err: syscall.ENOTDIR}
+	}
+	return &p9Server{
+		root:     root,
+		readOnly: readOnly,
+		msize:    p9MaxMsize,
+		fids:     make(map[uint32]*p9Fid),
+		qids:     make(map[string]uint64),
+	}, nil
+}
+
+// reset clunks all fids.
+func (s *p9Server) reset() {
+	for fid := range s.fids {
+		s.clunk(fid)
+	}
+}
+
+func (s *p9Server) clunk(fid uint32) {
+	if f, ok := s.fids[fid]; ok {
+		if f.file != nil {
+			f.file.Close()
+		}
+		delete(s.fids, fid)
+	}
+}
+
+// handle handles the request and returns the response.
+func (s *p9Server) handle(req []byte) []byte {
+	r := &p9Reader{b: req}
+	r.u32() // size
+	typ := r.u8()
+	tag := r.u16()
+	w := &p9Writer{}
+	w.u32(0) // size is filled later.
+	w.u8(typ + 1)
+	w.u16(tag)
+	err := s.dispatch(typ, r, w)
+	if err == nil && r.err != nil {
+		err = p9Error(p9EPROTO)
+	}
+	if err != nil {
+		w = &p9Writer{}
+		w.u32(0)
+		w.u8(p9Rlerror)
+		w.u16(tag)
+		w.u32(uint32(p9Errno(err)))
+	}
+	return w.finish()
+}
+
+// p9Errno converts the error of the host to Linux errno.
+func p9Errno(err error) p9Error {
+	var e p9Error
+	if errors.As(err, &e) {
+		return e
+	}
+	for _, m := range []struct {
+		err   error
+		errno p9Error
+	}{
+		{fs.ErrNotExist, p9ENOENT},
+		{fs.ErrExist, p9EEXIST},
+		{fs.ErrPermission, p9EACCES},
+		{syscall.ENOTDIR, p9ENOTDIR},
+		{syscall.EISDIR, p9EISDIR},
+		{syscall.ENOTEMPTY, p9ENOTEMPTY},
+		{syscall.EXDEV, p9EXDEV},
+		{syscall.ENOSPC, p9ENOSPC},
+		{syscall.EROFS, p9EROFS},
+		{syscall.EINVAL, p9EINVAL},
+		{syscall.ENAMETOOLONG, p9ENAMETOOLONG},
+		{syscall.EPERM, p9EPERM},
+	} {
+		if errors.Is(err, m.err) {
+			return m.errno
+		}
+	}
+	return p9EIO
+}
+
+func (s *p9Server) dispatch(typ uint8, r *p9Reader, w *p9Writer) error {
+	switch typ {
+	case p9Tversion:
+		msize, version := r.u32(), r.str()
+		s.reset()
+		s.msize = p9MaxMsize
+		if r.err != nil {
+			return nil
+		}
+		if msize < p9MinMsize {
+			return p9Error(p9EINVAL)
+		}
+		if msize < s.msize {
+			s.msize = msize
+		}
+		if version != p9Version {
+			version = "unknown"
+		}
+		w.u32(s.msize)
+		w.str(version)
+		return nil
+	case p9Tflush:
+		// The requests are handled synchronously, so there is nothing to flush.
+		r.u16()
+		return nil
+	case p9Tattach:
+		fid := r.u32()
+		r.u32() // afid
+		r.str() // uname
+		r.str() // aname
+		r.u32() // n_uname
+		if r.err != nil {
+			return nil
+		}
+		return s.attach(fid, w)
+	case p9Twalk:
+		fid, newfid, n := r.u32(), r.u32(), r.u16()
+		if n > p9MaxWelem {
+			return p9Error(p9EINVAL)
+		}
+		names := make([]string, 0, n)
+		for i := 0; i < int(n) && r.err == nil; i++ {
+			names = append(names, r.str())
+		}
+		if r.err != nil {
+			return nil
+		}
+		return s.walk(fid, newfid, names, w)
+	case p9Tclunk:
+		fid := r.u32()
+		if _, ok := s.fids[fid]; !ok {
+			return p9Error(p9EBADF)
+		}
+		s.clunk(fid)
+		return nil
+	}
+	if r.err != nil {
+		return nil
+	}
+	return s.dispatchFile(typ, r, w)
+}
+
+// dispatchFile handles the requests for the files.
+func (s *p9Server) dispatchFile(typ uint8, r *p9Reader, w *p9Writer) error {
+	f, ok := s.fids[r.u32()]
+	if !ok {
+		return p9Error(p9EBADF)
+	}
+	switch typ {
+	case p9Tgetattr:
+		r.u64() // request_mask
+		return s.getattr(f, w)
+	case p9Tsetattr:
+		valid, mode := r.u32(), r.u32()
+		r.u32() // uid
+		r.u32() // gid
+		size := r.u64()
+		atime := time.Unix(int64(r.u64()), int64(r.u64()))
+		mtime := time.Unix(int64(r.u64()), int64(r.u64()))
+		if r.err != nil {
+			return nil
+		}
+		return s.setattr(f, valid, mode, size, atime, mtime)
+	case p9Tstatfs:
+		w.u32(v9fsMagic)
+		w.u32(4096) // bsize
+		w.u64(0)    // blocks
+		w.u64(0)    // bfree
+		w.u64(0)    // bavail
+		w.u64(0)    // files
+		w.u64(0)    // ffree
+		w.u64(0)    // fsid
+		w.u32(255)  // namelen
+		return nil
+	case p9Tlopen:
+		flags := r.u32()
+		if r.err != nil {
+			return nil
+		}
+		return s.lopen(f, flags, w)
+	case p9Tlcreate:
+		name, flags, mode := r.str(), r.u32(), r.u32()
+		r.u32() // gid
+		if r.err != nil {
+			return nil
+		}
+		return s.lcreate(f, name, flags, mode, w)
+	case p9Tread:
+		offset, count := r.u64(), r.u32()
+		if r.err != nil {
+			return nil
+		}
+		return s.read(f, offset, count, w)
+	case p9Twrite:
+		offset, count := r.u64(), r.u32()
+		data := r.bytes(int(count))
+		if r.err != nil {
+			return nil
+		}
+		return s.write(f, offset, data, w)
+	case p9Treaddir:
+		offset, count := r.u64(), r.u32()
+		if r.err != nil {
+			return nil
+		}
+		return s.readdir(f, offset, count, w)
+	case p9Tfsync:
+		if f.file != nil {
+			return f.file.Sync()
+		}
+		return nil
+	case p9Tmkdir:
+		name, mode := r.str(), r.u32()
+		r.u32() // gid
+		if r.err != nil {
+			return nil
+		}
+		return s.mkdir(f, name, mode, w)
+	case p9Tsymlink:
+		name, target := r.str(), r.str()
+		r.u32() // gid
+		if r.err != nil {
+			return nil
+		}
+		return s.symlink(f, name, target, w)
+	case p9Treadlink:
+		if f.path == "." {
+			return p9Error(p9EINVAL)
+		}
+		_, hp, err := s.confinedChild(path.Dir(f.path), path.Base(f.path))
+		if err != nil {
+			return err
+		}
+		target, err := os.Readlink(hp)
+		if err != nil {
+			return err
+		}
+		w.str(target)
+		return nil
+	case p9Tlink:
+		src, ok := s.fids[r.u32()]
+		name := r.str()
+		if r.err != nil {
+			return nil
+		}
+		if !ok {
+			return p9Error(p9EBADF)
+		}
+		return s.link(f, src, name)
+	case p9Trename:
+		dir, ok := s.fids[r.u32()]
+		name := r.str()
+		if r.err != nil {
+			return nil
+		}
+		if !ok {
+			return p9Error(p9EBADF)
+		}
+		p, err := s.child(dir.path, name)
+		if err != nil {
+			return err
+		}
+		return s.rename(f.path, p)
+	case p9Trenameat:
+		oldname := r.str()
+		newdir, ok := s.fids[r.u32()]
+		newname := r.str()
+		if r.err != nil {
+			return nil
+		}
+		if !ok {
+			return p9Error(p9EBADF)
+		}
+		oldpath, err := s.child(f.path, oldname)
+		if err != nil {
+			return err
+		}
+		newpath, err := s.child(newdir.path, newname)
+		if err != nil {
+			return err
+		}
+		return s.rename(oldpath, newpath)
+	case p9Tunlinkat:
+		name, flags := r.str(), r.u32()
+		if r.err != nil {
+			return nil
+		}
+		p, err := s.child(f.path, name)
+		if err != nil {
+			return err
+		}
+		return s.remove(p, flags&p9AtRemoveDir != 0)
+	case p9Tremove:
+		fi, err := os.Lstat(s.host(f.path))
+		if err != nil {
+			return err
+		}
+		err = s.remove(f.path, fi.IsDir())
+		// The fid is clunked even if the remove fails.
+		for fid, g := range s.fids {
+			if g == f {
+				s.clunk(fid)
+			}
+		}
+		return err
+	case p9Tlock:
+		// The locks are not shared with the host, so it always succeeds.
+		w.u8(p9LockSuccess)
+		return nil
+	case p9Tgetlock:
+		r.u8() // type
+		start, length, procID, clientID := r.u64(), r.u64(), r.u32(), r.str()
+		w.u8(p9LockTypeUnlck)
+		w.u64(start)
+		w.u64(length)
+		w.u32(procID)
+		w.str(clientID)
+		return nil
+	case p9Txattrwalk, p9Tmknod:
+		return p9Error(p9EOPNOTSUPP)
+	}
+	return p9Error(p9EOPNOTSUPP)
+}
+
+// host returns the path of the host.
+func (s *p9Server) host(p string) string {
+	return filepath.Join(s.root, filepath.FromSlash(p))
+}
+
+// child returns the path of name in dir. name must be a single path element.
+// ".." of the root is the root itself.
+func (s *p9Server) child(dir, name string) (string, error) {
+	switch {
+	case name == "" || strings.ContainsAny(name, "/\x00"):
+		return "", p9Error(p9EINVAL)
+	case name == ".":
+		return dir, nil
+	case name == "..":
+		return path.Dir(dir), nil
+	}
+	return path.Join(dir, name), nil
+}
+
+// confined returns the host path of p whose symbolic links are followed. It returns
+// EACCES if the resolved path is outside the root.
+func (s *p9Server) confined(p string) (string, error) {
+	resolved, err := filepath.EvalSymlinks(s.host(p))
+	if err != nil {
+		return "", err
+	}
+	if resolved != s.root && !strings.HasPrefix(resolved, s.root+string(filepath.Separator)) {
+		return "", p9Error(p9EACCES)
+	}
+	return resolved, nil
+}
+
+// confinedChild returns the host path of name in dir. dir is resolved inside the
+// root, and name is not followed.
+func (s *p9Server) confinedChild(dir, name string) (string, string, error) {
+	if name == "." || name == ".." {
+		return "", "", p9Error(p9EINVAL)
+	}
+	p, err := s.child(dir, name)
+	if err != nil {
+		return "", "", err
+	}
+	d, err := s.confined(dir)
+	if err != nil {
+		return "", "", err
+	}
+	return p, filepath.Join(d, name), nil
+}
+
+func (s *p9Server) qid(p string, fi fs.FileInfo) p9Qid {
+	id, ok := s.qids[p]
+	if !ok {
+		s.lastQid++
+		id = s.lastQid
+		s.qids[p] = id
+	}
+	q := p9Qid{typ: p9QTFile, path: id}
+	switch {
+	case fi.IsDir():
+		q.typ = p9QTDir
+	case fi.Mode()&fs.ModeSymlink != 0:
+		q.typ = p9QTSymlink
+	}
+	return q
+}
+
+func (s *p9Server) stat(p string) (p9Qid, fs.FileInfo, error) {
+	fi, err := os.Lstat(s.host(p))
+	if err != nil {
+		return p9Qid{}, nil, err
+	}
+	return s.qid(p, fi), fi, nil
+}
+
+func (s *p9Server) attach(fid uint32, w *p9Writer) error {
+	if _, ok := s.fids[fid]; ok {
+		return p9Error(p9EBADF)
+	}
+	q, _, err := s.stat(".")
+	if err != nil {
+		return err
+	}
+	s.fids[fid] = &p9Fid{path: "."}
+	w.qid(q)
+	return nil
+}
+
+// walk walks from fid by names. It returns the qids of the walked files. If the
+// first name cannot be walked, it returns the error.
+func (s *p9Server) walk(fid, newfid uint32, names []string, w *p9Writer) error {
+	f, ok := s.fids[fid]
+	if !ok {
+		return p9Error(p9EBADF)
+	}
+	if _, ok := s.fids[newfid]; ok && newfid != fid {
+		return p9Error(p9EBADF)
+	}
+	p := f.path
+	var qids []p9Qid
+	for i, name := range names {
+		// The walk does not go through the symbolic links.
+		fi, err := os.Lstat(s.host(p))
+		if err == nil && !fi.IsDir() {
+			err = p9Error(p9ENOTDIR)
+		}
+		var q p9Qid
+		if err == nil {
+			p, err = s.child(p, name)
+		}
+		if err == nil {
+			q, _, err = s.stat(p)
+		}
+		if err != nil {
+			if i == 0 {
+				return err
+			}
+			break
+		}
+		qids = append(qids, q)
+	}
+	if len(qids) == len(names) {
+		s.fids[newfid] = &p9Fid{path: p}
+	}
+	w.u16(uint16(len(qids)))
+	for _, q := range qids {
+		w.qid(q)
+	}
+	return nil
+}
+
+// getattr returns the basic attributes. The owner is always root.
+func (s *p9Server) getattr(f *p9Fid, w *p9Writer) error {
+	q, fi, err := s.stat(f.path)
+	if err != nil {
+		return err
+	}
+	mode := uint32(fi.Mode().Perm())
+	switch {
+	case fi.IsDir():
+		mode |= p9SIFDir
+	case fi.Mode()&fs.ModeSymlink != 0:
+		mode |= p9SIFLnk
+	default:
+		mode |= p9SIFReg
+	}
+	mtime := fi.ModTime()
+	w.u64(p9GetattrBasic)
+	w.qid(q)
+	w.u32(mode)
+	w.u32(0) // uid
+	w.u32(0) // gid
+	w.u64(1) // nlink
+	w.u64(0) // rdev
+	w.u64(uint64(fi.Size()))
+	w.u64(4096)                            // blksize
+	w.u64((uint64(fi.Size()) + 511) / 512) // blocks
+	for i := 0; i < 4; i++ {
+		// atime, mtime, ctime and btime
+		w.u64(uint64(mtime.Unix()))
+		w.u64(uint64(mtime.Nanosecond()))
+	}
+	w.u64(0) // gen
+	w.u64(0) // data_version
+	return nil
+}
+
+func (s *p9Server) setattr(f *p9Fid, valid, mode uint32, size uint64, atime, mtime time.Time) error {
+	if s.readOnly {
+		return p9Error(p9EROFS)
+	}
+	hp, err := s.confined(f.path)
+	if err != nil {
+		return err
+	}
+	if valid&p9SetattrMode != 0 {
+		if err := os.Chmod(hp, fs.FileMode(mode&0o777)); err != nil {
+			return err
+		}
+	}
+	if valid&p9SetattrSize != 0 {
+		if err := os.Truncate(hp, int64(size)); err != nil {
+			return err
+		}
+	}
+	if valid&(p9SetattrAtime|p9SetattrMtime) != 0 {
+		fi, err := os.Stat(hp)
+		if err != nil {
+			return err
+		}
+		now := time.Now()
+		a, m := now, fi.ModTime()
+		if valid&p9SetattrAtimeSet != 0 {
+			a = atime
+		}
+		if valid&p9SetattrMtime != 0 {
+			m = now
+			if valid&p9SetattrMtimeSet != 0 {
+				m = mtime
+			}
+		}
+		if err := os.Chtimes(hp, a, m); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// openFlags converts the flags of Tlopen to the flags of os.OpenFile. O_APPEND is
+// not passed because the client writes at the end of the file by itself.
+func (s *p9Server) openFlags(flags uint32) (int, error) {
+	var of int
+	switch flags & p9OAccmode {
+	case p9OWronly:
+		of = os.O_WRONLY
+	case p9ORdwr:
+		of = os.O_RDWR
+	default:
+		of = os.O_RDONLY
+	}
+	if flags&p9OTrunc != 0 {
+		of |= os.O_TRUNC
+	}
+	if s.readOnly && (of&(os.O_WRONLY|os.O_RDWR|os.O_TRUNC) != 0) {
+		return 0, p9Error(p9EROFS)
+	}
+	return of, nil
+}
+
+func (s *p9Server) lopen(f *p9Fid, flags uint32, w *p9Writer) error {
+	if f.file != nil || f.entries != nil {
+		return p9Error(p9EBADF)
+	}
+	of, err := s.openFlags(flags)
+	if err != nil {
+		return err
+	}
+	q, fi, err := s.stat(f.path)
+	if err != nil {
+		return err
+	}
+	hp, err := s.confined(f.path)
+	if err != nil {
+		return err
+	}
+	if fi.IsDir() {
+		entries, err := s.readEntries(f.path, hp)
+		if err != nil {
+			return err
+		}
+		f.entries = entries
+	} else {
+		file, err := os.OpenFile(hp, of, 0)
+		if err != nil {
+			return err
+		}
+		f.file = file
+	}
+	w.qid(q)
+	w.u32(0) // iounit
+	return nil
+}
+
+// readEntries reads the directory entries including "." and "..".
+func (s *p9Server) readEntries(p, hp string) ([]p9Dirent, error) {
+	des, err := os.ReadDir(hp)
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(des, func(i, j int) bool { return des[i].Name() < des[j].Name() })
+	entries := make([]p9Dirent, 0, len(des)+2)
+	for _, name := range []string{".", ".."} {
+		dp, _ := s.child(p, name)
+		q, _, err := s.stat(dp)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, p9Dirent{qid: q, typ: p9DTDir, name: name})
+	}
+	for _, de := range des {
+		fi, err := de.Info()
+		if err != nil {
+			// The file is removed after it is listed.
+			continue
+		}
+		e := p9Dirent{qid: s.qid(path.Join(p, de.Name()), fi), typ: p9DTReg, name: de.Name()}
+		switch e.qid.typ {
+		case p9QTDir:
+			e.typ = p9DTDir
+		case p9QTSymlink:
+			e.typ = p9DTLnk
+		}
+		entries = append(entries, e)
+	}
+	return entries, nil
+}
+
+func (s *p9Server) lcreate(f *p9Fid, name string, flags, mode uint32, w *p9Writer) error {
+	if s.readOnly {
+		return p9Error(p9EROFS)
+	}
+	if f.file != nil || f.entries != nil {
+		return p9Error(p9EBADF)
+	}
+	of, err := s.openFlags(flags)
+	if err != nil {
+		return err
+	}
+	p, hp, err := s.confinedChild(f.path, name)
+	if err != nil {
+		return err
+	}
+	// O_EXCL prevents from following the symbolic link which already exists.
+	file, err := os.OpenFile(hp, of|os.O_CREATE|os.O_EXCL, fs.FileMode(mode&0o777))
+	if err != nil {
+		return err
+	}
+	q, _, err := s.stat(p)
+	if err != nil {
+		file.Close()
+		return err
+	}
+	// The fid becomes the created file.
+	f.path = p
+	f.file = file
+	w.qid(q)
+	w.u32(0) // iounit
+	return nil
+}
+
+func (s *p9Server) read(f *p9Fid, offset uint64, count uint32, w *p9Writer) error {
+	if f.file == nil {
+		return p9Error(p9EBADF)
+	}
+	if max := s.msize - p9IOHeaderSize; count > max {
+		count = max
+	}
+	buf := make([]byte, count)
+	n, err := f.file.ReadAt(buf, int64(offset))
+	if err != nil && err != io.EOF {
+		return err
+	}
+	w.u32(uint32(n))
+	w.b = append(w.b, buf[:n]...)
+	return nil
+}
+
+func (s *p9Server) write(f *p9Fid, offset uint64, data []byte, w *p9Writer) error {
+	if f.file == nil {
+		return p9Error(p9EBADF)
+	}
+	n, err := f.file.WriteAt(data, int64(offset))
+	if err != nil {
+		return err
+	}
+	w.u32(uint32(n))
+	return nil
+}
+
+// readdir returns the entries from offset as long as they fit in count bytes.
+// The offset of each entry is the offset of the next entry.
+func (s *p9Server) readdir(f *p9Fid, offset uint64, count uint32, w *p9Writer) error {
+	if f.entries == nil {
+		return p9Error(p9EBADF)
+	}
+	if max := s.msize - p9IOHeaderSize; count > max {
+		count = max
+	}
+	data := &p9Writer{}
+	for i := offset; i < uint64(len(f.entries)); i++ {
+		e := f.entries[i]
+		entry := &p9Writer{}
+		entry.qid(e.qid)
+		entry.u64(i + 1)
+		entry.u8(e.typ)
+		entry.str(e.name)
+		if len(data.b)+len(entry.b) > int(count) {
+			break
+		}
+		data.b = append(data.b, entry.b...)
+	}
+	w.u32(uint32(len(data.b)))
+	w.b = append(w.b, data.b...)
+	return nil
+}
+
+func (s *p9Server) mkdir(f *p9Fid, name string, mode uint32, w *p9Writer) error {
+	if s.readOnly {
+		return p9Error(p9EROFS)
+	}
+	p, hp, err := s.confinedChild(f.path, name)
+	if err != nil {
+		return err
+	}
+	if err := os.Mkdir(hp, fs.FileMode(mode&0o777)); err != nil {
+		return err
+	}
+	q, _, err := s.stat(p)
+	if err != nil {
+		return err
+	}
+	w.qid(q)
+	return nil
+}
+
+// symlink creates the symbolic link. The target may point outside the root
+// because the server never follows it out of the root.
+func (s *p9Server) symlink(f *p9Fid, name, target string, w *p9Writer) error {
+	if s.readOnly {
+		return p9Error(p9EROFS)
+	}
+	p, hp, err := s.confinedChild(f.path, name)
+	if err != nil {
+		return err
+	}
+	if err := os.Symlink(target, hp); err != nil {
+		return err
+	}
+	q, _, err := s.stat(p)
+	if err != nil {
+		return err
+	}
+	w.qid(q)
+	return nil
+}
+
+func (s *p9Server) link(dir, src *p9Fid, name string) error {
+	if s.readOnly {
+		return p9Error(p9EROFS)
+	}
+	_, hp, err := s.confinedChild(dir.path, name)
+	if err != nil {
+		return err
+	}
+	old, err := s.confined(src.path)
+	if err != nil {
+		return err
+	}
+	return os.Link(old, hp)
+}
+
+func (s *p9Server) rename(oldpath, newpath string) error {
+	if s.readOnly {
+		return p9Error(p9EROFS)
+	}
+	if oldpath == "." || newpath == "." {
+		return p9Error(p9EBADF)
+	}
+	_, oldhp, err := s.confinedChild(path.Dir(oldpath), path.Base(oldpath))
+	if err != nil {
+		return err
+	}
+	_, newhp, err := s.confinedChild(path.Dir(newpath), path.Base(newpath))
+	if err != nil {
+		return err
+	}
+	if err := os.Rename(oldhp, newhp); err != nil {
+		return err
+	}
+	// The fids and the qids of the file and the files under it follow the file,
+	// because the guest keeps using the fids which are walked before the rename.
+	moved := func(p string) (string, bool) {
+		if p == oldpath {
+			return newpath, true
+		}
+		if strings.HasPrefix(p, oldpath+"/") {
+			return newpath + p[len(oldpath):], true
+		}
+		return "", false
+	}
+	for _, f := range s.fids {
+		if p, ok := moved(f.path); ok {
+			f.path = p
+		}
+	}
+	delete(s.qids, newpath)
+	qids := make(map[string]uint64)
+	for p, id := range s.qids {
+		if np, ok := moved(p); ok {
+			qids[np] = id
+			delete(s.qids, p)
+		}
+	}
+	for p, id := range qids {
+		s.qids[p] = id
+	}
+	return nil
+}
+
+// remove removes the file or the empty directory. dir reports whether the guest
+// removes the directory.
+func (s *p9Server) remove(p string, dir bool) error {
+	if s.readOnly {
+		return p9Error(p9EROFS)
+	}
+	if p == "." {
+		return p9Error(p9EBADF)
+	}
+	_, hp, err := s.confinedChild(path.Dir(p), path.Base(p))
+	if err != nil {
+		return err
+	}
+	fi, err := os.Lstat(hp)
+	if err != nil {
+		return err
+	}
+	switch {
+	case dir && !fi.IsDir():
+		return p9Error(p9ENOTDIR)
+	case !dir && fi.IsDir():
+		return p9Error(p9EISDIR)
+	}
+	if err := os.Remove(hp); err != nil {
+		return err
+	}
+	delete(s.qids, p)
+	return nil
+}
+
+// p9Reader decodes the message. The values are little endian. If the message is
+// too short, err is set and the values are zero.
+type p9Reader struct {
+	b   []byte
+	err error
+}
+
+func (r *p9Reader) bytes(n int) []byte {
+	if r.err != nil || n > len(r.b) {
+		r.err = io.ErrUnexpectedEOF
+		return nil
+	}
+	b := r.b[:n]
+	r.b = r.b[n:]
+	return b
+}
+
+func (r *p9Reader) u8() uint8 {
+	if b := r.bytes(1); b != nil {
+		return b[0]
+	}
+	return 0
+}
+
+func (r *p9Reader) u16() uint16 {
+	return uint16(r.uint(2))
+}
+
+func (r *p9Reader) u32() uint32 {
+	return uint32(r.uint(4))
+}
+
+func (r *p9Reader) u64() uint64 {
+	return r.uint(8)
+}
+
+func (r *p9Reader) uint(n int) uint64 {
+	var v uint64
+	for i, c := range r.bytes(n) {
+		v |= uint64(c) << (8 * i)
+	}
+	return v
+}
+
+// str decodes the string which is prefixed by its length[2].
+func (r *p9Reader) str() string {
+	return string(r.bytes(int(r.u16())))
+}
+
+// p9Writer encodes the message.
+type p9Writer struct {
+	b []byte
+}
+
+func (w *p9Writer) u8(v uint8) { w.b = append(w.b, v) }
+
+func (w *p9Writer) u16(v uint16) { w.uint(uint64(v), 2) }
+
+func (w *p9Writer) u32(v uint32) { w.uint(uint64(v), 4) }
+
+func (w *p9Writer) u64(v uint64) { w.uint(v, 8) }
+
+func (w *p9Writer) uint(v uint64, n int) {
+	for i := 0; i < n; i++ {
+		w.b = append(w.b, byte(v>>(8*i)))
+	}
+}
+
+func (w *p9Writer) str(s string) {
+	w.u16(uint16(len(s)))
+	w.b = append(w.b, s...)
+}
+
+func (w *p9Writer) qid(q p9Qid) {
+	w.u8(q.typ)
+	w.u32(q.version)
+	w.u64(q.path)
+}
+
+// finish fills the size of the message and returns it.
+func (w *p9Writer) finish() []byte {
+	n := uint32(len(w.b))
+	w.b[0], w.b[1], w.b[2], w.b[3] = byte(n), byte(n>>8), byte(n>>16), byte(n>>24)
+	return w.b
+}
@@ -0,0 +1,267 @@
+package riscv
+
+import (
+	"fmt"
+	mathbits "math/bits"
+	"sync"
+)
+
+// PLIC (Platform-Level Interrupt Controller) routes the interrupts from the devices
+// to the hart. The register layout is compatible with SiFive PLIC which is used by
+// QEMU virt machine.
+//
+// Two contexts are connected to hart 0. Context 0 is for M-mode and delivers the
+// machine external interrupt (MEIP), and context 1 is for S-mode and delivers the
+// supervisor external interrupt (SEIP).
+//
+// The interrupt sources are level-triggered. The pending bit of the source is set
+// while the line is asserted unless the interrupt has been claimed and not completed yet.
+//
+// see: https://github.com/riscv/riscv-plic-spec/blob/master/riscv-plic.adoc
+type PLIC struct {
+	mu sync.Mutex
+
+	priority [plicSources]uint32
+	pending  [plicSources / 32]uint32
+	// level is the current level of the interrupt lines.
+	level [plicSources]bool
+	// claimed is set between the claim and the completion of the interrupt.
+	claimed [plicSources]bool
+
+	enable    [plicContexts][plicSources / 32]uint32
+	threshold [plicContexts]uint32
+}
+
+var (
+	_ Device          = (*PLIC)(nil)
+	_ InterruptSource = (*PLIC)(nil)
+)
+
+const (
+	plicStartAddress = 0xc000000
+	plicSize         = 0x4000000
+
+	// plicSources is the number of the interrupt sources. Source 0 does not exist.
+	plicSources = 128
+	// plicContexts is the number of the contexts which are connected to the hart.
+	plicContexts = 2
+	// plicMaxPriority is the maximum priority. The priority 0 means "never interrupt".
+	plicMaxPriority = 7
+
+	// The offsets of the registers.
+	plicPriority      = 0x0
+	plicPending       = 0x1000
+	plicEnable        = 0x2000
+	plicEnableStride  = 0x80
+	plicContext       = 0x200000
+	plicContextStride = 0x1000
+	// plicThreshold and plicClaim are the offsets in the context.
+	plicThreshold = 0x0
+	plicClaim     = 0x4
+)
+
+// NewPLIC creates PLIC.
+func NewPLIC() *PLIC {
+	return &PLIC{}
+}
+
+// StartAddr represents start address for PLIC.
+func (p *PLIC) StartAddr() uint64 { return plicStartAddress }
+
+// EndAddr represents end of address for PLIC.
+func (p *PLIC) EndAddr() uint64 { return plicStartAddress + plicSize }
+
+// plicLine is the interrupt line which is connected to the source of PLIC.
+type plicLine struct {
+	p  *PLIC
+	id int
+}
+
+func (l *plicLine) SetLevel(level bool) {
+	l.p.mu.Lock()
+	defer l.p.mu.Unlock()
+	l.p.level[l.id] = level
+	if !l.p.claimed[l.id] {
+		l.p.setPending(l.id, level)
+	}
+}
+
+// Line returns the interrupt line which is connected to the source id.
+// It panics if id is not in [1, 127].
+func (p *PLIC) Line(id int) InterruptLine {
+	if id <= 0 || id >= plicSources {
+		panic(fmt.Sprintf("riscv: invalid PLIC interrupt source: %d", id))
+	}
+	return &plicLine{p: p, id: id}
+}
+
+func (p *PLIC) setPending(id int, pending bool) {
+	if pending {
+		p.pending[id/32] |= 1 << (id % 32)
+	} else {
+		p.pending[id/32] &^= 1 << (id % 32)
+	}
+}
+
+// Read reads the 32-bit register. The 64-bit access is split into two 32-bit accesses.
+func (p *PLIC) Read(addr, size uint64) uint64 {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if size == 8 {
+		return uint64(p.read(addr)) | uint64(p.read(addr+4))<<32
+	}
+	return readRegister(uint64(p.read(addr&^3)), addr&3, size)
+}
+
+func (p *PLIC) read(addr uint64) uint32 {
+	switch {
+	case addr < plicPending:
+		if id := (addr - plicPriority) / 4; id < plicSources {
+			return p.priority[id]
+		}
+	case addr < plicEnable:
+		if w := (addr - plicPending) / 4; w < plicSources/32 {
+			return p.pending[w]
+		}
+	case addr < plicContext:
+		ctx, w := (addr-plicEnable)/plicEnableStride, (addr-plicEnable)%plicEnableStride/4
+		if ctx < plicContexts && w < plicSources/32 {
+			return p.enable[ctx][w]
+		}
+	default:
+		ctx, off := (addr-plicContext)/plicContextStride, (addr-plicContext)%plicContextStride
+		if ctx >= plicContexts {
+			break
+		}
+		switch off {
+		case plicThreshold:
+			return p.threshold[ctx]
+		case plicClaim:
+			return uint32(p.claim(int(ctx)))
+		}
+	}
+	return 0
+}
+
+// Write writes the 32-bit register. The 64-bit access is split into two 32-bit accesses.
+// The narrower accesses are only supported for the priority, the enable and the threshold registers.
+func (p *PLIC) Write(addr, size, value uint64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	switch size {
+	case 8:
+		p.write(addr, uint32(value))
+		p.write(addr+4, uint32(value>>32))
+	case 4:
+		p.write(addr, uint32(value))
+	default:
+		// The claim register is not read here because the read has the side effect.
+		if addr >= plicContext && (addr-plicContext)%plicContextStride&^3 == plicClaim {
+			return
+		}
+		aligned := addr &^ 3
+		p.write(aligned, uint32(writeRegister(uint64(p.read(aligned)), addr&3, size, value)))
+	}
+}
+
+func (p *PLIC) write(addr uint64, value uint32) {
+	switch {
+	case addr < plicPending:
+		// The priority of source 0 is hardwired to zero.
+		if id := (addr - plicPriority) / 4; id > 0 && id < plicSources {
+			p.priority[id] = min32(value, plicMaxPriority)
+		}
+	case addr < plicEnable:
+		// The pending bits are read-only.
+	case addr < plicContext:
+		ctx, w := (addr-plicEnable)/plicEnableStride, (addr-plicEnable)%plicEnableStride/4
+		if ctx < plicContexts && w < plicSources/32 {
+			if w == 0 {
+				// Source 0 does not exist.
+				value &^= 1
+			}
+			p.enable[ctx][w] = value
+		}
+	default:
+		ctx, off := (addr-plicContext)/plicContextStride, (addr-plicContext)%plicContextStride
+		if ctx >= plicContexts {
+			break
+		}
+		switch off {
+		case plicThreshold:
+			p.threshold[ctx] = min32(value, plicMaxPriority)
+		case plicClaim:
+			p.complete(int(ctx), int(value))
+		}
+	}
+}
+
+func min32(a, b uint32) uint32 {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func (p *PLIC) enabled(ctx, id int) bool {
+	return p.enable[ctx][id/32]>>(id%32)&1 != 0
+}
+
+// highest returns the pending and enabled source which has the highest priority
+// in the context. The ties are broken by the lowest ID. It returns 0 if no source
+// has the priority above the threshold.
+func (p *PLIC) highest(ctx int) int {
+	id, best := 0, p.threshold[ctx]
+	for w, pending := range p.pending {
+		for candidates := pending & p.enable[ctx][w]; candidates != 0; candidates &= candidates - 1 {
+			i := w*32 + mathbits.TrailingZeros32(candidates)
+			if p.priority[i] > best {
+				id, best = i, p.priority[i]
+			}
+		}
+	}
+	return id
+}
+
+// claim returns the ID of the highest priority pending interrupt and clears
+// its pending bit. It returns 0 if there is no pending interrupt.
+//
+// see: Interrupt Claim Process
+func (p *PLIC) claim(ctx int) int {
+	id := p.highest(ctx)
+	if id != 0 {
+		p.setPending(id, false)
+		p.claimed[id] = true
+	}
+	return id
+}
+
+// complete signals that the handler has completed the interrupt. The completion
+// is ignored if the source is not enabled for the context. If the line is still
+// asserted, the interrupt becomes pending again.
+//
+// see: Interrupt Completion
+func (p *PLIC) complete(ctx, id int) {
+	if id <= 0 || id >= plicSources || !p.enabled(ctx, id) {
+		return
+	}
+	p.claimed[id] = false
+	if p.level[id] {
+		p.setPending(id, true)
+	}
+}
+
+// PendingInterrupts returns MEIP and SEIP. The interrupt is pending in the context
+// if any enabled source is pending with the priority above the threshold.
+func (p *PLIC) PendingInterrupts() uint64 {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var pending uint64
+	if p.highest(0) != 0 {
+		pending |= 1 << MachineExternalInterrupt
+	}
+	if p.highest(1) != 0 {
+		pending |= 1 << SupervisorExternalInterrupt
+	}
+	return pending
+}
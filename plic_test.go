@@ -0,0 +1,97 @@
+package riscv
+
+import "testing"
+
+// newPLICWithLines returns PLIC whose lines of the sources are asserted.
+func newPLICWithLines(ids ...int) *PLIC {
+	p := NewPLIC()
+	for _, id := range ids {
+		p.Line(id).SetLevel(true)
+	}
+	return p
+}
+
+func TestPLIC(t *testing.T) {
+	const (
+		priority   = plicStartAddress + plicPriority
+		pending    = plicStartAddress + plicPending
+		enable0    = plicStartAddress + plicEnable
+		enable1    = enable0 + plicEnableStride
+		threshold0 = plicStartAddress + plicContext + plicThreshold
+		claim0     = plicStartAddress + plicContext + plicClaim
+		threshold1 = threshold0 + plicContextStride
+		claim1     = claim0 + plicContextStride
+	)
+	p := NewPLIC()
+	bus := NewBus(p)
+	mustWrite(t, bus, priority+1*4, 4, 2)
+	mustWrite(t, bus, priority+2*4, 4, 5)
+	mustWrite(t, bus, priority+33*4, 4, 0xff)
+	if got := mustRead(t, bus, priority+33*4, 4); got != plicMaxPriority {
+		t.Errorf("want priority is limited to %d but got %d", plicMaxPriority, got)
+	}
+	mustWrite(t, bus, priority, 4, 1)
+	if got := mustRead(t, bus, priority, 4); got != 0 {
+		t.Errorf("want priority of source 0 is hardwired to zero but got %d", got)
+	}
+
+	p.Line(1).SetLevel(true)
+	p.Line(33).SetLevel(true)
+	if got := mustRead(t, bus, pending, 8); got != 1<<33|1<<1 {
+		t.Errorf("want source 1 and 33 are pending but got 0x%x", got)
+	}
+	if got := p.PendingInterrupts(); got != 0 {
+		t.Errorf("want no interrupts because no source is enabled but got 0x%x", got)
+	}
+
+	// Source 1 is for M-mode and source 33 is for S-mode.
+	mustWrite(t, bus, enable0, 4, 1<<1|1<<2)
+	mustWrite(t, bus, enable1+4, 4, 1<<(33-32))
+	if got := p.PendingInterrupts(); got != 1<<MachineExternalInterrupt|1<<SupervisorExternalInterrupt {
+		t.Errorf("want MEIP and SEIP but got 0x%x", got)
+	}
+
+	// The interrupt is masked by the threshold.
+	mustWrite(t, bus, threshold1, 4, plicMaxPriority)
+	if got := p.PendingInterrupts(); got != 1<<MachineExternalInterrupt {
+		t.Errorf("want only MEIP but got 0x%x", got)
+	}
+	if got := mustRead(t, bus, claim1, 4); got != 0 {
+		t.Errorf("want no interrupt is claimed but got %d", got)
+	}
+
+	// The higher priority interrupt is claimed first.
+	p.Line(2).SetLevel(true)
+	if got := mustRead(t, bus, claim0, 4); got != 2 {
+		t.Errorf("want source 2 is claimed but got %d", got)
+	}
+	if got := mustRead(t, bus, claim0, 4); got != 1 {
+		t.Errorf("want source 1 is claimed but got %d", got)
+	}
+	if got := p.PendingInterrupts(); got != 0 {
+		t.Errorf("want no interrupts while the sources are claimed but got 0x%x", got)
+	}
+
+	// The line of source 2 is deasserted, so it does not become pending after the completion.
+	p.Line(2).SetLevel(false)
+	mustWrite(t, bus, claim0, 4, 2)
+	if got := p.PendingInterrupts(); got != 0 {
+		t.Errorf("want no interrupts but got 0x%x", got)
+	}
+	// The line of source 1 is still asserted.
+	mustWrite(t, bus, claim0, 4, 1)
+	if got := p.PendingInterrupts(); got != 1<<MachineExternalInterrupt {
+		t.Errorf("want MEIP after the completion but got 0x%x", got)
+	}
+
+	// The completion is ignored if the source is not enabled.
+	if got := mustRead(t, bus, claim0, 4); got != 1 {
+		t.Errorf("want source 1 is claimed but got %d", got)
+	}
+	mustWrite(t, bus, enable0, 4, 0)
+	mustWrite(t, bus, claim0, 4, 1)
+	mustWrite(t, bus, enable0, 4, 1<<1)
+	if got := p.PendingInterrupts(); got != 0 {
+		t.Errorf("want no interrupts because the completion is ignored but got 0x%x", got)
+	}
+}
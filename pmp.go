@@ -0,0 +1,216 @@
+package riscv
+
+import "fmt"
+
+// pmpcfg fields. Each pmpcfg register holds the configurations of 4 entries in RV32
+// and 8 entries in RV64.
+//
+// see: 3.7.1 Physical Memory Protection CSRs
+const (
+	pmpR = 1 << 0
+	pmpW = 1 << 1
+	pmpX = 1 << 2
+	pmpA = 0b11 << pmpAShift
+	pmpL = 1 << 7
+
+	pmpAShift = 3
+	// pmpcfgMask is a mask of the fields in each configuration. Bits 5 and 6 are reserved.
+	pmpcfgMask = pmpR | pmpW | pmpX | pmpA | pmpL
+)
+
+// Address-matching modes which are encoded in A field of the configuration.
+//
+// see: 3.7.1.1 Address Matching
+const (
+	pmpOff   = 0 // Null region (disabled)
+	pmpTOR   = 1 // Top of range
+	pmpNA4   = 2 // Naturally aligned four-byte region
+	pmpNAPOT = 3 // Naturally aligned power-of-two region, ≥8 bytes
+)
+
+const (
+	// maxPMPEntries is the number of PMP CSRs which are defined by the specification.
+	maxPMPEntries = 64
+	// defaultPMPEntries is the number of PMP entries which are implemented by default.
+	defaultPMPEntries = 16
+)
+
+// WithPMPEntries sets the number of PMP entries which are implemented.
+// It panics if n is not 0, 16 or 64.
+//
+// The PMP CSRs of the entries which are not implemented are read-only zero.
+// If any entry is implemented, S-mode and U-mode can access only the memory
+// which is permitted by the entries, so M-mode software must configure them first.
+//
+// The default is 16.
+func WithPMPEntries(n int) Option {
+	if n != 0 && n != 16 && n != maxPMPEntries {
+		panic(fmt.Sprintf("riscv: unsupported number of PMP entries: %d", n))
+	}
+	return func(c *CPU) {
+		c.pmpEntries = n
+	}
+}
+
+// pmpEntry is a PMP entry which is decoded from pmpcfg and pmpaddr.
+type pmpEntry struct {
+	cfg uint8
+	// The entry matches the physical addresses in [lo, hi).
+	lo, hi uint64
+}
+
+// pmpcfgAddr returns the address of pmpcfg register which holds the configuration
+// of the i-th entry and the position of the configuration in the register.
+// The odd-numbered pmpcfg registers are not used in RV64.
+func pmpcfgAddr(i int, xlen XLEN) (uint32, uint) {
+	if xlen == XLEN64 {
+		return CSRPmpcfg0 + uint32(i/8*2), uint(i%8) * 8
+	}
+	return CSRPmpcfg0 + uint32(i/4), uint(i%4) * 8
+}
+
+// pmpcfg returns the configuration of the i-th entry.
+func (f *CSRFile) pmpcfg(i int, xlen XLEN) uint8 {
+	addr, shift := pmpcfgAddr(i, xlen)
+	return uint8(f.Get(addr) >> shift)
+}
+
+// addPMP adds pmpcfg and pmpaddr registers. The first n entries are implemented.
+//
+// The configuration and the address of the locked entry can not be written.
+// The address of the entry which is followed by the locked TOR entry can not be
+// written either because it is the bottom of the locked range.
+// The configuration with W=1 and R=0 is reserved, so the write to it is ignored.
+//
+// pmpaddr holds bits 33:2 of the address in RV32 and bits 55:2 in RV64.
+// The granularity is 4 bytes.
+func (f *CSRFile) addPMP(n int, xlen XLEN) {
+	mask := ^uint64(0) >> (64 - xlen)
+	cfgRegs, perReg := 16, 4
+	if xlen == XLEN64 {
+		cfgRegs, perReg = 8, 8
+	}
+	for r := 0; r < cfgRegs; r++ {
+		first := r * perReg
+		writable := uint64(0)
+		for j := 0; j < perReg && first+j < n; j++ {
+			writable |= pmpcfgMask << (8 * j)
+		}
+		addr, _ := pmpcfgAddr(first, xlen)
+		f.add(addr, 0, mask, writable, func(old, new uint64) uint64 {
+			for j := 0; j < perReg; j++ {
+				shift := uint(8 * j)
+				cfg := new >> shift & 0xff
+				if old>>shift&pmpL != 0 || cfg&(pmpR|pmpW) == pmpW {
+					new = new&^(0xff<<shift) | old&(0xff<<shift)
+				}
+			}
+			return new
+		})
+	}
+
+	addrMask := uint64(1<<54 - 1)
+	if xlen == XLEN32 {
+		addrMask = 1<<32 - 1
+	}
+	for i := 0; i < maxPMPEntries; i++ {
+		i := i
+		writable := uint64(0)
+		if i < n {
+			writable = addrMask
+		}
+		f.add(CSRPmpaddr0+uint32(i), 0, mask, writable, func(old, new uint64) uint64 {
+			if f.pmpcfg(i, xlen)&pmpL != 0 {
+				return old
+			}
+			if next := i + 1; next < n {
+				cfg := f.pmpcfg(next, xlen)
+				if cfg&pmpL != 0 && cfg&pmpA>>pmpAShift == pmpTOR {
+					return old
+				}
+			}
+			return new
+		})
+	}
+}
+
+// updatePMP decodes the PMP entries from the CSRs. It is called whenever the PMP CSRs are written.
+// Only the entries which are not disabled are kept in the order of the priority.
+func (c *CPU) updatePMP() {
+	c.pmp = c.pmp[:0]
+	prev := uint64(0)
+	for i := 0; i < c.pmpEntries; i++ {
+		cfg := c.csrs.pmpcfg(i, c.xlen)
+		addr := c.csrs.Get(CSRPmpaddr0 + uint32(i))
+		e := pmpEntry{cfg: cfg}
+		switch cfg & pmpA >> pmpAShift {
+		case pmpOff:
+			prev = addr
+			continue
+		case pmpTOR:
+			// The range is empty if the bottom is not below the top.
+			e.lo, e.hi = prev<<2, addr<<2
+		case pmpNA4:
+			e.lo, e.hi = addr<<2, addr<<2+4
+		case pmpNAPOT:
+			// The number of the trailing ones encodes the size of the region.
+			// e.g. yyyy...yy01 is 16 bytes, and yyyy...y011 is 32 bytes.
+			ones := uint(0)
+			for addr>>ones&1 != 0 {
+				ones++
+			}
+			size := uint64(8) << ones
+			e.lo = (addr &^ (1<<ones - 1)) << 2
+			e.hi = e.lo + size
+		}
+		prev = addr
+		c.pmp = append(c.pmp, e)
+	}
+}
+
+// pmpPermitted reports whether the access to size bytes at the physical address paddr
+// in the privilege mode is permitted by PMP.
+//
+// The entries are checked in order and the lowest-numbered entry which matches any
+// byte of the access determines whether the access succeeds. The access fails if
+// the entry does not match all bytes of the access. M-mode accesses are checked only
+// against the locked entries. If no entry matches, only M-mode accesses succeed unless
+// no entries are implemented.
+//
+// see: 3.7.1.2 Priority and Matching Logic
+func (c *CPU) pmpPermitted(paddr, size uint64, mode PrivilegeMode, access accessType) bool {
+	end := paddr + size
+	for _, e := range c.pmp {
+		if end <= e.lo || paddr >= e.hi {
+			continue
+		}
+		if paddr < e.lo || end > e.hi {
+			return false
+		}
+		if mode == MachineMode && e.cfg&pmpL == 0 {
+			return true
+		}
+		switch access {
+		case accessInstruction:
+			return e.cfg&pmpX != 0
+		case accessLoad:
+			return e.cfg&pmpR != 0
+		}
+		return e.cfg&pmpW != 0
+	}
+	return mode == MachineMode || c.pmpEntries == 0
+}
+
+// physicalAddr translates the virtual address of the access to size bytes and checks
+// the physical address by PMP. An access fault is raised if PMP denies the access.
+func (c *CPU) physicalAddr(vaddr, size uint64, access accessType) (uint64, error) {
+	paddr, err := c.translate(vaddr, access)
+	if err != nil {
+		return 0, err
+	}
+	if !c.pmpPermitted(paddr, size, c.effectiveMode(access), access) {
+		c.debugf("pmp: access to 0x%x is denied", paddr)
+		return 0, access.accessFault(vaddr)
+	}
+	return paddr, nil
+}
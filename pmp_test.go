@@ -0,0 +1,237 @@
+package riscv
+
+import (
+	"errors"
+	"testing"
+)
+
+// permitAll configures PMP so that S-mode and U-mode can access the whole memory.
+func permitAll(t *testing.T, cpu *CPU) {
+	t.Helper()
+	mustWriteCSR(t, cpu, CSRPmpaddr0, ^uint64(0))
+	mustWriteCSR(t, cpu, CSRPmpcfg0, pmpNAPOT<<pmpAShift|pmpR|pmpW|pmpX)
+}
+
+func mustWriteCSR(t *testing.T, cpu *CPU, addr uint32, value uint64) {
+	t.Helper()
+	if err := cpu.csrs.Write(addr, value); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPMP(t *testing.T) {
+	const (
+		tor   = pmpTOR << pmpAShift
+		na4   = pmpNA4 << pmpAShift
+		napot = pmpNAPOT << pmpAShift
+		rwx   = pmpR | pmpW | pmpX
+	)
+	cases := []struct {
+		name string
+		// cfg and addr are the values of pmpcfg0 and pmpaddr0-pmpaddr3.
+		cfg    uint64
+		addr   [4]uint64
+		mode   PrivilegeMode
+		paddr  uint64
+		size   uint64
+		access accessType
+		want   bool
+	}{
+		{name: "no entry matches in S-mode", mode: SupervisorMode, paddr: 0x1000, size: 4, access: accessLoad, want: false},
+		{name: "no entry matches in M-mode", mode: MachineMode, paddr: 0x1000, size: 4, access: accessLoad, want: true},
+		{
+			name:  "TOR",
+			cfg:   tor | pmpR,
+			addr:  [4]uint64{0x2000 >> 2},
+			mode:  UserMode,
+			paddr: 0x1ffc, size: 4, access: accessLoad,
+			want: true,
+		},
+		{
+			name:  "TOR top is excluded",
+			cfg:   tor | pmpR,
+			addr:  [4]uint64{0x2000 >> 2},
+			mode:  UserMode,
+			paddr: 0x2000, size: 4, access: accessLoad,
+			want: false,
+		},
+		{
+			name:  "TOR bottom is previous pmpaddr",
+			cfg:   (tor|rwx)<<8 | 0,
+			addr:  [4]uint64{0x1000 >> 2, 0x2000 >> 2},
+			mode:  UserMode,
+			paddr: 0xffc, size: 4, access: accessLoad,
+			want: false,
+		},
+		{
+			name:  "TOR permission",
+			cfg:   tor | pmpR,
+			addr:  [4]uint64{0x2000 >> 2},
+			mode:  UserMode,
+			paddr: 0x1000, size: 4, access: accessStore,
+			want: false,
+		},
+		{
+			name:  "NA4",
+			cfg:   na4 | pmpX,
+			addr:  [4]uint64{0x1004 >> 2},
+			mode:  SupervisorMode,
+			paddr: 0x1004, size: 4, access: accessInstruction,
+			want: true,
+		},
+		{
+			name:  "NA4 partial match",
+			cfg:   na4 | pmpR,
+			addr:  [4]uint64{0x1004 >> 2},
+			mode:  SupervisorMode,
+			paddr: 0x1000, size: 8, access: accessLoad,
+			want: false,
+		},
+		{
+			name:  "NAPOT",
+			cfg:   napot | pmpW | pmpR,
+			addr:  [4]uint64{0x1000>>2 | 0x1ff}, // 0x1000-0x1fff
+			mode:  UserMode,
+			paddr: 0x1ff8, size: 8, access: accessStore,
+			want: true,
+		},
+		{
+			name:  "NAPOT out of range",
+			cfg:   napot | pmpW | pmpR,
+			addr:  [4]uint64{0x1000>>2 | 0x1ff}, // 0x1000-0x1fff
+			mode:  UserMode,
+			paddr: 0x2000, size: 4, access: accessLoad,
+			want: false,
+		},
+		{
+			name:  "lowest-numbered entry has priority",
+			cfg:   (napot|rwx)<<8 | na4,
+			addr:  [4]uint64{0x1000 >> 2, 0x1000>>2 | 0x1ff},
+			mode:  UserMode,
+			paddr: 0x1000, size: 4, access: accessLoad,
+			want: false,
+		},
+		{
+			name:  "partial match is not covered by following entry",
+			cfg:   (napot|rwx)<<8 | na4 | rwx,
+			addr:  [4]uint64{0x1000 >> 2, 0x1000>>2 | 0x1ff},
+			mode:  UserMode,
+			paddr: 0x1000, size: 8, access: accessLoad,
+			want: false,
+		},
+		{
+			name:  "unlocked entry does not apply to M-mode",
+			cfg:   na4,
+			addr:  [4]uint64{0x1000 >> 2},
+			mode:  MachineMode,
+			paddr: 0x1000, size: 4, access: accessStore,
+			want: true,
+		},
+		{
+			name:  "locked entry applies to M-mode",
+			cfg:   na4 | pmpL | pmpR,
+			addr:  [4]uint64{0x1000 >> 2},
+			mode:  MachineMode,
+			paddr: 0x1000, size: 4, access: accessStore,
+			want: false,
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			cpu := NewCPU(make([]byte, 4))
+			for i, addr := range tc.addr {
+				mustWriteCSR(t, cpu, CSRPmpaddr0+uint32(i), addr)
+			}
+			mustWriteCSR(t, cpu, CSRPmpcfg0, tc.cfg)
+			if got := cpu.pmpPermitted(tc.paddr, tc.size, tc.mode, tc.access); got != tc.want {
+				t.Errorf("want %t but got %t", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestPMPNoEntries(t *testing.T) {
+	cpu := NewCPU(make([]byte, 4), WithPMPEntries(0))
+	mustWriteCSR(t, cpu, CSRPmpaddr0, 0x1000>>2)
+	if got := cpu.csrs.Get(CSRPmpaddr0); got != 0 {
+		t.Errorf("want pmpaddr0 is read-only zero but got 0x%x", got)
+	}
+	if !cpu.pmpPermitted(0x1000, 4, UserMode, accessLoad) {
+		t.Error("want U-mode access is permitted")
+	}
+}
+
+func TestPMPCSRs(t *testing.T) {
+	t.Run("lock", func(t *testing.T) {
+		cpu := NewCPU(make([]byte, 4))
+		mustWriteCSR(t, cpu, CSRPmpaddr0, 0x1000>>2)
+		mustWriteCSR(t, cpu, CSRPmpaddr0+1, 0x2000>>2)
+		mustWriteCSR(t, cpu, CSRPmpcfg0, (pmpTOR<<pmpAShift|pmpL|pmpR)<<8|pmpNA4<<pmpAShift|pmpL)
+
+		// Both the configurations and the addresses are locked.
+		mustWriteCSR(t, cpu, CSRPmpcfg0, 0)
+		mustWriteCSR(t, cpu, CSRPmpaddr0, 0x3000>>2)
+		mustWriteCSR(t, cpu, CSRPmpaddr0+1, 0x3000>>2)
+		if got, want := cpu.csrs.Get(CSRPmpcfg0), uint64(0x8990); got != want {
+			t.Errorf("want pmpcfg0 0x%x but got 0x%x", want, got)
+		}
+		if got, want := cpu.csrs.Get(CSRPmpaddr0), uint64(0x1000>>2); got != want {
+			t.Errorf("want pmpaddr0 0x%x but got 0x%x", want, got)
+		}
+		if got, want := cpu.csrs.Get(CSRPmpaddr0+1), uint64(0x2000>>2); got != want {
+			t.Errorf("want pmpaddr1 0x%x but got 0x%x", want, got)
+		}
+	})
+	t.Run("bottom of locked TOR entry", func(t *testing.T) {
+		cpu := NewCPU(make([]byte, 4))
+		mustWriteCSR(t, cpu, CSRPmpaddr0, 0x1000>>2)
+		mustWriteCSR(t, cpu, CSRPmpcfg0, (pmpTOR<<pmpAShift|pmpL|pmpR)<<8)
+		mustWriteCSR(t, cpu, CSRPmpaddr0, 0x3000>>2)
+		if got, want := cpu.csrs.Get(CSRPmpaddr0), uint64(0x1000>>2); got != want {
+			t.Errorf("want pmpaddr0 0x%x but got 0x%x", want, got)
+		}
+	})
+	t.Run("reserved W without R", func(t *testing.T) {
+		cpu := NewCPU(make([]byte, 4))
+		// The write to entry 1 is ignored while entry 0 is written.
+		mustWriteCSR(t, cpu, CSRPmpcfg0, pmpW<<8|pmpR)
+		if got, want := cpu.csrs.Get(CSRPmpcfg0), uint64(pmpR); got != want {
+			t.Errorf("want pmpcfg0 0x%x but got 0x%x", want, got)
+		}
+	})
+	t.Run("RV64", func(t *testing.T) {
+		cpu := NewCPU(make([]byte, 4), WithXLEN(XLEN64))
+		if cpu.csrs.Exists(CSRPmpcfg0 + 1) {
+			t.Error("want pmpcfg1 does not exist in RV64")
+		}
+		// pmpcfg0 holds the configurations of entry 0-7.
+		mustWriteCSR(t, cpu, CSRPmpaddr0+7, 0x1000>>2)
+		mustWriteCSR(t, cpu, CSRPmpcfg0, (pmpNA4<<pmpAShift|pmpR)<<56)
+		if !cpu.pmpPermitted(0x1000, 4, UserMode, accessLoad) {
+			t.Error("want U-mode load is permitted by entry 7")
+		}
+		if got, want := cpu.csrs.Get(CSRPmpaddr0+7), uint64(0x1000>>2); got != want {
+			t.Errorf("want pmpaddr7 0x%x but got 0x%x", want, got)
+		}
+	})
+}
+
+func TestPMPAccessFault(t *testing.T) {
+	cpu := NewCPU(make([]byte, pageSize))
+	// dram is readable but not writable in U-mode.
+	mustWriteCSR(t, cpu, CSRPmpaddr0, dramStartAddress>>2|(pageSize>>3-1))
+	mustWriteCSR(t, cpu, CSRPmpcfg0, pmpNAPOT<<pmpAShift|pmpR)
+	cpu.mode = UserMode
+
+	if _, err := cpu.load(dramStartAddress, 4); err != nil {
+		t.Fatal(err)
+	}
+	err := cpu.store(dramStartAddress, 4, 1)
+	var e *Exception
+	if !errors.As(err, &e) || e.Code != StoreAMOAccessFault || e.Value != dramStartAddress {
+		t.Errorf("want store/AMO access fault but got %v", err)
+	}
+	if _, err := cpu.fetch(dramStartAddress, 4, true); !errors.As(err, &e) || e.Code != InstructionAccessFault {
+		t.Errorf("want instruction access fault but got %v", err)
+	}
+}
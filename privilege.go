@@ -0,0 +1,52 @@
+package riscv
+
+import "fmt"
+
+// PrivilegeMode represents the privilege level at which the hart is running.
+// The value is same as the encoding of MPP field in mstatus.
+//
+// see: 1.2 Privilege Levels (The RISC-V Instruction Set Manual Volume II: Privileged Architecture)
+type PrivilegeMode uint8
+
+const (
+	// UserMode is for the application code.
+	UserMode PrivilegeMode = 0b00
+	// SupervisorMode is for the operating system.
+	SupervisorMode PrivilegeMode = 0b01
+	// MachineMode is the highest privilege level. The hart starts in this mode.
+	MachineMode PrivilegeMode = 0b11
+)
+
+func (m PrivilegeMode) String() string {
+	switch m {
+	case UserMode:
+		return "U-mode"
+	case SupervisorMode:
+		return "S-mode"
+	case MachineMode:
+		return "M-mode"
+	}
+	return fmt.Sprintf("reserved mode (%d)", uint8(m))
+}
+
+// supportsMode reports whether the privilege mode is supported by the extensions.
+// M-mode is always supported.
+func (e Extensions) supportsMode(m PrivilegeMode) bool {
+	switch m {
+	case MachineMode:
+		return true
+	case SupervisorMode:
+		return e&ExtensionS != 0
+	case UserMode:
+		return e&ExtensionU != 0
+	}
+	return false
+}
+
+// leastPrivilegedMode returns the least-privileged mode which is supported by the extensions.
+func (e Extensions) leastPrivilegedMode() PrivilegeMode {
+	if e&ExtensionU != 0 {
+		return UserMode
+	}
+	return MachineMode
+}
@@ -0,0 +1,216 @@
+package riscv
+
+import (
+	"sync"
+	"time"
+)
+
+// Clock provides the wall-clock time to the devices. e.g. the real-time clock.
+type Clock interface {
+	Now() time.Time
+}
+
+// hostClock is Clock of the host wall time.
+type hostClock struct{}
+
+func (hostClock) Now() time.Time { return time.Now() }
+
+// HostClock returns Clock which reads the host wall time.
+func HostClock() Clock { return hostClock{} }
+
+// VirtualClock is Clock which advances by the fixed step on each tick of the device
+// which reads it, so the time seen by the program is deterministic. The time does
+// not advance if the step is zero. It is safe to set or advance the time from other goroutines.
+type VirtualClock struct {
+	mu   sync.Mutex
+	now  time.Time
+	step time.Duration
+}
+
+var (
+	_ Clock  = (*VirtualClock)(nil)
+	_ Ticker = (*VirtualClock)(nil)
+)
+
+// NewVirtualClock creates the clock which starts at start and advances by step per tick.
+func NewVirtualClock(start time.Time, step time.Duration) *VirtualClock {
+	return &VirtualClock{now: start, step: step}
+}
+
+// Now returns the current time of the clock.
+func (c *VirtualClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+// Set sets the current time of the clock.
+func (c *VirtualClock) Set(t time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = t
+}
+
+// Advance advances the clock by d.
+func (c *VirtualClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+// Tick advances the clock by the step.
+func (c *VirtualClock) Tick() {
+	if c.step != 0 {
+		c.Advance(c.step)
+	}
+}
+
+// GoldfishRTC is Goldfish real-time clock which is mapped to VIRT_RTC region of
+// QEMU virt machine. The time is the nanoseconds since the Unix epoch which is read
+// from Clock. The program can set the time, and the offset from the clock is kept
+// by the device.
+//
+// The alarm is armed by writing ALARM_LOW after ALARM_HIGH. When the time reaches
+// the alarm, the interrupt is pending until CLEAR_INTERRUPT is written, and the
+// interrupt line is asserted while it is pending and enabled by IRQ_ENABLED.
+// On QEMU virt machine, the RTC is connected to the source 11 of PLIC.
+//
+// see: https://android.googlesource.com/platform/external/qemu/+/master/docs/GOLDFISH-VIRTUAL-HARDWARE.TXT
+// see: https://github.com/qemu/qemu/blob/master/hw/rtc/goldfish_rtc.c
+type GoldfishRTC struct {
+	clock Clock
+	irq   InterruptLine
+
+	// offset is added to the time of the clock when the program sets the time.
+	offset uint64
+	// timeHigh is the upper 32 bits of the time which is latched when TIME_LOW is read.
+	timeHigh     uint32
+	alarm        uint64
+	alarmRunning bool
+	irqEnabled   bool
+	irqPending   bool
+}
+
+var (
+	_ Device = (*GoldfishRTC)(nil)
+	_ Ticker = (*GoldfishRTC)(nil)
+)
+
+const (
+	rtcStartAddress = 0x101000
+	rtcSize         = 0x1000
+
+	// The offsets of the 32-bit registers.
+	rtcTimeLow        = 0x00
+	rtcTimeHigh       = 0x04
+	rtcAlarmLow       = 0x08
+	rtcAlarmHigh      = 0x0c
+	rtcIRQEnabled     = 0x10
+	rtcClearAlarm     = 0x14
+	rtcAlarmStatus    = 0x18
+	rtcClearInterrupt = 0x1c
+)
+
+// NewGoldfishRTC creates the RTC whose time is read from clock. clock may be nil
+// to use the host wall time. irq may be nil if the interrupt line is not connected.
+func NewGoldfishRTC(clock Clock, irq InterruptLine) *GoldfishRTC {
+	if clock == nil {
+		clock = HostClock()
+	}
+	return &GoldfishRTC{
+		clock: clock,
+		irq:   irq,
+	}
+}
+
+// StartAddr represents start address for the RTC.
+func (r *GoldfishRTC) StartAddr() uint64 { return rtcStartAddress }
+
+// EndAddr represents end of address for the RTC.
+func (r *GoldfishRTC) EndAddr() uint64 { return rtcStartAddress + rtcSize }
+
+// now returns the time of the device in nanoseconds.
+func (r *GoldfishRTC) now() uint64 {
+	return uint64(r.clock.Now().UnixNano()) + r.offset
+}
+
+// Read reads the register. Reading TIME_LOW latches the upper half of the time,
+// so the 64-bit time is read consistently by TIME_LOW and then TIME_HIGH.
+func (r *GoldfishRTC) Read(addr, size uint64) uint64 {
+	var v uint32
+	switch addr &^ 3 {
+	case rtcTimeLow:
+		now := r.now()
+		r.timeHigh = uint32(now >> 32)
+		v = uint32(now)
+	case rtcTimeHigh:
+		v = r.timeHigh
+	case rtcAlarmLow:
+		v = uint32(r.alarm)
+	case rtcAlarmHigh:
+		v = uint32(r.alarm >> 32)
+	case rtcIRQEnabled:
+		if r.irqEnabled {
+			v = 1
+		}
+	case rtcAlarmStatus:
+		if r.alarmRunning {
+			v = 1
+		}
+	}
+	return readRegister(uint64(v), addr&3, size)
+}
+
+// Write writes the register. Writing TIME_LOW or TIME_HIGH sets the half of the time.
+func (r *GoldfishRTC) Write(addr, size, value uint64) {
+	switch addr {
+	case rtcTimeLow:
+		now := r.now()
+		r.offset += now&^0xffffffff | value&0xffffffff - now
+	case rtcTimeHigh:
+		now := r.now()
+		r.offset += value<<32 | now&0xffffffff - now
+	case rtcAlarmLow:
+		r.alarm = r.alarm&^0xffffffff | value&0xffffffff
+		r.setAlarm()
+	case rtcAlarmHigh:
+		r.alarm = value<<32 | r.alarm&0xffffffff
+	case rtcIRQEnabled:
+		r.irqEnabled = value&1 != 0
+		r.updateIRQ()
+	case rtcClearAlarm:
+		r.alarmRunning = false
+	case rtcClearInterrupt:
+		r.irqPending = false
+		r.updateIRQ()
+	}
+}
+
+// setAlarm arms the alarm. The alarm fires immediately if the time has passed.
+func (r *GoldfishRTC) setAlarm() {
+	r.alarmRunning = true
+	r.checkAlarm()
+}
+
+// checkAlarm fires the alarm if the time has reached it.
+func (r *GoldfishRTC) checkAlarm() {
+	if r.alarmRunning && r.now() >= r.alarm {
+		r.alarmRunning = false
+		r.irqPending = true
+		r.updateIRQ()
+	}
+}
+
+func (r *GoldfishRTC) updateIRQ() {
+	if r.irq != nil {
+		r.irq.SetLevel(r.irqPending && r.irqEnabled)
+	}
+}
+
+// Tick checks the alarm. If the clock is a Ticker (e.g. VirtualClock), it is advanced first.
+func (r *GoldfishRTC) Tick() {
+	if t, ok := r.clock.(Ticker); ok {
+		t.Tick()
+	}
+	r.checkAlarm()
+}
@@ -0,0 +1,106 @@
+package riscv
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestGoldfishRTCProgram(t *testing.T) {
+	code, err := os.ReadFile(filepath.Join("testdata", "rtc", "rtc.bin"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Date(2023, 4, 1, 12, 0, 0, 0, time.UTC)
+	line := &interruptLine{}
+	cpu := NewCPU(code, WithDevices(NewGoldfishRTC(NewVirtualClock(start, 10*time.Nanosecond), line)))
+	if _, err := cpu.Run(); err != nil {
+		t.Fatal(err)
+	}
+	got := uint64(uint32(cpu.xregs[11]))<<32 | uint64(uint32(cpu.xregs[10]))
+	// The clock is advanced before each instruction, and the time is read by the second one.
+	if want := uint64(start.UnixNano()) + 2*10; got != want {
+		t.Errorf("want time %d but got %d", want, got)
+	}
+	if after := uint64(uint32(cpu.xregs[12])); after < uint64(uint32(got))+100 {
+		t.Errorf("want the time after the alarm is at least %d but got %d", uint32(got)+100, after)
+	}
+	if !line.Level() {
+		t.Errorf("want the interrupt is asserted by the alarm")
+	}
+}
+
+func TestGoldfishRTC(t *testing.T) {
+	start := time.Unix(1700000000, 0)
+	clock := NewVirtualClock(start, 0)
+	line := &interruptLine{}
+	r := NewGoldfishRTC(clock, line)
+	readTime := func() uint64 {
+		low := r.Read(rtcTimeLow, 4)
+		return r.Read(rtcTimeHigh, 4)<<32 | low
+	}
+
+	if got, want := readTime(), uint64(start.UnixNano()); got != want {
+		t.Errorf("want time %d but got %d", want, got)
+	}
+	// TIME_HIGH is latched when TIME_LOW is read.
+	r.Read(rtcTimeLow, 4)
+	clock.Advance(time.Hour)
+	if got, want := r.Read(rtcTimeHigh, 4), uint64(start.UnixNano())>>32; got != want {
+		t.Errorf("want latched TIME_HIGH 0x%x but got 0x%x", want, got)
+	}
+
+	// The program sets the time. The offset is kept while the clock advances.
+	r.Write(rtcTimeHigh, 4, 0)
+	r.Write(rtcTimeLow, 4, 1000)
+	clock.Advance(time.Microsecond)
+	if got := readTime(); got != 2000 {
+		t.Errorf("want time 2000 but got %d", got)
+	}
+
+	// The alarm fires when the time reaches it.
+	r.Write(rtcIRQEnabled, 4, 1)
+	r.Write(rtcAlarmHigh, 4, 0)
+	r.Write(rtcAlarmLow, 4, 2500)
+	if got := r.Read(rtcAlarmStatus, 4); got != 1 {
+		t.Errorf("want the alarm is running but got %d", got)
+	}
+	r.Tick()
+	if line.Level() {
+		t.Errorf("want no interrupt before the alarm")
+	}
+	clock.Advance(500 * time.Nanosecond)
+	r.Tick()
+	if got := r.Read(rtcAlarmStatus, 4); got != 0 {
+		t.Errorf("want the alarm is not running after fired but got %d", got)
+	}
+	if !line.Level() {
+		t.Errorf("want the interrupt is asserted by the alarm")
+	}
+	r.Write(rtcClearInterrupt, 4, 1)
+	if line.Level() {
+		t.Errorf("want the interrupt is deasserted after cleared")
+	}
+
+	// The alarm in the past fires immediately, but the interrupt is masked while disabled.
+	r.Write(rtcIRQEnabled, 4, 0)
+	r.Write(rtcAlarmLow, 4, 0)
+	if line.Level() {
+		t.Errorf("want the interrupt is masked")
+	}
+	r.Write(rtcIRQEnabled, 4, 1)
+	if !line.Level() {
+		t.Errorf("want the pending interrupt is asserted when enabled")
+	}
+	r.Write(rtcClearInterrupt, 4, 1)
+
+	// The running alarm is cleared by CLEAR_ALARM.
+	r.Write(rtcAlarmLow, 4, 1<<31)
+	r.Write(rtcClearAlarm, 4, 1)
+	clock.Advance(time.Hour)
+	r.Tick()
+	if r.Read(rtcAlarmStatus, 4) != 0 || line.Level() {
+		t.Errorf("want the cleared alarm does not fire")
+	}
+}
@@ -0,0 +1,33 @@
+main:
+  j start
+lock:
+  .word 0
+counter:
+  .word 5
+start:
+  la s0, lock
+  la s1, counter
+  li t0, 1
+  lr.w a0, (s0)
+  sc.w a1, t0, (s0)
+  sc.w a2, t0, (s0)    # reservation is already released.
+  lr.w a3, (s1)
+  sw zero, 0(s1)       # store invalidates the reservation.
+  sc.w a4, t0, (s1)
+  amoswap.w.aq s2, zero, (s0)
+  li t1, 10
+  amoadd.w s3, t1, (s1)
+  li t1, -3
+  amomin.w s4, t1, (s1)
+  li t1, 7
+  amominu.w s5, t1, (s1)
+  li t1, -1
+  amomax.w s6, t1, (s1)
+  amomaxu.w s7, t1, (s1)
+  li t1, 0xf0
+  amoand.w s8, t1, (s1)
+  li t1, 0x0f
+  amoor.w s9, t1, (s1)
+  amoxor.w.rl s10, t1, (s1)
+  fence rw, rw
+  lw s11, 0(s1)
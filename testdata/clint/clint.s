@@ -0,0 +1,37 @@
+# The program waits for the machine timer interrupt, and then raises the machine
+# software interrupt. The handler records mcause to s1.
+main:
+  la t0, handler
+  csrw mtvec, t0
+  li t0, 0x2004000 # mtimecmp
+  li t1, 0x200bff8 # mtime
+  lw a0, 0(t1)
+  addi a0, a0, 100
+  sw zero, 4(t0)
+  sw a0, 0(t0)
+  li t2, 1 << 7 # MTIE
+  csrs mie, t2
+  csrsi mstatus, 1 << 3 # MIE
+wait:
+  beqz s0, wait
+  li t2, 1 << 3 # MSIE
+  csrs mie, t2
+  li t0, 0x2000000 # msip
+  li t1, 1
+  sw t1, 0(t0)
+  addi s3, s3, 1 # executed after the handler returns.
+  j done
+handler:
+  addi s0, s0, 1
+  csrr a1, mcause
+  slli s1, s1, 8
+  andi a1, a1, 0xff
+  or s1, s1, a1
+  csrr s2, mcause
+  li t0, 0x2004000
+  li t1, -1
+  sw t1, 4(t0) # clear MTIP
+  li t0, 0x2000000
+  sw zero, 0(t0) # clear MSIP
+  mret
+done:
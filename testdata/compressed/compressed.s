@@ -0,0 +1,51 @@
+main:
+  j start
+  .balign 16
+stack:
+  .space 32
+stack_top:
+start:
+  la sp, stack_top
+  c.li a0, 5
+  c.addi a0, 3
+  c.li a1, -2
+  c.add a0, a1         # 6
+  c.mv a2, a0
+  c.slli a2, 2
+  c.srli a2, 1         # 12
+  c.li a3, -16
+  c.srai a3, 2         # -4
+  c.andi a3, 14        # 12
+  c.sub a3, a2         # 0
+  c.lui a4, 1
+  c.addi16sp sp, -32
+  c.addi4spn a5, sp, 8
+  c.sw a0, 0(a5)
+  c.lw s0, 0(a5)
+  c.swsp a2, 4(sp)
+  c.lwsp s1, 4(sp)
+  c.jal func
+  c.beqz a3, 1f
+  c.li a0, 0           # skipped
+1:
+  c.bnez a3, end       # not taken
+  c.li a1, 3
+  c.or a1, a2          # 15
+  c.xor a1, a0         # 9
+  c.and a1, s1         # 9
+  c.nop
+  addi t0, zero, 100   # 32-bit instruction on a two-byte boundary.
+  fcvt.d.w fa0, a0
+  c.fsdsp fa0, 8(sp)
+  c.fldsp fa1, 8(sp)
+  fcvt.w.d t1, fa1
+  c.j end
+func:
+  c.addi s1, 1         # 13
+  c.jr ra
+end:
+  c.addi16sp sp, 32
+  auipc t2, 0
+  c.addi t2, 8
+  c.jalr t2            # jump to the next instruction.
+  c.nop
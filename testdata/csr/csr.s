@@ -0,0 +1,21 @@
+main:
+  csrr x1, misa
+  li x5, 0x12345678
+  csrrw x2, mscratch, x5
+  csrrs x3, mscratch, x0
+  li x6, 0xff
+  csrrc x4, mscratch, x6
+  csrrsi x7, mscratch, 0x1f
+  csrrci x8, mscratch, 0x3
+  csrrwi x9, mscratch, 0x5
+  csrr x10, mscratch
+  li x11, -1
+  csrw mie, x11
+  csrr x12, mie
+  csrw mstatus, x11
+  csrr x13, mstatus
+  csrw mtvec, x11
+  csrr x14, mtvec
+  csrw mepc, x11
+  csrr x15, mepc
+  csrr x16, mhartid
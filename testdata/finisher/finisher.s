@@ -0,0 +1,6 @@
+# The program stops the emulator by writing the status in a0 to the test finisher.
+# The instructions after the write are never executed.
+main:
+  li t0, 0x100000 # test finisher
+  sw a0, 0(t0)
+  li s0, 1
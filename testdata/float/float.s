@@ -0,0 +1,41 @@
+main:
+  j start
+  .balign 8
+data:
+  .word 0x40400000     # 3.0f
+  .word 0x3fc00000     # 1.5f
+  .dword 0x4004000000000000  # 2.5
+result:
+  .dword 0
+start:
+  la s0, data
+  flw ft0, 0(s0)
+  flw ft1, 4(s0)
+  fadd.s ft2, ft0, ft1
+  fmv.x.w a0, ft2      # 4.5f
+  fmul.s ft3, ft0, ft1
+  feq.s a1, ft2, ft3
+  fdiv.s ft4, ft0, ft1
+  fcvt.w.s a2, ft4
+  li t0, 1
+  fcvt.s.w ft5, t0
+  li t1, 3
+  fcvt.s.w ft6, t1
+  fdiv.s ft7, ft5, ft6 # 1/3 is inexact.
+  frflags a3
+  fclass.s a4, ft7
+  fld fa0, 8(s0)
+  fcvt.d.s fa1, ft1
+  fmadd.d fa2, fa0, fa1, fa1
+  fcvt.w.d a5, fa2, rtz
+  fsd fa2, 16(s0)
+  lw a6, 16(s0)
+  lw a7, 20(s0)
+  fsgnjn.d fa3, fa2, fa2
+  flt.d s1, fa3, fa0
+  fcvt.s.d ft8, fa3
+  fmv.x.w s2, ft8      # -5.25f
+  csrr s3, mstatus
+  fsflags zero
+  fcvt.wu.d s4, fa3    # negative value is invalid.
+  frflags s5
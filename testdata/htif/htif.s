@@ -0,0 +1,115 @@
+# The program follows the environment of riscv-tests (env/p). It prints to the
+# console of HTIF, writes to stdout by the syscall proxy and passes the test by
+# writing 1 to tohost from the trap handler. It is linked at 0x80000000 with
+# tohost and fromhost in their own page like env/p/link.ld.
+  .section .text.init
+  .globl _start
+_start:
+  j reset_vector
+
+trap_vector:
+  # RVTEST_PASS and RVTEST_FAIL reach here by ecall.
+  csrr t5, mcause
+  li t6, 11 # environment call from M-mode
+  beq t5, t6, write_tohost
+  ori gp, gp, 1337
+write_tohost:
+  sw gp, .Ltohost, t5
+  sw zero, .Ltohost+4, t5
+  j write_tohost
+
+reset_vector:
+  csrr a0, mhartid
+1:
+  bnez a0, 1b
+  # The machine may not implement PMP.
+  la t0, 1f
+  csrw mtvec, t0
+  li t0, -1
+  csrw pmpaddr0, t0
+  li t0, 0x1f # NAPOT, R, W and X
+  csrw pmpcfg0, t0
+  .align 2
+1:
+  la t0, trap_vector
+  csrw mtvec, t0
+  li gp, 0
+
+  # putchar by the console device.
+  la s0, message
+2:
+  lbu a1, 0(s0)
+  beqz a1, 4f
+  # The command is issued by writing the upper word.
+  sw a1, .Ltohost, t5
+  li t0, 0x01010000 # device 1, command 1
+  sw t0, .Ltohost+4, t5
+3:
+  lw t0, .Lfromhost
+  beqz t0, 3b
+  sw zero, .Lfromhost, t5
+  sw zero, .Lfromhost+4, t5
+  addi s0, s0, 1
+  j 2b
+4:
+  # write(1, buf, 3) by the syscall proxy.
+  la s1, magic_mem
+  li t0, 64 # SYS_write
+  sw t0, 0(s1)
+  li t0, 1
+  sw t0, 8(s1)
+  la t0, buf
+  sw t0, 16(s1)
+  li t0, 3
+  sw t0, 24(s1)
+  sw s1, .Ltohost, t5
+  sw zero, .Ltohost+4, t5
+5:
+  lw t0, .Lfromhost
+  beqz t0, 5b
+  sw zero, .Lfromhost, t5
+  lw s2, 0(s1) # the return value
+
+  # TEST_IMM_OP(2, addi, 5, 2, 3)
+  li gp, 2
+  li a0, 2
+  addi a1, a0, 3
+  li t2, 5
+  bne a1, t2, fail
+  li gp, 3
+  li t2, 3
+  bne s2, t2, fail
+
+pass:
+  fence
+  li gp, 1
+  li a7, 93
+  li a0, 0
+  ecall
+fail:
+  fence
+  slli gp, gp, 1
+  ori gp, gp, 1
+  li a7, 93
+  mv a0, gp
+  ecall
+
+message:
+  .string "ok\n"
+buf:
+  .string "hi\n"
+
+  .balign 8
+magic_mem:
+  .zero 64
+
+  .balign 4096
+  .globl tohost
+tohost:
+.Ltohost:
+  .dword 0
+  .balign 64
+  .globl fromhost
+fromhost:
+.Lfromhost:
+  .dword 0
@@ -0,0 +1,19 @@
+main:
+  li x1, -7
+  li x2, 3
+  li x3, 0x80000000
+  li x4, -1
+  mul x5, x1, x2
+  mulh x6, x3, x3
+  mulhsu x7, x4, x4
+  mulhu x8, x4, x4
+  div x9, x1, x2
+  divu x10, x1, x2
+  rem x11, x1, x2
+  remu x12, x1, x2
+  div x13, x1, zero
+  divu x14, x1, zero
+  rem x15, x1, zero
+  remu x16, x1, zero
+  div x17, x3, x4
+  rem x18, x3, x4
@@ -0,0 +1,42 @@
+# The program handles the external interrupts from source 1 and 2 which are
+# asserted before the program starts. The handler records the claimed IDs to s1.
+main:
+  la t0, handler
+  csrw mtvec, t0
+  li t0, 0xc000000 # priority
+  li t1, 1
+  sw t1, 4(t0) # source 1
+  li t1, 3
+  sw t1, 8(t0) # source 2
+  li t0, 0xc002000 # enable bits for context 0
+  li t1, 0b110
+  sw t1, 0(t0)
+  li t0, 0xc200000 # threshold for context 0
+  sw zero, 0(t0)
+  li t1, 1 << 11 # MEIE
+  csrs mie, t1
+  csrsi mstatus, 1 << 3 # MIE
+  li t1, 2
+wait:
+  blt s0, t1, wait
+  j done
+handler:
+  li t0, 0xc200004 # claim/complete
+  lw a0, 0(t0)
+  slli s1, s1, 4
+  or s1, s1, a0
+  sw a0, 0(t0)
+  # The line is still asserted, so disable the source not to be interrupted again.
+  li t2, 0xc002000
+  lw t3, 0(t2)
+  li t4, 1
+  sll t4, t4, a0
+  not t4, t4
+  and t3, t3, t4
+  sw t3, 0(t2)
+  addi s0, s0, 1
+  mret
+done:
+  lw s2, 0(t0) # no interrupt is pending.
+  li t0, 0xc001000
+  lw s3, 0(t0) # pending bits
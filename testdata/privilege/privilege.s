@@ -0,0 +1,45 @@
+# The program goes down from M-mode to S-mode and U-mode with mret and sret.
+# The exceptions which are raised in U-mode are handled in M-mode or S-mode
+# depending on medeleg.
+main:
+  # Allow S-mode and U-mode to access the whole memory.
+  li t0, -1
+  csrw pmpaddr0, t0
+  li t0, 0x1f # NAPOT | X | W | R
+  csrw pmpcfg0, t0
+  la t0, mhandler
+  csrw mtvec, t0
+  la t0, shandler
+  csrw stvec, t0
+  li t0, 1 << 8 # delegate environment call from U-mode.
+  csrw medeleg, t0
+  li t0, 1 << 12 # MPP = S
+  csrc mstatus, t0
+  csrsi mstatus, 1 << 1 # SIE = 1
+  la t0, smode
+  csrw mepc, t0
+  mret
+smode:
+  csrr s0, sstatus
+  la t0, umode
+  csrw sepc, t0
+  sret # SPP = U
+umode:
+  csrr s1, mstatus # illegal instruction
+  ecall
+mhandler:
+  addi s2, s2, 1
+  csrr t1, mcause
+  slli s3, s3, 4
+  or s3, s3, t1
+  csrr t1, mepc
+  addi t1, t1, 4
+  csrw mepc, t1
+  mret
+shandler:
+  csrr s4, scause
+  csrr s5, sstatus
+  csrr s6, sepc
+  ecall
+  j done
+done:
@@ -0,0 +1,27 @@
+// The subset of riscv-tests env/encoding.h which is used by the tests.
+// see: https://github.com/riscv/riscv-test-env/blob/master/encoding.h
+
+#ifndef RISCV_CSR_ENCODING_H
+#define RISCV_CSR_ENCODING_H
+
+#define MSTATUS_MPP         0x00001800
+
+#define MNSTATUS_NMIE       0x00000008
+
+#define PMP_R     0x01
+#define PMP_W     0x02
+#define PMP_X     0x04
+#define PMP_NAPOT 0x18
+
+#define CSR_MNSTATUS 0x744
+
+#define CAUSE_MISALIGNED_FETCH 0x0
+#define CAUSE_BREAKPOINT 0x3
+#define CAUSE_USER_ECALL 0x8
+#define CAUSE_SUPERVISOR_ECALL 0x9
+#define CAUSE_MACHINE_ECALL 0xb
+#define CAUSE_FETCH_PAGE_FAULT 0xc
+#define CAUSE_LOAD_PAGE_FAULT 0xd
+#define CAUSE_STORE_PAGE_FAULT 0xf
+
+#endif
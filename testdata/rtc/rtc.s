@@ -0,0 +1,15 @@
+# The program reads the time from the Goldfish RTC and waits for the alarm
+# which is set 100ns later. a0 and a1 are the time, and a2 is the time after the alarm.
+main:
+  li t0, 0x101000 # Goldfish RTC
+  lw a0, 0(t0)    # TIME_LOW latches TIME_HIGH.
+  lw a1, 4(t0)    # TIME_HIGH
+  li t1, 1
+  sw t1, 16(t0)   # IRQ_ENABLED
+  addi t2, a0, 100
+  sw a1, 12(t0)   # ALARM_HIGH
+  sw t2, 8(t0)    # ALARM_LOW arms the alarm.
+loop:
+  lw t3, 24(t0)   # ALARM_STATUS
+  bnez t3, loop
+  lw a2, 0(t0)
@@ -0,0 +1,37 @@
+  j start
+  .balign 8
+data:
+  .dword 0
+  .dword 0
+start:
+  la s0, data
+  li a0, -1
+  li a1, 0x7fffffff
+  addiw a2, a1, 1
+  slli a3, a0, 63
+  srai a4, a3, 32
+  srli a5, a3, 32
+  sraiw a6, a2, 4
+  srliw a7, a2, 4
+  subw s2, zero, a1
+  sd a0, 0(s0)
+  lw s3, 0(s0)
+  lwu s4, 0(s0)
+  ld s5, 0(s0)
+  li t0, 7
+  mulw s6, a1, t0
+  mul s7, a1, t0
+  divw s8, a2, a0
+  remuw s9, a0, t0
+  mulhu s10, a0, a0
+  addi t1, s0, 8
+  li t2, 5
+  amoadd.d s11, t2, (t1)
+  amoswap.d t3, a3, (t1)
+  ld t4, 8(s0)
+  lr.d t5, (t1)
+  sc.d t6, a0, (t1)
+  ld ra, 8(s0)
+  fcvt.d.l fa0, a5
+  fcvt.l.d gp, fa0
+  fmv.x.d tp, fa0
@@ -0,0 +1,13 @@
+# The instructions which have already been executed are used as the data area.
+main:
+  auipc x1, 0
+  addi x2, x0, -16
+  sw x2, 0(x1)
+  lw x3, 0(x1)
+  addi x4, x0, 0x7f
+  sb x4, 1(x1)
+  lw x5, 0(x1)
+  sh x0, 2(x1)
+  lw x6, 0(x1)
+  lb x7, 0(x1)
+  lhu x8, 0(x1)
@@ -0,0 +1,60 @@
+# The program enables Sv32 paging and accesses the memory in S-mode.
+#
+# 0x40000000-0x403fffff: read-only megapage which is mapped to 0x80000000
+# 0x80000000-0x803fffff: megapage which is identity-mapped (RWX)
+# 0xc0000000-0xc0000fff: page which is mapped to data (RW, A and D are not set)
+  j main
+
+  .balign 4096
+root:
+  .skip 0x100 * 4
+  .word 0x20000043 # 0x40000000: V | R | A
+  .skip (0x200 - 0x101) * 4
+  .word 0x200000cf # 0x80000000: V | R | W | X | A | D
+  .skip (0x300 - 0x201) * 4
+  .word 0x20000801 # 0xc0000000: pointer to l2
+  .skip (0x400 - 0x301) * 4
+l2:
+  .word 0x20000c07 # 0xc0000000: V | R | W
+  .skip 4092
+data:
+  .word 0x12345678
+  .word 0
+
+main:
+  # Allow S-mode and U-mode to access the whole memory.
+  li t0, -1
+  csrw pmpaddr0, t0
+  li t0, 0x1f # NAPOT | X | W | R
+  csrw pmpcfg0, t0
+  la t0, handler
+  csrw mtvec, t0
+  li t0, 0x80000000 | 0x80001 # Sv32, PPN of root
+  csrw satp, t0
+  li t0, 1 << 12 # MPP = S
+  csrc mstatus, t0
+  la t0, smode
+  csrw mepc, t0
+  mret
+handler:
+  csrr t5, mcause
+  slli s2, s2, 4
+  or s2, s2, t5
+  csrr s3, mtval
+  csrr t5, mepc
+  addi t5, t5, 4
+  csrw mepc, t5
+  mret
+smode:
+  li t0, 0x40003000
+  lw s0, 0(t0)
+  sw s0, 0(t0) # store/AMO page fault
+  li t1, 0xc0000000
+  lw s1, 0(t1) # A is set
+  li t2, 0x9abcdef0
+  sw t2, 4(t1) # D is set
+  lw s4, 4(t0)
+  li t3, 0xc0001000
+  lw s5, 0(t3) # load page fault
+  la t4, l2
+  lw s6, 0(t4)
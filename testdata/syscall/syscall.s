@@ -0,0 +1,12 @@
+# write(1, msg, 6) and exit(3)
+main:
+  li a0, 1
+  la a1, msg
+  li a2, 6
+  li a7, 64
+  ecall
+  li a0, 3
+  li a7, 93
+  ecall
+msg:
+  .ascii "hello\n"
@@ -0,0 +1,27 @@
+# Each exception is handled by the trap handler which records mcause to s1
+# and skips the faulting instruction.
+main:
+  la t0, handler
+  ori t0, t0, 1 # vectored mode. exceptions still jump to the base address.
+  csrw mtvec, t0
+  .word 0 # illegal instruction
+  lw t1, 1(zero)
+  lw t1, 0(zero)
+  sw t1, 0(zero)
+  ecall
+  ebreak
+  la t0, main
+  jalr zero, 2(t0)
+  j done
+handler:
+  addi s0, s0, 1
+  csrr a0, mcause
+  slli s1, s1, 4
+  or s1, s1, a0
+  csrr s2, mtval
+  csrr t2, mepc
+  addi t2, t2, 4
+  csrw mepc, t2
+  mret
+done:
+  csrr s3, mstatus
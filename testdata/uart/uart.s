@@ -0,0 +1,28 @@
+# The program prints the greeting, and then echoes the received bytes until
+# the newline is received. The registers of UART are polled.
+main:
+  la a0, hello
+  li s0, 0x10000000 # UART
+print:
+  lbu t0, 0(a0)
+  beqz t0, echo
+wait_thre:
+  lbu t1, 5(s0) # LSR
+  andi t1, t1, 1 << 5 # THRE
+  beqz t1, wait_thre
+  sb t0, 0(s0) # THR
+  addi a0, a0, 1
+  j print
+echo:
+  lbu t1, 5(s0) # LSR
+  andi t1, t1, 1 << 0 # DR
+  beqz t1, echo
+  lbu t0, 0(s0) # RBR
+  sb t0, 0(s0) # THR
+  li t1, '\n'
+  bne t0, t1, echo
+  j done
+hello:
+  .string "hello, "
+  .balign 4
+done:
@@ -0,0 +1,88 @@
+# The program reads the sector 0 by the virtio block device in slot 0 of virtio-mmio.
+# The first word of the sector is loaded to a0 and the status of the request to a1.
+main:
+  li s0, 0x10001000
+  lw s1, 0x000(s0) # magic
+  lw s2, 0x008(s0) # device ID
+  sw zero, 0x070(s0) # reset
+  li t0, 3 # ACKNOWLEDGE | DRIVER
+  sw t0, 0x070(s0)
+  li t0, 1
+  sw t0, 0x024(s0) # driver features [63:32]
+  sw t0, 0x020(s0) # VIRTIO_F_VERSION_1
+  li t0, 11 # FEATURES_OK
+  sw t0, 0x070(s0)
+  lw s3, 0x070(s0)
+  # queue 0
+  sw zero, 0x030(s0)
+  li t0, 4
+  sw t0, 0x038(s0)
+  la t0, desc
+  sw t0, 0x080(s0)
+  la t0, avail
+  sw t0, 0x090(s0)
+  la t0, used
+  sw t0, 0x0a0(s0)
+  li t0, 1
+  sw t0, 0x044(s0) # ready
+  li t0, 15 # DRIVER_OK
+  sw t0, 0x070(s0)
+  # descriptor 0: the header (VIRTIO_BLK_T_IN, sector 0)
+  la t1, desc
+  la t0, header
+  sw t0, 0(t1)
+  li t0, 16
+  sw t0, 8(t1)
+  li t0, 0x00010001 # next 1, NEXT
+  sw t0, 12(t1)
+  # descriptor 1: the data
+  la t0, data
+  sw t0, 16(t1)
+  li t0, 512
+  sw t0, 24(t1)
+  li t0, 0x00020003 # next 2, WRITE | NEXT
+  sw t0, 28(t1)
+  # descriptor 2: the status
+  la t0, status
+  sw t0, 32(t1)
+  li t0, 1
+  sw t0, 40(t1)
+  li t0, 2 # WRITE
+  sw t0, 44(t1)
+  # avail.ring[0] = 0, avail.idx = 1
+  la t1, avail
+  li t0, 0x00010000
+  sw t0, 0(t1)
+  sw zero, 4(t1)
+  sw zero, 0x050(s0) # notify queue 0
+  la t1, used
+wait:
+  lhu t0, 2(t1)
+  beqz t0, wait
+  la t0, data
+  lw a0, 0(t0)
+  la t0, status
+  lbu a1, 0(t0)
+  lw a2, 0x060(s0) # interrupt status
+  sw a2, 0x064(s0) # interrupt ACK
+  lw a3, 0x060(s0)
+  j done
+
+  .balign 16
+desc:
+  .zero 64
+avail:
+  .zero 16
+  .balign 16
+used:
+  .zero 48
+header:
+  .word 0 # VIRTIO_BLK_T_IN
+  .word 0
+  .dword 0
+data:
+  .zero 512
+status:
+  .byte 0xff
+  .zero 3
+done:
@@ -0,0 +1,113 @@
+package riscv
+
+// TLBStats represents the statistics of the TLB.
+type TLBStats struct {
+	// Hits is the number of the translations which are found in the TLB.
+	Hits uint64
+	// Misses is the number of the translations which walk the page table.
+	Misses uint64
+	// Flushes is the number of SFENCE.VMA instructions which are executed.
+	Flushes uint64
+}
+
+// defaultTLBSize is the number of the TLB entries by default.
+const defaultTLBSize = 256
+
+// tlbEntry caches the translation of a 4 KiB page. Each 4 KiB page in a superpage
+// is cached in the separate entry.
+type tlbEntry struct {
+	valid bool
+	vpn   uint64
+	asid  uint64
+	// ppn is the physical page number of the 4 KiB page.
+	ppn uint64
+	// pte is the leaf page table entry. The permissions are checked on every access
+	// because they depend on the privilege mode, SUM and MXR.
+	pte uint64
+	// superpageBits is the number of VPN bits which are covered by the leaf entry.
+	// It is zero for 4 KiB pages.
+	superpageBits uint
+}
+
+// global reports whether the entry is a global mapping which exists in all address spaces.
+func (e *tlbEntry) global() bool {
+	return e.pte&pteG != 0
+}
+
+// tlb is a direct-mapped translation lookaside buffer. The entries are indexed by
+// VPN and tagged with VPN and ASID. The global mappings match any ASID.
+//
+// see: 4.2.1 Supervisor Memory-Management Fence Instruction
+type tlb struct {
+	entries []tlbEntry
+	stats   TLBStats
+}
+
+// newTLB creates a TLB. size must be a power of two. If size is zero, nothing is cached.
+func newTLB(size int) *tlb {
+	return &tlb{
+		entries: make([]tlbEntry, size),
+	}
+}
+
+// index returns the entry which may hold the translation of vpn.
+func (t *tlb) index(vpn uint64) *tlbEntry {
+	if len(t.entries) == 0 {
+		return nil
+	}
+	return &t.entries[vpn&uint64(len(t.entries)-1)]
+}
+
+// lookup returns the entry which holds the translation of vpn in the address space of asid.
+// It returns nil if the translation is not cached.
+func (t *tlb) lookup(vpn, asid uint64) *tlbEntry {
+	e := t.index(vpn)
+	if e == nil || !e.valid || e.vpn != vpn || e.asid != asid && !e.global() {
+		return nil
+	}
+	return e
+}
+
+// insert caches the translation. The entry which has the same index is evicted.
+func (t *tlb) insert(e tlbEntry) {
+	if slot := t.index(e.vpn); slot != nil {
+		e.valid = true
+		*slot = e
+	}
+}
+
+// flush invalidates the entries which match f.
+func (t *tlb) flush(f func(e *tlbEntry) bool) {
+	t.stats.Flushes++
+	for i := range t.entries {
+		if e := &t.entries[i]; e.valid && f(e) {
+			e.valid = false
+		}
+	}
+}
+
+// sfenceVMA invalidates the cached translations as SFENCE.VMA does.
+//
+// If rs1 is not x0, only the translations of the page which contains the virtual
+// address in rs1 are invalidated. If rs2 is not x0, only the translations of the
+// address space which is identified by ASID in rs2 are invalidated, and the global
+// mappings are kept.
+//
+// see: 4.2.1 Supervisor Memory-Management Fence Instruction
+func (c *CPU) sfenceVMA(rs1, rs2 uint32) {
+	vpn := c.xregs[rs1] >> pageShift
+	asid := c.xregs[rs2] & satp64ASID
+	if c.xlen == XLEN32 {
+		vpn = c.truncate(c.xregs[rs1]) >> pageShift
+		asid = c.xregs[rs2] & satp32ASID
+	}
+	c.tlb.flush(func(e *tlbEntry) bool {
+		if rs1 != 0 && e.vpn>>e.superpageBits != vpn>>e.superpageBits {
+			return false
+		}
+		if rs2 != 0 && (e.global() || e.asid != asid) {
+			return false
+		}
+		return true
+	})
+}
@@ -0,0 +1,168 @@
+package riscv
+
+import (
+	"encoding/binary"
+	"testing"
+)
+
+// countingMemory is the memory device which counts the reads. e.g. the walks of
+// the page table on it.
+type countingMemory struct {
+	startAddr uint64
+	mem       []byte
+	reads     int
+}
+
+func (m *countingMemory) StartAddr() uint64 { return m.startAddr }
+func (m *countingMemory) EndAddr() uint64   { return m.startAddr + uint64(len(m.mem)) }
+
+func (m *countingMemory) Read(addr, size uint64) uint64 {
+	m.reads++
+	var b [8]byte
+	copy(b[:size], m.mem[addr:])
+	return binary.LittleEndian.Uint64(b[:])
+}
+
+func (m *countingMemory) Write(addr, size, value uint64) {
+	var b [8]byte
+	binary.LittleEndian.PutUint64(b[:], value)
+	copy(m.mem[addr:addr+size], b[:size])
+}
+
+func TestTLB(t *testing.T) {
+	const (
+		root  = dramStartAddress
+		vaddr = 0x00400123 // VPN[1] = 1
+		asid  = 3
+	)
+	// newCPU returns the CPU in S-mode which maps 0x00400000-0x007fffff to 0x90000000
+	// by the megapage. The page is global if global is set.
+	newCPU := func(t *testing.T, global bool, opts ...Option) *CPU {
+		t.Helper()
+		cpu := NewCPU(make([]byte, pageSize), opts...)
+		permitAll(t, cpu)
+		cpu.mode = SupervisorMode
+		if err := cpu.csrs.Write(CSRSatp, 1<<31|asid<<satp32ASIDShift|root>>pageShift); err != nil {
+			t.Fatal(err)
+		}
+		pte := uint64(0x90000<<ptePPNShift | pteV | pteR | pteW | pteA | pteD)
+		if global {
+			pte |= pteG
+		}
+		mustWrite(t, cpu.bus, root+1*4, 4, pte)
+		return cpu
+	}
+	// remap changes the mapping to 0xa0000000 without flushing the TLB.
+	remap := func(t *testing.T, cpu *CPU) {
+		t.Helper()
+		mustWrite(t, cpu.bus, root+1*4, 4, 0xa0000<<ptePPNShift|pteV|pteR|pteW|pteA|pteD)
+	}
+	translate := func(t *testing.T, cpu *CPU) uint64 {
+		t.Helper()
+		paddr, err := cpu.translate(vaddr, accessLoad)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return paddr
+	}
+
+	t.Run("hit", func(t *testing.T) {
+		cpu := newCPU(t, false)
+		translate(t, cpu)
+		// The other page in the same megapage is cached separately.
+		if _, err := cpu.translate(vaddr+pageSize, accessLoad); err != nil {
+			t.Fatal(err)
+		}
+		remap(t, cpu)
+		if got := translate(t, cpu); got != 0x90000123 {
+			t.Errorf("want the cached translation but got 0x%x", got)
+		}
+		want := TLBStats{Hits: 1, Misses: 2}
+		if got := cpu.TLBStats(); got != want {
+			t.Errorf("want %+v but got %+v", want, got)
+		}
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		cpu := newCPU(t, false, WithTLBSize(0))
+		translate(t, cpu)
+		remap(t, cpu)
+		if got := translate(t, cpu); got != 0xa0000123 {
+			t.Errorf("want the new translation but got 0x%x", got)
+		}
+		want := TLBStats{Misses: 2}
+		if got := cpu.TLBStats(); got != want {
+			t.Errorf("want %+v but got %+v", want, got)
+		}
+	})
+
+	t.Run("instruction fetch", func(t *testing.T) {
+		// The page table is on the device to count the walks.
+		const table = 0x30000000
+		pt := &countingMemory{startAddr: table, mem: make([]byte, pageSize)}
+		cpu := NewCPU([]byte{0x13, 0, 0, 0}, WithDevices(pt)) // nop
+		permitAll(t, cpu)
+		cpu.mode = SupervisorMode
+		mustWriteCSR(t, cpu, CSRSatp, 1<<31|table>>pageShift)
+		// 0x00400000-0x007fffff is mapped to the DRAM by the megapage.
+		mustWrite(t, cpu.bus, table+1*4, 4, dramStartAddress>>pageShift<<ptePPNShift|pteV|pteX|pteA)
+		cpu.nextpc = 0x00400000
+		cpu.Next()
+		if _, err := cpu.Fetch(); err != nil {
+			t.Fatal(err)
+		}
+		if pt.reads != 1 {
+			t.Errorf("want the page table is walked once but got %d reads", pt.reads)
+		}
+		// The upper parcel of the instruction is translated by the TLB.
+		want := TLBStats{Hits: 1, Misses: 1}
+		if got := cpu.TLBStats(); got != want {
+			t.Errorf("want %+v but got %+v", want, got)
+		}
+	})
+
+	cases := []struct {
+		name   string
+		global bool
+		// rs1 and rs2 are the values of the registers of SFENCE.VMA. zero means x0.
+		rs1, rs2 uint64
+		want     uint64
+	}{
+		{name: "all", rs1: 0, rs2: 0, want: 0xa0000123},
+		{name: "all global", global: true, rs1: 0, rs2: 0, want: 0xa0000123},
+		{name: "address", rs1: vaddr, want: 0xa0000123},
+		{name: "address in the same megapage", rs1: 0x007ff000, want: 0xa0000123},
+		{name: "other address", rs1: 0x00800000, want: 0x90000123},
+		{name: "asid", rs2: asid, want: 0xa0000123},
+		{name: "other asid", rs2: asid + 1, want: 0x90000123},
+		{name: "asid global", global: true, rs2: asid, want: 0x90000123},
+		{name: "address and asid", rs1: vaddr, rs2: asid, want: 0xa0000123},
+		{name: "address and other asid", rs1: vaddr, rs2: asid + 1, want: 0x90000123},
+	}
+	for _, tc := range cases {
+		t.Run("sfence.vma "+tc.name, func(t *testing.T) {
+			cpu := newCPU(t, tc.global)
+			translate(t, cpu)
+			remap(t, cpu)
+
+			var rs1, rs2 uint32
+			if tc.rs1 != 0 {
+				rs1, cpu.xregs[10] = 10, tc.rs1
+			}
+			if tc.rs2 != 0 {
+				rs2, cpu.xregs[11] = 11, tc.rs2
+			}
+			// sfence.vma rs1, rs2
+			inst := 0b0001001<<25 | rs2<<20 | rs1<<15 | OPSYSTEM
+			if err := cpu.Execute(cpu.Decode(inst)); err != nil {
+				t.Fatal(err)
+			}
+			if got := translate(t, cpu); got != tc.want {
+				t.Errorf("want 0x%x but got 0x%x", tc.want, got)
+			}
+			if got := cpu.TLBStats().Flushes; got != 1 {
+				t.Errorf("want 1 flush but got %d", got)
+			}
+		})
+	}
+}
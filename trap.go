@@ -0,0 +1,217 @@
+package riscv
+
+// trap transfers the control to the trap handler.
+//
+// The trap is taken in M-mode by default. If the trap is raised in S-mode or U-mode
+// and it is delegated by medeleg or mideleg, it is taken in S-mode instead.
+// The traps which are raised in M-mode are never delegated.
+//
+// see: 3.1.8 Machine Trap Delegation Registers (medeleg and mideleg)
+func (c *CPU) trap(cause uint32, interrupt bool, tval uint64) {
+	c.debugf("trap: cause=%d, interrupt=%t, tval=0x%08x, mode=%s", cause, interrupt, tval, c.mode)
+
+	deleg := c.csrs.Get(CSRMedeleg)
+	if interrupt {
+		deleg = c.csrs.Get(CSRMideleg)
+	}
+	if c.mode != MachineMode && deleg>>cause&1 != 0 {
+		c.trapToSupervisor(cause, interrupt, tval)
+	} else {
+		c.trapToMachine(cause, interrupt, tval)
+	}
+	c.trapped = true
+}
+
+// trapToMachine transfers the control to the trap handler in M-mode.
+//
+// The address of the instruction which is interrupted or raised the exception is
+// written to mepc, the cause is written to mcause and the exception-specific
+// information is written to mtval. Then the global interrupt-enable and the
+// previous privilege mode are pushed to the stack in mstatus.
+//
+// see: 3.1.6.1 Privilege and Global Interrupt-Enable Stack in mstatus register
+// see: 3.1.7 Machine Trap-Vector Base-Address Register (mtvec)
+func (c *CPU) trapToMachine(cause uint32, interrupt bool, tval uint64) {
+	c.csrs.Set(CSRMepc, c.pc)
+	c.csrs.Set(CSRMcause, c.trapCause(cause, interrupt))
+	c.csrs.Set(CSRMtval, tval)
+
+	mstatus := c.csrs.Get(CSRMstatus)
+	// MPIE = MIE, MIE = 0, MPP = the previous mode
+	mpie := uint64(0)
+	if mstatus&mstatusMIE != 0 {
+		mpie = mstatusMPIE
+	}
+	mpp := uint64(c.mode) << mstatusMPPShift
+	mstatus = mstatus&^(mstatusMIE|mstatusMPIE|mstatusMPP) | mpie | mpp
+	c.csrs.Set(CSRMstatus, mstatus)
+
+	c.mode = MachineMode
+	c.nextpc = trapVector(c.csrs.Get(CSRMtvec), cause, interrupt)
+}
+
+// trapToSupervisor transfers the control to the trap handler in S-mode.
+// It is same as trapToMachine except that sepc, scause, stval, stvec and
+// the fields for S-mode in mstatus are used.
+//
+// see: 4.1.1 Supervisor Status Register (sstatus)
+// see: 4.1.2 Supervisor Trap Vector Base Address Register (stvec)
+func (c *CPU) trapToSupervisor(cause uint32, interrupt bool, tval uint64) {
+	c.csrs.Set(CSRSepc, c.pc)
+	c.csrs.Set(CSRScause, c.trapCause(cause, interrupt))
+	c.csrs.Set(CSRStval, tval)
+
+	mstatus := c.csrs.Get(CSRMstatus)
+	// SPIE = SIE, SIE = 0, SPP = the previous mode (0: U-mode, 1: S-mode)
+	spie := uint64(0)
+	if mstatus&mstatusSIE != 0 {
+		spie = mstatusSPIE
+	}
+	spp := uint64(0)
+	if c.mode == SupervisorMode {
+		spp = mstatusSPP
+	}
+	mstatus = mstatus&^(mstatusSIE|mstatusSPIE|mstatusSPP) | spie | spp
+	c.csrs.Set(CSRMstatus, mstatus)
+
+	c.mode = SupervisorMode
+	c.nextpc = trapVector(c.csrs.Get(CSRStvec), cause, interrupt)
+}
+
+// trapCause returns the value of mcause or scause.
+// The interrupt bit is the most significant bit.
+func (c *CPU) trapCause(cause uint32, interrupt bool) uint64 {
+	v := uint64(cause)
+	if interrupt {
+		v |= 1 << (c.xlen - 1)
+	}
+	return v
+}
+
+// trapVector returns the address of the trap handler which is specified by mtvec or stvec.
+func trapVector(tvec uint64, cause uint32, interrupt bool) uint64 {
+	base := tvec &^ mtvecModeMask
+	if interrupt && tvec&mtvecModeMask == mtvecModeVectored {
+		// Asynchronous interrupts set pc to BASE+4×cause.
+		return base + 4*uint64(cause)
+	}
+	return base
+}
+
+// mret returns from the trap handler in M-mode.
+//
+// MIE is set to MPIE, MPIE is set to 1, the privilege mode is set to MPP and
+// MPP is set to the least-privileged supported mode. If MPP is not M-mode, MPRV is cleared.
+// Then pc is set to mepc.
+//
+// see: 3.3.2 Trap-Return Instructions
+func (c *CPU) mret() {
+	mstatus := c.csrs.Get(CSRMstatus)
+	mie := uint64(0)
+	if mstatus&mstatusMPIE != 0 {
+		mie = mstatusMIE
+	}
+	mode := PrivilegeMode(mstatus & mstatusMPP >> mstatusMPPShift)
+	mpp := uint64(c.ext.leastPrivilegedMode()) << mstatusMPPShift
+	mstatus = mstatus&^(mstatusMIE|mstatusMPP) | mie | mstatusMPIE | mpp
+	if mode != MachineMode {
+		mstatus &^= mstatusMPRV
+	}
+	c.csrs.Set(CSRMstatus, mstatus)
+	c.mode = mode
+	c.nextpc = c.csrs.Get(CSRMepc)
+}
+
+// sret returns from the trap handler in S-mode.
+//
+// SIE is set to SPIE, SPIE is set to 1, the privilege mode is set to SPP and
+// SPP is set to U-mode. MPRV is cleared because the new mode is never M-mode.
+// Then pc is set to sepc.
+//
+// see: 3.3.2 Trap-Return Instructions
+func (c *CPU) sret() {
+	mstatus := c.csrs.Get(CSRMstatus)
+	sie := uint64(0)
+	if mstatus&mstatusSPIE != 0 {
+		sie = mstatusSIE
+	}
+	mode := UserMode
+	if mstatus&mstatusSPP != 0 {
+		mode = SupervisorMode
+	}
+	mstatus = mstatus&^(mstatusSIE|mstatusSPP|mstatusMPRV) | sie | mstatusSPIE
+	c.csrs.Set(CSRMstatus, mstatus)
+	c.mode = mode
+	c.nextpc = c.csrs.Get(CSRSepc)
+}
+
+// interruptPriority is the order in which the simultaneous interrupts are taken.
+//
+// see: 3.1.9 Machine Interrupt Registers (mip and mie)
+var interruptPriority = []InterruptCode{
+	MachineExternalInterrupt,
+	MachineSoftwareInterrupt,
+	MachineTimerInterrupt,
+	SupervisorExternalInterrupt,
+	SupervisorSoftwareInterrupt,
+	SupervisorTimerInterrupt,
+}
+
+// sampleInterrupts reflects the interrupts which are asserted by the sources to mip.
+// MSIP, MTIP and MEIP are driven only by the sources. SEIP is also writable by
+// M-mode software, so the value written by the software is kept.
+func (c *CPU) sampleInterrupts() {
+	if len(c.sources) == 0 {
+		return
+	}
+	var asserted uint64
+	for _, s := range c.sources {
+		asserted |= s.PendingInterrupts()
+	}
+	driven := uint64(mipMSIP | mipMTIP | mipMEIP)
+	if c.has(ExtensionS) {
+		driven |= mipSEIP
+	}
+	mip := c.csrs.Get(CSRMip)&^driven | asserted&driven | c.seip
+	c.csrs.Set(CSRMip, mip)
+}
+
+// pendingInterrupt returns the interrupt which should be taken before the next instruction.
+//
+// An interrupt is taken if it is pending in mip and enabled in mie, and the global
+// interrupt-enable for the privilege mode which handles it allows the interrupt.
+// The interrupts for M-mode are globally enabled if the current mode is less
+// privileged than M-mode, or it is M-mode and MIE is set. The interrupts which are
+// delegated to S-mode are never taken in M-mode, and they are globally enabled if the
+// current mode is U-mode, or it is S-mode and SIE is set.
+//
+// see: 3.1.6.1 Privilege and Global Interrupt-Enable Stack in mstatus register
+// see: 3.1.9 Machine Interrupt Registers (mip and mie)
+func (c *CPU) pendingInterrupt() (InterruptCode, bool) {
+	c.sampleInterrupts()
+	pending := c.csrs.Get(CSRMip) & c.csrs.Get(CSRMie)
+	if pending == 0 {
+		return 0, false
+	}
+	mstatus := c.csrs.Get(CSRMstatus)
+	deleg := c.csrs.Get(CSRMideleg)
+	var enabled uint64
+	if c.mode < MachineMode || mstatus&mstatusMIE != 0 {
+		enabled |= pending &^ deleg
+	}
+	if c.mode < SupervisorMode || c.mode == SupervisorMode && mstatus&mstatusSIE != 0 {
+		enabled |= pending & deleg
+	}
+	if enabled == 0 {
+		return 0, false
+	}
+	// The interrupts for M-mode take precedence over the interrupts for S-mode.
+	for _, mask := range []uint64{enabled &^ deleg, enabled & deleg} {
+		for _, code := range interruptPriority {
+			if mask>>code&1 != 0 {
+				return code, true
+			}
+		}
+	}
+	return 0, false
+}
@@ -0,0 +1,214 @@
+package riscv
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestTrap(t *testing.T) {
+	code, err := os.ReadFile(filepath.Join("testdata", "trap", "trap.bin"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// "C" extension is disabled because the program jumps to the address
+	// which is aligned on a two-byte boundary to raise the exception.
+	cpu := NewCPU(code, WithExtensions(ExtensionM|ExtensionA|ExtensionF|ExtensionD))
+	if _, err := cpu.Run(); err != nil {
+		t.Fatal(err)
+	}
+	const (
+		s0 = 8
+		s1 = 9
+		s2 = 18
+		s3 = 19
+	)
+	if got := cpu.xregs[s0]; got != 7 {
+		t.Errorf("want 7 traps but got %d", got)
+	}
+	// illegal instruction, load address misaligned, load access fault, store/AMO access fault,
+	// environment call from M-mode, breakpoint, instruction address misaligned.
+	if got := cpu.xregs[s1]; got != 0x2457b30 {
+		t.Errorf("want mcause history 0x2457b30 but got 0x%x", got)
+	}
+	if got := cpu.xregs[s2]; got != dramStartAddress+2 {
+		t.Errorf("want mtval 0x%08x but got 0x%08x", dramStartAddress+2, got)
+	}
+	if want := uint64(mstatusMPIE | mstatusMPP | fsInitial); cpu.xregs[s3] != want {
+		t.Errorf("want mstatus 0x%x but got 0x%x", want, cpu.xregs[s3])
+	}
+}
+
+func TestTrapWithoutHandler(t *testing.T) {
+	// illegal instruction. mtvec is 0 where no device is mapped.
+	cpu := NewCPU(make([]byte, 4))
+	_, err := cpu.Run()
+	var e *Exception
+	if !errors.As(err, &e) {
+		t.Fatalf("want *Exception but got %v", err)
+	}
+	if e.Code != InstructionAccessFault || e.Value != 0 {
+		t.Errorf("unexpected exception: %v", e)
+	}
+	if got := cpu.csrs.Get(CSRMcause); got != uint64(IllegalInstruction) {
+		t.Errorf("want mcause %d but got %d", IllegalInstruction, got)
+	}
+	if got := cpu.csrs.Get(CSRMepc); got != dramStartAddress {
+		t.Errorf("want mepc 0x%08x but got 0x%08x", dramStartAddress, got)
+	}
+}
+
+func TestTrapReturn(t *testing.T) {
+	const (
+		sret = 0x10200073
+		mret = 0x30200073
+		wfi  = 0x10500073
+	)
+	cases := []struct {
+		name     string
+		mode     PrivilegeMode
+		mstatus  uint64
+		rawInst  uint32
+		wantMode PrivilegeMode
+		wantErr  bool
+	}{
+		{name: "mret in M-mode", mode: MachineMode, mstatus: uint64(SupervisorMode) << mstatusMPPShift, rawInst: mret, wantMode: SupervisorMode},
+		{name: "mret in S-mode", mode: SupervisorMode, rawInst: mret, wantErr: true},
+		{name: "sret in S-mode", mode: SupervisorMode, rawInst: sret, wantMode: UserMode},
+		{name: "sret in M-mode", mode: MachineMode, mstatus: mstatusSPP, rawInst: sret, wantMode: SupervisorMode},
+		{name: "sret in U-mode", mode: UserMode, rawInst: sret, wantErr: true},
+		{name: "sret in S-mode with TSR", mode: SupervisorMode, mstatus: mstatusTSR, rawInst: sret, wantErr: true},
+		{name: "wfi in S-mode", mode: SupervisorMode, rawInst: wfi, wantMode: SupervisorMode},
+		{name: "wfi in S-mode with TW", mode: SupervisorMode, mstatus: mstatusTW, rawInst: wfi, wantErr: true},
+		{name: "wfi in U-mode", mode: UserMode, rawInst: wfi, wantErr: true},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			cpu := NewCPU(make([]byte, 4))
+			cpu.mode = tc.mode
+			cpu.csrs.Set(CSRMstatus, tc.mstatus)
+			err := cpu.Execute(cpu.Decode(tc.rawInst))
+			if tc.wantErr {
+				var e *Exception
+				if !errors.As(err, &e) || e.Code != IllegalInstruction {
+					t.Fatalf("want illegal instruction but got %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if cpu.mode != tc.wantMode {
+				t.Errorf("want %s but got %s", tc.wantMode, cpu.mode)
+			}
+		})
+	}
+}
+
+// interruptSource is an InterruptSource which asserts the fixed interrupts.
+type interruptSource struct {
+	startAddr uint64
+	pending   uint64
+}
+
+func (s *interruptSource) StartAddr() uint64              { return s.startAddr }
+func (s *interruptSource) EndAddr() uint64                { return s.startAddr + 4 }
+func (s *interruptSource) Read(addr, size uint64) uint64  { return 0 }
+func (s *interruptSource) Write(addr, size, value uint64) {}
+func (s *interruptSource) PendingInterrupts() uint64      { return s.pending }
+
+func TestPendingInterrupt(t *testing.T) {
+	const (
+		mei = 1 << MachineExternalInterrupt
+		msi = 1 << MachineSoftwareInterrupt
+		mti = 1 << MachineTimerInterrupt
+		sei = 1 << SupervisorExternalInterrupt
+		ssi = 1 << SupervisorSoftwareInterrupt
+	)
+	cases := []struct {
+		name     string
+		mode     PrivilegeMode
+		mstatus  uint64
+		mie      uint64
+		mideleg  uint64
+		asserted uint64
+		mip      uint64 // written by the software
+		want     InterruptCode
+		wantOK   bool
+	}{
+		{name: "M-mode with MIE", mode: MachineMode, mstatus: mstatusMIE, mie: mti, asserted: mti, want: MachineTimerInterrupt, wantOK: true},
+		{name: "M-mode without MIE", mode: MachineMode, mie: mti, asserted: mti},
+		{name: "not enabled in mie", mode: MachineMode, mstatus: mstatusMIE, mie: msi, asserted: mti},
+		{name: "S-mode without MIE", mode: SupervisorMode, mie: mti, asserted: mti, want: MachineTimerInterrupt, wantOK: true},
+		{name: "priority", mode: MachineMode, mstatus: mstatusMIE, mie: mei | msi | mti, asserted: msi | mti | mei, want: MachineExternalInterrupt, wantOK: true},
+		{name: "software before timer", mode: MachineMode, mstatus: mstatusMIE, mie: msi | mti, asserted: msi | mti, want: MachineSoftwareInterrupt, wantOK: true},
+		{name: "delegated in M-mode", mode: MachineMode, mstatus: mstatusMIE | mstatusSIE, mie: sei, mideleg: sei, asserted: sei},
+		{name: "delegated in S-mode with SIE", mode: SupervisorMode, mstatus: mstatusSIE, mie: sei, mideleg: sei, asserted: sei, want: SupervisorExternalInterrupt, wantOK: true},
+		{name: "delegated in S-mode without SIE", mode: SupervisorMode, mie: sei, mideleg: sei, asserted: sei},
+		{name: "delegated in U-mode", mode: UserMode, mie: sei, mideleg: sei, asserted: sei, want: SupervisorExternalInterrupt, wantOK: true},
+		{name: "M-mode interrupt before delegated one", mode: UserMode, mie: sei | mti, mideleg: sei, asserted: sei | mti, want: MachineTimerInterrupt, wantOK: true},
+		{name: "SEIP written by software", mode: UserMode, mie: sei, mip: sei, want: SupervisorExternalInterrupt, wantOK: true},
+		{name: "SSIP written by software", mode: MachineMode, mstatus: mstatusMIE, mie: ssi, mip: ssi, want: SupervisorSoftwareInterrupt, wantOK: true},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			cpu := NewCPU(make([]byte, 4), WithDevices(&interruptSource{pending: tc.asserted}))
+			cpu.mode = tc.mode
+			cpu.csrs.Set(CSRMstatus, tc.mstatus)
+			cpu.csrs.Set(CSRMie, tc.mie)
+			cpu.csrs.Set(CSRMideleg, tc.mideleg)
+			if err := cpu.csrs.Write(CSRMip, tc.mip); err != nil {
+				t.Fatal(err)
+			}
+			got, ok := cpu.pendingInterrupt()
+			if ok != tc.wantOK || got != tc.want {
+				t.Errorf("want (%v, %t) but got (%v, %t)", tc.want, tc.wantOK, got, ok)
+			}
+		})
+	}
+}
+
+func TestInterruptTrap(t *testing.T) {
+	// The interrupt is taken before the first instruction.
+	cpu := NewCPU(make([]byte, 4), WithDevices(&interruptSource{pending: 1 << MachineTimerInterrupt}))
+	cpu.csrs.Set(CSRMstatus, mstatusMIE)
+	cpu.csrs.Set(CSRMie, 1<<MachineTimerInterrupt)
+	cpu.csrs.Set(CSRMtvec, dramStartAddress+4|mtvecModeVectored)
+	cpu.Next()
+	if code, ok := cpu.pendingInterrupt(); ok {
+		cpu.trap(uint32(code), true, 0)
+	}
+	if got, want := cpu.csrs.Get(CSRMcause), uint64(1<<31|MachineTimerInterrupt); got != want {
+		t.Errorf("want mcause 0x%x but got 0x%x", want, got)
+	}
+	if got := cpu.csrs.Get(CSRMepc); got != dramStartAddress {
+		t.Errorf("want mepc 0x%x but got 0x%x", dramStartAddress, got)
+	}
+	// Vectored mode: BASE + 4 * cause.
+	if got, want := cpu.nextpc, uint64(dramStartAddress+4+4*MachineTimerInterrupt); got != want {
+		t.Errorf("want pc 0x%x but got 0x%x", want, got)
+	}
+	if got := cpu.csrs.Get(CSRMstatus); got&mstatusMIE != 0 || got&mstatusMPIE == 0 {
+		t.Errorf("want MIE is cleared and MPIE is set but got mstatus 0x%x", got)
+	}
+}
+
+func TestSEIPReadModifyWrite(t *testing.T) {
+	source := &interruptSource{pending: 1 << SupervisorExternalInterrupt}
+	cpu := NewCPU(make([]byte, 4), WithDevices(source))
+	cpu.sampleInterrupts()
+	cpu.xregs[5] = mipSTIP
+	// csrc mip, t0
+	if err := cpu.Execute(cpu.Decode(0x3442b073)); err != nil {
+		t.Fatal(err)
+	}
+	if cpu.seip != 0 {
+		t.Errorf("want SEIP asserted by the source is not latched but got 0x%x", cpu.seip)
+	}
+	source.pending = 0
+	cpu.sampleInterrupts()
+	if got := cpu.csrs.Get(CSRMip); got&mipSEIP != 0 {
+		t.Errorf("want SEIP is cleared after deasserted but got mip 0x%x", got)
+	}
+}
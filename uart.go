@@ -0,0 +1,249 @@
+package riscv
+
+import (
+	"io"
+	"sync"
+)
+
+// UART is NS16550A compatible universal asynchronous receiver-transmitter.
+// The received bytes are read from io.Reader and the transmitted bytes are
+// written to io.Writer. The registers are 1 byte wide and packed. (reg-shift is 0)
+//
+// The interrupt line is asserted while IIR reports the pending interrupt.
+// On QEMU virt machine, UART0 is connected to the source 10 of PLIC.
+//
+// see: http://caro.su/msx/ocm_de1/16550.pdf
+type UART struct {
+	mu   sync.Mutex
+	cond *sync.Cond
+	w    io.Writer
+	irq  InterruptLine
+
+	// rx is the receiver FIFO.
+	rx  []byte
+	ier uint8
+	lcr uint8
+	mcr uint8
+	scr uint8
+	dll uint8
+	dlm uint8
+	// fifo is set if the FIFOs are enabled by FCR.
+	fifo bool
+	// thrInterrupt is set when THR becomes empty and cleared when IIR reporting it is read.
+	thrInterrupt bool
+}
+
+var _ Device = (*UART)(nil)
+
+const (
+	uartStartAddress = 0x10000000
+	uartSize         = 0x100
+	// uartFIFOSize is the number of the bytes which can be held by the receiver FIFO.
+	uartFIFOSize = 16
+
+	// The offsets of the registers.
+	uartRBR = 0 // Receiver Buffer Register (read, DLAB=0)
+	uartTHR = 0 // Transmitter Holding Register (write, DLAB=0)
+	uartDLL = 0 // Divisor Latch LSB (DLAB=1)
+	uartIER = 1 // Interrupt Enable Register (DLAB=0)
+	uartDLM = 1 // Divisor Latch MSB (DLAB=1)
+	uartIIR = 2 // Interrupt Identification Register (read)
+	uartFCR = 2 // FIFO Control Register (write)
+	uartLCR = 3 // Line Control Register
+	uartMCR = 4 // Modem Control Register
+	uartLSR = 5 // Line Status Register
+	uartMSR = 6 // Modem Status Register
+	uartSCR = 7 // Scratch Register
+
+	uartIERRxData = 1 << 0 // Enable Received Data Available Interrupt
+	uartIERTHRE   = 1 << 1 // Enable Transmitter Holding Register Empty Interrupt
+	uartIERMask   = 0x0f
+
+	uartIIRNone   = 0x01 // No interrupt is pending.
+	uartIIRTHRE   = 0x02 // Transmitter holding register empty
+	uartIIRRxData = 0x04 // Received data available
+	uartIIRFIFO   = 0xc0 // FIFOs are enabled.
+
+	uartFCREnable  = 1 << 0
+	uartFCRClearRx = 1 << 1
+
+	uartLCRDLAB = 1 << 7
+
+	uartMCRLoop = 1 << 4
+	uartMCRMask = 0x1f
+
+	uartLSRDataReady = 1 << 0
+	uartLSRTHRE      = 1 << 5 // Transmitter holding register empty
+	uartLSRTEMT      = 1 << 6 // Transmitter empty
+
+	// The lines are always connected unless it is in the loopback mode.
+	uartMSRCTS = 1 << 4
+	uartMSRDSR = 1 << 5
+	uartMSRDCD = 1 << 7
+)
+
+// NewUART creates UART. The bytes which are read from r are received by the
+// goroutine until r returns an error. r may be nil if nothing is received.
+// irq may be nil if the interrupt line is not connected.
+func NewUART(r io.Reader, w io.Writer, irq InterruptLine) *UART {
+	u := &UART{
+		w:            w,
+		irq:          irq,
+		thrInterrupt: true,
+	}
+	u.cond = sync.NewCond(&u.mu)
+	if r != nil {
+		go u.receive(r)
+	}
+	return u
+}
+
+// StartAddr represents start address for UART.
+func (u *UART) StartAddr() uint64 { return uartStartAddress }
+
+// EndAddr represents end of address for UART.
+func (u *UART) EndAddr() uint64 { return uartStartAddress + uartSize }
+
+// receive reads the bytes from r and pushes them to the receiver FIFO.
+// It waits while the FIFO is full, so the bytes are never dropped.
+func (u *UART) receive(r io.Reader) {
+	buf := make([]byte, uartFIFOSize)
+	for {
+		n, err := r.Read(buf)
+		for _, b := range buf[:n] {
+			u.mu.Lock()
+			for len(u.rx) >= uartFIFOSize {
+				u.cond.Wait()
+			}
+			u.rx = append(u.rx, b)
+			u.updateIRQ()
+			u.mu.Unlock()
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// Read reads the register. Only the lower byte is used for the wider accesses.
+func (u *UART) Read(addr, size uint64) uint64 {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	dlab := u.lcr&uartLCRDLAB != 0
+	switch addr {
+	case uartRBR:
+		if dlab {
+			return uint64(u.dll)
+		}
+		if len(u.rx) == 0 {
+			return 0
+		}
+		b := u.rx[0]
+		u.rx = u.rx[1:]
+		u.cond.Signal()
+		u.updateIRQ()
+		return uint64(b)
+	case uartIER:
+		if dlab {
+			return uint64(u.dlm)
+		}
+		return uint64(u.ier)
+	case uartIIR:
+		iir := u.iir()
+		// Reading IIR clears the transmitter holding register empty interrupt.
+		if iir&0x0f == uartIIRTHRE {
+			u.thrInterrupt = false
+			u.updateIRQ()
+		}
+		return uint64(iir)
+	case uartLCR:
+		return uint64(u.lcr)
+	case uartMCR:
+		return uint64(u.mcr)
+	case uartLSR:
+		lsr := uint8(uartLSRTHRE | uartLSRTEMT)
+		if len(u.rx) > 0 {
+			lsr |= uartLSRDataReady
+		}
+		return uint64(lsr)
+	case uartMSR:
+		if u.mcr&uartMCRLoop != 0 {
+			// DTR, RTS, OUT1 and OUT2 are looped back to DSR, CTS, RI and DCD.
+			mcr := u.mcr
+			return uint64(mcr&0b0001<<5 | mcr&0b0010<<3 | mcr&0b0100<<4 | mcr&0b1000<<4)
+		}
+		return uartMSRCTS | uartMSRDSR | uartMSRDCD
+	case uartSCR:
+		return uint64(u.scr)
+	}
+	return 0
+}
+
+// Write writes the register. Only the lower byte is used for the wider accesses.
+// The transmitted byte is written to io.Writer immediately, so the transmitter is always empty.
+func (u *UART) Write(addr, size, value uint64) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	v := uint8(value)
+	dlab := u.lcr&uartLCRDLAB != 0
+	switch addr {
+	case uartTHR:
+		if dlab {
+			u.dll = v
+			return
+		}
+		if u.mcr&uartMCRLoop != 0 {
+			if len(u.rx) < uartFIFOSize {
+				u.rx = append(u.rx, v)
+			}
+		} else if u.w != nil {
+			_, _ = u.w.Write([]byte{v})
+		}
+		u.thrInterrupt = true
+	case uartIER:
+		if dlab {
+			u.dlm = v
+			return
+		}
+		// Enabling the interrupt when THR is empty raises the interrupt immediately.
+		if u.ier&uartIERTHRE == 0 && v&uartIERTHRE != 0 {
+			u.thrInterrupt = true
+		}
+		u.ier = v & uartIERMask
+	case uartFCR:
+		u.fifo = v&uartFCREnable != 0
+		if v&uartFCRClearRx != 0 {
+			u.rx = u.rx[:0]
+			u.cond.Signal()
+		}
+	case uartLCR:
+		u.lcr = v
+	case uartMCR:
+		u.mcr = v & uartMCRMask
+	case uartSCR:
+		u.scr = v
+	}
+	u.updateIRQ()
+}
+
+// iir returns the value of IIR which identifies the highest priority pending interrupt.
+func (u *UART) iir() uint8 {
+	iir := uint8(uartIIRNone)
+	switch {
+	case u.ier&uartIERRxData != 0 && len(u.rx) > 0:
+		iir = uartIIRRxData
+	case u.ier&uartIERTHRE != 0 && u.thrInterrupt:
+		iir = uartIIRTHRE
+	}
+	if u.fifo {
+		iir |= uartIIRFIFO
+	}
+	return iir
+}
+
+// updateIRQ asserts the interrupt line while any interrupt is pending.
+func (u *UART) updateIRQ() {
+	if u.irq != nil {
+		u.irq.SetLevel(u.iir()&uartIIRNone == 0)
+	}
+}
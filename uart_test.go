@@ -0,0 +1,140 @@
+package riscv
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// interruptLine is an InterruptLine which records the level.
+type interruptLine struct {
+	mu    sync.Mutex
+	level bool
+}
+
+func (l *interruptLine) SetLevel(level bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.level = level
+}
+
+func (l *interruptLine) Level() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.level
+}
+
+func TestUARTProgram(t *testing.T) {
+	code, err := os.ReadFile(filepath.Join("testdata", "uart", "uart.bin"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	cpu := NewCPU(code, WithDevices(NewUART(strings.NewReader("world\n"), &out, nil)))
+	if _, err := cpu.Run(); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := out.String(), "hello, world\n"; got != want {
+		t.Errorf("want %q but got %q", want, got)
+	}
+}
+
+func TestUART(t *testing.T) {
+	const (
+		rbr = uartStartAddress + uartRBR
+		ier = uartStartAddress + uartIER
+		iir = uartStartAddress + uartIIR
+		lcr = uartStartAddress + uartLCR
+		lsr = uartStartAddress + uartLSR
+	)
+	r, w := io.Pipe()
+	defer w.Close()
+	line := &interruptLine{}
+	var out bytes.Buffer
+	bus := NewBus(NewUART(r, &out, line))
+
+	if got := mustRead(t, bus, iir, 1); got != uartIIRNone {
+		t.Errorf("want no interrupt but got IIR 0x%x", got)
+	}
+
+	// The transmitter holding register empty interrupt is raised when it is enabled,
+	// and cleared by reading IIR.
+	mustWrite(t, bus, ier, 1, uartIERTHRE)
+	if !line.Level() {
+		t.Error("want the line is asserted")
+	}
+	if got := mustRead(t, bus, iir, 1); got != uartIIRTHRE {
+		t.Errorf("want THRE interrupt but got IIR 0x%x", got)
+	}
+	if got := mustRead(t, bus, iir, 1); got != uartIIRNone || line.Level() {
+		t.Errorf("want THRE interrupt is cleared but got IIR 0x%x", got)
+	}
+	mustWrite(t, bus, rbr, 1, 'a')
+	if got := mustRead(t, bus, iir, 1); got != uartIIRTHRE {
+		t.Errorf("want THRE interrupt after the transmission but got IIR 0x%x", got)
+	}
+	if got := out.String(); got != "a" {
+		t.Errorf("want %q is transmitted but got %q", "a", got)
+	}
+
+	// The received data available interrupt has the higher priority.
+	mustWrite(t, bus, ier, 1, uartIERRxData|uartIERTHRE)
+	if _, err := w.Write([]byte("xy")); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, func() bool { return mustRead(t, bus, lsr, 1)&uartLSRDataReady != 0 })
+	if got := mustRead(t, bus, iir, 1); got != uartIIRRxData || !line.Level() {
+		t.Errorf("want received data available interrupt but got IIR 0x%x", got)
+	}
+	if got := mustRead(t, bus, rbr, 1); got != 'x' {
+		t.Errorf("want %q but got %q", 'x', got)
+	}
+	waitFor(t, func() bool { return mustRead(t, bus, lsr, 1)&uartLSRDataReady != 0 })
+	if got := mustRead(t, bus, rbr, 1); got != 'y' {
+		t.Errorf("want %q but got %q", 'y', got)
+	}
+	if got := mustRead(t, bus, lsr, 1); got != uartLSRTHRE|uartLSRTEMT {
+		t.Errorf("want LSR 0x%x but got 0x%x", uartLSRTHRE|uartLSRTEMT, got)
+	}
+
+	// The divisor latch is accessed when DLAB is set.
+	mustWrite(t, bus, lcr, 1, uartLCRDLAB)
+	mustWrite(t, bus, rbr, 1, 0x12)
+	mustWrite(t, bus, ier, 1, 0x34)
+	mustWrite(t, bus, lcr, 1, 0x03)
+	if got := mustRead(t, bus, ier, 1); got != uartIERRxData|uartIERTHRE {
+		t.Errorf("want IER is not changed but got 0x%x", got)
+	}
+	mustWrite(t, bus, lcr, 1, uartLCRDLAB)
+	if got := mustRead(t, bus, rbr, 1) | mustRead(t, bus, ier, 1)<<8; got != 0x3412 {
+		t.Errorf("want divisor 0x3412 but got 0x%x", got)
+	}
+	if got := out.String(); got != "a" {
+		t.Errorf("want %q is transmitted but got %q", "a", got)
+	}
+
+	// IIR reports that FIFOs are enabled. 8250 driver identifies 16550A by them.
+	mustWrite(t, bus, lcr, 1, 0x03)
+	mustWrite(t, bus, ier, 1, 0)
+	mustWrite(t, bus, uartStartAddress+uartFCR, 1, uartFCREnable)
+	if got := mustRead(t, bus, iir, 1); got != uartIIRFIFO|uartIIRNone {
+		t.Errorf("want IIR 0x%x but got 0x%x", uartIIRFIFO|uartIIRNone, got)
+	}
+}
+
+// waitFor waits until cond returns true.
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
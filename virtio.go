@@ -0,0 +1,554 @@
+package riscv
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// VirtioDevice is the device type specific part of the virtio device which is
+// connected to the bus by VirtioMMIO. e.g. VirtioBlock.
+type VirtioDevice interface {
+	// deviceID returns the virtio device ID. e.g. 2 for the block device.
+	deviceID() uint32
+	// features returns the device specific feature bits which are offered to the driver.
+	features() uint64
+	// numQueues returns the number of the virtqueues.
+	numQueues() int
+	// readConfig and writeConfig access the device configuration space.
+	readConfig(offset, size uint64) uint64
+	writeConfig(offset, size, value uint64)
+	// notify is called when the driver makes the buffers available in the queue.
+	notify(q *virtqueue) error
+	// reset is called when the driver resets the device.
+	reset()
+}
+
+// virtioPoller is implemented by the virtio devices which receive the data
+// asynchronously. e.g. the network device. poll is called before each instruction.
+type virtioPoller interface {
+	poll(queues []*virtqueue) error
+}
+
+// VirtioMMIO is virtio over MMIO transport (version 2, i.e. non-legacy) with split virtqueues.
+// The device is mapped to one of the slots of VIRT_VIRTIO region. On QEMU virt machine,
+// the slot i is at 0x10001000 + i*0x1000 and connected to the source i+1 of PLIC.
+//
+// The notifications from the driver are handled before the next instruction is executed.
+// Indirect descriptors and VIRTIO_F_EVENT_IDX are not supported.
+//
+// see: https://docs.oasis-open.org/virtio/virtio/v1.1/virtio-v1.1.html
+type VirtioMMIO struct {
+	slot int
+	dev  VirtioDevice
+	irq  InterruptLine
+	bus  *Bus
+
+	deviceFeaturesSel uint32
+	driverFeatures    uint64
+	driverFeaturesSel uint32
+	queueSel          uint32
+	queues            []*virtqueue
+	interruptStatus   uint32
+	status            uint32
+}
+
+var (
+	_ Device    = (*VirtioMMIO)(nil)
+	_ Ticker    = (*VirtioMMIO)(nil)
+	_ busMaster = (*VirtioMMIO)(nil)
+)
+
+const (
+	virtioStartAddress = 0x10001000
+	virtioSize         = 0x1000
+	// virtioSlots is the number of the virtio-mmio transports on QEMU virt machine.
+	virtioSlots = 8
+
+	// virtioMagic is "virt" in little endian.
+	virtioMagic   = 0x74726976
+	virtioVersion = 2
+	// virtioVendor is "QEMU" in little endian.
+	virtioVendor = 0x554d4551
+	// virtioQueueSizeMax is the maximum number of the descriptors in the virtqueue.
+	virtioQueueSizeMax = 256
+
+	// The offsets of the registers.
+	virtioMMIOMagicValue        = 0x000
+	virtioMMIOVersion           = 0x004
+	virtioMMIODeviceID          = 0x008
+	virtioMMIOVendorID          = 0x00c
+	virtioMMIODeviceFeatures    = 0x010
+	virtioMMIODeviceFeaturesSel = 0x014
+	virtioMMIODriverFeatures    = 0x020
+	virtioMMIODriverFeaturesSel = 0x024
+	virtioMMIOQueueSel          = 0x030
+	virtioMMIOQueueNumMax       = 0x034
+	virtioMMIOQueueNum          = 0x038
+	virtioMMIOQueueReady        = 0x044
+	virtioMMIOQueueNotify       = 0x050
+	virtioMMIOInterruptStatus   = 0x060
+	virtioMMIOInterruptACK      = 0x064
+	virtioMMIOStatus            = 0x070
+	virtioMMIOQueueDescLow      = 0x080
+	virtioMMIOQueueDescHigh     = 0x084
+	virtioMMIOQueueDriverLow    = 0x090
+	virtioMMIOQueueDriverHigh   = 0x094
+	virtioMMIOQueueDeviceLow    = 0x0a0
+	virtioMMIOQueueDeviceHigh   = 0x0a4
+	virtioMMIOConfigGeneration  = 0x0fc
+	virtioMMIOConfig            = 0x100
+
+	// The bits of InterruptStatus.
+	virtioInterruptUsedBuffer   = 1 << 0
+	virtioInterruptConfigChange = 1 << 1
+
+	// The bits of the device status.
+	virtioStatusFeaturesOK      = 8
+	virtioStatusDeviceNeedReset = 64
+
+	// virtioFVersion1 indicates compliance with the specification version 1.
+	virtioFVersion1 = 1 << 32
+)
+
+// NewVirtioMMIO creates the transport of dev at the slot. It panics if slot is not in [0, 7].
+// irq may be nil if the interrupt line is not connected.
+func NewVirtioMMIO(slot int, dev VirtioDevice, irq InterruptLine) *VirtioMMIO {
+	if slot < 0 || slot >= virtioSlots {
+		panic(fmt.Sprintf("riscv: invalid virtio-mmio slot: %d", slot))
+	}
+	v := &VirtioMMIO{
+		slot:   slot,
+		dev:    dev,
+		irq:    irq,
+		queues: make([]*virtqueue, dev.numQueues()),
+	}
+	for i := range v.queues {
+		v.queues[i] = &virtqueue{index: i, transport: v}
+	}
+	return v
+}
+
+// StartAddr represents start address for the virtio-mmio transport.
+func (v *VirtioMMIO) StartAddr() uint64 { return virtioStartAddress + uint64(v.slot)*virtioSize }
+
+// EndAddr represents end of address for the virtio-mmio transport.
+func (v *VirtioMMIO) EndAddr() uint64 { return v.StartAddr() + virtioSize }
+
+func (v *VirtioMMIO) connect(bus *Bus) { v.bus = bus }
+
+// queue returns the queue which is selected by QueueSel. It returns nil if the queue does not exist.
+func (v *VirtioMMIO) queue() *virtqueue {
+	if int(v.queueSel) < len(v.queues) {
+		return v.queues[v.queueSel]
+	}
+	return nil
+}
+
+// Read reads the 32-bit register or the device configuration space.
+func (v *VirtioMMIO) Read(addr, size uint64) uint64 {
+	if addr >= virtioMMIOConfig {
+		return v.dev.readConfig(addr-virtioMMIOConfig, size)
+	}
+	if size != 4 {
+		return 0
+	}
+	switch addr {
+	case virtioMMIOMagicValue:
+		return virtioMagic
+	case virtioMMIOVersion:
+		return virtioVersion
+	case virtioMMIODeviceID:
+		return uint64(v.dev.deviceID())
+	case virtioMMIOVendorID:
+		return virtioVendor
+	case virtioMMIODeviceFeatures:
+		features := v.dev.features() | virtioFVersion1
+		if v.deviceFeaturesSel < 2 {
+			return features >> (32 * v.deviceFeaturesSel) & 0xffffffff
+		}
+	case virtioMMIOQueueNumMax:
+		if v.queue() != nil {
+			return virtioQueueSizeMax
+		}
+	case virtioMMIOQueueReady:
+		if q := v.queue(); q != nil && q.ready {
+			return 1
+		}
+	case virtioMMIOInterruptStatus:
+		return uint64(v.interruptStatus)
+	case virtioMMIOStatus:
+		return uint64(v.status)
+	case virtioMMIOConfigGeneration:
+		return 0
+	}
+	return 0
+}
+
+// Write writes the 32-bit register or the device configuration space.
+func (v *VirtioMMIO) Write(addr, size, value uint64) {
+	if addr >= virtioMMIOConfig {
+		v.dev.writeConfig(addr-virtioMMIOConfig, size, value)
+		return
+	}
+	if size != 4 {
+		return
+	}
+	val := uint32(value)
+	switch addr {
+	case virtioMMIODeviceFeaturesSel:
+		v.deviceFeaturesSel = val
+	case virtioMMIODriverFeatures:
+		if v.driverFeaturesSel < 2 {
+			shift := 32 * v.driverFeaturesSel
+			v.driverFeatures = v.driverFeatures&^(0xffffffff<<shift) | uint64(val)<<shift
+		}
+	case virtioMMIODriverFeaturesSel:
+		v.driverFeaturesSel = val
+	case virtioMMIOQueueSel:
+		v.queueSel = val
+	case virtioMMIOInterruptACK:
+		v.interruptStatus &^= val
+		v.updateIRQ()
+	case virtioMMIOStatus:
+		v.setStatus(val)
+	case virtioMMIOQueueNotify:
+		if int(val) < len(v.queues) {
+			v.queues[val].notified = true
+		}
+	case virtioMMIOQueueNum, virtioMMIOQueueReady,
+		virtioMMIOQueueDescLow, virtioMMIOQueueDescHigh,
+		virtioMMIOQueueDriverLow, virtioMMIOQueueDriverHigh,
+		virtioMMIOQueueDeviceLow, virtioMMIOQueueDeviceHigh:
+		v.writeQueue(addr, val)
+	}
+}
+
+// writeQueue writes the register of the queue which is selected by QueueSel.
+// Only QueueReady can be written while the queue is ready.
+func (v *VirtioMMIO) writeQueue(addr uint64, val uint32) {
+	q := v.queue()
+	if q == nil || q.ready && addr != virtioMMIOQueueReady {
+		return
+	}
+	switch addr {
+	case virtioMMIOQueueNum:
+		// The queue size must be a power of two.
+		if val != 0 && val <= virtioQueueSizeMax && val&(val-1) == 0 {
+			q.size = val
+		}
+	case virtioMMIOQueueReady:
+		q.ready = val&1 != 0 && q.size != 0
+	case virtioMMIOQueueDescLow, virtioMMIOQueueDescHigh:
+		q.desc = writeRegister(q.desc, addr-virtioMMIOQueueDescLow, 4, uint64(val))
+	case virtioMMIOQueueDriverLow, virtioMMIOQueueDriverHigh:
+		q.driver = writeRegister(q.driver, addr-virtioMMIOQueueDriverLow, 4, uint64(val))
+	case virtioMMIOQueueDeviceLow, virtioMMIOQueueDeviceHigh:
+		q.device = writeRegister(q.device, addr-virtioMMIOQueueDeviceLow, 4, uint64(val))
+	}
+}
+
+// setStatus writes the device status. Writing 0 resets the device. FEATURES_OK is
+// not set if the driver accepts the features which are not offered or does not accept VIRTIO_F_VERSION_1.
+//
+// see: 3.1.1 Driver Requirements: Device Initialization
+func (v *VirtioMMIO) setStatus(status uint32) {
+	if status == 0 {
+		v.reset()
+		return
+	}
+	if status&virtioStatusFeaturesOK != 0 && v.status&virtioStatusFeaturesOK == 0 {
+		offered := v.dev.features() | virtioFVersion1
+		if v.driverFeatures&^offered != 0 || v.driverFeatures&virtioFVersion1 == 0 {
+			status &^= virtioStatusFeaturesOK
+		}
+	}
+	v.status = status | v.status&virtioStatusDeviceNeedReset
+}
+
+func (v *VirtioMMIO) reset() {
+	v.deviceFeaturesSel = 0
+	v.driverFeatures = 0
+	v.driverFeaturesSel = 0
+	v.queueSel = 0
+	v.interruptStatus = 0
+	v.status = 0
+	for i := range v.queues {
+		v.queues[i] = &virtqueue{index: i, transport: v}
+	}
+	v.dev.reset()
+	v.updateIRQ()
+}
+
+// Tick handles the notified queues and polls the device.
+func (v *VirtioMMIO) Tick() {
+	if v.bus == nil || v.status&virtioStatusDeviceNeedReset != 0 {
+		return
+	}
+	for _, q := range v.queues {
+		if !q.notified {
+			continue
+		}
+		q.notified = false
+		if q.ready {
+			if err := v.dev.notify(q); err != nil {
+				v.fail()
+				return
+			}
+		}
+	}
+	if p, ok := v.dev.(virtioPoller); ok {
+		if err := p.poll(v.queues); err != nil {
+			v.fail()
+		}
+	}
+}
+
+// fail sets DEVICE_NEEDS_RESET when the device encounters an error from which it
+// cannot recover. e.g. the broken descriptor chain.
+//
+// see: 2.1.1 Device Requirements: Device Status Field
+func (v *VirtioMMIO) fail() {
+	v.status |= virtioStatusDeviceNeedReset
+	v.interrupt(virtioInterruptConfigChange)
+}
+
+func (v *VirtioMMIO) interrupt(bits uint32) {
+	v.interruptStatus |= bits
+	v.updateIRQ()
+}
+
+func (v *VirtioMMIO) updateIRQ() {
+	if v.irq != nil {
+		v.irq.SetLevel(v.interruptStatus != 0)
+	}
+}
+
+// virtqueue is the split virtqueue.
+//
+// see: 2.6 Split Virtqueues
+type virtqueue struct {
+	index     int
+	transport *VirtioMMIO
+	size      uint32
+	ready     bool
+	// notified is set when the driver notifies the queue.
+	notified bool
+	// desc, driver and device are the guest physical addresses of the descriptor
+	// table, the available ring and the used ring.
+	desc   uint64
+	driver uint64
+	device uint64
+	// lastAvail is the index of the next available ring entry which is processed.
+	lastAvail uint16
+	usedIdx   uint16
+}
+
+const (
+	virtqDescFNext  = 1
+	virtqDescFWrite = 2
+
+	virtqAvailFNoInterrupt = 1
+
+	// virtqMaxChainLen is the maximum total length of the device-readable buffers or
+	// the device-writable buffers in the chain. The longer chain breaks the virtqueue.
+	virtqMaxChainLen = 1 << 30
+	// virtqChunkSize is the size of the host buffer which streams the data of the chain.
+	virtqChunkSize = 64 * 1024
+)
+
+// errVirtqueue is returned when the driver breaks the virtqueue.
+var errVirtqueue = errors.New("broken virtqueue")
+
+// virtqBuffer is the buffer which is described by the descriptor.
+type virtqBuffer struct {
+	addr uint64
+	len  uint32
+}
+
+// virtqChain is the descriptor chain. The device-readable buffers precede the device-writable buffers.
+type virtqChain struct {
+	head     uint16
+	readable []virtqBuffer
+	writable []virtqBuffer
+}
+
+// pending reports whether the driver has made the buffers available which are not processed yet.
+func (q *virtqueue) pending() (bool, error) {
+	if !q.ready {
+		return false, nil
+	}
+	idx, err := q.transport.bus.Read(q.driver+2, 2)
+	if err != nil {
+		return false, err
+	}
+	return uint16(idx) != q.lastAvail, nil
+}
+
+// pop returns the next available descriptor chain. It returns nil if no buffer is available.
+//
+// see: 2.6.13.1 Placing Buffers Into The Descriptor Table
+func (q *virtqueue) pop() (*virtqChain, error) {
+	if ok, err := q.pending(); err != nil || !ok {
+		return nil, err
+	}
+	bus := q.transport.bus
+	head, err := bus.Read(q.driver+4+2*uint64(uint32(q.lastAvail)%q.size), 2)
+	if err != nil {
+		return nil, err
+	}
+	q.lastAvail++
+	chain := &virtqChain{head: uint16(head)}
+	var raw [16]byte
+	i := uint32(head)
+	for n := uint32(0); ; n++ {
+		// The chain is longer than the queue if it contains a loop.
+		if i >= q.size || n >= q.size {
+			return nil, errVirtqueue
+		}
+		if err := bus.readBytes(q.desc+16*uint64(i), raw[:]); err != nil {
+			return nil, err
+		}
+		buf := virtqBuffer{
+			addr: binary.LittleEndian.Uint64(raw[0:]),
+			len:  binary.LittleEndian.Uint32(raw[8:]),
+		}
+		flags := binary.LittleEndian.Uint16(raw[12:])
+		if flags&virtqDescFWrite != 0 {
+			chain.writable = append(chain.writable, buf)
+		} else if len(chain.writable) == 0 {
+			chain.readable = append(chain.readable, buf)
+		} else {
+			// The device-readable buffer must not follow the device-writable buffer.
+			return nil, errVirtqueue
+		}
+		if buf.len > virtqMaxChainLen || chain.readableLen() > virtqMaxChainLen || chain.writableLen() > virtqMaxChainLen {
+			return nil, errVirtqueue
+		}
+		if flags&virtqDescFNext == 0 {
+			return chain, nil
+		}
+		i = uint32(binary.LittleEndian.Uint16(raw[14:]))
+	}
+}
+
+// readableLen returns the total length of the device-readable buffers.
+func (c *virtqChain) readableLen() int {
+	return buffersLen(c.readable)
+}
+
+// writableLen returns the total length of the device-writable buffers.
+func (c *virtqChain) writableLen() int {
+	return buffersLen(c.writable)
+}
+
+func buffersLen(bufs []virtqBuffer) int {
+	var n int
+	for _, b := range bufs {
+		n += int(b.len)
+	}
+	return n
+}
+
+// read returns the bytes of the device-readable buffers. It returns errVirtqueue if
+// they are longer than max, so the host never allocates the buffer which is sized by the driver.
+func (q *virtqueue) read(c *virtqChain, max int) ([]byte, error) {
+	n := c.readableLen()
+	if n > max {
+		return nil, errVirtqueue
+	}
+	p := make([]byte, n)
+	if err := q.readAt(c, p, 0); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// readAt reads len(p) bytes at offset off in the device-readable buffers.
+// It returns errVirtqueue if they are shorter than off+len(p).
+func (q *virtqueue) readAt(c *virtqChain, p []byte, off int) error {
+	return q.copyAt(c.readable, p, off, q.transport.bus.readBytes)
+}
+
+// write writes p to the device-writable buffers. It returns errVirtqueue if p does not fit.
+func (q *virtqueue) write(c *virtqChain, p []byte) error {
+	return q.writeAt(c, p, 0)
+}
+
+// writeAt writes p at offset off in the device-writable buffers.
+// It returns errVirtqueue if p does not fit.
+func (q *virtqueue) writeAt(c *virtqChain, p []byte, off int) error {
+	return q.copyAt(c.writable, p, off, q.transport.bus.writeBytes)
+}
+
+// chunkSize returns the size of the host buffer which streams n bytes.
+func chunkSize(n int) int {
+	if n > virtqChunkSize {
+		return virtqChunkSize
+	}
+	return n
+}
+
+// copyAt copies p from or to the buffers at offset off by access.
+func (q *virtqueue) copyAt(bufs []virtqBuffer, p []byte, off int, access func(addr uint64, p []byte) error) error {
+	if off < 0 || off+len(p) > buffersLen(bufs) {
+		return errVirtqueue
+	}
+	for _, b := range bufs {
+		if len(p) == 0 {
+			break
+		}
+		if off >= int(b.len) {
+			off -= int(b.len)
+			continue
+		}
+		n := int(b.len) - off
+		if n > len(p) {
+			n = len(p)
+		}
+		if err := access(b.addr+uint64(off), p[:n]); err != nil {
+			return err
+		}
+		p = p[n:]
+		off = 0
+	}
+	return nil
+}
+
+// push returns the descriptor chain to the driver with the number of the bytes
+// which are written, and sends the used buffer notification.
+//
+// see: 2.6.8 The Virtqueue Used Ring
+func (q *virtqueue) push(c *virtqChain, written uint32) error {
+	bus := q.transport.bus
+	var elem [8]byte
+	binary.LittleEndian.PutUint32(elem[0:], uint32(c.head))
+	binary.LittleEndian.PutUint32(elem[4:], written)
+	if err := bus.writeBytes(q.device+4+8*uint64(uint32(q.usedIdx)%q.size), elem[:]); err != nil {
+		return err
+	}
+	q.usedIdx++
+	if err := bus.Write(q.device+2, 2, uint64(q.usedIdx)); err != nil {
+		return err
+	}
+	flags, err := bus.Read(q.driver, 2)
+	if err != nil {
+		return err
+	}
+	if flags&virtqAvailFNoInterrupt == 0 {
+		q.transport.interrupt(virtioInterruptUsedBuffer)
+	}
+	return nil
+}
+
+// readConfigBytes reads size bytes at offset in the device configuration space
+// which is laid out in little endian.
+func readConfigBytes(config []byte, offset, size uint64) uint64 {
+	var v uint64
+	for i := uint64(0); i < size; i++ {
+		if offset+i < uint64(len(config)) {
+			v |= uint64(config[offset+i]) << (8 * i)
+		}
+	}
+	return v
+}
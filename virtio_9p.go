@@ -0,0 +1,114 @@
+package riscv
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+// Virtio9P is virtio 9P transport device which exports the host directory by 9P2000.L.
+// The guest mounts it by the tag. e.g. on Linux:
+//
+//	mount -t 9p -o trans=virtio,version=9p2000.L <tag> /mnt
+//
+// The guest cannot access the files outside the directory even if it follows the
+// symbolic links. The files are accessed with the permissions of the host process,
+// and all files are owned by root in the guest.
+//
+// see: 5.18 9P Transport Device (draft), https://github.com/chaos/diod/blob/master/protocol.md
+type Virtio9P struct {
+	tag    string
+	server *p9Server
+}
+
+var _ VirtioDevice = (*Virtio9P)(nil)
+
+const (
+	virtio9PDeviceID = 9
+
+	// virtio9PFMountTag is the feature bit which means the tag is in the configuration space.
+	virtio9PFMountTag = 1 << 0
+)
+
+// Virtio9POption represents an option for NewVirtio9P.
+type Virtio9POption func(*Virtio9P)
+
+// With9PReadOnly makes the directory read-only. The requests which modify the files
+// fail with EROFS.
+func With9PReadOnly() Virtio9POption {
+	return func(v *Virtio9P) {
+		v.server.readOnly = true
+	}
+}
+
+// NewVirtio9P creates the 9P device which exports dir by the mount tag.
+// It returns an error if dir is not a directory.
+func NewVirtio9P(tag, dir string, opts ...Virtio9POption) (*Virtio9P, error) {
+	if tag == "" || len(tag) > 0xffff {
+		return nil, errors.New("riscv: invalid 9P mount tag")
+	}
+	server, err := newP9Server(dir, false)
+	if err != nil {
+		return nil, err
+	}
+	v := &Virtio9P{
+		tag:    tag,
+		server: server,
+	}
+	for _, opt := range opts {
+		opt(v)
+	}
+	return v, nil
+}
+
+func (v *Virtio9P) deviceID() uint32 { return virtio9PDeviceID }
+
+func (v *Virtio9P) features() uint64 { return virtio9PFMountTag }
+
+func (v *Virtio9P) numQueues() int { return 1 }
+
+// readConfig reads the length of the tag followed by the tag.
+func (v *Virtio9P) readConfig(offset, size uint64) uint64 {
+	config := make([]byte, 2+len(v.tag))
+	binary.LittleEndian.PutUint16(config, uint16(len(v.tag)))
+	copy(config[2:], v.tag)
+	return readConfigBytes(config, offset, size)
+}
+
+func (v *Virtio9P) writeConfig(offset, size, value uint64) {}
+
+// reset closes the files which are opened by the driver.
+func (v *Virtio9P) reset() {
+	v.server.reset()
+}
+
+// notify handles the requests. Each request is the 9P message in the driver-readable
+// buffers, and the response is written to the device-writable buffers.
+func (v *Virtio9P) notify(q *virtqueue) error {
+	for {
+		c, err := q.pop()
+		if err != nil || c == nil {
+			return err
+		}
+		req, err := q.read(c, p9MaxMsize)
+		if err != nil {
+			return err
+		}
+		if len(req) < p9HeaderSize {
+			return errVirtqueue
+		}
+		size := binary.LittleEndian.Uint32(req)
+		if size < p9HeaderSize || uint64(size) > uint64(len(req)) {
+			return errVirtqueue
+		}
+		resp := v.server.handle(req[:size])
+		if len(resp) > c.writableLen() {
+			return errVirtqueue
+		}
+		if err := q.write(c, resp); err != nil {
+			return err
+		}
+		if err := q.push(c, uint32(len(resp))); err != nil {
+			return err
+		}
+	}
+}
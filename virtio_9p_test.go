@@ -0,0 +1,496 @@
+package riscv
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// p9Client sends the 9P messages through the request queue of Virtio9P.
+type p9Client struct {
+	t *testing.T
+	d *virtioDriver
+}
+
+func newP9Client(t *testing.T, v *Virtio9P) *p9Client {
+	t.Helper()
+	d := newVirtioDriver(t, v, nil)
+	d.init(virtioFVersion1|virtio9PFMountTag, 1)
+	return &p9Client{t: t, d: d}
+}
+
+// rpc sends the request and returns the reader of the response body. It returns
+// the errno if the response is Rlerror.
+func (c *p9Client) rpc(typ uint8, body func(w *p9Writer)) (*p9Reader, uint32) {
+	c.t.Helper()
+	w := &p9Writer{}
+	w.u32(0)
+	w.u8(typ)
+	w.u16(1) // tag
+	if body != nil {
+		body(w)
+	}
+	buf := &testBuffer{writable: 8192}
+	c.d.submit(0, &testBuffer{data: w.finish()}, buf)
+	c.d.kick(0)
+	used := c.d.used(0)
+	if len(used) != 1 {
+		c.t.Fatalf("want the request is completed but got %v", used)
+	}
+	r := &p9Reader{b: c.d.readMem(buf.addr, int(used[0].len))}
+	if size := r.u32(); size != used[0].len {
+		c.t.Fatalf("want size %d but got %d", used[0].len, size)
+	}
+	rtyp, tag := r.u8(), r.u16()
+	if tag != 1 {
+		c.t.Fatalf("want tag 1 but got %d", tag)
+	}
+	if rtyp == p9Rlerror {
+		return r, r.u32()
+	}
+	if rtyp != typ+1 {
+		c.t.Fatalf("want response type %d but got %d", typ+1, rtyp)
+	}
+	return r, 0
+}
+
+// must is rpc which fails the test if the response is Rlerror.
+func (c *p9Client) must(typ uint8, body func(w *p9Writer)) *p9Reader {
+	c.t.Helper()
+	r, errno := c.rpc(typ, body)
+	if errno != 0 {
+		c.t.Fatalf("want request %d succeeds but got errno %d", typ, errno)
+	}
+	return r
+}
+
+// attach negotiates the version and attaches fid 0 to the root.
+func (c *p9Client) attach() {
+	c.t.Helper()
+	r := c.must(p9Tversion, func(w *p9Writer) {
+		w.u32(8192)
+		w.str(p9Version)
+	})
+	if msize, version := r.u32(), r.str(); msize != 8192 || version != p9Version {
+		c.t.Fatalf("want msize 8192 and %s but got %d and %s", p9Version, msize, version)
+	}
+	c.must(p9Tattach, func(w *p9Writer) {
+		w.u32(0)
+		w.u32(^uint32(0))
+		w.str("root")
+		w.str("")
+		w.u32(0)
+	})
+}
+
+// walk walks from fid 0 to newfid and returns errno.
+func (c *p9Client) walk(newfid uint32, names ...string) uint32 {
+	c.t.Helper()
+	r, errno := c.rpc(p9Twalk, func(w *p9Writer) {
+		w.u32(0)
+		w.u32(newfid)
+		w.u16(uint16(len(names)))
+		for _, name := range names {
+			w.str(name)
+		}
+	})
+	if errno == 0 && int(r.u16()) != len(names) {
+		return p9ENOENT
+	}
+	return errno
+}
+
+// lopen opens fid and returns errno.
+func (c *p9Client) lopen(fid, flags uint32) uint32 {
+	c.t.Helper()
+	_, errno := c.rpc(p9Tlopen, func(w *p9Writer) {
+		w.u32(fid)
+		w.u32(flags)
+	})
+	return errno
+}
+
+func (c *p9Client) read(fid uint32) string {
+	c.t.Helper()
+	r := c.must(p9Tread, func(w *p9Writer) {
+		w.u32(fid)
+		w.u64(0)
+		w.u32(4096)
+	})
+	return string(r.bytes(int(r.u32())))
+}
+
+// create creates the file in the root and returns qid.path of it.
+func (c *p9Client) create(name string) uint64 {
+	c.t.Helper()
+	if errno := c.walk(1); errno != 0 {
+		c.t.Fatalf("want walk succeeds but got errno %d", errno)
+	}
+	r := c.must(p9Tlcreate, func(w *p9Writer) {
+		w.u32(1)
+		w.str(name)
+		w.u32(p9ORdwr)
+		w.u32(0o644)
+		w.u32(0)
+	})
+	r.bytes(5) // type and version
+	path := r.u64()
+	c.clunk(1)
+	return path
+}
+
+func (c *p9Client) clunk(fid uint32) {
+	c.t.Helper()
+	c.must(p9Tclunk, func(w *p9Writer) { w.u32(fid) })
+}
+
+func TestVirtio9P(t *testing.T) {
+	dir := t.TempDir()
+	outside := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "hello.txt"), []byte("hello"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(filepath.Join(dir, "sub"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(outside, "secret"), []byte("secret"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(filepath.Join(outside, "secret"), filepath.Join(dir, "escape")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("hello.txt", filepath.Join(dir, "link")); err != nil {
+		t.Fatal(err)
+	}
+
+	v, err := NewVirtio9P("share", dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := newP9Client(t, v)
+	var config []byte
+	for i := uint64(0); i < 7; i++ {
+		b, err := c.d.cpu.bus.Read(c.d.v.StartAddr()+virtioMMIOConfig+i, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		config = append(config, byte(b))
+	}
+	if want := "\x05\x00share"; string(config) != want {
+		t.Errorf("want config %q but got %q", want, config)
+	}
+	c.attach()
+
+	t.Run("read", func(t *testing.T) {
+		c.t = t
+		if errno := c.walk(1, "sub", "..", "hello.txt"); errno != 0 {
+			t.Fatalf("want walk succeeds but got errno %d", errno)
+		}
+		r := c.must(p9Tgetattr, func(w *p9Writer) {
+			w.u32(1)
+			w.u64(p9GetattrBasic)
+		})
+		r.u64() // valid
+		if q := r.bytes(13); q[0] != p9QTFile {
+			t.Errorf("want file qid but got type 0x%x", q[0])
+		}
+		if mode := r.u32(); mode != p9SIFReg|0o644 {
+			t.Errorf("want mode 0%o but got 0%o", p9SIFReg|0o644, mode)
+		}
+		r.bytes(4 + 4 + 8 + 8)
+		if size := r.u64(); size != 5 {
+			t.Errorf("want size 5 but got %d", size)
+		}
+		if errno := c.lopen(1, 0); errno != 0 {
+			t.Fatalf("want lopen succeeds but got errno %d", errno)
+		}
+		if got := c.read(1); got != "hello" {
+			t.Errorf("want %q but got %q", "hello", got)
+		}
+		c.clunk(1)
+	})
+
+	t.Run("readdir", func(t *testing.T) {
+		c.t = t
+		if errno := c.walk(1); errno != 0 {
+			t.Fatalf("want walk succeeds but got errno %d", errno)
+		}
+		if errno := c.lopen(1, 0); errno != 0 {
+			t.Fatalf("want lopen succeeds but got errno %d", errno)
+		}
+		r := c.must(p9Treaddir, func(w *p9Writer) {
+			w.u32(1)
+			w.u64(0)
+			w.u32(4096)
+		})
+		r = &p9Reader{b: r.bytes(int(r.u32()))}
+		var names []string
+		for len(r.b) > 0 {
+			r.bytes(13) // qid
+			r.u64()     // offset
+			r.u8()      // type
+			names = append(names, r.str())
+		}
+		want := []string{".", "..", "escape", "hello.txt", "link", "sub"}
+		if len(names) != len(want) {
+			t.Fatalf("want %q but got %q", want, names)
+		}
+		for i := range want {
+			if names[i] != want[i] {
+				t.Errorf("want %q but got %q", want, names)
+				break
+			}
+		}
+		c.clunk(1)
+	})
+
+	t.Run("create", func(t *testing.T) {
+		c.t = t
+		if errno := c.walk(1, "sub"); errno != 0 {
+			t.Fatalf("want walk succeeds but got errno %d", errno)
+		}
+		c.must(p9Tlcreate, func(w *p9Writer) {
+			w.u32(1)
+			w.str("new.txt")
+			w.u32(p9ORdwr)
+			w.u32(0o600)
+			w.u32(0)
+		})
+		r := c.must(p9Twrite, func(w *p9Writer) {
+			w.u32(1)
+			w.u64(0)
+			w.u32(3)
+			w.b = append(w.b, "new"...)
+		})
+		if n := r.u32(); n != 3 {
+			t.Errorf("want 3 bytes are written but got %d", n)
+		}
+		c.clunk(1)
+		if got, err := os.ReadFile(filepath.Join(dir, "sub", "new.txt")); err != nil || string(got) != "new" {
+			t.Errorf("want %q on the host but got %q, %v", "new", got, err)
+		}
+	})
+
+	t.Run("qid", func(t *testing.T) {
+		c.t = t
+		a, b := c.create("a"), c.create("b")
+		c.must(p9Tunlinkat, func(w *p9Writer) {
+			w.u32(0)
+			w.str("a")
+			w.u32(0)
+		})
+		// The identifier of the removed file is not reused.
+		if got := c.create("c"); got == a || got == b {
+			t.Errorf("want the unique qid but got %d which is used by a (%d) or b (%d)", got, a, b)
+		}
+	})
+
+	t.Run("renameat", func(t *testing.T) {
+		c.t = t
+		if err := os.Mkdir(filepath.Join(dir, "olddir"), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, "olddir", "f"), []byte("moved"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		// The guest keeps the fids of the directory and the file in it.
+		if errno := c.walk(2, "olddir"); errno != 0 {
+			t.Fatalf("want walk succeeds but got errno %d", errno)
+		}
+		if errno := c.walk(3, "olddir", "f"); errno != 0 {
+			t.Fatalf("want walk succeeds but got errno %d", errno)
+		}
+		c.must(p9Trenameat, func(w *p9Writer) {
+			w.u32(0)
+			w.str("olddir")
+			w.u32(0)
+			w.str("newdir")
+		})
+		// The new file at the old path must not be accessed by the fids.
+		if err := os.Mkdir(filepath.Join(dir, "olddir"), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, "olddir", "f"), []byte("stale"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+
+		c.must(p9Twalk, func(w *p9Writer) {
+			w.u32(2)
+			w.u32(4)
+			w.u16(1)
+			w.str("f")
+		})
+		for _, fid := range []uint32{3, 4} {
+			if errno := c.lopen(fid, 0); errno != 0 {
+				t.Fatalf("want lopen fid %d succeeds but got errno %d", fid, errno)
+			}
+			if got := c.read(fid); got != "moved" {
+				t.Errorf("want fid %d reads %q but got %q", fid, "moved", got)
+			}
+			c.clunk(fid)
+		}
+		c.clunk(2)
+	})
+
+	t.Run("confinement", func(t *testing.T) {
+		c.t = t
+		// ".." of the root is the root.
+		if errno := c.walk(1, "..", "..", "hello.txt"); errno != 0 {
+			t.Fatalf("want walk succeeds but got errno %d", errno)
+		}
+		if errno := c.lopen(1, 0); errno != 0 {
+			t.Fatalf("want lopen succeeds but got errno %d", errno)
+		}
+		if got := c.read(1); got != "hello" {
+			t.Errorf("want %q but got %q", "hello", got)
+		}
+		c.clunk(1)
+
+		for _, names := range [][]string{{"a/b"}, {""}, {"escape", "x"}} {
+			if errno := c.walk(1, names...); errno == 0 {
+				t.Errorf("want walk %q fails", names)
+				c.clunk(1)
+			}
+		}
+
+		// The symbolic link inside the share can be followed.
+		if errno := c.walk(1, "link"); errno != 0 {
+			t.Fatalf("want walk succeeds but got errno %d", errno)
+		}
+		if errno := c.lopen(1, 0); errno != 0 {
+			t.Fatalf("want lopen succeeds but got errno %d", errno)
+		}
+		c.clunk(1)
+
+		// The symbolic link to the outside cannot be followed.
+		if errno := c.walk(1, "escape"); errno != 0 {
+			t.Fatalf("want walk succeeds but got errno %d", errno)
+		}
+		if errno := c.lopen(1, 0); errno != p9EACCES {
+			t.Errorf("want EACCES but got errno %d", errno)
+		}
+		c.clunk(1)
+	})
+
+	t.Run("error", func(t *testing.T) {
+		c.t = t
+		if errno := c.walk(1, "missing"); errno != p9ENOENT {
+			t.Errorf("want ENOENT but got errno %d", errno)
+		}
+		if _, errno := c.rpc(p9Tclunk, func(w *p9Writer) { w.u32(99) }); errno != p9EBADF {
+			t.Errorf("want EBADF but got errno %d", errno)
+		}
+		if _, errno := c.rpc(p9Txattrwalk, func(w *p9Writer) {
+			w.u32(0)
+			w.u32(1)
+			w.str("user.foo")
+		}); errno != p9EOPNOTSUPP {
+			t.Errorf("want EOPNOTSUPP but got errno %d", errno)
+		}
+	})
+}
+
+func TestVirtio9PReadOnly(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "hello.txt"), []byte("hello"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	v, err := NewVirtio9P("share", dir, With9PReadOnly())
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := newP9Client(t, v)
+	c.attach()
+
+	if errno := c.walk(1, "hello.txt"); errno != 0 {
+		t.Fatalf("want walk succeeds but got errno %d", errno)
+	}
+	if errno := c.lopen(1, p9OWronly); errno != p9EROFS {
+		t.Errorf("want EROFS on write open but got errno %d", errno)
+	}
+	if errno := c.lopen(1, 0); errno != 0 {
+		t.Fatalf("want lopen succeeds but got errno %d", errno)
+	}
+	if got := c.read(1); got != "hello" {
+		t.Errorf("want %q but got %q", "hello", got)
+	}
+	c.clunk(1)
+
+	for _, tt := range []struct {
+		name string
+		typ  uint8
+		body func(w *p9Writer)
+	}{
+		{"mkdir", p9Tmkdir, func(w *p9Writer) {
+			w.u32(0)
+			w.str("dir")
+			w.u32(0o755)
+			w.u32(0)
+		}},
+		{"unlinkat", p9Tunlinkat, func(w *p9Writer) {
+			w.u32(0)
+			w.str("hello.txt")
+			w.u32(0)
+		}},
+	} {
+		if _, errno := c.rpc(tt.typ, tt.body); errno != p9EROFS {
+			t.Errorf("want EROFS on %s but got errno %d", tt.name, errno)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir, "hello.txt")); err != nil {
+		t.Errorf("want the file is not removed: %v", err)
+	}
+}
+
+func TestNewVirtio9P(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "file")
+	if err := os.WriteFile(file, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewVirtio9P("share", file); err == nil {
+		t.Errorf("want error for the file")
+	}
+	if _, err := NewVirtio9P("", t.TempDir()); err == nil {
+		t.Errorf("want error for the empty tag")
+	}
+}
+
+func TestVirtio9PVersion(t *testing.T) {
+	v, err := NewVirtio9P("share", t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := newP9Client(t, v)
+	version := func(msize uint32) (uint32, uint32) {
+		r, errno := c.rpc(p9Tversion, func(w *p9Writer) {
+			w.u32(msize)
+			w.str(p9Version)
+		})
+		if errno != 0 {
+			return 0, errno
+		}
+		return r.u32(), 0
+	}
+	if _, errno := version(p9IOHeaderSize - 1); errno != p9EINVAL {
+		t.Errorf("want EINVAL for too small msize but got errno %d", errno)
+	}
+	if got, _ := version(p9MinMsize); got != p9MinMsize {
+		t.Errorf("want msize %d but got %d", p9MinMsize, got)
+	}
+	// msize is negotiated again from the maximum.
+	if got, _ := version(1 << 20); got != p9MaxMsize {
+		t.Errorf("want msize %d but got %d", p9MaxMsize, got)
+	}
+
+	c.attach()
+	names := make([]string, p9MaxWelem+1)
+	for i := range names {
+		names[i] = "."
+	}
+	if errno := c.walk(1, names...); errno != p9EINVAL {
+		t.Errorf("want EINVAL for too many names but got errno %d", errno)
+	}
+	if errno := c.walk(1, names[:p9MaxWelem]...); errno != 0 {
+		t.Errorf("want walk with %d names succeeds but got errno %d", p9MaxWelem, errno)
+	}
+}
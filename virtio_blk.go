@@ -0,0 +1,282 @@
+package riscv
+
+import (
+	"encoding/binary"
+	"io"
+	"sync"
+)
+
+// VirtioBlock is virtio block device whose disk image is io.ReaderAt. The image is
+// writable if it also implements io.WriterAt and the device is not read-only.
+// If it implements interface{ Sync() error } (e.g. *os.File), the flush command is supported.
+//
+// see: 5.2 Block Device
+type VirtioBlock struct {
+	disk     io.ReaderAt
+	capacity uint64
+	readOnly bool
+}
+
+var _ VirtioDevice = (*VirtioBlock)(nil)
+
+const (
+	virtioBlockDeviceID = 2
+	// virtioBlockSectorSize is the unit of the capacity and the sector number. It is always 512.
+	virtioBlockSectorSize = 512
+
+	// The feature bits.
+	virtioBlkFRO    = 1 << 5
+	virtioBlkFFlush = 1 << 9
+
+	// The request types.
+	virtioBlkTIn    = 0
+	virtioBlkTOut   = 1
+	virtioBlkTFlush = 4
+	virtioBlkTGetID = 8
+
+	// The request status.
+	virtioBlkSOK     = 0
+	virtioBlkSIOErr  = 1
+	virtioBlkSUnsupp = 2
+
+	// virtioBlkReqHeaderSize is the size of the request header. (type, reserved and sector)
+	virtioBlkReqHeaderSize = 16
+	// virtioBlkIDBytes is the length of the device ID string.
+	virtioBlkIDBytes = 20
+	// virtioBlkID is the device ID string which is returned by VIRTIO_BLK_T_GET_ID.
+	virtioBlkID = "go-riscv"
+)
+
+// VirtioBlockOption represents an option for NewVirtioBlock.
+type VirtioBlockOption func(*VirtioBlock)
+
+// WithBlockReadOnly makes the device read-only. The driver sees VIRTIO_BLK_F_RO,
+// and the write requests fail.
+func WithBlockReadOnly() VirtioBlockOption {
+	return func(b *VirtioBlock) {
+		b.readOnly = true
+	}
+}
+
+// WithBlockCopyOnWrite keeps the writes in memory by CopyOnWrite. The disk image is never modified.
+func WithBlockCopyOnWrite() VirtioBlockOption {
+	return func(b *VirtioBlock) {
+		b.disk = NewCopyOnWrite(b.disk)
+	}
+}
+
+// NewVirtioBlock creates the block device of the disk image whose size is size bytes.
+// The bytes after the last whole sector are not accessible.
+func NewVirtioBlock(disk io.ReaderAt, size int64, opts ...VirtioBlockOption) *VirtioBlock {
+	b := &VirtioBlock{
+		disk:     disk,
+		capacity: uint64(size) / virtioBlockSectorSize,
+	}
+	for _, opt := range opts {
+		opt(b)
+	}
+	if _, ok := b.disk.(io.WriterAt); !ok {
+		b.readOnly = true
+	}
+	return b
+}
+
+func (b *VirtioBlock) deviceID() uint32 { return virtioBlockDeviceID }
+
+func (b *VirtioBlock) features() uint64 {
+	var f uint64
+	if b.readOnly {
+		f |= virtioBlkFRO
+	}
+	if _, ok := b.disk.(interface{ Sync() error }); ok {
+		f |= virtioBlkFFlush
+	}
+	return f
+}
+
+func (b *VirtioBlock) numQueues() int { return 1 }
+
+// readConfig reads the capacity. The other fields are not offered.
+func (b *VirtioBlock) readConfig(offset, size uint64) uint64 {
+	var config [8]byte
+	binary.LittleEndian.PutUint64(config[:], b.capacity)
+	return readConfigBytes(config[:], offset, size)
+}
+
+func (b *VirtioBlock) writeConfig(offset, size, value uint64) {}
+
+func (b *VirtioBlock) reset() {}
+
+// notify handles the requests. Each request consists of the header, the data
+// buffers and the status byte in the last device-writable buffer. The data is
+// streamed between the disk and the buffers by chunks.
+//
+// see: 5.2.6 Device Operation
+func (b *VirtioBlock) notify(q *virtqueue) error {
+	for {
+		c, err := q.pop()
+		if err != nil || c == nil {
+			return err
+		}
+		if c.writableLen() < 1 {
+			return errVirtqueue
+		}
+		var hdr [virtioBlkReqHeaderSize]byte
+		if err := q.readAt(c, hdr[:], 0); err != nil {
+			return err
+		}
+		typ := binary.LittleEndian.Uint32(hdr[0:])
+		sector := binary.LittleEndian.Uint64(hdr[8:])
+		// The data which is read is followed by the status. The status is always
+		// written to the last byte even if the request fails.
+		n := c.writableLen() - 1
+		status, err := b.handle(q, c, typ, sector, n)
+		if err != nil {
+			return err
+		}
+		if err := q.writeAt(c, []byte{status}, n); err != nil {
+			return err
+		}
+		if err := q.push(c, uint32(n+1)); err != nil {
+			return err
+		}
+	}
+}
+
+// handle performs the request and returns the status. n is the length of the buffer
+// for the data of the read request. The error is returned only if the chain is broken.
+func (b *VirtioBlock) handle(q *virtqueue, c *virtqChain, typ uint32, sector uint64, n int) (uint8, error) {
+	switch typ {
+	case virtioBlkTIn:
+		if !b.inRange(sector, uint64(n)) {
+			return virtioBlkSIOErr, nil
+		}
+		buf := make([]byte, chunkSize(n))
+		for off := 0; off < n; off += len(buf) {
+			if n-off < len(buf) {
+				buf = buf[:n-off]
+			}
+			if _, err := b.disk.ReadAt(buf, int64(sector*virtioBlockSectorSize)+int64(off)); err != nil {
+				return virtioBlkSIOErr, nil
+			}
+			if err := q.writeAt(c, buf, off); err != nil {
+				return 0, err
+			}
+		}
+		return virtioBlkSOK, nil
+	case virtioBlkTOut:
+		n := c.readableLen() - virtioBlkReqHeaderSize
+		w, ok := b.disk.(io.WriterAt)
+		if b.readOnly || !ok || !b.inRange(sector, uint64(n)) {
+			return virtioBlkSIOErr, nil
+		}
+		buf := make([]byte, chunkSize(n))
+		for off := 0; off < n; off += len(buf) {
+			if n-off < len(buf) {
+				buf = buf[:n-off]
+			}
+			if err := q.readAt(c, buf, virtioBlkReqHeaderSize+off); err != nil {
+				return 0, err
+			}
+			if _, err := w.WriteAt(buf, int64(sector*virtioBlockSectorSize)+int64(off)); err != nil {
+				return virtioBlkSIOErr, nil
+			}
+		}
+		return virtioBlkSOK, nil
+	case virtioBlkTFlush:
+		s, ok := b.disk.(interface{ Sync() error })
+		if !ok {
+			return virtioBlkSUnsupp, nil
+		}
+		if err := s.Sync(); err != nil {
+			return virtioBlkSIOErr, nil
+		}
+		return virtioBlkSOK, nil
+	case virtioBlkTGetID:
+		if n < virtioBlkIDBytes {
+			return virtioBlkSIOErr, nil
+		}
+		id := make([]byte, virtioBlkIDBytes)
+		copy(id, virtioBlkID)
+		if err := q.writeAt(c, id, 0); err != nil {
+			return 0, err
+		}
+		return virtioBlkSOK, nil
+	}
+	return virtioBlkSUnsupp, nil
+}
+
+// inRange reports whether n bytes from the sector are in the disk.
+// n must be a multiple of the sector size.
+func (b *VirtioBlock) inRange(sector, n uint64) bool {
+	if n%virtioBlockSectorSize != 0 {
+		return false
+	}
+	return sector <= b.capacity && n/virtioBlockSectorSize <= b.capacity-sector
+}
+
+// CopyOnWrite is the overlay of the disk image. The written sectors are kept in
+// memory and the base image is never modified. It is safe for concurrent use.
+type CopyOnWrite struct {
+	mu   sync.Mutex
+	base io.ReaderAt
+	// sectors is the written sectors which are indexed by the sector number.
+	sectors map[int64][]byte
+}
+
+var (
+	_ io.ReaderAt = (*CopyOnWrite)(nil)
+	_ io.WriterAt = (*CopyOnWrite)(nil)
+)
+
+// NewCopyOnWrite creates the overlay of base.
+func NewCopyOnWrite(base io.ReaderAt) *CopyOnWrite {
+	return &CopyOnWrite{
+		base:    base,
+		sectors: make(map[int64][]byte),
+	}
+}
+
+// ReadAt reads the written sectors from memory and the others from the base image.
+func (c *CopyOnWrite) ReadAt(p []byte, off int64) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	n := 0
+	for n < len(p) {
+		pos := off + int64(n)
+		sector, start := pos/virtioBlockSectorSize, int(pos%virtioBlockSectorSize)
+		chunk := p[n:]
+		if len(chunk) > virtioBlockSectorSize-start {
+			chunk = chunk[:virtioBlockSectorSize-start]
+		}
+		if s, ok := c.sectors[sector]; ok {
+			copy(chunk, s[start:])
+		} else if m, err := c.base.ReadAt(chunk, pos); err != nil {
+			return n + m, err
+		}
+		n += len(chunk)
+	}
+	return n, nil
+}
+
+// WriteAt writes p to memory. The sector is copied from the base image when it is written first.
+// The bytes beyond the end of the base image are zero.
+func (c *CopyOnWrite) WriteAt(p []byte, off int64) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	n := 0
+	for n < len(p) {
+		pos := off + int64(n)
+		sector, start := pos/virtioBlockSectorSize, int(pos%virtioBlockSectorSize)
+		s, ok := c.sectors[sector]
+		if !ok {
+			s = make([]byte, virtioBlockSectorSize)
+			if _, err := c.base.ReadAt(s, sector*virtioBlockSectorSize); err != nil && err != io.EOF {
+				return n, err
+			}
+			c.sectors[sector] = s
+		}
+		n += copy(s[start:], p[n:])
+	}
+	return n, nil
+}
@@ -0,0 +1,199 @@
+package riscv
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// memDisk is the disk image in memory.
+type memDisk []byte
+
+func (d memDisk) ReadAt(p []byte, off int64) (int, error) {
+	return bytes.NewReader(d).ReadAt(p, off)
+}
+
+func (d memDisk) WriteAt(p []byte, off int64) (int, error) {
+	return copy(d[off:], p), nil
+}
+
+// blockRequest submits the request and returns the data and the status.
+func blockRequest(t *testing.T, d *virtioDriver, typ uint32, sector uint64, data []byte, n int) ([]byte, uint8) {
+	t.Helper()
+	header := make([]byte, virtioBlkReqHeaderSize)
+	binary.LittleEndian.PutUint32(header[0:], typ)
+	binary.LittleEndian.PutUint64(header[8:], sector)
+	bufs := []*testBuffer{{data: header}}
+	if len(data) > 0 {
+		bufs = append(bufs, &testBuffer{data: data})
+	}
+	in := &testBuffer{writable: n}
+	if n > 0 {
+		bufs = append(bufs, in)
+	}
+	status := &testBuffer{writable: 1}
+	bufs = append(bufs, status)
+	head := d.submit(0, bufs...)
+	d.kick(0)
+	used := d.used(0)
+	if len(used) != 1 || used[0].id != uint32(head) {
+		t.Fatalf("want the chain %d is used but got %v", head, used)
+	}
+	var got []byte
+	if n > 0 {
+		got = d.readMem(in.addr, n)
+	}
+	return got, d.readMem(status.addr, 1)[0]
+}
+
+func sectors(n int, b byte) []byte {
+	return bytes.Repeat([]byte{b}, n*virtioBlockSectorSize)
+}
+
+func TestVirtioBlock(t *testing.T) {
+	t.Run("read and write", func(t *testing.T) {
+		disk := memDisk(append(sectors(1, 'a'), sectors(3, 'b')...))
+		d := newVirtioDriver(t, NewVirtioBlock(disk, int64(len(disk))), nil)
+		d.init(virtioFVersion1, 1)
+		if got := d.read32(virtioMMIOConfig); got != 4 {
+			t.Errorf("want capacity 4 but got %d", got)
+		}
+		d.write32(virtioMMIODeviceFeaturesSel, 0)
+		if got := d.read32(virtioMMIODeviceFeatures); got&(virtioBlkFRO|virtioBlkFFlush) != 0 {
+			t.Errorf("want neither VIRTIO_BLK_F_RO nor VIRTIO_BLK_F_FLUSH but got 0x%x", got)
+		}
+
+		got, status := blockRequest(t, d, virtioBlkTIn, 0, nil, 2*virtioBlockSectorSize)
+		if want := append(sectors(1, 'a'), sectors(1, 'b')...); status != virtioBlkSOK || !bytes.Equal(got, want) {
+			t.Errorf("want the first two sectors but got status %d", status)
+		}
+		if _, status := blockRequest(t, d, virtioBlkTOut, 3, sectors(1, 'c'), 0); status != virtioBlkSOK {
+			t.Errorf("want write succeeds but got status %d", status)
+		}
+		if !bytes.Equal(disk[3*virtioBlockSectorSize:], sectors(1, 'c')) {
+			t.Errorf("want the last sector is written")
+		}
+		if _, status := blockRequest(t, d, virtioBlkTIn, 3, nil, 2*virtioBlockSectorSize); status != virtioBlkSIOErr {
+			t.Errorf("want read beyond the capacity fails but got status %d", status)
+		}
+		if _, status := blockRequest(t, d, virtioBlkTFlush, 0, nil, 0); status != virtioBlkSUnsupp {
+			t.Errorf("want flush is unsupported but got status %d", status)
+		}
+		if _, status := blockRequest(t, d, 42, 0, nil, 0); status != virtioBlkSUnsupp {
+			t.Errorf("want the unknown request is unsupported but got status %d", status)
+		}
+		got, status = blockRequest(t, d, virtioBlkTGetID, 0, nil, virtioBlkIDBytes)
+		if want := "go-riscv"; status != virtioBlkSOK || string(bytes.TrimRight(got, "\x00")) != want {
+			t.Errorf("want ID %q but got %q (status %d)", want, got, status)
+		}
+	})
+
+	t.Run("streamed by chunks", func(t *testing.T) {
+		n := 3*virtqChunkSize/virtioBlockSectorSize + 1
+		disk := make(memDisk, n*virtioBlockSectorSize)
+		d := newVirtioDriver(t, NewVirtioBlock(disk, int64(len(disk))), nil)
+		d.init(virtioFVersion1, 1)
+		data := make([]byte, len(disk))
+		for i := range data {
+			data[i] = byte(i / 7)
+		}
+		if _, status := blockRequest(t, d, virtioBlkTOut, 0, data, 0); status != virtioBlkSOK || !bytes.Equal(disk, data) {
+			t.Fatalf("want the whole disk is written but got status %d", status)
+		}
+		if got, status := blockRequest(t, d, virtioBlkTIn, 0, nil, len(data)); status != virtioBlkSOK || !bytes.Equal(got, data) {
+			t.Errorf("want the whole disk is read but got status %d", status)
+		}
+	})
+
+	t.Run("read-only", func(t *testing.T) {
+		for name, dev := range map[string]*VirtioBlock{
+			"option":     NewVirtioBlock(memDisk(sectors(1, 'a')), virtioBlockSectorSize, WithBlockReadOnly()),
+			"not writer": NewVirtioBlock(bytes.NewReader(sectors(1, 'a')), virtioBlockSectorSize),
+		} {
+			d := newVirtioDriver(t, dev, nil)
+			d.init(virtioFVersion1|virtioBlkFRO, 1)
+			if _, status := blockRequest(t, d, virtioBlkTOut, 0, sectors(1, 'c'), 0); status != virtioBlkSIOErr {
+				t.Errorf("%s: want write fails but got status %d", name, status)
+			}
+			if got, status := blockRequest(t, d, virtioBlkTIn, 0, nil, virtioBlockSectorSize); status != virtioBlkSOK || !bytes.Equal(got, sectors(1, 'a')) {
+				t.Errorf("%s: want the sector is not modified (status %d)", name, status)
+			}
+		}
+	})
+
+	t.Run("copy-on-write", func(t *testing.T) {
+		disk := memDisk(sectors(2, 'a'))
+		d := newVirtioDriver(t, NewVirtioBlock(disk, int64(len(disk)), WithBlockCopyOnWrite()), nil)
+		d.init(virtioFVersion1, 1)
+		if _, status := blockRequest(t, d, virtioBlkTOut, 1, sectors(1, 'c'), 0); status != virtioBlkSOK {
+			t.Errorf("want write succeeds but got status %d", status)
+		}
+		got, status := blockRequest(t, d, virtioBlkTIn, 0, nil, 2*virtioBlockSectorSize)
+		if want := append(sectors(1, 'a'), sectors(1, 'c')...); status != virtioBlkSOK || !bytes.Equal(got, want) {
+			t.Errorf("want the written sector is read (status %d)", status)
+		}
+		if !bytes.Equal(disk, sectors(2, 'a')) {
+			t.Errorf("want the disk image is not modified")
+		}
+	})
+
+	t.Run("file", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "disk.img")
+		if err := os.WriteFile(path, sectors(2, 'a'), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		f, err := os.OpenFile(path, os.O_RDWR, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		d := newVirtioDriver(t, NewVirtioBlock(f, 2*virtioBlockSectorSize), nil)
+		d.init(virtioFVersion1|virtioBlkFFlush, 1)
+		if _, status := blockRequest(t, d, virtioBlkTOut, 0, sectors(1, 'c'), 0); status != virtioBlkSOK {
+			t.Errorf("want write succeeds but got status %d", status)
+		}
+		if _, status := blockRequest(t, d, virtioBlkTFlush, 0, nil, 0); status != virtioBlkSOK {
+			t.Errorf("want flush succeeds but got status %d", status)
+		}
+		got, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := append(sectors(1, 'c'), sectors(1, 'a')...); !bytes.Equal(got, want) {
+			t.Errorf("want the file is written")
+		}
+	})
+}
+
+func TestCopyOnWrite(t *testing.T) {
+	base := []byte("0123456789")
+	c := NewCopyOnWrite(bytes.NewReader(base))
+	if n, err := c.WriteAt([]byte("abc"), 4); n != 3 || err != nil {
+		t.Fatalf("want 3 bytes are written but got %d, %v", n, err)
+	}
+	// The sector is copied from the base image and the bytes beyond it are zero.
+	got := make([]byte, 12)
+	if n, err := c.ReadAt(got, 0); n != len(got) || err != nil {
+		t.Fatalf("want %d bytes are read but got %d, %v", len(got), n, err)
+	}
+	if want := []byte("0123abc789\x00\x00"); !bytes.Equal(got, want) {
+		t.Errorf("want %q but got %q", want, got)
+	}
+	// The write across the sector boundary.
+	if _, err := c.WriteAt([]byte("xy"), virtioBlockSectorSize-1); err != nil {
+		t.Fatal(err)
+	}
+	got = make([]byte, 2)
+	if _, err := c.ReadAt(got, virtioBlockSectorSize-1); err != nil || string(got) != "xy" {
+		t.Errorf("want %q but got %q, %v", "xy", got, err)
+	}
+	if string(base) != "0123456789" {
+		t.Errorf("want the base image is not modified but got %q", base)
+	}
+	// The sectors which are not written are read from the base image, so it fails beyond it.
+	if _, err := c.ReadAt(make([]byte, 1), 2*virtioBlockSectorSize); err == nil {
+		t.Errorf("want error beyond the base image")
+	}
+}
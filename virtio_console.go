@@ -0,0 +1,297 @@
+package riscv
+
+import (
+	"encoding/binary"
+	"io"
+	"sync"
+)
+
+// VirtioConsole is virtio console device with multiple ports. Each port is connected
+// to io.ReadWriter, and port 0 is the console. (e.g. /dev/hvc0 on Linux) The bytes
+// which are read from the ports are received by the goroutines until Read returns an error.
+//
+// If the driver does not accept VIRTIO_CONSOLE_F_MULTIPORT, only port 0 is available.
+//
+// see: 5.3 Console Device
+type VirtioConsole struct {
+	ports []*consolePort
+
+	mu sync.Mutex
+	// control is the control messages which are not delivered to the driver yet.
+	control [][]byte
+}
+
+// consolePort is the port of VirtioConsole.
+type consolePort struct {
+	rw   io.ReadWriter
+	mu   sync.Mutex
+	cond *sync.Cond
+	// rx is the received bytes which are not delivered to the driver yet.
+	rx []byte
+}
+
+var (
+	_ VirtioDevice = (*VirtioConsole)(nil)
+	_ virtioPoller = (*VirtioConsole)(nil)
+)
+
+const (
+	virtioConsoleDeviceID = 3
+
+	// The feature bits.
+	virtioConsoleFMultiport  = 1 << 1
+	virtioConsoleFEmergWrite = 1 << 2
+
+	// The indices of the virtqueues. The queues for port n (n >= 1) are 2+2n and 3+2n.
+	virtioConsoleControlReceiveq  = 2
+	virtioConsoleControlTransmitq = 3
+
+	// The control events.
+	virtioConsoleDeviceReady = 0
+	virtioConsoleDeviceAdd   = 1
+	virtioConsolePortReady   = 3
+	virtioConsoleConsolePort = 4
+	virtioConsolePortOpen    = 6
+
+	// virtioConsoleControlSize is the size of struct virtio_console_control.
+	virtioConsoleControlSize = 8
+	// virtioConsoleEmergWr is the offset of emerg_wr in the configuration space.
+	virtioConsoleEmergWr = 8
+	// virtioConsoleMaxTransmit is the maximum length of the transmit buffers in the chain.
+	// Linux transmits at most 32KiB at once.
+	virtioConsoleMaxTransmit = 64 * 1024
+	// consolePortBufferSize is the number of the received bytes which are held by each port.
+	consolePortBufferSize = 4096
+)
+
+// NewVirtioConsole creates the console device whose port i is connected to ports[i].
+// It panics if no port is given.
+func NewVirtioConsole(ports ...io.ReadWriter) *VirtioConsole {
+	if len(ports) == 0 {
+		panic("riscv: virtio console requires at least one port")
+	}
+	c := &VirtioConsole{}
+	for _, rw := range ports {
+		p := &consolePort{rw: rw}
+		p.cond = sync.NewCond(&p.mu)
+		c.ports = append(c.ports, p)
+		go p.receive()
+	}
+	return c
+}
+
+// receive reads the bytes from the port. It waits while the buffer is full.
+func (p *consolePort) receive() {
+	buf := make([]byte, 256)
+	for {
+		n, err := p.rw.Read(buf)
+		for b := buf[:n]; len(b) > 0; {
+			p.mu.Lock()
+			for len(p.rx) >= consolePortBufferSize {
+				p.cond.Wait()
+			}
+			m := consolePortBufferSize - len(p.rx)
+			if m > len(b) {
+				m = len(b)
+			}
+			p.rx = append(p.rx, b[:m]...)
+			b = b[m:]
+			p.mu.Unlock()
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+func (c *VirtioConsole) deviceID() uint32 { return virtioConsoleDeviceID }
+
+func (c *VirtioConsole) features() uint64 {
+	return virtioConsoleFMultiport | virtioConsoleFEmergWrite
+}
+
+// numQueues returns the number of the queues for all ports and the control queues.
+func (c *VirtioConsole) numQueues() int { return 2*len(c.ports) + 2 }
+
+// readConfig reads max_nr_ports. cols and rows are 0 because VIRTIO_CONSOLE_F_SIZE is not offered.
+func (c *VirtioConsole) readConfig(offset, size uint64) uint64 {
+	var config [12]byte
+	binary.LittleEndian.PutUint32(config[4:], uint32(len(c.ports)))
+	return readConfigBytes(config[:], offset, size)
+}
+
+// writeConfig writes the byte to port 0 if emerg_wr is written.
+func (c *VirtioConsole) writeConfig(offset, size, value uint64) {
+	if offset == virtioConsoleEmergWr {
+		_, _ = c.ports[0].rw.Write([]byte{byte(value)})
+	}
+}
+
+func (c *VirtioConsole) reset() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.control = nil
+}
+
+// port returns the port and reports whether the queue is the receive queue of it.
+// It returns nil for the control queues.
+func (c *VirtioConsole) port(q int) (*consolePort, bool) {
+	switch {
+	case q < 2:
+		return c.ports[0], q == 0
+	case q < 4:
+		return nil, false
+	}
+	return c.ports[q/2-1], q%2 == 0
+}
+
+// notify writes the bytes in the transmit queue to the port, or handles the control messages.
+// The received bytes are delivered by poll.
+//
+// see: 5.3.6 Device Operation
+func (c *VirtioConsole) notify(q *virtqueue) error {
+	if q.index == virtioConsoleControlTransmitq {
+		return c.handleControl(q)
+	}
+	p, rx := c.port(q.index)
+	if p == nil || rx {
+		return nil
+	}
+	for {
+		chain, err := q.pop()
+		if err != nil || chain == nil {
+			return err
+		}
+		b, err := q.read(chain, virtioConsoleMaxTransmit)
+		if err != nil {
+			return err
+		}
+		_, _ = p.rw.Write(b)
+		if err := q.push(chain, 0); err != nil {
+			return err
+		}
+	}
+}
+
+// handleControl handles the control messages from the driver.
+//
+// see: 5.3.6.2 Multiport Device Operation
+func (c *VirtioConsole) handleControl(q *virtqueue) error {
+	for {
+		chain, err := q.pop()
+		if err != nil || chain == nil {
+			return err
+		}
+		b, err := q.read(chain, virtioConsoleMaxTransmit)
+		if err != nil {
+			return err
+		}
+		if len(b) < virtioConsoleControlSize {
+			return errVirtqueue
+		}
+		id := binary.LittleEndian.Uint32(b[0:])
+		event := binary.LittleEndian.Uint16(b[4:])
+		value := binary.LittleEndian.Uint16(b[6:])
+		c.mu.Lock()
+		switch event {
+		case virtioConsoleDeviceReady:
+			if value == 1 {
+				for i := range c.ports {
+					c.sendControl(uint32(i), virtioConsoleDeviceAdd, 0)
+				}
+			}
+		case virtioConsolePortReady:
+			if value == 1 && int(id) < len(c.ports) {
+				if id == 0 {
+					c.sendControl(id, virtioConsoleConsolePort, 1)
+				}
+				// The host side of the port is always connected.
+				c.sendControl(id, virtioConsolePortOpen, 1)
+			}
+		}
+		c.mu.Unlock()
+		if err := q.push(chain, 0); err != nil {
+			return err
+		}
+	}
+}
+
+// sendControl queues the control message to the driver. c.mu must be held.
+func (c *VirtioConsole) sendControl(id uint32, event, value uint16) {
+	b := make([]byte, virtioConsoleControlSize)
+	binary.LittleEndian.PutUint32(b[0:], id)
+	binary.LittleEndian.PutUint16(b[4:], event)
+	binary.LittleEndian.PutUint16(b[6:], value)
+	c.control = append(c.control, b)
+}
+
+// poll delivers the control messages and the received bytes of the ports.
+func (c *VirtioConsole) poll(queues []*virtqueue) error {
+	multiport := queues[0].transport.driverFeatures&virtioConsoleFMultiport != 0
+	if multiport {
+		if err := c.deliverControl(queues[virtioConsoleControlReceiveq]); err != nil {
+			return err
+		}
+	}
+	for i, p := range c.ports {
+		if i > 0 && !multiport {
+			break
+		}
+		q := queues[0]
+		if i > 0 {
+			q = queues[2+2*i]
+		}
+		if err := p.deliver(q); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *VirtioConsole) deliverControl(q *virtqueue) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for len(c.control) > 0 {
+		chain, err := q.pop()
+		if err != nil || chain == nil {
+			return err
+		}
+		msg := c.control[0]
+		if chain.writableLen() < len(msg) {
+			return errVirtqueue
+		}
+		c.control = c.control[1:]
+		if err := q.write(chain, msg); err != nil {
+			return err
+		}
+		if err := q.push(chain, uint32(len(msg))); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// deliver writes the received bytes to the receive buffers while both are available.
+func (p *consolePort) deliver(q *virtqueue) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for len(p.rx) > 0 {
+		chain, err := q.pop()
+		if err != nil || chain == nil {
+			return err
+		}
+		n := chain.writableLen()
+		if n > len(p.rx) {
+			n = len(p.rx)
+		}
+		if err := q.write(chain, p.rx[:n]); err != nil {
+			return err
+		}
+		p.rx = p.rx[n:]
+		p.cond.Signal()
+		if err := q.push(chain, uint32(n)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,163 @@
+package riscv
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// readWriter is the port whose writes are recorded.
+type readWriter struct {
+	io.Reader
+	mu  sync.Mutex
+	out bytes.Buffer
+}
+
+func (rw *readWriter) Write(p []byte) (int, error) {
+	rw.mu.Lock()
+	defer rw.mu.Unlock()
+	return rw.out.Write(p)
+}
+
+func (rw *readWriter) String() string {
+	rw.mu.Lock()
+	defer rw.mu.Unlock()
+	return rw.out.String()
+}
+
+// controlMessage is struct virtio_console_control.
+type controlMessage struct {
+	id           uint32
+	event, value uint16
+}
+
+func sendControl(t *testing.T, d *virtioDriver, msg controlMessage) {
+	t.Helper()
+	b := make([]byte, virtioConsoleControlSize)
+	binary.LittleEndian.PutUint32(b[0:], msg.id)
+	binary.LittleEndian.PutUint16(b[4:], msg.event)
+	binary.LittleEndian.PutUint16(b[6:], msg.value)
+	d.submit(virtioConsoleControlTransmitq, &testBuffer{data: b})
+	d.kick(virtioConsoleControlTransmitq)
+}
+
+// receiveControl receives n control messages.
+func receiveControl(t *testing.T, d *virtioDriver, n int) []controlMessage {
+	t.Helper()
+	var msgs []controlMessage
+	for i := 0; i < n; i++ {
+		buf := &testBuffer{writable: virtioConsoleControlSize}
+		d.submit(virtioConsoleControlReceiveq, buf)
+		d.kick(virtioConsoleControlReceiveq)
+		if used := d.used(virtioConsoleControlReceiveq); len(used) != 1 {
+			t.Fatalf("want the control message is received but got %v", used)
+		}
+		b := d.readMem(buf.addr, virtioConsoleControlSize)
+		msgs = append(msgs, controlMessage{
+			id:    binary.LittleEndian.Uint32(b[0:]),
+			event: binary.LittleEndian.Uint16(b[4:]),
+			value: binary.LittleEndian.Uint16(b[6:]),
+		})
+	}
+	return msgs
+}
+
+// receivePort makes the receive buffer of the queue available and waits for the bytes.
+func receivePort(t *testing.T, d *virtioDriver, q, size int) string {
+	t.Helper()
+	buf := &testBuffer{writable: size}
+	d.submit(q, buf)
+	var used []usedElem
+	waitFor(t, func() bool {
+		d.kick(q)
+		used = d.used(q)
+		return len(used) > 0
+	})
+	return string(d.readMem(buf.addr, int(used[0].len)))
+}
+
+func TestVirtioConsole(t *testing.T) {
+	port0 := &readWriter{Reader: strings.NewReader("console")}
+	port1 := &readWriter{Reader: strings.NewReader("from host")}
+
+	t.Run("multiport", func(t *testing.T) {
+		d := newVirtioDriver(t, NewVirtioConsole(port0, port1), nil)
+		d.init(virtioFVersion1|virtioConsoleFMultiport, 6)
+		if got := d.read32(virtioMMIOConfig + 4); got != 2 {
+			t.Errorf("want max_nr_ports 2 but got %d", got)
+		}
+
+		sendControl(t, d, controlMessage{event: virtioConsoleDeviceReady, value: 1})
+		want := []controlMessage{
+			{id: 0, event: virtioConsoleDeviceAdd},
+			{id: 1, event: virtioConsoleDeviceAdd},
+		}
+		if got := receiveControl(t, d, 2); !equalControl(got, want) {
+			t.Errorf("want %v but got %v", want, got)
+		}
+		sendControl(t, d, controlMessage{id: 0, event: virtioConsolePortReady, value: 1})
+		sendControl(t, d, controlMessage{id: 1, event: virtioConsolePortReady, value: 1})
+		want = []controlMessage{
+			{id: 0, event: virtioConsoleConsolePort, value: 1},
+			{id: 0, event: virtioConsolePortOpen, value: 1},
+			{id: 1, event: virtioConsolePortOpen, value: 1},
+		}
+		if got := receiveControl(t, d, 3); !equalControl(got, want) {
+			t.Errorf("want %v but got %v", want, got)
+		}
+
+		// port 1 uses queue 4 and 5.
+		d.submit(5, &testBuffer{data: []byte("hello")})
+		d.kick(5)
+		if got := port1.String(); got != "hello" {
+			t.Errorf("want %q is written to port 1 but got %q", "hello", got)
+		}
+		if got := receivePort(t, d, 4, 64); got != "from host" {
+			t.Errorf("want %q from port 1 but got %q", "from host", got)
+		}
+		// The bytes which do not fit in the buffer are delivered to the next buffer.
+		if got := receivePort(t, d, 0, 4); got != "cons" {
+			t.Errorf("want %q from port 0 but got %q", "cons", got)
+		}
+		if got := receivePort(t, d, 0, 64); got != "ole" {
+			t.Errorf("want %q from port 0 but got %q", "ole", got)
+		}
+
+		if err := d.cpu.bus.Write(d.v.StartAddr()+virtioMMIOConfig+virtioConsoleEmergWr, 4, '!'); err != nil {
+			t.Fatal(err)
+		}
+		if got := port0.String(); got != "!" {
+			t.Errorf("want emergency write to port 0 but got %q", got)
+		}
+	})
+
+	t.Run("single port", func(t *testing.T) {
+		port := &readWriter{Reader: strings.NewReader("abc")}
+		other := &readWriter{Reader: strings.NewReader("xyz")}
+		d := newVirtioDriver(t, NewVirtioConsole(port, other), nil)
+		d.init(virtioFVersion1, 2)
+		d.submit(1, &testBuffer{data: []byte("hi")})
+		d.kick(1)
+		if got := port.String(); got != "hi" {
+			t.Errorf("want %q is written to port 0 but got %q", "hi", got)
+		}
+		if got := receivePort(t, d, 0, 64); got != "abc" {
+			t.Errorf("want %q from port 0 but got %q", "abc", got)
+		}
+	})
+}
+
+func equalControl(a, b []controlMessage) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,307 @@
+package riscv
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+)
+
+// EthernetLink sends and receives the Ethernet frames for VirtioNet.
+// The frames do not contain the frame check sequence.
+type EthernetLink interface {
+	// ReadFrame blocks until the frame is received. It returns io.EOF when the link is closed.
+	ReadFrame() ([]byte, error)
+	// WriteFrame sends the frame. The link may drop the frame like the real network.
+	WriteFrame(frame []byte) error
+}
+
+// EthernetTryReader is implemented by the EthernetLink which can receive the frame
+// without blocking. VirtioNet reads the frames from it before each instruction instead
+// of the goroutine, so the frames are delivered at the same instruction in every run.
+type EthernetTryReader interface {
+	// TryReadFrame returns the received frame, or nil if there is no frame.
+	// It returns io.EOF when the link is closed.
+	TryReadFrame() ([]byte, error)
+}
+
+// VirtioNet is virtio network device whose frames are sent and received by EthernetLink.
+// The frames are read by TryReadFrame if the link implements EthernetTryReader.
+// Otherwise, they are received by the goroutine until ReadFrame returns an error.
+//
+// Neither the checksum offloading nor the segmentation offloading is offered, so the
+// frames are passed to the link as they are. The received frame is dropped if it does
+// not fit in the receive buffer.
+//
+// see: 5.1 Network Device
+type VirtioNet struct {
+	mac  net.HardwareAddr
+	link EthernetLink
+	// tryReader is the link if it implements EthernetTryReader.
+	tryReader EthernetTryReader
+
+	mu   sync.Mutex
+	cond *sync.Cond
+	// rx is the received frames which are not delivered to the driver yet.
+	rx [][]byte
+}
+
+var (
+	_ VirtioDevice = (*VirtioNet)(nil)
+	_ virtioPoller = (*VirtioNet)(nil)
+)
+
+const (
+	virtioNetDeviceID = 1
+
+	// The feature bits.
+	virtioNetFMAC    = 1 << 5
+	virtioNetFStatus = 1 << 16
+
+	// virtioNetSLinkUp is the bit of the status in the configuration space.
+	virtioNetSLinkUp = 1
+
+	// The indices of the virtqueues.
+	virtioNetReceiveq  = 0
+	virtioNetTransmitq = 1
+
+	// virtioNetHdrSize is the size of struct virtio_net_hdr which precedes each frame.
+	virtioNetHdrSize = 12
+	// virtioNetMaxFrameSize is the maximum size of the transmitted frame, which is
+	// the maximum MTU of Linux plus the Ethernet header.
+	virtioNetMaxFrameSize = 65535 + 14
+	// virtioNetRxQueueSize is the number of the received frames which are held by the device.
+	virtioNetRxQueueSize = 64
+)
+
+// NewVirtioNet creates the network device which is connected to link. mac may be
+// nil if the driver chooses the MAC address. It panics if mac is not a 6-byte address.
+func NewVirtioNet(link EthernetLink, mac net.HardwareAddr) *VirtioNet {
+	if mac != nil && len(mac) != 6 {
+		panic(fmt.Sprintf("riscv: invalid MAC address: %s", mac))
+	}
+	n := &VirtioNet{
+		mac:  mac,
+		link: link,
+	}
+	n.cond = sync.NewCond(&n.mu)
+	if r, ok := link.(EthernetTryReader); ok {
+		n.tryReader = r
+	} else {
+		go n.receive()
+	}
+	return n
+}
+
+// receive reads the frames from the link. It waits while the queue is full.
+func (n *VirtioNet) receive() {
+	for {
+		frame, err := n.link.ReadFrame()
+		if err != nil {
+			return
+		}
+		n.mu.Lock()
+		for len(n.rx) >= virtioNetRxQueueSize {
+			n.cond.Wait()
+		}
+		n.rx = append(n.rx, frame)
+		n.mu.Unlock()
+	}
+}
+
+// tryReceive reads the frames from the link which implements EthernetTryReader until
+// the queue is full. The frames which are not read are left in the link.
+func (n *VirtioNet) tryReceive() {
+	if n.tryReader == nil {
+		return
+	}
+	for len(n.rx) < virtioNetRxQueueSize {
+		frame, err := n.tryReader.TryReadFrame()
+		if err != nil || frame == nil {
+			return
+		}
+		n.rx = append(n.rx, frame)
+	}
+}
+
+func (n *VirtioNet) deviceID() uint32 { return virtioNetDeviceID }
+
+func (n *VirtioNet) features() uint64 {
+	f := uint64(virtioNetFStatus)
+	if n.mac != nil {
+		f |= virtioNetFMAC
+	}
+	return f
+}
+
+func (n *VirtioNet) numQueues() int { return 2 }
+
+// readConfig reads the MAC address and the status. The link is always up.
+func (n *VirtioNet) readConfig(offset, size uint64) uint64 {
+	var config [8]byte
+	copy(config[:], n.mac)
+	binary.LittleEndian.PutUint16(config[6:], virtioNetSLinkUp)
+	return readConfigBytes(config[:], offset, size)
+}
+
+func (n *VirtioNet) writeConfig(offset, size, value uint64) {}
+
+func (n *VirtioNet) reset() {}
+
+// notify sends the frames in the transmit queue, or delivers the received frames
+// when the receive buffers are made available.
+//
+// see: 5.1.6 Device Operation
+func (n *VirtioNet) notify(q *virtqueue) error {
+	if q.index == virtioNetReceiveq {
+		return n.deliver(q)
+	}
+	for {
+		c, err := q.pop()
+		if err != nil || c == nil {
+			return err
+		}
+		p, err := q.read(c, virtioNetHdrSize+virtioNetMaxFrameSize)
+		if err != nil {
+			return err
+		}
+		if len(p) < virtioNetHdrSize {
+			return errVirtqueue
+		}
+		// The frame is lost if the link fails to send it.
+		_ = n.link.WriteFrame(p[virtioNetHdrSize:])
+		if err := q.push(c, 0); err != nil {
+			return err
+		}
+	}
+}
+
+// poll delivers the received frames.
+func (n *VirtioNet) poll(queues []*virtqueue) error {
+	return n.deliver(queues[virtioNetReceiveq])
+}
+
+// deliver writes the received frames to the receive buffers while both are available.
+func (n *VirtioNet) deliver(q *virtqueue) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.tryReceive()
+	for len(n.rx) > 0 {
+		c, err := q.pop()
+		if err != nil || c == nil {
+			return err
+		}
+		frame := n.rx[0]
+		n.rx = n.rx[1:]
+		n.cond.Signal()
+		if virtioNetHdrSize+len(frame) > c.writableLen() {
+			// The frame is dropped and the buffer is returned unused.
+			if err := q.push(c, 0); err != nil {
+				return err
+			}
+			continue
+		}
+		p := make([]byte, virtioNetHdrSize+len(frame))
+		// num_buffers is always 1 because VIRTIO_NET_F_MRG_RXBUF is not offered.
+		binary.LittleEndian.PutUint16(p[10:], 1)
+		copy(p[virtioNetHdrSize:], frame)
+		if err := q.write(c, p); err != nil {
+			return err
+		}
+		if err := q.push(c, uint32(len(p))); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// EthernetPipe is the end of the pipe which is created by NewEthernetPipe.
+type EthernetPipe struct {
+	// dropped is the number of the frames which are written to this end and dropped.
+	// It is the first field to be 64-bit aligned for the atomic operations.
+	dropped uint64
+	rx      <-chan []byte
+	tx      chan<- []byte
+	closed  chan struct{}
+	once    *sync.Once
+}
+
+var (
+	_ EthernetLink      = (*EthernetPipe)(nil)
+	_ EthernetTryReader = (*EthernetPipe)(nil)
+	_ io.Closer         = (*EthernetPipe)(nil)
+)
+
+// ethernetPipeSize is the number of the frames which are buffered in each direction.
+const ethernetPipeSize = 64
+
+// errPipeClosed is returned when the frame is written to the closed pipe.
+var errPipeClosed = errors.New("ethernet pipe is closed")
+
+// NewEthernetPipe creates the pair of the links which are connected each other
+// in the process. e.g. two CPUs can talk by VirtioNet. The frame which is written
+// to one end is read from the other end. The frames are dropped if the other end
+// does not read them, and they are counted by Dropped. Closing either end closes both.
+func NewEthernetPipe() (*EthernetPipe, *EthernetPipe) {
+	ab := make(chan []byte, ethernetPipeSize)
+	ba := make(chan []byte, ethernetPipeSize)
+	closed := make(chan struct{})
+	once := &sync.Once{}
+	a := &EthernetPipe{rx: ba, tx: ab, closed: closed, once: once}
+	b := &EthernetPipe{rx: ab, tx: ba, closed: closed, once: once}
+	return a, b
+}
+
+// ReadFrame reads the frame which is written to the other end.
+func (p *EthernetPipe) ReadFrame() ([]byte, error) {
+	select {
+	case frame := <-p.rx:
+		return frame, nil
+	case <-p.closed:
+		return nil, io.EOF
+	}
+}
+
+// TryReadFrame reads the frame which is written to the other end without blocking.
+// It returns nil if there is no frame.
+func (p *EthernetPipe) TryReadFrame() ([]byte, error) {
+	select {
+	case frame := <-p.rx:
+		return frame, nil
+	case <-p.closed:
+		return nil, io.EOF
+	default:
+		return nil, nil
+	}
+}
+
+// WriteFrame writes the frame to the other end. The frame is dropped if the other end is full.
+func (p *EthernetPipe) WriteFrame(frame []byte) error {
+	select {
+	case <-p.closed:
+		return errPipeClosed
+	default:
+	}
+	select {
+	case p.tx <- append([]byte(nil), frame...):
+	default:
+		// The other end is full.
+		atomic.AddUint64(&p.dropped, 1)
+	}
+	return nil
+}
+
+// Dropped returns the number of the frames which are written to this end and
+// dropped because the other end is full.
+func (p *EthernetPipe) Dropped() uint64 {
+	return atomic.LoadUint64(&p.dropped)
+}
+
+// Close closes the pipe.
+func (p *EthernetPipe) Close() error {
+	p.once.Do(func() { close(p.closed) })
+	return nil
+}
@@ -0,0 +1,153 @@
+package riscv
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"testing"
+)
+
+// sendFrame sends the frame through the transmit queue.
+func sendFrame(t *testing.T, d *virtioDriver, frame []byte) {
+	t.Helper()
+	d.submit(virtioNetTransmitq, &testBuffer{data: make([]byte, virtioNetHdrSize)}, &testBuffer{data: frame})
+	d.kick(virtioNetTransmitq)
+	if got := d.used(virtioNetTransmitq); len(got) != 1 {
+		t.Fatalf("want the transmit buffer is used but got %v", got)
+	}
+}
+
+// receiveFrame makes the receive buffer available and receives the frame.
+func receiveFrame(t *testing.T, d *virtioDriver, size int) []byte {
+	t.Helper()
+	buf := &testBuffer{writable: size}
+	d.submit(virtioNetReceiveq, buf)
+	d.kick(virtioNetReceiveq)
+	used := d.used(virtioNetReceiveq)
+	if len(used) != 1 {
+		t.Fatalf("want the receive buffer is used but got %v", used)
+	}
+	if used[0].len == 0 {
+		return nil
+	}
+	p := d.readMem(buf.addr, int(used[0].len))
+	if got := binary.LittleEndian.Uint16(p[10:]); got != 1 {
+		t.Errorf("want num_buffers 1 but got %d", got)
+	}
+	return p[virtioNetHdrSize:]
+}
+
+func TestVirtioNet(t *testing.T) {
+	a, b := NewEthernetPipe()
+	defer a.Close()
+	mac := net.HardwareAddr{0x52, 0x54, 0x00, 0x12, 0x34, 0x56}
+	line := &interruptLine{}
+	d1 := newVirtioDriver(t, NewVirtioNet(a, mac), nil)
+	d2 := newVirtioDriver(t, NewVirtioNet(b, nil), line)
+	d1.init(virtioFVersion1|virtioNetFMAC|virtioNetFStatus, 2)
+	d2.init(virtioFVersion1|virtioNetFStatus, 2)
+
+	var config []byte
+	for i := uint64(0); i < 8; i++ {
+		v, err := d1.cpu.bus.Read(d1.v.StartAddr()+virtioMMIOConfig+i, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		config = append(config, byte(v))
+	}
+	if want := append(append([]byte(nil), mac...), virtioNetSLinkUp, 0); !bytes.Equal(config, want) {
+		t.Errorf("want config % x but got % x", want, config)
+	}
+	d2.write32(virtioMMIODeviceFeaturesSel, 0)
+	if got := d2.read32(virtioMMIODeviceFeatures); got&virtioNetFMAC != 0 {
+		t.Errorf("want VIRTIO_NET_F_MAC is not offered without the MAC address but got 0x%x", got)
+	}
+
+	frame := append(bytes.Repeat([]byte{0xff}, 6), mac...)
+	frame = append(frame, 0x08, 0x06) // ARP
+	frame = append(frame, []byte("payload")...)
+	sendFrame(t, d1, frame)
+	if got := receiveFrame(t, d2, 1526); !bytes.Equal(got, frame) {
+		t.Errorf("want frame % x but got % x", frame, got)
+	}
+	if !line.Level() {
+		t.Errorf("want the interrupt is asserted when the frame is received")
+	}
+
+	// The other direction.
+	sendFrame(t, d2, []byte("reply"))
+	if got := receiveFrame(t, d1, 1526); string(got) != "reply" {
+		t.Errorf("want frame %q but got %q", "reply", got)
+	}
+
+	// The frame which does not fit in the buffer is dropped.
+	sendFrame(t, d1, frame)
+	if got := receiveFrame(t, d2, virtioNetHdrSize+4); got != nil {
+		t.Errorf("want the frame is dropped but got % x", got)
+	}
+}
+
+// blockingLink hides TryReadFrame of the link.
+type blockingLink struct{ EthernetLink }
+
+func TestVirtioNetBlockingLink(t *testing.T) {
+	a, b := NewEthernetPipe()
+	defer a.Close()
+	d := newVirtioDriver(t, NewVirtioNet(blockingLink{b}, nil), nil)
+	d.init(virtioFVersion1|virtioNetFStatus, 2)
+	if err := a.WriteFrame([]byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	// The frame is received by the goroutine.
+	buf := &testBuffer{writable: 1526}
+	d.submit(virtioNetReceiveq, buf)
+	d.kick(virtioNetReceiveq)
+	var used []usedElem
+	waitFor(t, func() bool {
+		d.v.Tick()
+		used = d.used(virtioNetReceiveq)
+		return len(used) > 0
+	})
+	if got := d.readMem(buf.addr, int(used[0].len))[virtioNetHdrSize:]; string(got) != "hello" {
+		t.Errorf("want frame %q but got %q", "hello", got)
+	}
+}
+
+func TestEthernetPipe(t *testing.T) {
+	a, b := NewEthernetPipe()
+	if err := a.WriteFrame([]byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	got, err := b.ReadFrame()
+	if err != nil || string(got) != "hello" {
+		t.Errorf("want %q but got %q, %v", "hello", got, err)
+	}
+	if got, err := b.TryReadFrame(); got != nil || err != nil {
+		t.Errorf("want no frame but got %q, %v", got, err)
+	}
+	// The frames are dropped while the other end is full.
+	for i := 0; i < ethernetPipeSize+1; i++ {
+		if err := b.WriteFrame([]byte{byte(i)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := b.Dropped(); got != 1 {
+		t.Errorf("want 1 dropped frame but got %d", got)
+	}
+	for i := 0; i < ethernetPipeSize; i++ {
+		if got, err := a.TryReadFrame(); err != nil || got[0] != byte(i) {
+			t.Fatalf("want frame %d but got %v, %v", i, got, err)
+		}
+	}
+	b.Close()
+	if _, err := a.ReadFrame(); err != io.EOF {
+		t.Errorf("want io.EOF after closed but got %v", err)
+	}
+	if _, err := a.TryReadFrame(); err != io.EOF {
+		t.Errorf("want io.EOF from TryReadFrame after closed but got %v", err)
+	}
+	if err := a.WriteFrame([]byte("hello")); err == nil {
+		t.Errorf("want error after closed")
+	}
+}
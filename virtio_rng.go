@@ -0,0 +1,67 @@
+package riscv
+
+import (
+	"io"
+)
+
+// VirtioRNG is virtio entropy device which provides the bytes read from io.Reader.
+// The runs are reproducible if the reader is seeded. e.g. rand.New(rand.NewSource(seed)).
+// For the real entropy, use crypto/rand.Reader.
+//
+// see: 5.4 Entropy Device
+type VirtioRNG struct {
+	r io.Reader
+}
+
+var _ VirtioDevice = (*VirtioRNG)(nil)
+
+const virtioRNGDeviceID = 4
+
+// NewVirtioRNG creates the entropy device which reads from r.
+func NewVirtioRNG(r io.Reader) *VirtioRNG {
+	return &VirtioRNG{r: r}
+}
+
+func (r *VirtioRNG) deviceID() uint32 { return virtioRNGDeviceID }
+
+func (r *VirtioRNG) features() uint64 { return 0 }
+
+func (r *VirtioRNG) numQueues() int { return 1 }
+
+func (r *VirtioRNG) readConfig(offset, size uint64) uint64 { return 0 }
+
+func (r *VirtioRNG) writeConfig(offset, size, value uint64) {}
+
+func (r *VirtioRNG) reset() {}
+
+// notify fills the buffers in the request queue by chunks. If the reader returns
+// an error, the buffer is filled partially.
+//
+// see: 5.4.6 Device Operation
+func (r *VirtioRNG) notify(q *virtqueue) error {
+	for {
+		c, err := q.pop()
+		if err != nil || c == nil {
+			return err
+		}
+		n := c.writableLen()
+		p := make([]byte, chunkSize(n))
+		off := 0
+		for off < n {
+			if n-off < len(p) {
+				p = p[:n-off]
+			}
+			m, _ := io.ReadFull(r.r, p)
+			if err := q.writeAt(c, p[:m], off); err != nil {
+				return err
+			}
+			off += m
+			if m < len(p) {
+				break
+			}
+		}
+		if err := q.push(c, uint32(off)); err != nil {
+			return err
+		}
+	}
+}
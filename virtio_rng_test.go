@@ -0,0 +1,52 @@
+package riscv
+
+import (
+	"bytes"
+	"math/rand"
+	"testing"
+)
+
+func TestVirtioRNG(t *testing.T) {
+	p := NewPLIC()
+	// The device in slot 1 is connected to the source 2 like QEMU virt machine.
+	v := NewVirtioMMIO(1, NewVirtioRNG(rand.New(rand.NewSource(42))), p.Line(2))
+	d := &virtioDriver{
+		t:    t,
+		cpu:  NewCPU(make([]byte, 1<<20), WithDevices(p, v)),
+		v:    v,
+		next: dramStartAddress + 0x1000,
+	}
+	d.init(virtioFVersion1, 1)
+	if got := d.read32(virtioMMIODeviceID); got != virtioRNGDeviceID {
+		t.Errorf("want device ID %d but got %d", virtioRNGDeviceID, got)
+	}
+
+	buf := &testBuffer{writable: 32}
+	d.submit(0, buf)
+	d.kick(0)
+	used := d.used(0)
+	if len(used) != 1 || used[0].len != 32 {
+		t.Fatalf("want 32 bytes are written but got %v", used)
+	}
+	want := make([]byte, 32)
+	rand.New(rand.NewSource(42)).Read(want)
+	if got := d.readMem(buf.addr, 32); !bytes.Equal(got, want) {
+		t.Errorf("want the bytes from the seeded source\nwant % x\n got % x", want, got)
+	}
+	if got := p.Read(plicPending, 4); got != 1<<2 {
+		t.Errorf("want source 2 is pending on PLIC but got 0x%x", got)
+	}
+	d.write32(virtioMMIOInterruptACK, virtioInterruptUsedBuffer)
+	if got := p.Read(plicPending, 4); got != 0 {
+		t.Errorf("want no pending source after the acknowledgement but got 0x%x", got)
+	}
+
+	// The reader which is exhausted fills the buffer partially.
+	d = newVirtioDriver(t, NewVirtioRNG(bytes.NewReader([]byte{1, 2, 3})), nil)
+	d.init(virtioFVersion1, 1)
+	d.submit(0, &testBuffer{writable: 8})
+	d.kick(0)
+	if used := d.used(0); len(used) != 1 || used[0].len != 3 {
+		t.Errorf("want 3 bytes are written but got %v", used)
+	}
+}
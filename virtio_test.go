@@ -0,0 +1,353 @@
+package riscv
+
+import (
+	"encoding/binary"
+	"testing"
+)
+
+// virtioDriver drives the virtio-mmio transport through the bus like the driver in the guest.
+// The virtqueues and the buffers are allocated from DRAM.
+type virtioDriver struct {
+	t      *testing.T
+	cpu    *CPU
+	v      *VirtioMMIO
+	next   uint64
+	queues []*testQueue
+}
+
+// testQueue is the virtqueue which is set up by virtioDriver.
+type testQueue struct {
+	size     uint32
+	desc     uint64
+	driver   uint64
+	device   uint64
+	nextDesc uint32
+	availIdx uint16
+	lastUsed uint16
+}
+
+// testBuffer is the buffer which is made available to the device.
+type testBuffer struct {
+	// data is the content of the device-readable buffer.
+	data []byte
+	// writable is the length of the device-writable buffer.
+	writable int
+	// addr is the address of the buffer which is set by submit.
+	addr uint64
+}
+
+// usedElem is the element of the used ring.
+type usedElem struct {
+	id, len uint32
+}
+
+const testQueueSize = 16
+
+func newVirtioDriver(t *testing.T, dev VirtioDevice, irq InterruptLine) *virtioDriver {
+	t.Helper()
+	v := NewVirtioMMIO(0, dev, irq)
+	cpu := NewCPU(make([]byte, 1<<20), WithDevices(v))
+	return &virtioDriver{
+		t:    t,
+		cpu:  cpu,
+		v:    v,
+		next: dramStartAddress + 0x1000,
+	}
+}
+
+func (d *virtioDriver) read32(offset uint64) uint32 {
+	d.t.Helper()
+	v, err := d.cpu.bus.Read(d.v.StartAddr()+offset, 4)
+	if err != nil {
+		d.t.Fatal(err)
+	}
+	return uint32(v)
+}
+
+func (d *virtioDriver) write32(offset uint64, value uint32) {
+	d.t.Helper()
+	if err := d.cpu.bus.Write(d.v.StartAddr()+offset, 4, uint64(value)); err != nil {
+		d.t.Fatal(err)
+	}
+}
+
+// alloc allocates n bytes of the memory which is aligned to 16 bytes.
+func (d *virtioDriver) alloc(n int) uint64 {
+	addr := d.next
+	d.next += (uint64(n) + 15) &^ 15
+	return addr
+}
+
+func (d *virtioDriver) readMem(addr uint64, n int) []byte {
+	d.t.Helper()
+	p := make([]byte, n)
+	if err := d.cpu.bus.readBytes(addr, p); err != nil {
+		d.t.Fatal(err)
+	}
+	return p
+}
+
+func (d *virtioDriver) writeMem(addr uint64, p []byte) {
+	d.t.Helper()
+	if err := d.cpu.bus.writeBytes(addr, p); err != nil {
+		d.t.Fatal(err)
+	}
+}
+
+// init initializes the device with the features and sets up n queues.
+// It fails the test if the device does not accept the features.
+//
+// see: 3.1.1 Driver Requirements: Device Initialization
+func (d *virtioDriver) init(features uint64, n int) {
+	d.t.Helper()
+	d.write32(virtioMMIOStatus, 0)
+	d.write32(virtioMMIOStatus, 1|2) // ACKNOWLEDGE | DRIVER
+	d.write32(virtioMMIODriverFeaturesSel, 0)
+	d.write32(virtioMMIODriverFeatures, uint32(features))
+	d.write32(virtioMMIODriverFeaturesSel, 1)
+	d.write32(virtioMMIODriverFeatures, uint32(features>>32))
+	d.write32(virtioMMIOStatus, 1|2|virtioStatusFeaturesOK)
+	if d.read32(virtioMMIOStatus)&virtioStatusFeaturesOK == 0 {
+		d.t.Fatalf("features 0x%x are not accepted", features)
+	}
+	d.queues = nil
+	for i := 0; i < n; i++ {
+		q := &testQueue{size: testQueueSize}
+		q.desc = d.alloc(16 * testQueueSize)
+		q.driver = d.alloc(6 + 2*testQueueSize)
+		q.device = d.alloc(6 + 8*testQueueSize)
+		d.write32(virtioMMIOQueueSel, uint32(i))
+		d.write32(virtioMMIOQueueNum, q.size)
+		d.write32(virtioMMIOQueueDescLow, uint32(q.desc))
+		d.write32(virtioMMIOQueueDescHigh, uint32(q.desc>>32))
+		d.write32(virtioMMIOQueueDriverLow, uint32(q.driver))
+		d.write32(virtioMMIOQueueDriverHigh, uint32(q.driver>>32))
+		d.write32(virtioMMIOQueueDeviceLow, uint32(q.device))
+		d.write32(virtioMMIOQueueDeviceHigh, uint32(q.device>>32))
+		d.write32(virtioMMIOQueueReady, 1)
+		d.queues = append(d.queues, q)
+	}
+	d.write32(virtioMMIOStatus, 1|2|virtioStatusFeaturesOK|4) // DRIVER_OK
+}
+
+// submit makes the buffers available in the queue as a descriptor chain. The device
+// is not notified. It returns the head of the chain.
+func (d *virtioDriver) submit(qi int, bufs ...*testBuffer) uint16 {
+	d.t.Helper()
+	q := d.queues[qi]
+	head := uint16(q.nextDesc % q.size)
+	for i, b := range bufs {
+		idx := q.nextDesc % q.size
+		q.nextDesc++
+		var raw [16]byte
+		var flags uint16
+		n := len(b.data)
+		if b.writable > 0 {
+			n = b.writable
+			flags |= virtqDescFWrite
+		}
+		if n > 0 {
+			b.addr = d.alloc(n)
+			d.writeMem(b.addr, b.data)
+		}
+		if i < len(bufs)-1 {
+			flags |= virtqDescFNext
+		}
+		binary.LittleEndian.PutUint64(raw[0:], b.addr)
+		binary.LittleEndian.PutUint32(raw[8:], uint32(n))
+		binary.LittleEndian.PutUint16(raw[12:], flags)
+		binary.LittleEndian.PutUint16(raw[14:], uint16(q.nextDesc%q.size))
+		d.writeMem(q.desc+16*uint64(idx), raw[:])
+	}
+	var ring [2]byte
+	binary.LittleEndian.PutUint16(ring[:], head)
+	d.writeMem(q.driver+4+2*uint64(uint32(q.availIdx)%q.size), ring[:])
+	q.availIdx++
+	binary.LittleEndian.PutUint16(ring[:], q.availIdx)
+	d.writeMem(q.driver+2, ring[:])
+	return head
+}
+
+// kick notifies the queue and lets the device handle it.
+func (d *virtioDriver) kick(qi int) {
+	d.t.Helper()
+	d.write32(virtioMMIOQueueNotify, uint32(qi))
+	d.v.Tick()
+}
+
+// used returns the elements which are added to the used ring since the last call.
+func (d *virtioDriver) used(qi int) []usedElem {
+	d.t.Helper()
+	q := d.queues[qi]
+	idx := binary.LittleEndian.Uint16(d.readMem(q.device+2, 2))
+	var elems []usedElem
+	for ; q.lastUsed != idx; q.lastUsed++ {
+		raw := d.readMem(q.device+4+8*uint64(uint32(q.lastUsed)%q.size), 8)
+		elems = append(elems, usedElem{
+			id:  binary.LittleEndian.Uint32(raw[0:]),
+			len: binary.LittleEndian.Uint32(raw[4:]),
+		})
+	}
+	return elems
+}
+
+// virtioDevice is a VirtioDevice which completes each chain without writing anything.
+type virtioDevice struct {
+	resets int
+}
+
+func (d *virtioDevice) deviceID() uint32 { return 42 }
+func (d *virtioDevice) features() uint64 { return 1 << 3 }
+func (d *virtioDevice) numQueues() int   { return 2 }
+func (d *virtioDevice) readConfig(offset, size uint64) uint64 {
+	return readConfigBytes([]byte{1, 2, 3, 4}, offset, size)
+}
+func (d *virtioDevice) writeConfig(offset, size, value uint64) {}
+func (d *virtioDevice) reset()                                 { d.resets++ }
+func (d *virtioDevice) notify(q *virtqueue) error {
+	for {
+		c, err := q.pop()
+		if err != nil || c == nil {
+			return err
+		}
+		if err := q.push(c, 0); err != nil {
+			return err
+		}
+	}
+}
+
+func TestVirtioMMIO(t *testing.T) {
+	line := &interruptLine{}
+	dev := &virtioDevice{}
+	d := newVirtioDriver(t, dev, line)
+
+	if got := d.v.StartAddr(); got != 0x10001000 {
+		t.Errorf("want start address 0x10001000 but got 0x%x", got)
+	}
+	if got := NewVirtioMMIO(7, dev, nil).StartAddr(); got != 0x10008000 {
+		t.Errorf("want start address of slot 7 0x10008000 but got 0x%x", got)
+	}
+	for _, tc := range []struct {
+		name   string
+		offset uint64
+		want   uint32
+	}{
+		{name: "magic", offset: virtioMMIOMagicValue, want: 0x74726976},
+		{name: "version", offset: virtioMMIOVersion, want: 2},
+		{name: "device ID", offset: virtioMMIODeviceID, want: 42},
+		{name: "vendor ID", offset: virtioMMIOVendorID, want: 0x554d4551},
+		{name: "queue num max", offset: virtioMMIOQueueNumMax, want: virtioQueueSizeMax},
+		{name: "config", offset: virtioMMIOConfig, want: 0x04030201},
+	} {
+		if got := d.read32(tc.offset); got != tc.want {
+			t.Errorf("%s: want 0x%x but got 0x%x", tc.name, tc.want, got)
+		}
+	}
+	d.write32(virtioMMIODeviceFeaturesSel, 0)
+	if got := d.read32(virtioMMIODeviceFeatures); got != 1<<3 {
+		t.Errorf("want device features[31:0] 0x8 but got 0x%x", got)
+	}
+	d.write32(virtioMMIODeviceFeaturesSel, 1)
+	if got := d.read32(virtioMMIODeviceFeatures); got != 1 {
+		t.Errorf("want VIRTIO_F_VERSION_1 in device features[63:32] but got 0x%x", got)
+	}
+	d.write32(virtioMMIOQueueSel, 2)
+	if got := d.read32(virtioMMIOQueueNumMax); got != 0 {
+		t.Errorf("want queue num max 0 for the queue which does not exist but got %d", got)
+	}
+
+	t.Run("features", func(t *testing.T) {
+		for _, tc := range []struct {
+			name     string
+			features uint64
+			want     bool
+		}{
+			{name: "version 1", features: virtioFVersion1, want: true},
+			{name: "device feature", features: virtioFVersion1 | 1<<3, want: true},
+			{name: "legacy", features: 1 << 3, want: false},
+			{name: "not offered", features: virtioFVersion1 | 1<<4, want: false},
+		} {
+			d.write32(virtioMMIOStatus, 0)
+			d.write32(virtioMMIODriverFeaturesSel, 0)
+			d.write32(virtioMMIODriverFeatures, uint32(tc.features))
+			d.write32(virtioMMIODriverFeaturesSel, 1)
+			d.write32(virtioMMIODriverFeatures, uint32(tc.features>>32))
+			d.write32(virtioMMIOStatus, 1|2|virtioStatusFeaturesOK)
+			if got := d.read32(virtioMMIOStatus)&virtioStatusFeaturesOK != 0; got != tc.want {
+				t.Errorf("%s: want FEATURES_OK %t but got %t", tc.name, tc.want, got)
+			}
+		}
+	})
+
+	t.Run("used buffer notification", func(t *testing.T) {
+		d.init(virtioFVersion1, 2)
+		head := d.submit(1, &testBuffer{data: []byte("ping")})
+		if line.Level() {
+			t.Fatal("want the interrupt is not asserted before the notification")
+		}
+		d.kick(1)
+		if got := d.used(1); len(got) != 1 || got[0].id != uint32(head) {
+			t.Errorf("want the chain %d is used but got %v", head, got)
+		}
+		if got := d.read32(virtioMMIOInterruptStatus); got != virtioInterruptUsedBuffer || !line.Level() {
+			t.Errorf("want the used buffer notification but got interrupt status 0x%x, level %t", got, line.Level())
+		}
+		d.write32(virtioMMIOInterruptACK, virtioInterruptUsedBuffer)
+		if got := d.read32(virtioMMIOInterruptStatus); got != 0 || line.Level() {
+			t.Errorf("want the interrupt is acknowledged but got interrupt status 0x%x, level %t", got, line.Level())
+		}
+		// The queue which is not notified is not handled.
+		d.submit(0, &testBuffer{data: []byte("ping")})
+		d.v.Tick()
+		if got := d.used(0); len(got) != 0 {
+			t.Errorf("want no used buffer but got %v", got)
+		}
+	})
+
+	t.Run("reset", func(t *testing.T) {
+		d.init(virtioFVersion1, 2)
+		resets := dev.resets
+		d.write32(virtioMMIOStatus, 0)
+		if dev.resets != resets+1 {
+			t.Errorf("want the device is reset")
+		}
+		d.write32(virtioMMIOQueueSel, 0)
+		if got := d.read32(virtioMMIOQueueReady); got != 0 {
+			t.Errorf("want the queue is not ready after the reset but got %d", got)
+		}
+	})
+
+	t.Run("broken descriptor chain", func(t *testing.T) {
+		d.init(virtioFVersion1, 2)
+		q := d.queues[0]
+		// The descriptor points to itself.
+		var raw [16]byte
+		binary.LittleEndian.PutUint16(raw[12:], virtqDescFNext)
+		d.writeMem(q.desc, raw[:])
+		d.writeMem(q.driver+2, []byte{1, 0})
+		d.kick(0)
+		if got := d.read32(virtioMMIOStatus); got&virtioStatusDeviceNeedReset == 0 {
+			t.Errorf("want DEVICE_NEEDS_RESET but got status 0x%x", got)
+		}
+		if got := d.read32(virtioMMIOInterruptStatus); got&virtioInterruptConfigChange == 0 {
+			t.Errorf("want the configuration change notification but got 0x%x", got)
+		}
+	})
+
+	t.Run("oversized descriptor chain", func(t *testing.T) {
+		d.init(virtioFVersion1, 2)
+		q := d.queues[0]
+		var raw [16]byte
+		binary.LittleEndian.PutUint32(raw[8:], 0xffffffff)
+		binary.LittleEndian.PutUint16(raw[12:], virtqDescFWrite)
+		d.writeMem(q.desc, raw[:])
+		d.writeMem(q.driver+2, []byte{1, 0})
+		d.kick(0)
+		if got := d.read32(virtioMMIOStatus); got&virtioStatusDeviceNeedReset == 0 {
+			t.Errorf("want DEVICE_NEEDS_RESET but got status 0x%x", got)
+		}
+		if got := d.used(0); len(got) != 0 {
+			t.Errorf("want no used buffer but got %v", got)
+		}
+	})
+}